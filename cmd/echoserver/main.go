@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+	"github.com/kahvecikaan/httpfromtcp/internal/websocket"
+)
+
+// echo is the JSON form of a reflected request.
+type echo struct {
+	Method  string            `json:"method"`
+	Target  string            `json:"target"`
+	Version string            `json:"version"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	// BodyBytes is set instead of Body when the body is not valid UTF-8,
+	// since JSON strings would mangle it. encoding/json emits base64.
+	BodyBytes []byte `json:"body_bytes,omitempty"`
+}
+
+type echoServer struct {
+	alwaysJSON bool
+}
+
+func (e *echoServer) ServeHTTP(w *response.Writer, req *request.Request) {
+	// WebSocket clients get their messages echoed instead.
+	if websocket.IsUpgrade(req) {
+		websocket.Echo(w, req)
+		return
+	}
+
+	var body []byte
+	contentType := "text/plain; charset=utf-8"
+	if e.alwaysJSON || wantsJSON(req) {
+		body = reflectJSON(req)
+		contentType = "application/json"
+	} else {
+		body = []byte(reflectText(req))
+	}
+
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", contentType)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body)
+	}
+}
+
+// wantsJSON reports whether the client asked for JSON with an Accept header
+// or a format=json query parameter.
+func wantsJSON(req *request.Request) bool {
+	if strings.Contains(req.Headers.Get("Accept"), "application/json") {
+		return true
+	}
+	_, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	for _, param := range strings.Split(query, "&") {
+		if param == "format=json" {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedHeaders returns the header names in a stable order.
+func sortedHeaders(req *request.Request) []string {
+	var keys []string
+	req.Headers.ForEach(func(key, _ string) {
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// reflectText rebuilds the request as the parser understood it, with each
+// part labelled so odd framing stands out.
+func reflectText(req *request.Request) string {
+	var b strings.Builder
+	b.WriteString("Request line:\n")
+	fmt.Fprintf(&b, "- Method: %s\n", req.RequestLine.Method)
+	fmt.Fprintf(&b, "- Target: %s\n", req.RequestLine.RequestTarget)
+	fmt.Fprintf(&b, "- Version: %s\n", req.RequestLine.HttpVersion)
+	b.WriteString("Headers:\n")
+	for _, key := range sortedHeaders(req) {
+		fmt.Fprintf(&b, "- %s: %s\n", key, req.Headers.Get(key))
+	}
+	fmt.Fprintf(&b, "Body (%d bytes):\n", len(req.Body))
+	if utf8.Valid(req.Body) {
+		b.Write(req.Body)
+	} else {
+		fmt.Fprintf(&b, "%q", req.Body)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func reflectJSON(req *request.Request) []byte {
+	e := echo{
+		Method:  req.RequestLine.Method,
+		Target:  req.RequestLine.RequestTarget,
+		Version: req.RequestLine.HttpVersion,
+		Headers: map[string]string{},
+	}
+	for _, key := range sortedHeaders(req) {
+		e.Headers[key] = req.Headers.Get(key)
+	}
+	if utf8.Valid(req.Body) {
+		e.Body = string(req.Body)
+	} else {
+		e.BodyBytes = req.Body
+	}
+
+	out, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		// Only strings and bytes go in, so this cannot happen.
+		panic(err)
+	}
+	return append(out, '\n')
+}
+
+func main() {
+	port := flag.Int("port", 42069, "port to listen on")
+	alwaysJSON := flag.Bool("json", false, "always answer with JSON instead of only when asked")
+	shutdownTimeout := flag.Duration("shutdown-timeout", graceful.DefaultTimeout, "how long in-flight requests may run after SIGINT or SIGTERM")
+	flag.Parse()
+
+	srv, err := server.Serve(*port, &echoServer{alwaysJSON: *alwaysJSON})
+	if err != nil {
+		log.Fatalf("error starting server: %v", err)
+	}
+	log.Println("Echo server started on port", *port)
+
+	err = graceful.Wait(*shutdownTimeout, srv.Shutdown)
+	log.Println("Server stopped")
+	if err != nil {
+		os.Exit(1)
+	}
+}
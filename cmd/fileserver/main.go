@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+func main() {
+	port := flag.Int("port", 42069, "port to listen on")
+	dir := flag.String("dir", ".", "directory to serve")
+	list := flag.Bool("list", false, "show listings for directories without an index.html")
+	shutdownTimeout := flag.Duration("shutdown-timeout", graceful.DefaultTimeout, "how long in-flight requests may run after SIGINT or SIGTERM")
+	flag.Parse()
+
+	root, err := filepath.Abs(*dir)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		log.Fatalf("error: %s is not a directory", root)
+	}
+
+	files := server.FileHandler(root)
+	files.ListDirectories = *list
+	srv, err := server.Serve(*port, files)
+	if err != nil {
+		log.Fatalf("error starting server: %v", err)
+	}
+	log.Printf("Serving %s on port %d", root, *port)
+
+	err = graceful.Wait(*shutdownTimeout, srv.Shutdown)
+	log.Println("Server stopped")
+	if err != nil {
+		os.Exit(1)
+	}
+}
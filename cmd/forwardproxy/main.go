@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/relay"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+// via is added to forwarded messages as RFC 9110 section 7.6.3 asks of
+// proxies.
+const via = "1.1 httpfromtcp"
+
+// forwardProxy relays absolute-form requests such as
+// "GET http://example.com/ HTTP/1.1" through the client package. CONNECT
+// requests are tunnelled by a server.Tunnel in front of it.
+type forwardProxy struct {
+	client *client.Client
+}
+
+// ServeHTTP sends an absolute-form request on to its origin and streams
+// the answer back.
+func (p *forwardProxy) ServeHTTP(w *response.Writer, req *request.Request) {
+	target := req.RequestLine.RequestTarget
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "http" || u.Host == "" {
+		writeText(w, response.StatusBadRequest, "proxy requests need an absolute http:// target; use CONNECT for https\n")
+		return
+	}
+
+	b := client.NewRequest(req.RequestLine.Method, target)
+	relay.ForEachEndToEnd(&req.Headers, func(key, value string) {
+		if key != "host" && key != "content-length" {
+			b.Header(key, value)
+		}
+	})
+	b.Header("Via", via)
+	upstreamReq, err := b.Body(req.Body).Build()
+	if err != nil {
+		writeText(w, response.StatusBadRequest, err.Error()+"\n")
+		return
+	}
+
+	hasBody := false
+	_, err = p.client.DoStream(upstreamReq, func(resp *response.Response) io.Writer {
+		var body io.Writer
+		body, hasBody = relay.WriteHead(w, resp, req.RequestLine.Method, headers.NewHeadersFromPairs("Via", via))
+		return body
+	})
+	if err != nil {
+		log.Printf("proxy: %s %s: %v", req.RequestLine.Method, target, err)
+		if w.StatusCode() == 0 {
+			writeText(w, response.StatusBadGateway, "upstream error\n")
+		}
+		// Otherwise the response is already under way; closing the
+		// connection without the last chunk tells the client it is cut short.
+		return
+	}
+	if hasBody {
+		w.WriteChunkedBodyDone()
+	}
+}
+
+func writeText(w *response.Writer, statusCode response.StatusCode, body string) {
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func main() {
+	port := flag.Int("port", 42069, "port to listen on")
+	dialTimeout := flag.Duration("dial-timeout", 10*time.Second, "time allowed to connect to an origin")
+	shutdownTimeout := flag.Duration("shutdown-timeout", graceful.DefaultTimeout, "how long in-flight requests and tunnels may run after SIGINT or SIGTERM")
+	flag.Parse()
+
+	c := client.NewClient()
+	c.DialTimeout = *dialTimeout
+	// Redirects and compression are the end client's business.
+	c.CheckRedirect = func(*request.Request, []*request.Request) error {
+		return client.ErrUseLastResponse
+	}
+	c.DisableCompression = true
+
+	p := &server.Tunnel{
+		DialTimeout: *dialTimeout,
+		Header:      headers.NewHeadersFromPairs("Via", via),
+		Next:        &forwardProxy{client: c},
+	}
+	srv, err := server.Serve(*port, p)
+	if err != nil {
+		log.Fatalf("error starting proxy: %v", err)
+	}
+	log.Printf("Forward proxy listening on port %d", *port)
+
+	err = graceful.Wait(*shutdownTimeout, srv.Shutdown)
+	c.CloseIdleConnections()
+	log.Println("Proxy stopped")
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header %q must have the form \"Name: value\"", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+func main() {
+	var hdrs headerFlags
+	method := flag.String("X", "", "request method (default GET, or POST when -d is given)")
+	flag.Var(&hdrs, "H", "request header \"Name: value\" (repeatable)")
+	data := flag.String("d", "", "request body; @file reads a file, @- streams stdin")
+	output := flag.String("o", "", "write the response body to this file instead of stdout")
+	include := flag.Bool("i", false, "include the status line and headers in the output")
+	verbose := flag.Bool("v", false, "print the request, response headers and connection events to stderr")
+	insecure := flag.Bool("k", false, "skip TLS certificate verification")
+	timeout := flag.Duration("timeout", 30*time.Second, "overall request timeout (0 disables it)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] URL\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetFlags(0)
+
+	if *method == "" {
+		*method = "GET"
+		if *data != "" {
+			*method = "POST"
+		}
+	}
+
+	b := client.NewRequest(*method, flag.Arg(0))
+	for _, h := range hdrs {
+		name, value, _ := strings.Cut(h, ":")
+		b.Header(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if err := setBody(b, *data); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	// Ctrl-C aborts the request and closes its connection instead of
+	// killing the process mid-write.
+	ctx, stop := graceful.NotifyContext(context.Background())
+	defer stop()
+	if *verbose {
+		ctx = client.WithClientTrace(ctx, verboseTrace())
+	}
+	req, err := b.Context(ctx).Build()
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	c := client.NewClient()
+	c.Timeout = *timeout
+	if *insecure {
+		c.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if *verbose {
+		printRequest(req)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	if *verbose {
+		printHead(os.Stderr, "< ", "\n", resp)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if *include {
+		printHead(out, "", "\r\n", resp)
+	}
+	if _, err := out.Write(resp.Body); err != nil {
+		log.Fatalf("error: writing body: %v", err)
+	}
+}
+
+// setBody attaches the -d argument to the request: a literal string, a file,
+// or stdin streamed with chunked encoding.
+func setBody(b *client.RequestBuilder, data string) error {
+	switch {
+	case data == "":
+		return nil
+	case data == "@-":
+		b.BodyReader(os.Stdin)
+	case strings.HasPrefix(data, "@"):
+		f, err := os.Open(data[1:])
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		// The file stays open until the process exits.
+		b.Header("Content-Length", strconv.FormatInt(info.Size(), 10)).BodyReader(f)
+	default:
+		b.Body([]byte(data))
+	}
+	return nil
+}
+
+func verboseTrace() *client.ClientTrace {
+	return &client.ClientTrace{
+		DNSDone: func(addrs []net.IPAddr, err error) {
+			if err == nil {
+				fmt.Fprintf(os.Stderr, "* Resolved to %v\n", addrs)
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "* Connecting to %s failed: %v\n", addr, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "* Connected to %s\n", addr)
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				fmt.Fprintf(os.Stderr, "* TLS %s, ALPN %q\n", tls.VersionName(state.Version), state.NegotiatedProtocol)
+			}
+		},
+		GotConn: func(info client.GotConnInfo) {
+			if info.Reused {
+				fmt.Fprintf(os.Stderr, "* Reusing connection to %s\n", info.Conn.RemoteAddr())
+			}
+		},
+	}
+}
+
+// printRequest shows the request as built. The client adds a few headers of
+// its own, such as Accept-Encoding, when it sends it.
+func printRequest(req *request.Request) {
+	fmt.Fprintf(os.Stderr, "> %s %s HTTP/%s\n", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion)
+	req.Headers.ForEach(func(key, value string) {
+		fmt.Fprintf(os.Stderr, "> %s: %s\n", key, value)
+	})
+	fmt.Fprintln(os.Stderr, ">")
+}
+
+// printHead writes the status line and headers of resp, each line starting
+// with prefix and ending with eol.
+func printHead(w io.Writer, prefix, eol string, resp *response.Response) {
+	sl := resp.StatusLine
+	fmt.Fprintf(w, "%sHTTP/%s %d %s%s", prefix, sl.HttpVersion, sl.StatusCode, sl.ReasonPhrase, eol)
+	resp.Headers.ForEach(func(key, value string) {
+		fmt.Fprintf(w, "%s%s: %s%s", prefix, key, value, eol)
+	})
+	fmt.Fprintf(w, "%s%s", strings.TrimSpace(prefix), eol)
+}
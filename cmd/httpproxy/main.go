@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/httpcache"
+	"github.com/kahvecikaan/httpfromtcp/internal/relay"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+const routePrefix = "/httpbin/"
+
+type proxy struct {
+	upstream string
+	client   *client.Client
+	// cache, if set, answers requests from stored responses where it can.
+	// Its responses arrive whole rather than streamed.
+	cache *httpcache.Cache
+}
+
+// chunkForwarder re-sends each piece of the upstream body as a chunk while
+// hashing and counting it for the trailers.
+type chunkForwarder struct {
+	chunks *relay.ChunkWriter
+	hash   hash.Hash
+	n      int64
+}
+
+func (f *chunkForwarder) Write(p []byte) (int, error) {
+	f.hash.Write(p)
+	f.n += int64(len(p))
+	return f.chunks.Write(p)
+}
+
+func (p *proxy) ServeHTTP(w *response.Writer, req *request.Request) {
+	target := req.RequestLine.RequestTarget
+	if !strings.HasPrefix(target, routePrefix) {
+		writeText(w, response.StatusNotFound, "not found: only "+routePrefix+"* is proxied\n")
+		return
+	}
+
+	b := client.NewRequest(req.RequestLine.Method, p.upstream+"/"+strings.TrimPrefix(target, routePrefix))
+	relay.ForEachEndToEnd(&req.Headers, func(key, value string) {
+		if key != "host" && key != "content-length" {
+			b.Header(key, value)
+		}
+	})
+	upstreamReq, err := b.Body(req.Body).Build()
+	if err != nil {
+		writeText(w, response.StatusBadRequest, err.Error()+"\n")
+		return
+	}
+
+	if p.cache != nil {
+		resp, err := p.cache.Do(upstreamReq)
+		if err != nil {
+			log.Printf("proxy: %s %s: %v", req.RequestLine.Method, target, err)
+			writeText(w, response.StatusBadGateway, "upstream error\n")
+			return
+		}
+		if fwd := writeHead(w, resp, req.RequestLine.Method); fwd != nil {
+			fwd.Write(resp.Body)
+			writeTrailers(w, fwd)
+		}
+		return
+	}
+
+	var (
+		started bool
+		fwd     *chunkForwarder
+	)
+	_, err = p.client.DoStream(upstreamReq, func(resp *response.Response) io.Writer {
+		started = true
+		if fwd = writeHead(w, resp, req.RequestLine.Method); fwd == nil {
+			return io.Discard
+		}
+		return fwd
+	})
+	if err != nil {
+		log.Printf("proxy: %s %s: %v", req.RequestLine.Method, target, err)
+		if !started {
+			writeText(w, response.StatusBadGateway, "upstream error\n")
+		}
+		// Otherwise the response is already under way; closing the
+		// connection without the last chunk tells the client it is cut short.
+		return
+	}
+	if fwd != nil {
+		writeTrailers(w, fwd)
+	}
+}
+
+// writeHead sends the status line and headers of the upstream response to
+// a request with method and returns the writer for its body, which is
+// chunked with trailers. A response that has no body, to HEAD or with
+// status 204 or 304, keeps its upstream framing fields and gets a nil
+// writer.
+func writeHead(w *response.Writer, resp *response.Response, method string) *chunkForwarder {
+	statusCode := resp.StatusLine.StatusCode
+	hasBody := method != "HEAD" && statusCode != 204 && statusCode != 304
+
+	h := headers.NewHeaders()
+	relay.ForEachEndToEnd(&resp.Headers, func(key, value string) {
+		// Without a body the length is only informational and is kept.
+		if key != "content-length" || !hasBody {
+			h.Set(key, value)
+		}
+	})
+	if hasBody {
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
+	}
+	h.Set("Connection", "close")
+
+	w.WriteStatusLine(response.StatusCode(statusCode))
+	w.WriteHeaders(*h)
+	if !hasBody {
+		return nil
+	}
+	return &chunkForwarder{chunks: relay.NewChunkWriter(w), hash: sha256.New()}
+}
+
+// writeTrailers ends the body with its hash and length.
+func writeTrailers(w *response.Writer, fwd *chunkForwarder) {
+	w.WriteTrailers(headers.NewHeadersFromPairs(
+		"X-Content-SHA256", fmt.Sprintf("%x", fwd.hash.Sum(nil)),
+		"X-Content-Length", strconv.FormatInt(fwd.n, 10),
+	))
+}
+
+func writeText(w *response.Writer, statusCode response.StatusCode, body string) {
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func main() {
+	port := flag.Int("port", 42069, "port to listen on")
+	upstream := flag.String("upstream", "https://httpbin.org", "base URL that "+routePrefix+"* is mapped to")
+	cacheSize := flag.Int64("cache-size", 0, "bytes of upstream responses kept in memory and reused as RFC 9111 allows (0 disables caching)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", graceful.DefaultTimeout, "how long in-flight requests may run after SIGINT or SIGTERM")
+	flag.Parse()
+
+	p := &proxy{
+		upstream: strings.TrimSuffix(*upstream, "/"),
+		client:   client.NewClient(),
+	}
+	if *cacheSize > 0 {
+		p.cache = &httpcache.Cache{Client: p.client, Store: httpcache.NewMemoryStore(*cacheSize)}
+	}
+	// The request ID travels upstream with the other request headers.
+	srv, err := server.Serve(*port, server.RequestID()(p))
+	if err != nil {
+		log.Fatalf("error starting proxy: %v", err)
+	}
+	log.Printf("Proxying %s* to %s on port %d", routePrefix, p.upstream, *port)
+
+	err = graceful.Wait(*shutdownTimeout, srv.Shutdown)
+	p.client.CloseIdleConnections()
+	log.Println("Proxy stopped")
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHead(t *testing.T) {
+	upstream := func(status int, pairs ...string) *response.Response {
+		return &response.Response{
+			StatusLine: response.StatusLine{StatusCode: status},
+			Headers:    *headers.NewHeadersFromPairs(pairs...),
+		}
+	}
+
+	// Test: A body is chunked and ended with its hash and length
+	t.Run("Body", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := response.NewWriter(&buf)
+		fwd := writeHead(w, upstream(200, "Content-Length", "5", "ETag", `"e"`), "GET")
+		require.NotNil(t, fwd)
+		fwd.Write([]byte("hello"))
+		writeTrailers(w, fwd)
+
+		resp, err := response.ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(resp.Body))
+		assert.Equal(t, "chunked", resp.Headers.Get("Transfer-Encoding"))
+		assert.Equal(t, `"e"`, resp.Headers.Get("ETag"))
+		assert.Empty(t, resp.Headers.Get("Content-Length"))
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("hello"))), resp.Trailers.Get("X-Content-SHA256"))
+		assert.Equal(t, "5", resp.Trailers.Get("X-Content-Length"))
+	})
+
+	// Test: Answers to HEAD and 204 or 304 answers keep their framing and
+	// get neither a body nor trailers
+	t.Run("No body", func(t *testing.T) {
+		for _, tc := range []struct {
+			method string
+			status int
+		}{{"HEAD", 200}, {"GET", 304}, {"GET", 204}} {
+			var buf bytes.Buffer
+			fwd := writeHead(response.NewWriter(&buf), upstream(tc.status, "Content-Length", "5", "ETag", `"e"`), tc.method)
+			assert.Nil(t, fwd, tc.method, tc.status)
+
+			raw := buf.String()
+			assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n")), raw)
+			resp, err := response.ResponseFromReaderWithOptions(&buf, response.Options{RequestMethod: "HEAD"})
+			require.NoError(t, err, raw)
+			assert.Equal(t, tc.status, resp.StatusLine.StatusCode, raw)
+			assert.Equal(t, "5", resp.Headers.Get("Content-Length"), raw)
+			assert.Equal(t, `"e"`, resp.Headers.Get("ETag"), raw)
+			assert.Empty(t, resp.Headers.Get("Transfer-Encoding"), raw)
+			assert.Empty(t, resp.Headers.Get("Trailer"), raw)
+			assert.Empty(t, resp.Body, raw)
+		}
+	})
+}
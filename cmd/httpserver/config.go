@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+	"gopkg.in/yaml.v3"
+)
+
+// config is everything the server command can be told, from a YAML file and
+// then from flags. Durations are written like "10s" or "1m30s".
+type config struct {
+	Listen          string        `yaml:"listen"`
+	TLS             tlsFiles      `yaml:"tls"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	DrainRetryAfter time.Duration `yaml:"drain_retry_after"`
+	MaxBodySize     int64         `yaml:"max_body_size"`
+	// ACME, if present, serves HTTPS with certificates obtained from an
+	// ACME CA instead of TLS files.
+	ACME *acmeConfig `yaml:"acme"`
+	// HTTP2 serves HTTP/2 to TLS clients that offer it, which is
+	// experimental.
+	HTTP2 bool `yaml:"http2"`
+	// Strict parses with the strict profile instead of the lenient one,
+	// refusing requests that could be read two ways by a proxy in front of
+	// the server.
+	Strict bool `yaml:"strict"`
+	// ReadHeaderTimeout and MinHeaderRate, in bytes per second, close
+	// clients that send their headers too slowly with 408.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	MinHeaderRate     int           `yaml:"min_header_rate"`
+	// IdleTimeout and MaxIdleConns bound connections kept open between
+	// requests, and MaxRequestsPerConn the requests each one carries.
+	// PipelineDepth is how many pipelined requests are handled at once.
+	IdleTimeout        time.Duration `yaml:"idle_timeout"`
+	MaxIdleConns       int           `yaml:"max_idle_conns"`
+	MaxRequestsPerConn int           `yaml:"max_requests_per_conn"`
+	PipelineDepth      int           `yaml:"pipeline_depth"`
+	// WriteStallTimeout drops clients that stop reading a response.
+	WriteStallTimeout time.Duration `yaml:"write_stall_timeout"`
+	// MaxConns and MaxInflightRequests cap the load taken on; zero means
+	// no limit.
+	MaxConns            int `yaml:"max_conns"`
+	MaxInflightRequests int `yaml:"max_inflight_requests"`
+	// Listeners and AcceptLoops spread accepting connections over several
+	// SO_REUSEPORT listeners and goroutines.
+	Listeners   int `yaml:"listeners"`
+	AcceptLoops int `yaml:"accept_loops"`
+	// IPFilter lists the CIDRs allowed to connect and those refused.
+	IPFilter ipFilter `yaml:"ip_filter"`
+	// TrustedProxies lists the CIDRs of proxies whose Forwarded and
+	// X-Forwarded-For headers name the client in logs.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// Shed, if present, answers 503 to new requests while overloaded.
+	Shed *loadShedding `yaml:"shed"`
+	// RateLimit, if present, answers 429 to clients sending requests
+	// faster than it allows.
+	RateLimit *rateLimit `yaml:"rate_limit"`
+	// Abuse, if present, bans addresses sending many malformed requests.
+	Abuse  *abuseBans    `yaml:"abuse"`
+	Video  string        `yaml:"video"`
+	Static []staticRoute `yaml:"static"`
+	// EarlyHints are Link values, like "</style.css>; rel=preload;
+	// as=style", sent in a 103 Early Hints ahead of each demo page.
+	EarlyHints []string `yaml:"early_hints"`
+	// Record names a file every request is appended to, for cmd/replay.
+	Record string `yaml:"record"`
+	// SecurityHeaders turns on server.SecurityHeaders. Its entries override
+	// the defaults; an empty value drops a header.
+	SecurityHeaders *securityHeaders `yaml:"security_headers"`
+	// Trace has the server echo TRACE requests back for debugging.
+	Trace bool `yaml:"trace"`
+	// Metrics is the path Prometheus metrics are served at, or empty.
+	Metrics string `yaml:"metrics"`
+	// Pprof serves runtime profiles under /debug/pprof/.
+	Pprof bool `yaml:"pprof"`
+	// AccessLog is "common", "json" or empty for no access log. Lines go to
+	// stdout.
+	AccessLog string `yaml:"access_log"`
+}
+
+// tlsFiles names a PEM certificate chain and its key. Both empty means
+// plain HTTP. ClientCA, a PEM bundle, turns on client certificates, which
+// ClientAuth makes "require" (the default), "verify_if_given" or
+// "request"; AllowedClients then limits requests to certificates with
+// those common names.
+type tlsFiles struct {
+	Cert           string   `yaml:"cert"`
+	Key            string   `yaml:"key"`
+	ClientCA       string   `yaml:"client_ca"`
+	ClientAuth     string   `yaml:"client_auth"`
+	AllowedClients []string `yaml:"allowed_clients"`
+}
+
+// acmeConfig names the domains to get certificates for and where to keep
+// them. HTTPListen, ":80" by default, answers the CA's HTTP-01 challenges
+// and redirects everything else to HTTPS.
+type acmeConfig struct {
+	Domains    []string `yaml:"domains"`
+	Email      string   `yaml:"email"`
+	CacheDir   string   `yaml:"cache_dir"`
+	Directory  string   `yaml:"directory"`
+	HTTPListen string   `yaml:"http_listen"`
+}
+
+// ipFilter holds CIDRs or bare addresses. An empty Allow admits every
+// source not in Deny.
+type ipFilter struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// abuseBans bans an address for Cooldown after Threshold bad requests
+// within Window, holding its connections for Tarpit if that is set.
+type abuseBans struct {
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+	Tarpit    time.Duration `yaml:"tarpit"`
+}
+
+// loadShedding lists the overload signals that shed requests. Any one that
+// is set and exceeded sheds; zero leaves a signal unused.
+type loadShedding struct {
+	Latency   time.Duration `yaml:"latency"`
+	HeapBytes uint64        `yaml:"heap_bytes"`
+}
+
+// rateLimit lets each client IP send Rate requests a second after a burst
+// of Burst.
+type rateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// securityHeaders is present in the YAML, possibly empty, to turn security
+// headers on.
+type securityHeaders struct {
+	Override map[string]string `yaml:"override"`
+}
+
+// staticRoute serves the files under Dir at request paths starting with
+// Prefix.
+type staticRoute struct {
+	Prefix string `yaml:"prefix"`
+	Dir    string `yaml:"dir"`
+}
+
+func defaultConfig() config {
+	return config{
+		Listen:          ":42069",
+		ShutdownTimeout: graceful.DefaultTimeout,
+		MaxBodySize:     request.MaxContentLength,
+		Video:           "assets/vim.mp4",
+	}
+}
+
+// loadConfig reads the YAML file at path over cfg. Unknown keys are errors,
+// so a typo does not silently leave a setting at its default.
+func loadConfig(path string, cfg *config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *config) validate() error {
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		return fmt.Errorf("tls needs both a cert and a key")
+	}
+	if c.HTTP2 && c.TLS.Cert == "" && c.ACME == nil {
+		return fmt.Errorf("http2 needs a tls cert or acme")
+	}
+	if c.TLS.ClientCA != "" && c.TLS.Cert == "" {
+		return fmt.Errorf("tls client_ca needs a cert and a key")
+	}
+	if _, err := clientAuthType(c.TLS.ClientAuth); err != nil {
+		return err
+	}
+	if len(c.TLS.AllowedClients) > 0 && c.TLS.ClientCA == "" {
+		return fmt.Errorf("tls allowed_clients needs a client_ca")
+	}
+	if c.ACME != nil {
+		if c.TLS.Cert != "" {
+			return fmt.Errorf("acme and tls cert are mutually exclusive")
+		}
+		if len(c.ACME.Domains) == 0 {
+			return fmt.Errorf("acme needs at least one domain")
+		}
+	}
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.ShutdownTimeout < 0 || c.ReadHeaderTimeout < 0 || c.IdleTimeout < 0 || c.WriteStallTimeout < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	if c.MinHeaderRate < 0 {
+		return fmt.Errorf("min header rate must not be negative")
+	}
+	if c.Listeners < 0 || c.AcceptLoops < 0 {
+		return fmt.Errorf("listeners and accept loops must not be negative")
+	}
+	if c.MaxConns < 0 || c.MaxInflightRequests < 0 || c.MaxIdleConns < 0 || c.MaxRequestsPerConn < 0 || c.PipelineDepth < 0 {
+		return fmt.Errorf("connection and request limits must not be negative")
+	}
+	if _, err := server.ParseIPFilter(c.IPFilter.Allow, c.IPFilter.Deny); err != nil {
+		return err
+	}
+	if _, err := server.ParsePrefixes(c.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+	if a := c.Abuse; a != nil && (a.Threshold <= 0 || a.Window <= 0 || a.Cooldown <= 0 || a.Tarpit < 0) {
+		return fmt.Errorf("abuse threshold, window and cooldown must be positive")
+	}
+	if sh := c.Shed; sh != nil && sh.Latency < 0 {
+		return fmt.Errorf("shed latency must not be negative")
+	}
+	if rl := c.RateLimit; rl != nil && (rl.Rate <= 0 || rl.Burst <= 0) {
+		return fmt.Errorf("rate limit rate and burst must be positive")
+	}
+	if c.DrainRetryAfter < 0 {
+		return fmt.Errorf("drain retry after must not be negative")
+	}
+	if c.MaxBodySize <= 0 {
+		return fmt.Errorf("max body size must be positive")
+	}
+	if c.AccessLog != "" && c.AccessLog != "common" && c.AccessLog != "json" {
+		return fmt.Errorf("access log format %q must be common or json", c.AccessLog)
+	}
+	if c.Metrics != "" && !strings.HasPrefix(c.Metrics, "/") {
+		return fmt.Errorf("metrics path %q must start with /", c.Metrics)
+	}
+	for _, link := range c.EarlyHints {
+		if !strings.HasPrefix(link, "<") || !strings.Contains(link, ">") {
+			return fmt.Errorf("early hint %q must start with <uri>", link)
+		}
+	}
+	for _, route := range c.Static {
+		if !strings.HasPrefix(route.Prefix, "/") || !strings.HasSuffix(route.Prefix, "/") {
+			return fmt.Errorf("static prefix %q must start and end with /", route.Prefix)
+		}
+		if info, err := os.Stat(route.Dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("static dir %q for %s is not a directory", route.Dir, route.Prefix)
+		}
+	}
+	return nil
+}
+
+// clientAuthType maps a tls client_auth value to its tls.ClientAuthType.
+func clientAuthType(name string) (tls.ClientAuthType, error) {
+	switch name {
+	case "", "require":
+		return tls.RequireAndVerifyClientCert, nil
+	case "verify_if_given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	}
+	return 0, fmt.Errorf("tls client_auth %q must be require, verify_if_given or request", name)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/acme"
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/recording"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+const pageTemplate = `<html>
+  <head>
+    <title>%d %s</title>
+  </head>
+  <body>
+    <h1>%s</h1>
+    <p>%s</p>
+  </body>
+</html>
+`
+
+// respondHTML writes a small HTML page with the given status.
+func respondHTML(w *response.Writer, statusCode response.StatusCode, heading, message string) {
+	body := []byte(fmt.Sprintf(pageTemplate, statusCode, statusCode.ReasonPhrase(), heading, message))
+
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", "text/html")
+
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
+
+// serveVideo streams the file at path, honouring Range requests so browsers
+// can seek.
+func serveVideo(w *response.Writer, req *request.Request, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("error opening video: %v", err)
+		respondHTML(w, response.StatusNotFound, "Not Found", "No video to see here.")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		respondHTML(w, response.StatusInternalServerError, "Internal Server Error", "Okay, you know what? This one is on me.")
+		return
+	}
+	server.ServeContent(w, req, path, info.ModTime(), f)
+}
+
+// app routes requests to the demo pages, the video and the static
+// directories from the config.
+type app struct {
+	video  string
+	static []staticRoute
+	// metrics, when set, is served at metricsPath.
+	metrics     *server.Metrics
+	metricsPath string
+	// debug, when set, serves the runtime profiles under /debug/pprof/.
+	debug *server.Router
+	// earlyHints are Link values sent in a 103 ahead of each demo page.
+	earlyHints []string
+}
+
+// hint sends the early hints ahead of a demo page and repeats them on its
+// final response, for clients that ignore 103.
+func (a *app) hint(w *response.Writer) {
+	if len(a.earlyHints) == 0 {
+		return
+	}
+	if err := response.EarlyHints(w, a.earlyHints...); err != nil {
+		log.Printf("error sending early hints: %v", err)
+	}
+	for _, link := range a.earlyHints {
+		w.Header().Set("Link", link)
+	}
+}
+
+func (a *app) ServeHTTP(w *response.Writer, req *request.Request) {
+	urlPath, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	if a.metrics != nil && urlPath == a.metricsPath {
+		a.metrics.ServeHTTP(w, req)
+		return
+	}
+	if a.debug != nil && strings.HasPrefix(urlPath+"/", "/debug/pprof/") {
+		a.debug.ServeHTTP(w, req)
+		return
+	}
+	for _, route := range a.static {
+		if strings.HasPrefix(urlPath, route.Prefix) {
+			serveStatic(w, req, route, urlPath)
+			return
+		}
+	}
+
+	switch urlPath {
+	case "/video":
+		serveVideo(w, req, a.video)
+	case "/yourproblem":
+		a.hint(w)
+		respondHTML(w, response.StatusBadRequest, "Bad Request", "Your request honestly kinda sucked.")
+	case "/myproblem":
+		a.hint(w)
+		respondHTML(w, response.StatusInternalServerError, "Internal Server Error", "Okay, you know what? This one is on me.")
+	default:
+		a.hint(w)
+		respondHTML(w, response.StatusOK, "Success!", "Your request was an absolute banger.")
+	}
+}
+
+func main() {
+	defaults := defaultConfig()
+	configPath := flag.String("config", "", "YAML config file; flags given explicitly override its values")
+	listen := flag.String("listen", defaults.Listen, "address to listen on")
+	listeners := flag.Int("listeners", 0, "listeners sharing the address through SO_REUSEPORT, Linux only (0 means one)")
+	acceptLoops := flag.Int("accept-loops", 0, "goroutines accepting connections from each listener (0 means one)")
+	port := flag.Int("port", 0, "port to listen on, on all interfaces (shorthand for -listen :PORT)")
+	certFile := flag.String("cert", "", "TLS certificate chain (PEM); serves HTTPS together with -key")
+	keyFile := flag.String("key", "", "TLS private key (PEM)")
+	http2 := flag.Bool("http2", false, "serve HTTP/2 to TLS clients that negotiate h2 (experimental)")
+	clientCA := flag.String("client-ca", "", "CA bundle (PEM) that client certificates must chain to; requires one unless the config says otherwise")
+	readTimeout := flag.Duration("read-timeout", 0, "time allowed to read a whole request (0 means no limit)")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 0, "time allowed to read the request line and headers (0 means no limit)")
+	minHeaderRate := flag.Int("min-header-rate", 0, "slowest header upload accepted, in bytes per second (0 means no minimum)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "time a connection may wait for its next request (0 means no limit)")
+	maxRequestsPerConn := flag.Int("max-requests-per-conn", 0, "most requests served on one connection before it is closed (0 means no limit)")
+	pipelineDepth := flag.Int("pipeline-depth", 0, "pipelined requests on a connection handled at once, answered in order (0 means one at a time)")
+	maxIdleConns := flag.Int("max-idle-conns", 0, "most connections waiting for a request; the longest idle are closed (0 means no limit)")
+	writeTimeout := flag.Duration("write-timeout", 0, "time allowed to write a response (0 means no limit)")
+	writeStallTimeout := flag.Duration("write-stall-timeout", 0, "time a single write may wait on a client that stops reading (0 means no limit)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", defaults.ShutdownTimeout, "how long in-flight requests may run after SIGINT or SIGTERM")
+	maxBodySize := flag.Int64("max-body-size", defaults.MaxBodySize, "largest request body accepted, in bytes")
+	strict := flag.Bool("strict", false, "refuse ambiguous requests that could be used for request smuggling")
+	maxConns := flag.Int("max-conns", 0, "most connections open at once; more get 503 (0 means no limit)")
+	maxInflight := flag.Int("max-inflight", 0, "most requests handled at once; more get 503 (0 means no limit)")
+	allow := flag.String("allow", "", "comma-separated CIDRs allowed to connect (empty allows all)")
+	deny := flag.String("deny", "", "comma-separated CIDRs refused at connect")
+	trusted := flag.String("trusted-proxies", "", "comma-separated CIDRs of proxies whose X-Forwarded-For and Forwarded headers are believed")
+	videoPath := flag.String("video", defaults.Video, "MP4 file served at /video")
+	recordPath := flag.String("record", "", "append every request to this file for cmd/replay")
+	secHeaders := flag.Bool("security-headers", false, "add HSTS, CSP and other security headers to every response")
+	trace := flag.Bool("trace", false, "echo TRACE requests back, less credentials, for debugging intermediaries")
+	metricsPath := flag.String("metrics", "", "serve Prometheus metrics at this path, e.g. /metrics (empty disables it)")
+	profiling := flag.Bool("pprof", false, "serve runtime profiles under /debug/pprof/")
+	accessLog := flag.String("access-log", "", "log each request to stdout: common or json (empty disables it)")
+	flag.Parse()
+
+	cfg := defaults
+	if *configPath != "" {
+		if err := loadConfig(*configPath, &cfg); err != nil {
+			log.Fatalf("error loading config: %v", err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "listeners":
+			cfg.Listeners = *listeners
+		case "accept-loops":
+			cfg.AcceptLoops = *acceptLoops
+		case "port":
+			cfg.Listen = fmt.Sprintf(":%d", *port)
+		case "cert":
+			cfg.TLS.Cert = *certFile
+		case "key":
+			cfg.TLS.Key = *keyFile
+		case "client-ca":
+			cfg.TLS.ClientCA = *clientCA
+		case "http2":
+			cfg.HTTP2 = *http2
+		case "read-timeout":
+			cfg.ReadTimeout = *readTimeout
+		case "read-header-timeout":
+			cfg.ReadHeaderTimeout = *readHeaderTimeout
+		case "min-header-rate":
+			cfg.MinHeaderRate = *minHeaderRate
+		case "idle-timeout":
+			cfg.IdleTimeout = *idleTimeout
+		case "max-idle-conns":
+			cfg.MaxIdleConns = *maxIdleConns
+		case "max-requests-per-conn":
+			cfg.MaxRequestsPerConn = *maxRequestsPerConn
+		case "pipeline-depth":
+			cfg.PipelineDepth = *pipelineDepth
+		case "write-timeout":
+			cfg.WriteTimeout = *writeTimeout
+		case "write-stall-timeout":
+			cfg.WriteStallTimeout = *writeStallTimeout
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = *shutdownTimeout
+		case "max-body-size":
+			cfg.MaxBodySize = *maxBodySize
+		case "strict":
+			cfg.Strict = *strict
+		case "max-conns":
+			cfg.MaxConns = *maxConns
+		case "max-inflight":
+			cfg.MaxInflightRequests = *maxInflight
+		case "allow":
+			cfg.IPFilter.Allow = splitList(*allow)
+		case "deny":
+			cfg.IPFilter.Deny = splitList(*deny)
+		case "trusted-proxies":
+			cfg.TrustedProxies = splitList(*trusted)
+		case "video":
+			cfg.Video = *videoPath
+		case "record":
+			cfg.Record = *recordPath
+		case "security-headers":
+			if !*secHeaders {
+				cfg.SecurityHeaders = nil
+			} else if cfg.SecurityHeaders == nil {
+				cfg.SecurityHeaders = &securityHeaders{}
+			}
+		case "trace":
+			cfg.Trace = *trace
+		case "metrics":
+			cfg.Metrics = *metricsPath
+		case "pprof":
+			cfg.Pprof = *profiling
+		case "access-log":
+			cfg.AccessLog = *accessLog
+		}
+	})
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("error: invalid config: %v", err)
+	}
+
+	profile := request.Lenient
+	if cfg.Strict {
+		profile = request.Strict
+	}
+	opts := server.Options{
+		ReadTimeout:   cfg.ReadTimeout,
+		WriteTimeout:  cfg.WriteTimeout,
+		MaxBodySize:   cfg.MaxBodySize,
+		ParserProfile: profile,
+
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		MinHeaderRate:     cfg.MinHeaderRate,
+		IdleTimeout:       cfg.IdleTimeout,
+		WriteStallTimeout: cfg.WriteStallTimeout,
+		MaxIdleConns:      cfg.MaxIdleConns,
+
+		MaxConns:            cfg.MaxConns,
+		MaxRequestsPerConn:  cfg.MaxRequestsPerConn,
+		PipelineDepth:       cfg.PipelineDepth,
+		MaxInflightRequests: cfg.MaxInflightRequests,
+		Listeners:           cfg.Listeners,
+		AcceptLoops:         cfg.AcceptLoops,
+		EnableTrace:         cfg.Trace,
+		DrainRetryAfter:     cfg.DrainRetryAfter,
+		EnableHTTP2:         cfg.HTTP2,
+	}
+	if len(cfg.IPFilter.Allow) > 0 || len(cfg.IPFilter.Deny) > 0 {
+		// validate has already parsed the lists once.
+		opts.IPFilter, _ = server.ParseIPFilter(cfg.IPFilter.Allow, cfg.IPFilter.Deny)
+	}
+	opts.TrustedProxies, _ = server.ParsePrefixes(cfg.TrustedProxies)
+	if a := cfg.Abuse; a != nil {
+		opts.AbuseTracker = server.NewAbuseTracker(a.Threshold, a.Window, a.Cooldown)
+		opts.AbuseTracker.Tarpit = a.Tarpit
+		opts.AbuseTracker.OnBan = func(b server.Ban) {
+			log.Printf("Banned %s until %s", b.Addr, b.Until.Format(time.RFC3339))
+		}
+	}
+	if sh := cfg.Shed; sh != nil {
+		var detectors []server.OverloadDetector
+		if sh.Latency > 0 {
+			detectors = append(detectors, server.LatencyDetector(sh.Latency))
+		}
+		if sh.HeapBytes > 0 {
+			detectors = append(detectors, server.HeapDetector(sh.HeapBytes))
+		}
+		opts.OverloadDetector = server.OverloadFunc(func(load server.Load) bool {
+			for _, d := range detectors {
+				if d.Overloaded(load) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if cfg.TLS.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			log.Fatalf("error loading TLS certificate: %v", err)
+		}
+		opts.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if cfg.TLS.ClientCA != "" {
+			pem, err := os.ReadFile(cfg.TLS.ClientCA)
+			if err != nil {
+				log.Fatalf("error loading client CAs: %v", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				log.Fatalf("error loading client CAs: no certificates in %s", cfg.TLS.ClientCA)
+			}
+			opts.TLSConfig.ClientCAs = pool
+			opts.TLSConfig.ClientAuth, _ = clientAuthType(cfg.TLS.ClientAuth)
+		}
+	}
+
+	var challenges *server.Server
+	if ac := cfg.ACME; ac != nil {
+		m := &acme.Manager{DirectoryURL: ac.Directory, Domains: ac.Domains, Email: ac.Email}
+		if ac.CacheDir != "" {
+			m.Cache = acme.DirCache(ac.CacheDir)
+		}
+		opts.TLSConfig = m.TLSConfig()
+		httpListen := ac.HTTPListen
+		if httpListen == "" {
+			httpListen = ":80"
+		}
+		var err error
+		challenges, err = server.ServeWithOptions(httpListen, m.HTTPHandler(nil), server.Options{})
+		if err != nil {
+			log.Fatalf("error starting ACME challenge server: %v", err)
+		}
+		log.Printf("ACME challenges served on http://%s", challenges.Addr())
+	}
+
+	a := &app{video: cfg.Video, static: cfg.Static, earlyHints: cfg.EarlyHints}
+	if cfg.Metrics != "" {
+		a.metrics = server.NewMetrics()
+		a.metricsPath = cfg.Metrics
+	}
+	if cfg.Pprof {
+		a.debug = server.NewRouter()
+		a.debug.Mount("/debug/pprof", server.Profiler())
+	}
+	var handler server.Handler = a
+	if cfg.Record != "" {
+		f, err := os.OpenFile(cfg.Record, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("error opening recording: %v", err)
+		}
+		defer f.Close()
+		handler = recording.Handler(recording.NewRecorder(f), handler, func(err error) {
+			log.Printf("error recording request: %v", err)
+		})
+	}
+
+	if len(cfg.TLS.AllowedClients) > 0 {
+		handler = server.ClientCert(server.AllowCommonNames(cfg.TLS.AllowedClients...))(handler)
+	}
+	if cfg.SecurityHeaders != nil {
+		handler = server.SecurityHeaders(cfg.SecurityHeaders.Override)(handler)
+	}
+	if rl := cfg.RateLimit; rl != nil {
+		handler = server.NewRateLimiter(rl.Rate, rl.Burst).Middleware()(handler)
+	}
+	if a.metrics != nil {
+		handler = a.metrics.Middleware()(handler)
+	}
+	handler = server.RequestID()(handler)
+	switch cfg.AccessLog {
+	case "common":
+		handler = server.AccessLog(os.Stdout, server.LogCommon)(handler)
+	case "json":
+		handler = server.AccessLog(os.Stdout, server.LogJSON)(handler)
+	}
+
+	srv, err := server.ServeWithOptions(cfg.Listen, handler, opts)
+	if err != nil {
+		log.Fatalf("error starting server: %v", err)
+	}
+	if a.metrics != nil {
+		a.metrics.Observe(srv)
+	}
+	scheme := "http"
+	if opts.TLSConfig != nil {
+		scheme = "https"
+	}
+	log.Printf("Server started on %s://%s", scheme, srv.Addr())
+
+	err = graceful.Wait(cfg.ShutdownTimeout, func(ctx context.Context) error {
+		if challenges != nil {
+			challenges.Shutdown(ctx)
+		}
+		return srv.Shutdown(ctx)
+	})
+	log.Println("Server gracefully stopped")
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
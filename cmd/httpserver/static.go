@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+// serveStatic answers a request whose path starts with route.Prefix with the
+// matching file under route.Dir.
+func serveStatic(w *response.Writer, req *request.Request, route staticRoute, urlPath string) {
+	// Cleaning a rooted path drops every "..", keeping the file inside Dir.
+	rel := path.Clean("/" + strings.TrimPrefix(urlPath, route.Prefix))
+	name := filepath.Join(route.Dir, filepath.FromSlash(rel))
+
+	f, err := os.Open(name)
+	if err != nil {
+		respondHTML(w, response.StatusNotFound, "Not Found", "Nothing lives at this path.")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		respondHTML(w, response.StatusNotFound, "Not Found", "Nothing lives at this path.")
+		return
+	}
+	server.ServeContent(w, req, name, info.ModTime(), f)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+)
+
+// backend is one upstream server. healthy and active are read by every
+// request; fails belongs to the health checker.
+type backend struct {
+	base    string
+	healthy atomic.Bool
+	// active counts requests in flight, for least-connections.
+	active atomic.Int64
+	fails  int
+}
+
+// balancer picks a healthy backend for each request.
+type balancer struct {
+	backends  []*backend
+	leastConn bool
+	next      atomic.Uint64
+}
+
+func newBalancer(bases []string, leastConn bool) *balancer {
+	b := &balancer{leastConn: leastConn}
+	for _, base := range bases {
+		be := &backend{base: base}
+		// Backends start in rotation; the first check ejects dead ones.
+		be.healthy.Store(true)
+		b.backends = append(b.backends, be)
+	}
+	return b
+}
+
+// pick returns the backend for the next request, or nil when all are down.
+func (b *balancer) pick() *backend {
+	n := len(b.backends)
+	start := int(b.next.Add(1) - 1)
+
+	var best *backend
+	for i := 0; i < n; i++ {
+		be := b.backends[(start+i)%n]
+		if !be.healthy.Load() {
+			continue
+		}
+		if !b.leastConn {
+			return be
+		}
+		// Ties go to the earliest in rotation order, so equally loaded
+		// backends still take turns.
+		if best == nil || be.active.Load() < best.active.Load() {
+			best = be
+		}
+	}
+	return best
+}
+
+// eject takes be out of rotation after a failed request, until a health
+// check passes again.
+func (b *balancer) eject(be *backend, reason error) {
+	if be.healthy.CompareAndSwap(true, false) {
+		log.Printf("backend %s ejected: %v", be.base, reason)
+	}
+}
+
+// healthChecker probes every backend's health path on a fixed interval.
+type healthChecker struct {
+	client    *client.Client
+	path      string
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+}
+
+func (h *healthChecker) run(ctx context.Context, b *balancer) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		for _, be := range b.backends {
+			h.check(ctx, be)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check probes be once. threshold consecutive failures eject a healthy
+// backend; one success re-admits an ejected one.
+func (h *healthChecker) check(ctx context.Context, be *backend) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	req, err := client.NewRequest("GET", be.base+h.path).Context(ctx).Build()
+	if err != nil {
+		log.Printf("backend %s: %v", be.base, err)
+		return
+	}
+	resp, err := h.client.Do(req)
+	if err == nil && resp.StatusLine.StatusCode >= 500 {
+		err = fmt.Errorf("status %d", resp.StatusLine.StatusCode)
+	}
+
+	if err != nil {
+		be.fails++
+		if be.fails >= h.threshold && be.healthy.CompareAndSwap(true, false) {
+			log.Printf("backend %s ejected after %d failed checks: %v", be.base, be.fails, err)
+		}
+		return
+	}
+	be.fails = 0
+	if be.healthy.CompareAndSwap(false, true) {
+		log.Printf("backend %s re-admitted", be.base)
+	}
+}
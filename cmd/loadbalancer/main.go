@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/relay"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+type proxy struct {
+	balancer *balancer
+	client   *client.Client
+}
+
+func (p *proxy) ServeHTTP(w *response.Writer, req *request.Request) {
+	be := p.balancer.pick()
+	if be == nil {
+		writeText(w, response.StatusServiceUnavailable, "no healthy backends\n")
+		return
+	}
+	be.active.Add(1)
+	defer be.active.Add(-1)
+
+	target := req.RequestLine.RequestTarget
+	b := client.NewRequest(req.RequestLine.Method, be.base+target)
+	relay.ForEachEndToEnd(&req.Headers, func(key, value string) {
+		if key != "host" && key != "content-length" {
+			b.Header(key, value)
+		}
+	})
+	upstreamReq, err := b.Body(req.Body).Build()
+	if err != nil {
+		writeText(w, response.StatusBadRequest, err.Error()+"\n")
+		return
+	}
+
+	hasBody := false
+	_, err = p.client.DoStream(upstreamReq, func(resp *response.Response) io.Writer {
+		var body io.Writer
+		body, hasBody = relay.WriteHead(w, resp, req.RequestLine.Method, nil)
+		return body
+	})
+	if err != nil {
+		log.Printf("%s %s via %s: %v", req.RequestLine.Method, target, be.base, err)
+		if w.StatusCode() == 0 {
+			// The backend failed before answering; keep traffic off it until
+			// a health check says otherwise.
+			p.balancer.eject(be, err)
+			writeText(w, response.StatusBadGateway, "upstream error\n")
+		}
+		return
+	}
+	if hasBody {
+		w.WriteChunkedBodyDone()
+	}
+}
+
+func writeText(w *response.Writer, statusCode response.StatusCode, body string) {
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+// parseBackends splits a comma-separated list of base URLs.
+func parseBackends(list string) ([]string, error) {
+	var bases []string
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("backend %q must be an http:// or https:// URL", raw)
+		}
+		bases = append(bases, strings.TrimSuffix(raw, "/"))
+	}
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("no backends given")
+	}
+	return bases, nil
+}
+
+func main() {
+	port := flag.Int("port", 42069, "port to listen on")
+	backendList := flag.String("backends", "", "comma-separated backend base URLs, e.g. http://10.0.0.1:8080,http://10.0.0.2:8080")
+	strategy := flag.String("strategy", "round-robin", "backend selection: round-robin or least-conn")
+	healthPath := flag.String("health-path", "/", "path probed on each backend")
+	healthInterval := flag.Duration("health-interval", 5*time.Second, "time between health checks")
+	healthTimeout := flag.Duration("health-timeout", 2*time.Second, "time allowed for one health check")
+	healthFails := flag.Int("health-fails", 2, "consecutive failed checks that eject a backend")
+	shutdownTimeout := flag.Duration("shutdown-timeout", graceful.DefaultTimeout, "how long in-flight requests may run after SIGINT or SIGTERM")
+	flag.Parse()
+
+	bases, err := parseBackends(*backendList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *strategy != "round-robin" && *strategy != "least-conn" {
+		fmt.Fprintf(os.Stderr, "error: unknown strategy %q\n", *strategy)
+		os.Exit(2)
+	}
+	if *healthInterval <= 0 || *healthTimeout <= 0 || *healthFails < 1 {
+		fmt.Fprintln(os.Stderr, "error: health check settings must be positive")
+		os.Exit(2)
+	}
+
+	c := client.NewClient()
+	c.MaxIdleConnsPerHost = 32
+	c.CheckRedirect = func(*request.Request, []*request.Request) error {
+		return client.ErrUseLastResponse
+	}
+	c.DisableCompression = true
+
+	b := newBalancer(bases, *strategy == "least-conn")
+	checker := &healthChecker{
+		client:    c,
+		path:      *healthPath,
+		interval:  *healthInterval,
+		timeout:   *healthTimeout,
+		threshold: *healthFails,
+	}
+	ctx, stopChecks := context.WithCancel(context.Background())
+	go checker.run(ctx, b)
+
+	// The request ID travels to the backend with the other request headers.
+	srv, err := server.Serve(*port, server.RequestID()(&proxy{balancer: b, client: c}))
+	if err != nil {
+		log.Fatalf("error starting load balancer: %v", err)
+	}
+	log.Printf("Balancing port %d across %s (%s)", *port, strings.Join(bases, ", "), *strategy)
+
+	err = graceful.Wait(*shutdownTimeout, srv.Shutdown)
+	stopChecks()
+	c.CloseIdleConnections()
+	log.Println("Load balancer stopped")
+	if err != nil {
+		os.Exit(1)
+	}
+}
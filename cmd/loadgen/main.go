@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+)
+
+// result is one worker's tally. Workers keep their own and merge at the end
+// so the hot loop never contends on a lock.
+type result struct {
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    map[string]int
+}
+
+func newResult() *result {
+	return &result{statuses: map[int]int{}, errors: map[string]int{}}
+}
+
+func (r *result) merge(other *result) {
+	r.latencies = append(r.latencies, other.latencies...)
+	for code, n := range other.statuses {
+		r.statuses[code] += n
+	}
+	for msg, n := range other.errors {
+		r.errors[msg] += n
+	}
+}
+
+func main() {
+	concurrency := flag.Int("c", 10, "number of concurrent workers, each holding its own connection")
+	duration := flag.Duration("d", 10*time.Second, "how long to run")
+	requests := flag.Int("n", 0, "stop after this many requests in total (0 means run for -d)")
+	method := flag.String("X", "GET", "request method")
+	body := flag.String("body", "", "request body")
+	timeout := flag.Duration("timeout", 5*time.Second, "per-request timeout")
+	noKeepAlive := flag.Bool("disable-keepalive", false, "open a new connection for every request")
+	insecure := flag.Bool("k", false, "skip TLS certificate verification")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] URL\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *concurrency < 1 || *duration <= 0 || *requests < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetFlags(0)
+	target := flag.Arg(0)
+
+	// Fail fast on a bad URL rather than once per request.
+	if _, err := client.NewRequest(*method, target).Build(); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	c := client.NewClient()
+	c.Timeout = *timeout
+	c.MaxIdleConnsPerHost = *concurrency
+	c.DisableKeepAlives = *noKeepAlive
+	if *insecure {
+		c.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	defer c.CloseIdleConnections()
+
+	// Ctrl-C ends the run early but still prints the report.
+	ctx, stop := graceful.NotifyContext(context.Background())
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	// budget hands out request slots when -n is set.
+	var budget chan struct{}
+	if *requests > 0 {
+		budget = make(chan struct{}, *requests)
+		for i := 0; i < *requests; i++ {
+			budget <- struct{}{}
+		}
+		close(budget)
+	}
+
+	fmt.Fprintf(os.Stderr, "Running %s %s with %d workers for %v\n", *method, target, *concurrency, *duration)
+
+	results := make([]*result, *concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range results {
+		results[i] = newResult()
+		wg.Add(1)
+		go func(r *result) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if budget != nil {
+					if _, ok := <-budget; !ok {
+						return
+					}
+				}
+				b := client.NewRequest(*method, target)
+				if *body != "" {
+					b.Body([]byte(*body))
+				}
+				req, _ := b.Context(ctx).Build()
+
+				begin := time.Now()
+				resp, err := c.Do(req)
+				if err != nil {
+					// Requests cut short by the end of the run are not errors.
+					if ctx.Err() == nil {
+						r.errors[err.Error()]++
+					}
+					continue
+				}
+				r.latencies = append(r.latencies, time.Since(begin))
+				r.statuses[resp.StatusLine.StatusCode]++
+			}
+		}(results[i])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	stop()
+
+	total := newResult()
+	for _, r := range results {
+		total.merge(r)
+	}
+	report(os.Stdout, total, elapsed, c.PoolStats())
+}
+
+func report(w io.Writer, r *result, elapsed time.Duration, stats client.PoolStats) {
+	errCount := 0
+	for _, n := range r.errors {
+		errCount += n
+	}
+	completed := len(r.latencies)
+
+	fmt.Fprintf(w, "Requests:    %d completed, %d failed in %v\n", completed, errCount, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput:  %.1f req/s\n", float64(completed)/elapsed.Seconds())
+	fmt.Fprintf(w, "Connections: %d dialed, %d reused\n", stats.Dials, stats.Reuses)
+
+	if completed > 0 {
+		sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+		var sum time.Duration
+		for _, l := range r.latencies {
+			sum += l
+		}
+		fmt.Fprintln(w, "Latency:")
+		fmt.Fprintf(w, "  mean  %v\n", (sum / time.Duration(completed)).Round(time.Microsecond))
+		for _, p := range []float64{50, 90, 99} {
+			fmt.Fprintf(w, "  p%-4g %v\n", p, percentile(r.latencies, p).Round(time.Microsecond))
+		}
+		fmt.Fprintf(w, "  max   %v\n", r.latencies[completed-1].Round(time.Microsecond))
+	}
+
+	if len(r.statuses) > 0 {
+		fmt.Fprintln(w, "Status codes:")
+		codes := make([]int, 0, len(r.statuses))
+		for code := range r.statuses {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "  %d  %d\n", code, r.statuses[code])
+		}
+	}
+
+	if errCount > 0 {
+		fmt.Fprintln(w, "Errors:")
+		msgs := make([]string, 0, len(r.errors))
+		for msg := range r.errors {
+			msgs = append(msgs, msg)
+		}
+		sort.Slice(msgs, func(i, j int) bool { return r.errors[msgs[i]] > r.errors[msgs[j]] })
+		for _, msg := range msgs {
+			fmt.Fprintf(w, "  %6d  %s\n", r.errors[msg], msg)
+		}
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return sorted[rank]
+}
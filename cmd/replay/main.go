@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/recording"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+)
+
+func main() {
+	target := flag.String("target", "http://localhost:42069", "base URL the recorded requests are sent to")
+	speed := flag.Float64("speed", 1, "pacing relative to the recording: 2 replays twice as fast, 0 sends back to back")
+	keepHost := flag.Bool("keep-host", false, "send the recorded Host header instead of the target's")
+	timeout := flag.Duration("timeout", 30*time.Second, "per-request timeout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] RECORDING\n\nSends the requests in a recording made with -record again.\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *speed < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetFlags(0)
+
+	base, err := url.Parse(*target)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		log.Fatalf("error: -target must be an http:// or https:// URL")
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	entries, err := recording.ReadEntries(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("error: reading %s: %v", flag.Arg(0), err)
+	}
+
+	ctx, stop := graceful.NotifyContext(context.Background())
+	defer stop()
+
+	c := client.NewClient()
+	c.Timeout = *timeout
+	c.CheckRedirect = func(*request.Request, []*request.Request) error {
+		return client.ErrUseLastResponse
+	}
+	defer c.CloseIdleConnections()
+
+	start := time.Now()
+	sent, failed, mismatched := 0, 0, 0
+	for i, e := range entries {
+		if *speed > 0 && i > 0 {
+			// Keep each request's offset from the first, scaled by speed.
+			due := start.Add(time.Duration(float64(e.Time.Sub(entries[0].Time)) / *speed))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		req, err := e.Request()
+		if err != nil {
+			log.Printf("#%d %s %s: skipped: %v", i+1, e.Method, e.Target, err)
+			failed++
+			continue
+		}
+		retarget(req, base, *keepHost)
+
+		sent++
+		began := time.Now()
+		resp, err := c.Do(req.WithContext(ctx))
+		if err != nil {
+			log.Printf("#%d %s %s: %v", i+1, e.Method, e.Target, err)
+			failed++
+			continue
+		}
+
+		note := ""
+		if e.Status != 0 && e.Status != resp.StatusLine.StatusCode {
+			note = fmt.Sprintf(" (recorded %d)", e.Status)
+			mismatched++
+		}
+		fmt.Printf("#%d %s %s -> %d%s in %v\n", i+1, e.Method, e.Target, resp.StatusLine.StatusCode, note, time.Since(began).Round(time.Microsecond))
+	}
+
+	fmt.Printf("%d of %d requests sent in %v: %d failed, %d with a different status\n",
+		sent, len(entries), time.Since(start).Round(time.Millisecond), failed, mismatched)
+	if failed > 0 || mismatched > 0 {
+		os.Exit(1)
+	}
+}
+
+// retarget points req at base, keeping its path and query. Absolute-form
+// targets recorded by a proxy are reduced to their path first.
+func retarget(req *request.Request, base *url.URL, keepHost bool) {
+	target := req.RequestLine.RequestTarget
+	if u, err := url.Parse(target); err == nil && u.IsAbs() {
+		target = u.RequestURI()
+	}
+	req.RequestLine.RequestTarget = strings.TrimSuffix(base.String(), "/") + target
+	if !keepHost {
+		req.Headers.Delete("Host")
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+)
+
+// dumpConn tees every byte read from and written to a connection into a
+// hex+ASCII dump on out, so framing problems such as bare LFs or stray
+// whitespace are visible.
+type dumpConn struct {
+	net.Conn
+	out io.Writer
+}
+
+func (c *dumpConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		fmt.Fprintf(c.out, "<<< read %d bytes from %s\n%s", n, c.RemoteAddr(), hex.Dump(p[:n]))
+	}
+	return n, err
+}
+
+func (c *dumpConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		fmt.Fprintf(c.out, ">>> wrote %d bytes to %s\n%s", n, c.RemoteAddr(), hex.Dump(p[:n]))
+	}
+	return n, err
+}
@@ -1,31 +1,95 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"strconv"
 
+	"github.com/kahvecikaan/httpfromtcp/internal/graceful"
+	"github.com/kahvecikaan/httpfromtcp/internal/recording"
 	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
 )
 
 func main() {
-	listener, err := net.Listen("tcp", ":42069")
+	host := flag.String("host", "", "address to listen on (empty means all interfaces)")
+	port := flag.Int("port", 42069, "port to listen on")
+	verbose := flag.Bool("v", false, "also print the body and the remote address")
+	dumpRaw := flag.Bool("dump-raw", false, "hex dump every byte read from and written to each connection on stderr")
+	recordPath := flag.String("record", "", "append every request to this file for cmd/replay")
+	maxBodySize := flag.Int64("max-body-size", request.MaxContentLength, "largest request body accepted, in bytes")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n\nPrints each HTTP request received on a TCP port and answers it with 200 OK.\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *port < 0 || *port > 65535 {
+		fmt.Fprintf(os.Stderr, "invalid port %d: must be between 0 and 65535\n", *port)
+		os.Exit(2)
+	}
+	if *maxBodySize <= 0 {
+		fmt.Fprintf(os.Stderr, "invalid max body size %d: must be positive\n", *maxBodySize)
+		os.Exit(2)
+	}
+
+	var rec *recording.Recorder
+	if *recordPath != "" {
+		f, err := os.OpenFile(*recordPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("error: opening recording: %v", err)
+		}
+		defer f.Close()
+		rec = recording.NewRecorder(f)
+	}
+
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatal("error: ", err)
+		log.Fatalf("error: listening on %s: %v", addr, err)
 	}
-	defer listener.Close()
+	log.Printf("listening on %s", listener.Addr())
 
+	// On SIGINT or SIGTERM stop accepting; the request being printed, if
+	// any, is finished first. A second signal kills the process.
+	ctx, stop := graceful.NotifyContext(context.Background())
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		listener.Close()
+	}()
+
+	opts := request.Options{MaxBodySize: *maxBodySize}
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Fatal("error: ", err)
+			if ctx.Err() != nil {
+				log.Println("stopped")
+				return
+			}
+			log.Fatal("error: accepting connection: ", err)
+		}
+		if *dumpRaw {
+			conn = &dumpConn{Conn: conn, out: os.Stderr}
 		}
 
-		req, err := request.RequestFromReader(conn)
+		req, err := request.RequestFromReaderWithOptions(conn, opts)
 		if err != nil {
-			log.Fatal("error", err)
+			// A bad request only affects its own connection.
+			log.Printf("error: reading request from %s: %v", conn.RemoteAddr(), err)
+			respond(conn, response.StatusBadRequest, err.Error()+"\n")
+			conn.Close()
+			continue
 		}
 
+		if *verbose {
+			fmt.Printf("Connection from %s\n", conn.RemoteAddr())
+		}
 		fmt.Printf("Request line:\n")
 		fmt.Printf("- Method: %s\n", req.RequestLine.Method)
 		fmt.Printf("- Target: %s\n", req.RequestLine.RequestTarget)
@@ -34,8 +98,28 @@ func main() {
 		req.Headers.ForEach(func(key, value string) {
 			fmt.Printf("- %s: %s\n", key, value)
 		})
+		if *verbose {
+			fmt.Printf("Body:\n%s\n", req.Body)
+		}
 		fmt.Printf("\n")
 
+		respond(conn, response.StatusOK, "Request received.\n")
+		if rec != nil {
+			if err := rec.Record(req, conn.RemoteAddr().String(), int(response.StatusOK)); err != nil {
+				log.Printf("error: recording request: %v", err)
+			}
+		}
 		conn.Close()
 	}
 }
+
+// respond writes a minimal plain-text response so clients such as curl see
+// a complete HTTP exchange rather than a dropped connection.
+func respond(conn net.Conn, statusCode response.StatusCode, body string) {
+	w := response.NewWriter(conn)
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	if _, err := w.WriteBody([]byte(body)); err != nil {
+		log.Printf("error: writing response to %s: %v", conn.RemoteAddr(), err)
+	}
+}
@@ -0,0 +1,55 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var ErrCacheMiss = fmt.Errorf("acme: cache miss")
+
+// Cache stores the account key and the certificates a Manager obtains, so
+// they survive restarts. Keys are domain names and "acme_account+key".
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	// Get returns the data stored under key, or ErrCacheMiss.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put stores data under key, replacing what was there.
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// DirCache is a Cache keeping each entry in a file of that name in the
+// directory, which is created on the first Put. Files are readable by the
+// owner only, since they hold private keys.
+type DirCache string
+
+func (d DirCache) Get(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(string(d), filepath.Base(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	return data, err
+}
+
+// Put writes to a temporary file and renames it over the entry, so a crash
+// never leaves half a key behind.
+func (d DirCache) Put(_ context.Context, key string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(string(d), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(string(d), filepath.Base(key)))
+}
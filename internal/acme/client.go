@@ -0,0 +1,266 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// Error is a problem document returned by the ACME server (RFC 8555
+// section 6.7).
+type Error struct {
+	StatusCode int
+	Type       string `json:"type"`
+	Detail     string `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("acme: %d %s: %s", e.StatusCode, e.Type, e.Detail)
+}
+
+const errBadNonce = "urn:ietf:params:acme:error:badNonce"
+
+// directory lists the resource URLs of an ACME server.
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *Error       `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+	Error  *Error `json:"error"`
+}
+
+// acmeClient speaks ACME to one server for one account: it keeps the
+// directory, the nonces handed out with each response and the account
+// URL that signs every request after registration.
+type acmeClient struct {
+	directoryURL string
+	key          *ecdsa.PrivateKey
+	http         *client.Client
+	// pollInterval is the wait between polls of a pending resource.
+	pollInterval time.Duration
+
+	mu     sync.Mutex
+	dir    *directory
+	kid    string
+	nonces []string
+}
+
+func (c *acmeClient) discover(ctx context.Context) (*directory, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dir != nil {
+		return c.dir, nil
+	}
+	req, err := client.NewRequest("GET", c.directoryURL).Context(ctx).Build()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	var dir directory
+	if err := json.Unmarshal(resp.Body, &dir); err != nil {
+		return nil, fmt.Errorf("acme: decoding directory: %w", err)
+	}
+	c.dir = &dir
+	return c.dir, nil
+}
+
+// nonce returns an unused nonce, asking for a fresh one when none is left
+// over from earlier responses.
+func (c *acmeClient) nonce(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	c.mu.Unlock()
+
+	dir, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := client.NewRequest("HEAD", dir.NewNonce).Context(ctx).Build()
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	nonce := resp.Headers.Get("replay-nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("acme: no Replay-Nonce from %s", dir.NewNonce)
+	}
+	return nonce, nil
+}
+
+// post sends payload to url signed with the account key and returns the
+// successful response. A nil payload makes a POST-as-GET. A rejected
+// nonce is retried once with a fresh one, as section 6.5 expects.
+func (c *acmeClient) post(ctx context.Context, url string, payload any) (*response.Response, error) {
+	for attempt := 0; ; attempt++ {
+		nonce, err := c.nonce(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		kid := c.kid
+		c.mu.Unlock()
+		body, err := signJWS(c.key, kid, nonce, url, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := client.NewRequest("POST", url).
+			Header("Content-Type", "application/jose+json").
+			Body(body).
+			Context(ctx).
+			Build()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if nonce := resp.Headers.Get("replay-nonce"); nonce != "" {
+			c.mu.Lock()
+			c.nonces = append(c.nonces, nonce)
+			c.mu.Unlock()
+		}
+
+		err = checkResponse(resp)
+		var acmeErr *Error
+		if errors.As(err, &acmeErr) && acmeErr.Type == errBadNonce && attempt == 0 {
+			continue
+		}
+		return resp, err
+	}
+}
+
+// postJSON is post decoding the response body into v.
+func (c *acmeClient) postJSON(ctx context.Context, url string, payload, v any) (*response.Response, error) {
+	resp, err := c.post(ctx, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body, v); err != nil {
+		return nil, fmt.Errorf("acme: decoding %s: %w", url, err)
+	}
+	return resp, nil
+}
+
+// checkResponse turns a non-2xx response into an *Error.
+func checkResponse(resp *response.Response) error {
+	code := resp.StatusLine.StatusCode
+	if code >= 200 && code < 300 {
+		return nil
+	}
+	e := &Error{StatusCode: code}
+	if json.Unmarshal(resp.Body, e) != nil || e.Type == "" {
+		e.Detail = string(resp.Body)
+	}
+	return e
+}
+
+// register creates the account for the key, or finds the existing one,
+// and remembers its URL for signing.
+func (c *acmeClient) register(ctx context.Context, email string) error {
+	dir, err := c.discover(ctx)
+	if err != nil {
+		return err
+	}
+	account := map[string]any{"termsOfServiceAgreed": true}
+	if email != "" {
+		account["contact"] = []string{"mailto:" + email}
+	}
+	resp, err := c.post(ctx, dir.NewAccount, account)
+	if err != nil {
+		return fmt.Errorf("acme: registering account: %w", err)
+	}
+	kid := resp.Headers.Get("location")
+	if kid == "" {
+		return fmt.Errorf("acme: account has no Location")
+	}
+	c.mu.Lock()
+	c.kid = kid
+	c.mu.Unlock()
+	return nil
+}
+
+// newOrder asks for a certificate for domains and returns the order and
+// its URL.
+func (c *acmeClient) newOrder(ctx context.Context, domains []string) (*order, string, error) {
+	dir, err := c.discover(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	ids := make([]identifier, len(domains))
+	for i, d := range domains {
+		ids[i] = identifier{Type: "dns", Value: d}
+	}
+	var o order
+	resp, err := c.postJSON(ctx, dir.NewOrder, map[string]any{"identifiers": ids}, &o)
+	if err != nil {
+		return nil, "", fmt.Errorf("acme: creating order: %w", err)
+	}
+	return &o, resp.Headers.Get("location"), nil
+}
+
+// poll fetches url into v until done reports true, giving up with the
+// context.
+func (c *acmeClient) poll(ctx context.Context, url string, v any, done func() bool) error {
+	for {
+		if _, err := c.postJSON(ctx, url, nil, v); err != nil {
+			return err
+		}
+		if done() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
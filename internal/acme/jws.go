@@ -0,0 +1,75 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// b64 is the unpadded base64url encoding JWS uses throughout.
+var b64 = base64.RawURLEncoding
+
+// jwk returns the JSON Web Key of an ECDSA P-256 public key with its members
+// in lexicographic order, as its thumbprint needs (RFC 7638).
+func jwk(pub *ecdsa.PublicKey) string {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	return fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`,
+		b64.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+		b64.EncodeToString(pub.Y.FillBytes(make([]byte, size))))
+}
+
+// thumbprint returns the base64url SHA-256 thumbprint of pub's JWK.
+func thumbprint(pub *ecdsa.PublicKey) string {
+	sum := sha256.Sum256([]byte(jwk(pub)))
+	return b64.EncodeToString(sum[:])
+}
+
+// keyAuthorization is the response to a challenge token: the token and
+// the account key's thumbprint (RFC 8555 section 8.1).
+func keyAuthorization(token string, pub *ecdsa.PublicKey) string {
+	return token + "." + thumbprint(pub)
+}
+
+// signJWS signs payload for url in the flattened JSON serialization ACME
+// uses (RFC 8555 section 6.2). The key is named by kid, the account URL,
+// or embedded as a JWK when kid is empty, as for creating the account. A
+// nil payload is sent empty, which makes the request a POST-as-GET.
+func signJWS(key *ecdsa.PrivateKey, kid, nonce, url string, payload any) ([]byte, error) {
+	protected := map[string]any{"alg": "ES256", "nonce": nonce, "url": url}
+	if kid != "" {
+		protected["kid"] = kid
+	} else {
+		protected["jwk"] = json.RawMessage(jwk(&key.PublicKey))
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if payload != nil {
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	signingInput := b64.EncodeToString(header) + "." + b64.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	// ES256 signatures are r and s as fixed-size big-endian integers.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return json.Marshal(map[string]string{
+		"protected": b64.EncodeToString(header),
+		"payload":   b64.EncodeToString(body),
+		"signature": b64.EncodeToString(sig),
+	})
+}
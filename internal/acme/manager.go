@@ -0,0 +1,454 @@
+// Package acme obtains and renews TLS certificates from an ACME certificate
+// authority such as Let's Encrypt (RFC 8555), answering HTTP-01 challenges
+// with this repository's own server and client.
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+// LetsEncryptURL is the directory of Let's Encrypt's production CA.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// DefaultRenewBefore is how long before expiry certificates are renewed
+// when Manager.RenewBefore is zero.
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// challengePath is where HTTP-01 challenge tokens are fetched from.
+const challengePath = "/.well-known/acme-challenge/"
+
+// accountKey is the Cache key of the account's private key.
+const accountKey = "acme_account+key"
+
+var ErrHostNotAllowed = fmt.Errorf("acme: host not in Domains")
+
+// Manager obtains a certificate for each of its Domains on the first TLS
+// handshake that asks for it, keeps it in memory and in Cache, and renews
+// it in the background once it comes within RenewBefore of expiry. Its
+// HTTPHandler must be reachable on port 80 of every domain for the CA's
+// HTTP-01 challenges.
+type Manager struct {
+	// DirectoryURL is the ACME directory of the CA. Empty means
+	// LetsEncryptURL.
+	DirectoryURL string
+	// Domains are the only names certificates are requested for;
+	// handshakes for other names fail with ErrHostNotAllowed.
+	Domains []string
+	// Email, if set, is given to the CA as the account's contact.
+	Email string
+	// Cache, if set, stores the account key and certificates.
+	Cache Cache
+	// RenewBefore is how long before expiry a certificate is renewed.
+	// Zero means DefaultRenewBefore.
+	RenewBefore time.Duration
+	// Client talks to the CA. Nil means client.NewClient().
+	Client *client.Client
+
+	// pollInterval is the wait between polls of pending authorizations
+	// and orders. Zero means a second.
+	pollInterval time.Duration
+
+	mu       sync.Mutex
+	acct     *acmeClient
+	certs    map[string]*tls.Certificate
+	tokens   map[string]string
+	obtains  map[string]*obtainCall
+	renewing map[string]bool
+}
+
+// obtainCall is a certificate being fetched, shared by the handshakes
+// that wait for it.
+type obtainCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+// TLSConfig returns a TLS configuration that gets certificates from m.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: m.GetCertificate}
+}
+
+// GetCertificate returns the certificate for the handshake's server name,
+// from memory, from Cache or, failing both, from the CA. It is meant for
+// tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name == "" {
+		return nil, fmt.Errorf("acme: client sent no server name")
+	}
+	if !m.allowed(name) {
+		return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, name)
+	}
+	ctx := hello.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	m.mu.Lock()
+	if cert, ok := m.certs[name]; ok {
+		if m.dueForRenewal(cert) && !m.renewing[name] {
+			m.renewing[name] = true
+			go m.renew(name)
+		}
+		m.mu.Unlock()
+		return cert, nil
+	}
+	call, ok := m.obtains[name]
+	if !ok {
+		call = &obtainCall{done: make(chan struct{})}
+		m.init()
+		m.obtains[name] = call
+		go m.obtainInto(call, name)
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.cert, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// init makes the maps; m.mu must be held.
+func (m *Manager) init() {
+	if m.certs == nil {
+		m.certs = map[string]*tls.Certificate{}
+		m.tokens = map[string]string{}
+		m.obtains = map[string]*obtainCall{}
+		m.renewing = map[string]bool{}
+	}
+}
+
+func (m *Manager) allowed(name string) bool {
+	for _, d := range m.Domains {
+		if strings.EqualFold(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) dueForRenewal(cert *tls.Certificate) bool {
+	before := m.RenewBefore
+	if before == 0 {
+		before = DefaultRenewBefore
+	}
+	return time.Until(cert.Leaf.NotAfter) < before
+}
+
+// obtainInto loads name's certificate from the cache, or fetches a new one
+// when it is missing or due for renewal, and completes call. It runs apart
+// from the handshake, so one that gives up does not cancel the order.
+func (m *Manager) obtainInto(call *obtainCall, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cert, err := m.cached(ctx, name)
+	if err != nil || m.dueForRenewal(cert) {
+		cert, err = m.obtain(ctx, name)
+	}
+	call.cert, call.err = cert, err
+
+	m.mu.Lock()
+	delete(m.obtains, name)
+	if err == nil {
+		m.certs[name] = cert
+	}
+	m.mu.Unlock()
+	close(call.done)
+}
+
+// renew replaces name's certificate with a new one, keeping the old one in
+// use if that fails; the next handshake tries again.
+func (m *Manager) renew(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	cert, err := m.obtain(ctx, name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.renewing, name)
+	if err != nil {
+		log.Printf("acme: renewing certificate for %s: %v", name, err)
+		return
+	}
+	m.certs[name] = cert
+}
+
+// cached loads name's certificate from the cache.
+func (m *Manager) cached(ctx context.Context, name string) (*tls.Certificate, error) {
+	if m.Cache == nil {
+		return nil, ErrCacheMiss
+	}
+	data, err := m.Cache.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return parseBundle(data)
+}
+
+// account returns the client for the CA, loading or creating the account
+// key and registering it on first use.
+func (m *Manager) account(ctx context.Context) (*acmeClient, error) {
+	m.mu.Lock()
+	acct := m.acct
+	m.mu.Unlock()
+	if acct != nil {
+		return acct, nil
+	}
+
+	key, err := m.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	acct = &acmeClient{
+		directoryURL: m.DirectoryURL,
+		key:          key,
+		http:         m.Client,
+		pollInterval: m.pollInterval,
+	}
+	if acct.directoryURL == "" {
+		acct.directoryURL = LetsEncryptURL
+	}
+	if acct.http == nil {
+		acct.http = client.NewClient()
+	}
+	if acct.pollInterval == 0 {
+		acct.pollInterval = time.Second
+	}
+	if err := acct.register(ctx, m.Email); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.acct == nil {
+		m.acct = acct
+	}
+	return m.acct, nil
+}
+
+func (m *Manager) accountKey(ctx context.Context) (*ecdsa.PrivateKey, error) {
+	if m.Cache != nil {
+		data, err := m.Cache.Get(ctx, accountKey)
+		if err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil {
+				return nil, fmt.Errorf("acme: cached account key is not PEM")
+			}
+			return x509.ParseECPrivateKey(block.Bytes)
+		}
+		if !errors.Is(err, ErrCacheMiss) {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if m.Cache != nil {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := m.Cache.Put(ctx, accountKey, data); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// obtain runs an order for name to the end: it answers the HTTP-01
+// challenge of each pending authorization, finalizes the order with a new
+// key and stores the issued certificate in the cache.
+func (m *Manager) obtain(ctx context.Context, name string) (*tls.Certificate, error) {
+	acct, err := m.account(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o, orderURL, err := acct.newOrder(ctx, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	for _, authzURL := range o.Authorizations {
+		if err := m.authorize(ctx, acct, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: name},
+		DNSNames: []string{name},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := acct.postJSON(ctx, o.Finalize, map[string]string{"csr": b64.EncodeToString(csr)}, o); err != nil {
+		return nil, fmt.Errorf("acme: finalizing order: %w", err)
+	}
+	err = acct.poll(ctx, orderURL, o, func() bool { return o.Status != "pending" && o.Status != "ready" && o.Status != "processing" })
+	if err != nil {
+		return nil, err
+	}
+	if o.Status != "valid" {
+		return nil, fmt.Errorf("acme: order for %s is %s: %v", name, o.Status, o.Error)
+	}
+
+	resp, err := acct.post(ctx, o.Certificate, nil)
+	if err != nil {
+		return nil, fmt.Errorf("acme: downloading certificate: %w", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	bundle := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), resp.Body...)
+	cert, err := parseBundle(bundle)
+	if err != nil {
+		return nil, err
+	}
+	if m.Cache != nil {
+		if err := m.Cache.Put(ctx, name, bundle); err != nil {
+			return nil, err
+		}
+	}
+	return cert, nil
+}
+
+// authorize completes one authorization with its HTTP-01 challenge,
+// serving the key authorization from HTTPHandler until the CA has checked
+// it.
+func (m *Manager) authorize(ctx context.Context, acct *acmeClient, authzURL string) error {
+	var authz authorization
+	if _, err := acct.postJSON(ctx, authzURL, nil, &authz); err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			chal = &authz.Challenges[i]
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("acme: no http-01 challenge for %s", authz.Identifier.Value)
+	}
+
+	m.mu.Lock()
+	m.init()
+	m.tokens[chal.Token] = keyAuthorization(chal.Token, &acct.key.PublicKey)
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.tokens, chal.Token)
+		m.mu.Unlock()
+	}()
+
+	if _, err := acct.post(ctx, chal.URL, struct{}{}); err != nil {
+		return fmt.Errorf("acme: accepting challenge: %w", err)
+	}
+	err := acct.poll(ctx, authzURL, &authz, func() bool { return authz.Status != "pending" })
+	if err != nil {
+		return err
+	}
+	if authz.Status != "valid" {
+		detail := ""
+		for _, c := range authz.Challenges {
+			if c.Error != nil {
+				detail = ": " + c.Error.Error()
+			}
+		}
+		return fmt.Errorf("acme: authorization for %s is %s%s", authz.Identifier.Value, authz.Status, detail)
+	}
+	return nil
+}
+
+// HTTPHandler answers the CA's HTTP-01 challenges and hands every other
+// request to fallback. A nil fallback redirects to https, for hosts in
+// Domains only; others get 421 Misdirected Request.
+func (m *Manager) HTTPHandler(fallback server.Handler) server.Handler {
+	return server.HandlerFunc(func(w *response.Writer, req *request.Request) {
+		path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		if token, ok := strings.CutPrefix(path, challengePath); ok {
+			m.mu.Lock()
+			keyAuth, found := m.tokens[token]
+			m.mu.Unlock()
+
+			status := response.StatusOK
+			if !found {
+				status, keyAuth = response.StatusNotFound, "unknown challenge token"
+			}
+			w.WriteStatusLine(status)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(keyAuth)))
+			w.WriteBody([]byte(keyAuth))
+			return
+		}
+		if fallback != nil {
+			fallback.ServeHTTP(w, req)
+			return
+		}
+		host := req.Headers.Get("host")
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+		if !m.allowed(host) {
+			// Redirecting to whatever host the request names would make
+			// this an open redirect.
+			body := "unknown host\n"
+			w.WriteStatusLine(response.StatusMisdirectedRequest)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+			w.WriteBody([]byte(body))
+			return
+		}
+		target := req.RequestLine.RequestTarget
+		if u, err := url.Parse(target); err == nil && u.IsAbs() {
+			target = u.RequestURI()
+		}
+		response.Redirect(w, response.StatusMovedPermanently, "https://"+host+response.EscapeLocation(target))
+	})
+}
+
+// parseBundle parses a PEM private key followed by the certificate chain.
+func parseBundle(data []byte) (*tls.Certificate, error) {
+	keyBlock, rest := pem.Decode(data)
+	if keyBlock == nil || keyBlock.Type != "EC PRIVATE KEY" {
+		return nil, fmt.Errorf("acme: certificate bundle does not start with a key")
+	}
+	cert, err := tls.X509KeyPair(rest, pem.EncodeToMemory(keyBlock))
+	if err != nil {
+		return nil, fmt.Errorf("acme: certificate bundle: %w", err)
+	}
+	return &cert, nil
+}
@@ -0,0 +1,513 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCA is a minimal ACME server: it checks every JWS, fetches HTTP-01
+// key authorizations from challengeAddr and signs certificates valid for
+// lifetime.
+type fakeCA struct {
+	t             *testing.T
+	base          string
+	challengeAddr string
+	lifetime      time.Duration
+	caKey         *ecdsa.PrivateKey
+	caCert        *x509.Certificate
+
+	mu        sync.Mutex
+	nonce     int
+	nonces    map[string]bool
+	badNonces int
+	accounts  map[string]*ecdsa.PublicKey
+	orders    []*fakeOrder
+}
+
+type fakeOrder struct {
+	domain      string
+	token       string
+	account     string
+	authzValid  bool
+	authzFailed bool
+	status      string
+	cert        []byte
+}
+
+func startFakeCA(t *testing.T, challengeAddr string, lifetime time.Duration) *fakeCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &fakeCA{
+		t:             t,
+		challengeAddr: challengeAddr,
+		lifetime:      lifetime,
+		caKey:         key,
+		caCert:        caCert,
+		nonces:        map[string]bool{},
+		accounts:      map[string]*ecdsa.PublicKey{},
+	}
+	s, err := server.Serve(0, server.HandlerFunc(ca.serve))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	ca.base = fmt.Sprintf("http://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+	return ca
+}
+
+func (ca *fakeCA) orderCount() int {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return len(ca.orders)
+}
+
+func (ca *fakeCA) reply(w *response.Writer, status response.StatusCode, h *headers.Headers, body []byte) {
+	ca.mu.Lock()
+	ca.nonce++
+	nonce := fmt.Sprintf("nonce-%d", ca.nonce)
+	ca.nonces[nonce] = true
+	ca.mu.Unlock()
+
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	h.Set("Replay-Nonce", nonce)
+	h.Set("Content-Length", fmt.Sprint(len(body)))
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
+
+func (ca *fakeCA) replyJSON(w *response.Writer, status response.StatusCode, location string, v any) {
+	body, _ := json.Marshal(v)
+	h := headers.NewHeadersFromPairs("Content-Type", "application/json")
+	if location != "" {
+		h.Set("Location", location)
+	}
+	ca.reply(w, status, h, body)
+}
+
+func (ca *fakeCA) problem(w *response.Writer, typ, detail string) {
+	ca.replyJSON(w, response.StatusBadRequest, "", map[string]string{
+		"type": "urn:ietf:params:acme:error:" + typ, "detail": detail,
+	})
+}
+
+func (ca *fakeCA) serve(w *response.Writer, req *request.Request) {
+	path := req.RequestLine.RequestTarget
+	switch path {
+	case "/dir":
+		ca.replyJSON(w, response.StatusOK, "", directory{
+			NewNonce:   ca.base + "/new-nonce",
+			NewAccount: ca.base + "/new-account",
+			NewOrder:   ca.base + "/new-order",
+		})
+		return
+	case "/new-nonce":
+		ca.reply(w, response.StatusOK, nil, nil)
+		return
+	}
+
+	payload, kid, pub, ok := ca.verify(w, req)
+	if !ok {
+		return
+	}
+	var id int
+	if sscan(path, "/cert/%d", &id) {
+		ca.mu.Lock()
+		cert := ca.orders[id].cert
+		ca.mu.Unlock()
+		ca.reply(w, response.StatusOK, headers.NewHeadersFromPairs("Content-Type", "application/pem-certificate-chain"), cert)
+		return
+	}
+	status, location, body := ca.handle(path, payload, kid, pub)
+	ca.replyJSON(w, status, location, body)
+}
+
+// handle answers a verified POST to path with a status, a Location and a
+// JSON body.
+func (ca *fakeCA) handle(path string, payload []byte, kid string, pub *ecdsa.PublicKey) (response.StatusCode, string, any) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	var id int
+	switch {
+	case path == "/new-account":
+		kid := fmt.Sprintf("%s/acct/%d", ca.base, len(ca.accounts))
+		ca.accounts[kid] = pub
+		return response.StatusCreated, kid, map[string]string{"status": "valid"}
+
+	case path == "/new-order":
+		var in struct{ Identifiers []identifier }
+		require.NoError(ca.t, json.Unmarshal(payload, &in))
+		o := &fakeOrder{domain: in.Identifiers[0].Value, token: fmt.Sprintf("token%d", len(ca.orders)), account: kid, status: "pending"}
+		ca.orders = append(ca.orders, o)
+		id = len(ca.orders) - 1
+		return response.StatusCreated, fmt.Sprintf("%s/order/%d", ca.base, id), ca.orderJSON(id, o)
+
+	case sscan(path, "/authz/%d", &id):
+		o := ca.orders[id]
+		status := "pending"
+		if o.authzValid {
+			status = "valid"
+		} else if o.authzFailed {
+			status = "invalid"
+		}
+		return response.StatusOK, "", authorization{
+			Status:     status,
+			Identifier: identifier{Type: "dns", Value: o.domain},
+			Challenges: []challenge{
+				{Type: "dns-01", URL: fmt.Sprintf("%s/nope/%d", ca.base, id), Token: "x"},
+				{Type: "http-01", URL: fmt.Sprintf("%s/chal/%d", ca.base, id), Token: o.token},
+			},
+		}
+
+	case sscan(path, "/chal/%d", &id):
+		o := ca.orders[id]
+		want := o.token + "." + thumbprint(ca.accounts[o.account])
+		ca.mu.Unlock()
+		got := ca.fetchKeyAuthorization(o.domain, o.token)
+		ca.mu.Lock()
+		o.authzValid = got == want
+		o.authzFailed = !o.authzValid
+		if o.authzValid {
+			o.status = "ready"
+		}
+		return response.StatusOK, "", challenge{Type: "http-01", Status: "processing"}
+
+	case sscan(path, "/finalize/%d", &id):
+		o := ca.orders[id]
+		var in struct{ CSR string }
+		require.NoError(ca.t, json.Unmarshal(payload, &in))
+		der, err := b64.DecodeString(in.CSR)
+		require.NoError(ca.t, err)
+		csr, err := x509.ParseCertificateRequest(der)
+		require.NoError(ca.t, err)
+		require.Equal(ca.t, []string{o.domain}, csr.DNSNames)
+		require.True(ca.t, o.authzValid)
+		leaf := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(id + 2)),
+			Subject:      pkix.Name{CommonName: o.domain},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(ca.lifetime),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca.caCert, csr.PublicKey, ca.caKey)
+		require.NoError(ca.t, err)
+		o.cert = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})...)
+		// The order stays processing until the next poll.
+		o.status = "processing"
+		return response.StatusOK, "", ca.orderJSON(id, o)
+
+	case sscan(path, "/order/%d", &id):
+		o := ca.orders[id]
+		if o.status == "processing" {
+			o.status = "valid"
+		}
+		return response.StatusOK, "", ca.orderJSON(id, o)
+	}
+	return response.StatusNotFound, "", map[string]string{"type": "urn:ietf:params:acme:error:malformed", "detail": "unknown resource " + path}
+}
+
+func (ca *fakeCA) orderJSON(id int, o *fakeOrder) order {
+	out := order{
+		Status:         o.status,
+		Identifiers:    []identifier{{Type: "dns", Value: o.domain}},
+		Authorizations: []string{fmt.Sprintf("%s/authz/%d", ca.base, id)},
+		Finalize:       fmt.Sprintf("%s/finalize/%d", ca.base, id),
+	}
+	if o.status == "valid" {
+		out.Certificate = fmt.Sprintf("%s/cert/%d", ca.base, id)
+	}
+	return out
+}
+
+// verify checks the JWS in req's body: its nonce, URL and signature by the
+// embedded key or the account named by kid. On failure it has answered.
+func (ca *fakeCA) verify(w *response.Writer, req *request.Request) (payload []byte, kid string, pub *ecdsa.PublicKey, ok bool) {
+	var jws struct{ Protected, Payload, Signature string }
+	require.NoError(ca.t, json.Unmarshal(req.Body, &jws))
+	assert.Equal(ca.t, "application/jose+json", req.Headers.Get("content-type"))
+
+	protectedJSON, err := b64.DecodeString(jws.Protected)
+	require.NoError(ca.t, err)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  *struct{ Crv, Kty, X, Y string }
+	}
+	require.NoError(ca.t, json.Unmarshal(protectedJSON, &protected))
+	require.Equal(ca.t, "ES256", protected.Alg)
+	require.Equal(ca.t, ca.base+req.RequestLine.RequestTarget, protected.URL)
+
+	ca.mu.Lock()
+	known := ca.nonces[protected.Nonce]
+	delete(ca.nonces, protected.Nonce)
+	reject := ca.badNonces > 0
+	if reject {
+		ca.badNonces--
+	}
+	pub = ca.accounts[protected.Kid]
+	ca.mu.Unlock()
+	if !known || reject {
+		ca.problem(w, "badNonce", "unknown nonce")
+		return nil, "", nil, false
+	}
+
+	if protected.JWK != nil {
+		x, _ := b64.DecodeString(protected.JWK.X)
+		y, _ := b64.DecodeString(protected.JWK.Y)
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	}
+	require.NotNil(ca.t, pub, "request signed by unknown account %q", protected.Kid)
+	sig, err := b64.DecodeString(jws.Signature)
+	require.NoError(ca.t, err)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	require.Len(ca.t, sig, 64)
+	require.True(ca.t, ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])), "bad signature")
+
+	payload, err = b64.DecodeString(jws.Payload)
+	require.NoError(ca.t, err)
+	return payload, protected.Kid, pub, true
+}
+
+// fetchKeyAuthorization gets the HTTP-01 response for token the way a CA
+// would, from port 80 of domain, which here is challengeAddr.
+func (ca *fakeCA) fetchKeyAuthorization(domain, token string) string {
+	c := &client.Client{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, ca.challengeAddr)
+	}}
+	resp, err := c.Get("http://" + domain + challengePath + token)
+	if err != nil || resp.StatusLine.StatusCode != 200 {
+		return ""
+	}
+	return string(resp.Body)
+}
+
+func sscan(path, format string, id *int) bool {
+	n, err := fmt.Sscanf(path, format, id)
+	return err == nil && n == 1
+}
+
+// newTestManager returns a Manager for example.test whose HTTPHandler is
+// served on the address the fake CA fetches challenges from.
+func newTestManager(t *testing.T, cache Cache, lifetime time.Duration) (*Manager, *fakeCA) {
+	t.Helper()
+	m := &Manager{Domains: []string{"example.test"}, Cache: cache, pollInterval: time.Millisecond}
+	s, err := server.Serve(0, m.HTTPHandler(nil))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	ca := startFakeCA(t, s.Addr().String(), lifetime)
+	m.DirectoryURL = ca.base + "/dir"
+	return m, ca
+}
+
+func hello(name string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{ServerName: name}
+}
+
+func TestManager(t *testing.T) {
+	// Test: A certificate is obtained through HTTP-01, then kept
+	t.Run("Obtain", func(t *testing.T) {
+		cache := DirCache(t.TempDir())
+		m, ca := newTestManager(t, cache, 90*24*time.Hour)
+		ca.badNonces = 1
+
+		cert, err := m.GetCertificate(hello("Example.TEST."))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"example.test"}, cert.Leaf.DNSNames)
+		assert.Equal(t, "fake ACME CA", cert.Leaf.Issuer.CommonName)
+		assert.Len(t, cert.Certificate, 2)
+
+		again, err := m.GetCertificate(hello("example.test"))
+		require.NoError(t, err)
+		assert.Same(t, cert, again)
+		assert.Equal(t, 1, ca.orderCount())
+
+		// A new Manager on the same cache needs no new order.
+		m2, ca2 := newTestManager(t, cache, 90*24*time.Hour)
+		cached, err := m2.GetCertificate(hello("example.test"))
+		require.NoError(t, err)
+		assert.Equal(t, cert.Certificate, cached.Certificate)
+		assert.Equal(t, 0, ca2.orderCount())
+	})
+
+	// Test: Concurrent handshakes share one order
+	t.Run("Concurrent", func(t *testing.T) {
+		m, ca := newTestManager(t, nil, 90*24*time.Hour)
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := m.GetCertificate(hello("example.test"))
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, ca.orderCount())
+	})
+
+	// Test: A certificate close to expiry is renewed in the background
+	t.Run("Renew", func(t *testing.T) {
+		m, ca := newTestManager(t, nil, time.Hour)
+		m.RenewBefore = 2 * time.Hour
+
+		first, err := m.GetCertificate(hello("example.test"))
+		require.NoError(t, err)
+		served, err := m.GetCertificate(hello("example.test"))
+		require.NoError(t, err)
+		assert.Same(t, first, served, "the old certificate serves while renewing")
+
+		require.Eventually(t, func() bool {
+			cert, err := m.GetCertificate(hello("example.test"))
+			return err == nil && cert != first
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.GreaterOrEqual(t, ca.orderCount(), 2)
+	})
+
+	// Test: Names outside Domains are refused without an order
+	t.Run("Host not allowed", func(t *testing.T) {
+		m, ca := newTestManager(t, nil, time.Hour)
+		_, err := m.GetCertificate(hello("other.test"))
+		assert.ErrorIs(t, err, ErrHostNotAllowed)
+		_, err = m.GetCertificate(hello(""))
+		assert.Error(t, err)
+		assert.Equal(t, 0, ca.orderCount())
+	})
+
+	// Test: A failed challenge fails the handshake
+	t.Run("Challenge fails", func(t *testing.T) {
+		m, _ := newTestManager(t, nil, time.Hour)
+		m.Domains = append(m.Domains, "unreachable.test")
+		// The CA fetches challenges from a Manager holding no tokens.
+		other := &Manager{Domains: m.Domains, DirectoryURL: m.DirectoryURL, pollInterval: time.Millisecond}
+		s, err := server.Serve(0, other.HTTPHandler(nil))
+		require.NoError(t, err)
+		defer s.Close()
+
+		bad := startFakeCA(t, s.Addr().String(), time.Hour)
+		m.DirectoryURL = bad.base + "/dir"
+		_, err = m.GetCertificate(hello("unreachable.test"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "authorization for unreachable.test is invalid")
+	})
+}
+
+func TestHTTPHandler(t *testing.T) {
+	m := &Manager{Domains: []string{"example.test"}}
+	m.init()
+	m.tokens["abc"] = "abc.thumb"
+	s, err := server.Serve(0, m.HTTPHandler(nil))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	base := fmt.Sprintf("http://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+	c := &client.Client{CheckRedirect: func(*request.Request, []*request.Request) error { return client.ErrUseLastResponse }}
+
+	// Test: Known tokens get their key authorization
+	t.Run("Known token", func(t *testing.T) {
+		resp, err := c.Get(base + challengePath + "abc")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "abc.thumb", string(resp.Body))
+	})
+
+	// Test: Unknown tokens are not found
+	t.Run("Unknown token", func(t *testing.T) {
+		resp, err := c.Get(base + challengePath + "nope")
+		require.NoError(t, err)
+		assert.Equal(t, 404, resp.StatusLine.StatusCode)
+	})
+
+	// Test: Other requests are redirected to https
+	t.Run("Redirect", func(t *testing.T) {
+		req, err := client.NewRequest("GET", base+"/page?q=1").Header("Host", "example.test").Build()
+		require.NoError(t, err)
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 301, resp.StatusLine.StatusCode)
+		assert.Equal(t, "https://example.test/page?q=1", resp.Headers.Get("location"))
+
+		req, err = client.NewRequest("GET", base+"/").Header("Host", "Example.Test.:8080").Build()
+		require.NoError(t, err)
+		resp, err = c.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.test/", resp.Headers.Get("location"))
+	})
+
+	// Test: Hosts outside Domains are not redirected to
+	t.Run("Unknown host", func(t *testing.T) {
+		for _, host := range []string{"evil.test", "example.test.evil.test", "[::1]:80", "[::1]"} {
+			req, err := client.NewRequest("GET", base+"/page").Header("Host", host).Build()
+			require.NoError(t, err)
+			resp, err := c.Do(req)
+			require.NoError(t, err)
+			assert.Equal(t, 421, resp.StatusLine.StatusCode, host)
+			assert.Empty(t, resp.Headers.Get("location"), host)
+		}
+	})
+
+	// Test: The target is escaped, so it cannot add fields to the redirect
+	t.Run("Escaped target", func(t *testing.T) {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: example.test\r\n\r\n"))
+		require.NoError(t, err)
+		req.RequestLine.RequestTarget = "/a?x\r\nSet-Cookie: pwned=1"
+		var buf bytes.Buffer
+		m.HTTPHandler(nil).ServeHTTP(response.NewWriter(&buf), req)
+		resp, err := response.ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.test/a?x%0D%0ASet-Cookie:%20pwned=1", resp.Headers.Get("location"))
+		assert.Empty(t, resp.Headers.Get("Set-Cookie"))
+	})
+}
+
+func TestJWS(t *testing.T) {
+	// Test: The JWK has the RFC 7638 member order and the thumbprint is a SHA-256
+	t.Run("Thumbprint", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(jwk(&key.PublicKey), `{"crv":"P-256","kty":"EC","x":"`))
+		assert.Len(t, thumbprint(&key.PublicKey), 43)
+		assert.Equal(t, "tok."+thumbprint(&key.PublicKey), keyAuthorization("tok", &key.PublicKey))
+	})
+}
@@ -0,0 +1,275 @@
+package chunked
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+)
+
+type decoderState int
+
+const (
+	stateSize decoderState = iota
+	stateData
+	stateDataCRLF
+	stateTrailer
+	stateDone
+)
+
+var CRLF = []byte("\r\n")
+
+var (
+	ErrInvalidChunkSize      = fmt.Errorf("invalid chunk size")
+	ErrInvalidChunkExtension = fmt.Errorf("invalid chunk extension")
+	ErrMissingChunkCRLF      = fmt.Errorf("missing CRLF after chunk data")
+	ErrDecoderDone           = fmt.Errorf("trying to decode data in done state")
+)
+
+// Extension is one chunk extension, sent after the chunk size as
+// ";name=value" (RFC 9112 section 7.1.1). Value is empty when the extension
+// has none, and unquoted when it was sent as a quoted-string.
+type Extension struct {
+	Name  string
+	Value string
+}
+
+// Options controls optional decoder behaviour. The zero value matches
+// NewDecoder.
+type Options struct {
+	// OnExtensions, when set, is called with the size and extensions of each
+	// chunk that has any, the last chunk included. Extensions are always
+	// validated; without OnExtensions they are otherwise ignored.
+	OnExtensions func(size int64, exts []Extension)
+}
+
+// Decoder incrementally decodes a chunked transfer-coded body
+// (RFC 9112 section 7.1). Like headers.Parse it makes one step per call, so
+// callers loop until no bytes are consumed.
+type Decoder struct {
+	state     decoderState
+	remaining int64
+	trailers  *headers.Headers
+	opts      Options
+}
+
+func NewDecoder() *Decoder {
+	return NewDecoderWithOptions(Options{})
+}
+
+func NewDecoderWithOptions(opts Options) *Decoder {
+	return &Decoder{
+		state:    stateSize,
+		trailers: headers.NewHeaders(),
+		opts:     opts,
+	}
+}
+
+// Trailers returns the trailer fields sent after the last chunk. It is only
+// complete once Done reports true.
+func (d *Decoder) Trailers() *headers.Headers {
+	return d.trailers
+}
+
+// Done reports whether the last chunk and trailer section have been consumed.
+func (d *Decoder) Done() bool {
+	return d.state == stateDone
+}
+
+// parseChunkLine parses a chunk-size line without its CRLF. Extensions are
+// only collected when keepExts is set, but are validated either way so a
+// malformed line is never taken for a size.
+func parseChunkLine(line []byte, keepExts bool) (int64, []Extension, error) {
+	i := 0
+	for i < len(line) && isHexDigit(line[i]) {
+		i++
+	}
+	if i == 0 {
+		return 0, nil, fmt.Errorf("%w: %q", ErrInvalidChunkSize, line)
+	}
+	size, err := strconv.ParseInt(string(line[:i]), 16, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %q", ErrInvalidChunkSize, line[:i])
+	}
+
+	exts, err := parseExtensions(line[i:], keepExts)
+	if err != nil {
+		return 0, nil, err
+	}
+	return size, exts, nil
+}
+
+// parseExtensions parses
+//
+//	chunk-ext = *( BWS ";" BWS chunk-ext-name [ BWS "=" BWS chunk-ext-val ] )
+//
+// followed by optional whitespace, which earlier versions of the decoder
+// accepted after a bare size.
+func parseExtensions(rest []byte, keep bool) ([]Extension, error) {
+	var exts []Extension
+	for {
+		rest = trimWhitespace(rest)
+		if len(rest) == 0 {
+			return exts, nil
+		}
+		if rest[0] != ';' {
+			return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidChunkExtension, rest)
+		}
+		rest = trimWhitespace(rest[1:])
+
+		n := tokenLen(rest)
+		if n == 0 {
+			return nil, fmt.Errorf("%w: missing name", ErrInvalidChunkExtension)
+		}
+		name := rest[:n]
+		rest = trimWhitespace(rest[n:])
+
+		var value []byte
+		if len(rest) > 0 && rest[0] == '=' {
+			rest = trimWhitespace(rest[1:])
+			var err error
+			value, rest, err = parseExtensionValue(rest)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if keep {
+			exts = append(exts, Extension{Name: string(name), Value: string(value)})
+		}
+	}
+}
+
+// parseExtensionValue parses a token or quoted-string and returns its
+// unquoted value and the bytes after it.
+func parseExtensionValue(data []byte) ([]byte, []byte, error) {
+	if len(data) == 0 || data[0] != '"' {
+		n := tokenLen(data)
+		if n == 0 {
+			return nil, nil, fmt.Errorf("%w: missing value", ErrInvalidChunkExtension)
+		}
+		return data[:n], data[n:], nil
+	}
+
+	var value []byte
+	for i := 1; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '"':
+			return value, data[i+1:], nil
+		case c == '\\':
+			i++
+			if i == len(data) || !isQuotedChar(data[i]) && data[i] != '"' && data[i] != '\\' {
+				return nil, nil, fmt.Errorf("%w: invalid quoted-pair", ErrInvalidChunkExtension)
+			}
+			value = append(value, data[i])
+		case isQuotedChar(c):
+			value = append(value, c)
+		default:
+			return nil, nil, fmt.Errorf("%w: byte 0x%02x in quoted-string", ErrInvalidChunkExtension, c)
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: unterminated quoted-string", ErrInvalidChunkExtension)
+}
+
+func trimWhitespace(data []byte) []byte {
+	for len(data) > 0 && (data[0] == ' ' || data[0] == '\t') {
+		data = data[1:]
+	}
+	return data
+}
+
+func tokenLen(data []byte) int {
+	n := 0
+	for n < len(data) && isTokenChar(data[n]) {
+		n++
+	}
+	return n
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func isTokenChar(c byte) bool {
+	if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
+
+// isQuotedChar reports whether c may appear unescaped in a quoted-string:
+// qdtext, that is HTAB, SP, visible characters other than '"' and '\',
+// and obs-text.
+func isQuotedChar(c byte) bool {
+	return c == '\t' || c == ' ' || c == 0x21 || 0x23 <= c && c <= 0x5b || 0x5d <= c && c <= 0x7e || c >= 0x80
+}
+
+// Parse consumes the next piece of chunked framing in data. payload is the
+// chunk data found in the consumed bytes; it aliases data. n is 0 when more
+// input is needed.
+func (d *Decoder) Parse(data []byte) (n int, payload []byte, done bool, err error) {
+	switch d.state {
+	case stateSize:
+		idx := bytes.Index(data, CRLF)
+		if idx == -1 {
+			return 0, nil, false, nil
+		}
+
+		size, exts, err := parseChunkLine(data[:idx], d.opts.OnExtensions != nil)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		if len(exts) > 0 {
+			d.opts.OnExtensions(size, exts)
+		}
+
+		d.remaining = size
+		if size == 0 {
+			d.state = stateTrailer
+		} else {
+			d.state = stateData
+		}
+		return idx + len(CRLF), nil, false, nil
+
+	case stateData:
+		if len(data) == 0 {
+			return 0, nil, false, nil
+		}
+
+		n := int64(len(data))
+		if n > d.remaining {
+			n = d.remaining
+		}
+		d.remaining -= n
+		if d.remaining == 0 {
+			d.state = stateDataCRLF
+		}
+		return int(n), data[:n], false, nil
+
+	case stateDataCRLF:
+		if len(data) < len(CRLF) {
+			return 0, nil, false, nil
+		}
+		if !bytes.HasPrefix(data, CRLF) {
+			return 0, nil, false, ErrMissingChunkCRLF
+		}
+		d.state = stateSize
+		return len(CRLF), nil, false, nil
+
+	case stateTrailer:
+		n, done, err := d.trailers.ParseAll(data)
+		if err != nil {
+			return 0, nil, false, fmt.Errorf("invalid trailer: %w", err)
+		}
+		if done {
+			d.state = stateDone
+		}
+		return n, nil, done, nil
+
+	default:
+		return 0, nil, false, ErrDecoderDone
+	}
+}
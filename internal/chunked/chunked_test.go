@@ -0,0 +1,307 @@
+package chunked
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeAll feeds data to a fresh decoder numBytesPerRead bytes at a time, the
+// way a parser reading from a network connection would.
+func decodeAll(data string, numBytesPerRead int) (string, bool, error) {
+	return decodeWith(NewDecoder(), data, numBytesPerRead)
+}
+
+func decodeWith(d *Decoder, data string, numBytesPerRead int) (string, bool, error) {
+	var body []byte
+	buf := []byte{}
+	pos := 0
+
+	for !d.Done() {
+		if pos >= len(data) {
+			return string(body), false, nil
+		}
+		end := pos + numBytesPerRead
+		if end > len(data) {
+			end = len(data)
+		}
+		buf = append(buf, data[pos:end]...)
+		pos = end
+
+		for !d.Done() {
+			n, payload, _, err := d.Parse(buf)
+			if err != nil {
+				return string(body), false, err
+			}
+			if n == 0 {
+				break
+			}
+			body = append(body, payload...)
+			buf = buf[n:]
+		}
+	}
+	return string(body), true, nil
+}
+
+func TestDecoder(t *testing.T) {
+	// Test: Standard chunked body
+	t.Run("Standard chunked body", func(t *testing.T) {
+		for _, chunkSize := range []int{1, 2, 3, 7, 50} {
+			t.Run(fmt.Sprintf("ChunkSize_%d", chunkSize), func(t *testing.T) {
+				body, done, err := decodeAll("5\r\nhello\r\n7\r\n world!\r\n0\r\n\r\n", chunkSize)
+				require.NoError(t, err)
+				assert.True(t, done)
+				assert.Equal(t, "hello world!", body)
+			})
+		}
+	})
+
+	// Test: Uppercase hex size
+	t.Run("Uppercase hex size", func(t *testing.T) {
+		body, done, err := decodeAll("A\r\n0123456789\r\n0\r\n\r\n", 4)
+		require.NoError(t, err)
+		assert.True(t, done)
+		assert.Equal(t, "0123456789", body)
+	})
+
+	// Test: Chunk extensions are ignored
+	t.Run("Chunk extensions are ignored", func(t *testing.T) {
+		body, done, err := decodeAll("5;name=value\r\nhello\r\n0;last\r\n\r\n", 3)
+		require.NoError(t, err)
+		assert.True(t, done)
+		assert.Equal(t, "hello", body)
+	})
+
+	// Test: Chunk extensions are surfaced through OnExtensions
+	t.Run("Chunk extensions are surfaced", func(t *testing.T) {
+		type chunkExts struct {
+			size int64
+			exts []Extension
+		}
+		var got []chunkExts
+		d := NewDecoderWithOptions(Options{OnExtensions: func(size int64, exts []Extension) {
+			got = append(got, chunkExts{size, exts})
+		}})
+		body, done, err := decodeWith(d, "5 ; a = 1;b\r\nhello\r\n3\r\nabc\r\n0;sig=\"x\\\"y z\"\r\n\r\n", 4)
+		require.NoError(t, err)
+		assert.True(t, done)
+		assert.Equal(t, "helloabc", body)
+		assert.Equal(t, []chunkExts{
+			{5, []Extension{{Name: "a", Value: "1"}, {Name: "b"}}},
+			{0, []Extension{{Name: "sig", Value: `x"y z`}}},
+		}, got)
+	})
+
+	// Test: Malformed chunk extensions are rejected
+	t.Run("Malformed chunk extensions", func(t *testing.T) {
+		for _, data := range []string{
+			"5;\r\nhello\r\n0\r\n\r\n",
+			"5;=1\r\nhello\r\n0\r\n\r\n",
+			"5;a=\r\nhello\r\n0\r\n\r\n",
+			"5;a b\r\nhello\r\n0\r\n\r\n",
+			"5;a=\"1\r\nhello\r\n0\r\n\r\n",
+			"5;a=\"\x01\"\r\nhello\r\n0\r\n\r\n",
+			"5 x\r\nhello\r\n0\r\n\r\n",
+			"5;a@b\r\nhello\r\n0\r\n\r\n",
+		} {
+			_, _, err := decodeAll(data, 3)
+			require.ErrorIs(t, err, ErrInvalidChunkExtension, data)
+		}
+	})
+
+	// Test: Trailer fields are parsed
+	t.Run("Trailer fields are parsed", func(t *testing.T) {
+		for _, chunkSize := range []int{1, 5, 100} {
+			d := NewDecoder()
+			body, done, err := decodeWith(d, "5\r\nhello\r\n0\r\nX-Checksum: abc\r\nX-Count:  2 \r\n\r\n", chunkSize)
+			require.NoError(t, err)
+			assert.True(t, done)
+			assert.Equal(t, "hello", body)
+			assert.Equal(t, "abc", d.Trailers().Get("x-checksum"))
+			assert.Equal(t, "2", d.Trailers().Get("x-count"))
+		}
+	})
+
+	// Test: Malformed trailer
+	t.Run("Malformed trailer", func(t *testing.T) {
+		_, _, err := decodeAll("5\r\nhello\r\n0\r\nnot a trailer\r\n\r\n", 5)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid trailer")
+	})
+
+	// Test: Empty body
+	t.Run("Empty body", func(t *testing.T) {
+		body, done, err := decodeAll("0\r\n\r\n", 1)
+		require.NoError(t, err)
+		assert.True(t, done)
+		assert.Equal(t, "", body)
+	})
+
+	// Test: Incomplete body
+	t.Run("Incomplete body", func(t *testing.T) {
+		body, done, err := decodeAll("5\r\nhel", 2)
+		require.NoError(t, err)
+		assert.False(t, done)
+		assert.Equal(t, "hel", body)
+	})
+
+	// Test: Invalid chunk sizes
+	t.Run("Invalid chunk sizes", func(t *testing.T) {
+		for _, data := range []string{
+			"xyz\r\nhello\r\n0\r\n\r\n",
+			"\r\nhello\r\n0\r\n\r\n",
+			"-5\r\nhello\r\n0\r\n\r\n",
+			"+5\r\nhello\r\n0\r\n\r\n",
+			"-0\r\n\r\n",
+			"fffffffffffffffff\r\n",
+		} {
+			_, _, err := decodeAll(data, 3)
+			require.ErrorIs(t, err, ErrInvalidChunkSize, data)
+		}
+	})
+
+	// Test: Missing CRLF after chunk data
+	t.Run("Missing CRLF after chunk data", func(t *testing.T) {
+		_, _, err := decodeAll("5\r\nhelloXX0\r\n\r\n", 3)
+		require.ErrorIs(t, err, ErrMissingChunkCRLF)
+	})
+
+	// Test: Parse after done
+	t.Run("Parse after done", func(t *testing.T) {
+		d := NewDecoder()
+		_, _, _, err := d.Parse([]byte("0\r\n"))
+		require.NoError(t, err)
+		_, _, done, err := d.Parse([]byte("\r\n"))
+		require.NoError(t, err)
+		require.True(t, done)
+		_, _, _, err = d.Parse([]byte("more"))
+		require.ErrorIs(t, err, ErrDecoderDone)
+	})
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	// 64 chunks of 16 KB, fed in network-sized reads.
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	chunk := bytes.Repeat([]byte("x"), 16*1024)
+	for i := 0; i < 64; i++ {
+		w.Write(chunk)
+	}
+	w.Close()
+	data := buf.String()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		if _, done, err := decodeAll(data, 4096); err != nil || !done {
+			b.Fatalf("decode failed: %v", err)
+		}
+	}
+}
+
+// FuzzChunkedDecode checks that the decoder never panics, never reports
+// more bytes consumed than it was given, and decodes the same body whether
+// the input arrives at once or a byte at a time.
+func FuzzChunkedDecode(f *testing.F) {
+	f.Add([]byte("5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"))
+	f.Add([]byte("5;name=value\r\nhello\r\n0\r\nExpires: never\r\n\r\n"))
+	f.Add([]byte("5 ; a=\"q\\\"t\" ;b\r\nhello\r\n0\r\n\r\n"))
+	f.Add([]byte("fffffffffffffff\r\nx"))
+	f.Add([]byte("5\r\nhelloXX0\r\n\r\n"))
+	f.Add([]byte("0\r\n\r\nGET / HTTP/1.1\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder()
+		buf := data
+		var body []byte
+		for !d.Done() {
+			n, payload, _, err := d.Parse(buf)
+			if n < 0 || n > len(buf) || len(payload) > n {
+				t.Fatalf("consumed %d with %d payload bytes of %d", n, len(payload), len(buf))
+			}
+			if err != nil || n == 0 {
+				break
+			}
+			body = append(body, payload...)
+			buf = buf[n:]
+		}
+
+		whole, done, err := decodeAll(string(data), max(len(data), 1))
+		split, splitDone, splitErr := decodeAll(string(data), 1)
+		if (err == nil) != (splitErr == nil) || done != splitDone || whole != split {
+			t.Fatalf("whole: %q done=%v err=%v, byte at a time: %q done=%v err=%v",
+				whole, done, err, split, splitDone, splitErr)
+		}
+		if whole != string(body) {
+			t.Fatalf("decoded %q and %q from the same input", whole, body)
+		}
+		if err != nil || !done {
+			return
+		}
+
+		// A complete body survives being chunked again.
+		var enc bytes.Buffer
+		w := NewWriter(&enc)
+		w.Write([]byte(whole))
+		w.Close()
+		again, done, err := decodeAll(enc.String(), 4096)
+		if err != nil || !done || again != whole {
+			t.Fatalf("re-encoded body decoded as %q, done=%v, err=%v", again, done, err)
+		}
+	})
+}
+
+func TestWriter(t *testing.T) {
+	// Test: Writes become chunks and Close ends the body
+	t.Run("Round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+
+		_, err := w.Write([]byte("hello"))
+		require.NoError(t, err)
+		_, err = w.Write(nil)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(" world, and then some"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, "5\r\nhello\r\n15\r\n world, and then some\r\n0\r\n\r\n", buf.String())
+
+		body, done, err := decodeAll(buf.String(), 4)
+		require.NoError(t, err)
+		assert.True(t, done)
+		assert.Equal(t, "hello world, and then some", body)
+	})
+
+	// Test: Trailers follow the last chunk
+	t.Run("Trailers", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		_, err := w.Write([]byte("data"))
+		require.NoError(t, err)
+		require.NoError(t, w.WriteTrailers(headers.NewHeadersFromPairs("X-Checksum", "abc")))
+		assert.Equal(t, "4\r\ndata\r\n0\r\nx-checksum: abc\r\n\r\n", buf.String())
+
+		d := NewDecoder()
+		body, done, err := decodeWith(d, buf.String(), 5)
+		require.NoError(t, err)
+		assert.True(t, done)
+		assert.Equal(t, "data", body)
+		assert.Equal(t, "abc", d.Trailers().Get("x-checksum"))
+
+		require.ErrorIs(t, w.WriteTrailers(nil), ErrWriterClosed)
+	})
+
+	// Test: Writing after Close fails
+	t.Run("Write after close", func(t *testing.T) {
+		w := NewWriter(&bytes.Buffer{})
+		require.NoError(t, w.Close())
+		require.NoError(t, w.Close())
+		_, err := w.Write([]byte("x"))
+		require.ErrorIs(t, err, ErrWriterClosed)
+	})
+}
@@ -0,0 +1,73 @@
+package chunked
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+)
+
+var ErrWriterClosed = fmt.Errorf("write to closed chunked writer")
+
+// Writer encodes what is written to it as chunked transfer coding. Every
+// non-empty Write becomes one chunk; Close writes the last chunk and an empty
+// trailer section, WriteTrailers the last chunk and the given trailer fields.
+// Neither closes the underlying writer.
+type Writer struct {
+	w      io.Writer
+	closed bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (cw *Writer) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, ErrWriterClosed
+	}
+	// An empty chunk would read as the last one.
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if _, err := fmt.Fprintf(cw.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := cw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := cw.w.Write(CRLF); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (cw *Writer) Close() error {
+	if cw.closed {
+		return nil
+	}
+	return cw.WriteTrailers(nil)
+}
+
+// WriteTrailers ends the body with the last chunk followed by trailers, which
+// may be nil.
+func (cw *Writer) WriteTrailers(trailers *headers.Headers) error {
+	if cw.closed {
+		return ErrWriterClosed
+	}
+	cw.closed = true
+
+	var b bytes.Buffer
+	b.WriteString("0\r\n")
+	if trailers != nil {
+		trailers.ForEach(func(key, value string) {
+			fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+		})
+	}
+	b.Write(CRLF)
+	_, err := cw.w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,235 @@
+package client
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// challenge is one authentication challenge from a WWW-Authenticate header.
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// authorize returns a copy of req carrying credentials that answer the 401
+// in resp, or nil when the client should not or cannot answer it. Only
+// challenges from originHost, the host of the first request, are answered so
+// credentials do not follow redirects elsewhere. Digest is preferred over
+// Basic when both are offered.
+func (c *Client) authorize(req *request.Request, t *target, resp *response.Response, originHost string) *request.Request {
+	if c.Credentials == nil || resp.StatusLine.StatusCode != 401 || t.host != originHost {
+		return nil
+	}
+	// Leave caller-supplied credentials alone, and do not try to replay a
+	// streamed body.
+	if req.Headers.Get("authorization") != "" || req.BodyReader != nil {
+		return nil
+	}
+
+	challenges := parseChallenges(resp.Headers.Get("www-authenticate"))
+
+	authorization := ""
+	for _, ch := range challenges {
+		if ch.scheme == "digest" {
+			authorization = digestAuthorization(ch, c.Credentials, req.RequestLine.Method, t.requestURI, req.Body, newCnonce())
+			if authorization != "" {
+				break
+			}
+		}
+	}
+	if authorization == "" {
+		for _, ch := range challenges {
+			if ch.scheme == "basic" {
+				authorization = basicAuthorization(c.Credentials)
+				break
+			}
+		}
+	}
+	if authorization == "" {
+		return nil
+	}
+
+	next := *req
+	next.Headers = *req.Headers.Clone()
+	next.Headers.Set("Authorization", authorization)
+	return &next
+}
+
+func basicAuthorization(user *url.Userinfo) string {
+	password, _ := user.Password()
+	credentials := user.Username() + ":" + password
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
+
+// digestAuthorization computes the Authorization value answering a Digest
+// challenge (RFC 7616). It returns an empty string when the challenge uses an
+// algorithm or qop this client does not support.
+func digestAuthorization(ch challenge, user *url.Userinfo, method, uri string, body []byte, cnonce string) string {
+	algorithm := ch.params["algorithm"]
+	sess := strings.HasSuffix(strings.ToUpper(algorithm), "-SESS")
+
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return ""
+	}
+	h := func(s string) string {
+		sum := newHash()
+		sum.Write([]byte(s))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	qop := ""
+	if offered, ok := ch.params["qop"]; ok {
+		for _, q := range strings.Split(offered, ",") {
+			q = strings.TrimSpace(q)
+			if q == "auth" || (q == "auth-int" && qop == "") {
+				qop = q
+			}
+		}
+		if qop == "" {
+			return ""
+		}
+	}
+
+	realm, nonce := ch.params["realm"], ch.params["nonce"]
+	password, _ := user.Password()
+	const nc = "00000001"
+
+	ha1 := h(user.Username() + ":" + realm + ":" + password)
+	if sess {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+	if qop == "auth-int" {
+		ha2 = h(method + ":" + uri + ":" + h(string(body)))
+	}
+
+	var digest string
+	if qop == "" {
+		// RFC 2069 compatibility.
+		digest = h(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		digest = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s`,
+		quote(user.Username()), quote(realm), quote(nonce), quote(uri))
+	if algorithm != "" {
+		fmt.Fprintf(&b, ", algorithm=%s", algorithm)
+	}
+	fmt.Fprintf(&b, ", response=%s", quote(digest))
+	if qop != "" {
+		fmt.Fprintf(&b, ", qop=%s, nc=%s, cnonce=%s", qop, nc, quote(cnonce))
+	}
+	if opaque, ok := ch.params["opaque"]; ok {
+		fmt.Fprintf(&b, ", opaque=%s", quote(opaque))
+	}
+	return b.String()
+}
+
+func newCnonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// quote renders s as an HTTP quoted-string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// parseChallenges splits a WWW-Authenticate value into its challenges. Since
+// repeated headers are joined with commas, a new challenge starts at any
+// token that is not followed by '='. Scheme and parameter names are
+// lowercased.
+func parseChallenges(s string) []challenge {
+	var challenges []challenge
+	p := &challengeParser{s: s}
+
+	for {
+		p.skip(" \t,")
+		if p.pos >= len(s) {
+			return challenges
+		}
+		name := p.token()
+		if name == "" {
+			// Not something we understand; stop rather than guess.
+			return challenges
+		}
+		p.skip(" \t")
+
+		if p.pos < len(s) && s[p.pos] == '=' && len(challenges) > 0 {
+			p.pos++
+			p.skip(" \t")
+			challenges[len(challenges)-1].params[strings.ToLower(name)] = p.value()
+			continue
+		}
+		challenges = append(challenges, challenge{scheme: strings.ToLower(name), params: map[string]string{}})
+	}
+}
+
+type challengeParser struct {
+	s   string
+	pos int
+}
+
+func (p *challengeParser) skip(chars string) {
+	for p.pos < len(p.s) && strings.IndexByte(chars, p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *challengeParser) token() string {
+	start := p.pos
+	for p.pos < len(p.s) && isTokenChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// value reads a parameter value, either a token or a quoted-string.
+func (p *challengeParser) value() string {
+	if p.pos >= len(p.s) || p.s[p.pos] != '"' {
+		return p.token()
+	}
+
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch c := p.s[p.pos]; c {
+		case '\\':
+			if p.pos+1 < len(p.s) {
+				p.pos++
+				b.WriteByte(p.s[p.pos])
+			}
+		case '"':
+			p.pos++
+			return b.String()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isTokenChar(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChallenges(t *testing.T) {
+	// Test: Several challenges with quoted and token parameters
+	challenges := parseChallenges(`Digest realm="http-auth@example.org", qop="auth, auth-int", ` +
+		`algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", Basic realm="a \"quoted\" realm"`)
+	require.Len(t, challenges, 2)
+
+	assert.Equal(t, "digest", challenges[0].scheme)
+	assert.Equal(t, map[string]string{
+		"realm":     "http-auth@example.org",
+		"qop":       "auth, auth-int",
+		"algorithm": "SHA-256",
+		"nonce":     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+	}, challenges[0].params)
+
+	assert.Equal(t, "basic", challenges[1].scheme)
+	assert.Equal(t, `a "quoted" realm`, challenges[1].params["realm"])
+
+	// Test: Empty header
+	assert.Empty(t, parseChallenges(""))
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	// The example from RFC 7616 section 3.9.1.
+	ch := challenge{scheme: "digest", params: map[string]string{
+		"realm":  "http-auth@example.org",
+		"qop":    "auth, auth-int",
+		"nonce":  "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+		"opaque": "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+	}}
+	user := url.UserPassword("Mufasa", "Circle of Life")
+	cnonce := "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+
+	// Test: MD5
+	t.Run("MD5", func(t *testing.T) {
+		ch.params["algorithm"] = "MD5"
+		got := digestAuthorization(ch, user, "GET", "/dir/index.html", nil, cnonce)
+		assert.Contains(t, got, `response="8ca523f5e9506fed4657c9700eebdbec"`)
+		assert.Contains(t, got, `username="Mufasa"`)
+		assert.Contains(t, got, `qop=auth, nc=00000001, cnonce="`+cnonce+`"`)
+		assert.Contains(t, got, `opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`)
+	})
+
+	// Test: SHA-256
+	t.Run("SHA-256", func(t *testing.T) {
+		ch.params["algorithm"] = "SHA-256"
+		got := digestAuthorization(ch, user, "GET", "/dir/index.html", nil, cnonce)
+		assert.Contains(t, got, `response="753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"`)
+		assert.Contains(t, got, "algorithm=SHA-256")
+	})
+
+	// Test: Unsupported algorithm
+	t.Run("Unsupported algorithm", func(t *testing.T) {
+		ch.params["algorithm"] = "SHA-512-256"
+		assert.Empty(t, digestAuthorization(ch, user, "GET", "/", nil, cnonce))
+	})
+}
+
+// challengeResponder answers 401 with challenge until a request carries an
+// Authorization header accepted by valid.
+func challengeResponder(challenge string, valid func(authorization string) bool) func(*request.Request) string {
+	return func(req *request.Request) string {
+		if valid(req.Headers.Get("authorization")) {
+			return "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nsecret"
+		}
+		return "HTTP/1.1 401 Unauthorized\r\nWWW-Authenticate: " + challenge + "\r\nContent-Length: 0\r\n\r\n"
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	// Test: Basic challenge is answered
+	t.Run("Basic", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, challengeResponder(`Basic realm="test"`, func(a string) bool {
+			return a == "Basic dXNlcjpwYXNz"
+		}))
+		c := &Client{Credentials: url.UserPassword("user", "pass")}
+		defer c.CloseIdleConnections()
+
+		resp, err := c.Get("http://" + addr + "/private")
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(resp.Body))
+	})
+
+	// Test: Digest is preferred and computed over the request URI
+	t.Run("Digest", func(t *testing.T) {
+		challenge := `Basic realm="test", Digest realm="test", qop="auth", nonce="abc", algorithm=SHA-256`
+		addr, _ := serveKeepAlive(t, challengeResponder(challenge, func(a string) bool {
+			ch := parseChallenges(a)
+			if len(ch) != 1 || ch[0].scheme != "digest" || ch[0].params["uri"] != "/private?x=1" {
+				return false
+			}
+			want := digestAuthorization(parseChallenges(challenge)[1], url.UserPassword("user", "pass"),
+				"GET", "/private?x=1", nil, ch[0].params["cnonce"])
+			return a == want
+		}))
+		c := &Client{Credentials: url.UserPassword("user", "pass")}
+		defer c.CloseIdleConnections()
+
+		resp, err := c.Get("http://" + addr + "/private?x=1")
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(resp.Body))
+	})
+
+	// Test: Wrong credentials get the second 401 back
+	t.Run("Wrong credentials", func(t *testing.T) {
+		var attempts int
+		addr, _ := serveKeepAlive(t, challengeResponder(`Basic realm="test"`, func(string) bool {
+			attempts++
+			return false
+		}))
+		c := &Client{Credentials: url.UserPassword("user", "wrong")}
+		defer c.CloseIdleConnections()
+
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 401, resp.StatusLine.StatusCode)
+		assert.Equal(t, 2, attempts)
+	})
+
+	// Test: Without credentials the 401 is returned
+	t.Run("No credentials", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, challengeResponder(`Basic realm="test"`, func(a string) bool {
+			return strings.HasPrefix(a, "Basic")
+		}))
+
+		resp, err := NewClient().Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 401, resp.StatusLine.StatusCode)
+	})
+}
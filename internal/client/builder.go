@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+)
+
+// RequestBuilder assembles a request step by step. Errors are remembered and
+// reported by Build, so calls can be chained without checking each one.
+type RequestBuilder struct {
+	method     string
+	url        *url.URL
+	query      url.Values
+	headers    *headers.Headers
+	body       []byte
+	bodyReader io.Reader
+	ctx        context.Context
+	err        error
+}
+
+// NewRequest starts building a request for method and an absolute http or
+// https URL.
+func NewRequest(method, rawURL string) *RequestBuilder {
+	b := &RequestBuilder{
+		method:  method,
+		headers: headers.NewHeaders(),
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		b.err = fmt.Errorf("invalid url %q: %w", rawURL, err)
+		return b
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		b.err = fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+		return b
+	}
+	if u.Host == "" {
+		b.err = ErrMissingHost
+		return b
+	}
+	b.url = u
+	return b
+}
+
+// Header adds a header field. Repeated names are combined as by
+// headers.Headers.Set.
+func (b *RequestBuilder) Header(key, value string) *RequestBuilder {
+	b.headers.Set(key, value)
+	return b
+}
+
+// QueryParam appends a query parameter to the URL, after any already present
+// in it.
+func (b *RequestBuilder) QueryParam(key, value string) *RequestBuilder {
+	if b.query == nil {
+		b.query = url.Values{}
+	}
+	b.query.Add(key, value)
+	return b
+}
+
+// Body sets the request body.
+func (b *RequestBuilder) Body(body []byte) *RequestBuilder {
+	b.body = body
+	return b
+}
+
+// BodyReader sets a body of unknown length, which the client streams with
+// chunked encoding.
+func (b *RequestBuilder) BodyReader(r io.Reader) *RequestBuilder {
+	b.bodyReader = r
+	return b
+}
+
+// JSONBody encodes v as the request body and sets Content-Type to
+// application/json unless it was set already.
+func (b *RequestBuilder) JSONBody(v any) *RequestBuilder {
+	body, err := json.Marshal(v)
+	if err != nil {
+		if b.err == nil {
+			b.err = fmt.Errorf("encoding json body: %w", err)
+		}
+		return b
+	}
+	b.body = body
+	if b.headers.Get("content-type") == "" {
+		b.headers.Set("Content-Type", "application/json")
+	}
+	return b
+}
+
+// Context sets the context of the built request.
+func (b *RequestBuilder) Context(ctx context.Context) *RequestBuilder {
+	b.ctx = ctx
+	return b
+}
+
+// Build returns the request, or the first error met while building it. The
+// request target is kept in absolute form so Client.Do knows the scheme; Host
+// and Content-Length are filled in when not set explicitly.
+func (b *RequestBuilder) Build() (*request.Request, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	u := *b.url
+	if len(b.query) > 0 {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += b.query.Encode()
+	}
+
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{
+		Method:        b.method,
+		RequestTarget: u.String(),
+		HttpVersion:   "1.1",
+	}
+	req.Headers = *b.headers.Clone()
+	if req.Headers.Get("host") == "" {
+		req.Headers.Set("Host", u.Host)
+	}
+	if len(b.body) > 0 && req.Headers.Get("content-length") == "" {
+		req.Headers.Set("Content-Length", strconv.Itoa(len(b.body)))
+	}
+	req.Body = b.body
+	req.BodyReader = b.bodyReader
+
+	if b.ctx != nil {
+		req = req.WithContext(b.ctx)
+	}
+	return req, nil
+}
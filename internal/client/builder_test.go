@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	// Test: Headers, query parameters and JSON body
+	t.Run("JSON POST", func(t *testing.T) {
+		req, err := NewRequest("POST", "http://example.com:8080/items?sort=asc").
+			Header("X-Trace", "abc").
+			QueryParam("page", "2").
+			QueryParam("q", "a b").
+			JSONBody(map[string]int{"n": 1}).
+			Build()
+		require.NoError(t, err)
+
+		assert.Equal(t, "POST", req.RequestLine.Method)
+		assert.Equal(t, "http://example.com:8080/items?sort=asc&page=2&q=a+b", req.RequestLine.RequestTarget)
+		assert.Equal(t, "example.com:8080", req.Headers.Get("host"))
+		assert.Equal(t, "abc", req.Headers.Get("x-trace"))
+		assert.Equal(t, "application/json", req.Headers.Get("content-type"))
+		assert.Equal(t, "7", req.Headers.Get("content-length"))
+		assert.Equal(t, `{"n":1}`, string(req.Body))
+	})
+
+	// Test: Explicit Content-Type is kept
+	t.Run("Explicit content type", func(t *testing.T) {
+		req, err := NewRequest("PUT", "https://example.com/").
+			Header("Content-Type", "application/vnd.api+json").
+			JSONBody([]int{1}).
+			Build()
+		require.NoError(t, err)
+		assert.Equal(t, "application/vnd.api+json", req.Headers.Get("content-type"))
+	})
+
+	// Test: Context is attached
+	t.Run("Context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		req, err := NewRequest("GET", "http://example.com/").Context(ctx).Build()
+		require.NoError(t, err)
+		assert.Equal(t, ctx, req.Context())
+	})
+
+	// Test: Errors surface from Build
+	t.Run("Errors", func(t *testing.T) {
+		_, err := NewRequest("GET", "ftp://example.com/").Build()
+		require.ErrorIs(t, err, ErrUnsupportedScheme)
+
+		_, err = NewRequest("GET", "/relative").Build()
+		require.Error(t, err)
+
+		_, err = NewRequest("POST", "http://example.com/").JSONBody(make(chan int)).Build()
+		require.Error(t, err)
+	})
+
+	// Test: Built requests can be sent
+	t.Run("Round trip", func(t *testing.T) {
+		received := make(chan *request.Request, 1)
+		addr := serveOnce(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", received)
+
+		req, err := NewRequest("POST", "http://"+addr+"/submit").QueryParam("id", "7").Body([]byte("hi")).Build()
+		require.NoError(t, err)
+		_, err = NewClient().Do(req)
+		require.NoError(t, err)
+
+		got := <-received
+		assert.Equal(t, "/submit?id=7", got.RequestLine.RequestTarget)
+		assert.Equal(t, "hi", string(got.Body))
+	})
+}
@@ -0,0 +1,558 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+const (
+	defaultHTTPPort  = "80"
+	defaultHTTPSPort = "443"
+)
+
+var (
+	ErrMissingHost           = fmt.Errorf("request has no host")
+	ErrUnsupportedScheme     = fmt.Errorf("unsupported url scheme")
+	ErrResponseHeaderTimeout = fmt.Errorf("timeout awaiting response headers")
+)
+
+// Client sends requests over TCP, or TLS for https targets, and parses the
+// responses.
+// Connections are kept alive and reused across requests to the same host.
+// The zero value is ready to use.
+type Client struct {
+	// MaxIdleConnsPerHost caps the idle connections kept per host. Zero means
+	// DefaultMaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection stays in the pool. Zero
+	// means DefaultIdleConnTimeout.
+	IdleConnTimeout time.Duration
+	// DisableKeepAlives sends Connection: close and never pools connections.
+	DisableKeepAlives bool
+	// DisableCompression stops the client from requesting gzip on its own.
+	// When it does request gzip, a gzip-encoded response body is decoded
+	// transparently and Response.Uncompressed is set.
+	DisableCompression bool
+	// MaxResponseBodySize caps the response body the client reads, after
+	// decompression, at this many bytes. Larger bodies fail with
+	// response.ErrBodyTooLarge and the connection is closed. Zero means no
+	// limit.
+	MaxResponseBodySize int64
+
+	// Timeout bounds the whole exchange, from dialing to reading the last body
+	// byte, on top of any deadline carried by the request's context. Zero means
+	// no timeout.
+	Timeout time.Duration
+	// DialTimeout bounds establishing the TCP connection.
+	DialTimeout time.Duration
+	// FallbackDelay is how long a connection attempt runs before the next
+	// resolved address is tried alongside it, so a broken IPv6 or IPv4 path
+	// does not stall the dial. Zero means DefaultFallbackDelay; a negative
+	// value tries addresses one at a time.
+	FallbackDelay time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake for https targets.
+	TLSHandshakeTimeout time.Duration
+
+	// Proxy returns the forward proxy to use for a request, or nil for a
+	// direct connection. Plain http requests are sent to the proxy in
+	// absolute form; https requests are tunnelled with CONNECT. Credentials in
+	// the proxy URL are sent as Proxy-Authorization.
+	Proxy func(req *request.Request) (*url.URL, error)
+
+	// TLSClientConfig configures TLS for https targets: root CAs, client
+	// certificates, InsecureSkipVerify and so on. When ServerName is empty it
+	// is taken from the target host. Nil means the default configuration.
+	TLSClientConfig *tls.Config
+	// ResponseHeaderTimeout bounds the wait for the response headers after the
+	// request has been written. It does not cover reading the body.
+	ResponseHeaderTimeout time.Duration
+
+	// ExpectContinueThreshold makes the client send Expect: 100-continue
+	// with bodies of at least this many bytes. Zero disables it, though a
+	// caller-supplied Expect header is still honored.
+	ExpectContinueThreshold int
+	// ExpectContinueTimeout is how long to wait for 100 Continue before
+	// sending the body anyway. Zero means DefaultExpectContinueTimeout.
+	ExpectContinueTimeout time.Duration
+
+	// DialContext, when set, opens connections in place of the built-in
+	// dialer. It receives the unresolved host:port of the origin or proxy,
+	// so Resolver is not consulted. Use it to route through SOCKS, pin
+	// addresses or connect to in-memory listeners.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Resolver looks up host names for the built-in dialer. Nil means
+	// net.DefaultResolver.
+	Resolver Resolver
+
+	// Credentials answer 401 challenges from the host of the original
+	// request: Digest (RFC 7616) when offered, Basic otherwise. The request is
+	// retried once with the Authorization header.
+	Credentials *url.Userinfo
+
+	// Retry configures automatic retries of failed attempts. By default no
+	// request is retried.
+	Retry RetryPolicy
+
+	// CheckRedirect decides whether to follow a redirect. next is the request
+	// about to be sent and via the requests made so far, oldest first. A nil
+	// CheckRedirect follows up to MaxRedirects redirects. Returning
+	// ErrUseLastResponse stops and returns the redirect response itself.
+	CheckRedirect func(next *request.Request, via []*request.Request) error
+	// MaxRedirects caps the redirects followed by the default policy. Zero
+	// means DefaultMaxRedirects.
+	MaxRedirects int
+
+	mu     sync.Mutex
+	idle   map[string][]*persistConn
+	stats  PoolStats
+	http10 map[string]bool // origins known to speak only HTTP/1.0
+}
+
+func NewClient() *Client {
+	return &Client{}
+}
+
+// target describes where a request goes and how its request line looks on the
+// wire.
+type target struct {
+	scheme     string
+	addr       string // host:port of the origin
+	host       string // value for the Host header
+	requestURI string // origin-form request target
+	proxy      *url.URL
+}
+
+// url returns the absolute URL the target refers to.
+func (t *target) url() *url.URL {
+	u, err := url.Parse(t.scheme + "://" + t.host + t.requestURI)
+	if err != nil {
+		return &url.URL{Scheme: t.scheme, Host: t.host, Path: t.requestURI}
+	}
+	return u
+}
+
+func resolveTarget(req *request.Request) (*target, error) {
+	requestTarget := req.RequestLine.RequestTarget
+
+	if strings.HasPrefix(requestTarget, "/") {
+		host := req.Headers.Get("host")
+		if host == "" {
+			return nil, ErrMissingHost
+		}
+		return &target{
+			scheme:     "http",
+			addr:       withDefaultPort(host, defaultHTTPPort),
+			host:       host,
+			requestURI: requestTarget,
+		}, nil
+	}
+
+	u, err := url.Parse(requestTarget)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request target %q: %w", requestTarget, err)
+	}
+	port := defaultHTTPPort
+	switch u.Scheme {
+	case "http":
+	case "https":
+		port = defaultHTTPSPort
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, ErrMissingHost
+	}
+
+	return &target{
+		scheme:     u.Scheme,
+		addr:       withDefaultPort(u.Host, port),
+		host:       u.Host,
+		requestURI: u.RequestURI(),
+	}, nil
+}
+
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// poolKey identifies connections that can be shared between targets. Plain
+// requests through a proxy can share one connection to it whatever their
+// origin; tunnels are bound to their origin.
+func (t *target) poolKey() string {
+	if t.proxy != nil {
+		if t.scheme == "http" {
+			return "proxy " + t.proxy.Host
+		}
+		return "proxy " + t.proxy.Host + " " + t.scheme + "://" + t.addr
+	}
+	return t.scheme + "://" + t.addr
+}
+
+// Do sends req and returns the parsed response, following redirects as
+// allowed by CheckRedirect. The request target may be an absolute URL
+// (http://host/path) or an origin-form path with a Host header. Do sends the
+// Host and Content-Length headers when req lacks them, without modifying req;
+// a BodyReader without Content-Length is streamed with chunked encoding.
+// Cancelling the request's context aborts the exchange.
+func (c *Client) Do(req *request.Request) (*response.Response, error) {
+	if c.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), c.Timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	var via []*request.Request
+	originHost := ""
+	for {
+		t, err := resolveTarget(req)
+		if err != nil {
+			return nil, err
+		}
+		if t.proxy, err = c.proxyFor(req); err != nil {
+			return nil, err
+		}
+		if originHost == "" {
+			originHost = t.host
+		}
+
+		resp, err := c.doWithRetry(req, t)
+		if err != nil {
+			return nil, err
+		}
+		if authed := c.authorize(req, t, resp, originHost); authed != nil {
+			// Answer the challenge once; a second 401 is returned as is.
+			if resp, err = c.doWithRetry(authed, t); err != nil {
+				return nil, err
+			}
+		}
+
+		next, err := c.nextRedirect(req, t, resp, via)
+		if err != nil {
+			if errors.Is(err, ErrUseLastResponse) {
+				return resp, nil
+			}
+			return nil, err
+		}
+		if next == nil {
+			return resp, nil
+		}
+
+		via = append(via, req)
+		req = next
+	}
+}
+
+// do performs a single request/response exchange.
+func (c *Client) do(req *request.Request, t *target) (*response.Response, error) {
+	ctx := req.Context()
+	trace := ContextClientTrace(ctx)
+	if req.BodyReader != nil && req.Headers.Get("content-length") == "" && c.isHTTP10(t) {
+		var err error
+		if req, err = bufferBody(req); err != nil {
+			return nil, err
+		}
+	}
+	out, requestedGzip := c.wireRequest(req, t)
+
+	trace.getConn(t.addr)
+	pc, err := c.getConn(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	trace.gotConn(pc)
+
+	resp, err := c.roundTrip(ctx, pc, out)
+	if err != nil && pc.reused && ctx.Err() == nil && isStaleConnErr(err) && c.Retry.replayable(req) {
+		// The server most likely closed the pooled connection while it sat
+		// idle, before reading our request. Try again on a fresh connection,
+		// unless the request is one the server may have acted on already and
+		// must not see twice.
+		pc, err = c.dialConn(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		trace.gotConn(pc)
+		resp, err = c.roundTrip(ctx, pc, out)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.noteVersion(t, resp)
+	if !pc.broken && pc.reader.Buffered() == 0 && c.shouldKeepAlive(out, resp) {
+		c.putIdleConn(pc)
+	} else {
+		pc.conn.Close()
+	}
+
+	resp.TLS = pc.tlsState
+
+	if requestedGzip {
+		if err := decodeGzipBody(resp, c.MaxResponseBodySize); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// wireRequest returns the request as it is sent: origin-form target plus the
+// headers the client adds on the caller's behalf. req itself is left alone.
+// requestedGzip reports whether the client asked for gzip on its own.
+func (c *Client) wireRequest(req *request.Request, t *target) (out *request.Request, requestedGzip bool) {
+	wire := *req
+	wire.RequestLine.RequestTarget = t.requestURI
+	wire.Headers = *req.Headers.Clone()
+
+	if t.proxy != nil && t.scheme == "http" {
+		// Plain requests through a forward proxy use the absolute form.
+		wire.RequestLine.RequestTarget = t.url().String()
+		if auth := proxyAuthorization(t.proxy); auth != "" && wire.Headers.Get("proxy-authorization") == "" {
+			wire.Headers.Set("Proxy-Authorization", auth)
+		}
+	}
+
+	if wire.Headers.Get("host") == "" {
+		wire.Headers.Set("Host", t.host)
+	}
+	if wire.BodyReader != nil {
+		// The length is unknown, so stream the body in chunks.
+		if wire.Headers.Get("content-length") == "" && wire.Headers.Get("transfer-encoding") == "" {
+			wire.Headers.Set("Transfer-Encoding", "chunked")
+		}
+	} else if len(wire.Body) > 0 && wire.Headers.Get("content-length") == "" {
+		wire.Headers.Set("Content-Length", strconv.Itoa(len(wire.Body)))
+	}
+	if c.DisableKeepAlives && wire.Headers.Get("connection") == "" {
+		wire.Headers.Set("Connection", "close")
+	}
+	if c.ExpectContinueThreshold > 0 && len(wire.Body) >= c.ExpectContinueThreshold &&
+		wire.Headers.Get("expect") == "" {
+		wire.Headers.Set("Expect", "100-continue")
+	}
+	if !c.DisableCompression && contextStream(req.Context()) == nil && wire.Headers.Get("accept-encoding") == "" &&
+		wire.Headers.Get("range") == "" && wire.RequestLine.Method != "HEAD" {
+		wire.Headers.Set("Accept-Encoding", "gzip")
+		requestedGzip = true
+	}
+
+	return &wire, requestedGzip
+}
+
+// isStaleConnErr reports whether err looks like the peer closed the
+// connection before handling our request.
+func isStaleConnErr(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func (c *Client) getConn(ctx context.Context, t *target) (*persistConn, error) {
+	if pc := c.getIdleConn(t.poolKey()); pc != nil {
+		return pc, nil
+	}
+	return c.dialConn(ctx, t)
+}
+
+func (c *Client) dialConn(ctx context.Context, t *target) (*persistConn, error) {
+	dialAddr := t.addr
+	if t.proxy != nil {
+		dialAddr = withDefaultPort(t.proxy.Host, defaultHTTPPort)
+	}
+
+	conn, err := c.dialTCP(ctx, dialAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.proxy != nil && t.scheme == "https" {
+		if err := c.connectTunnel(ctx, conn, t); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.Dials++
+	c.mu.Unlock()
+
+	pc := &persistConn{conn: conn, key: t.poolKey()}
+	if t.scheme == "https" {
+		tlsConn, err := c.handshake(ctx, conn, t.addr)
+		if err != nil {
+			return nil, err
+		}
+		state := tlsConn.ConnectionState()
+		pc.conn = tlsConn
+		pc.tlsState = &state
+	}
+	pc.reader = response.NewReader(connReader{pc})
+
+	return pc, nil
+}
+
+// roundTrip writes req on pc and reads the response, applying the context and
+// header deadlines to the connection. On error the connection is closed.
+func (c *Client) roundTrip(ctx context.Context, pc *persistConn, req *request.Request) (*response.Response, error) {
+	ctxDeadline, _ := ctx.Deadline()
+	pc.conn.SetDeadline(ctxDeadline)
+
+	// Unblock any pending read or write as soon as the context is cancelled.
+	// mu keeps the header timeout from overwriting that expired deadline.
+	var mu sync.Mutex
+	cancelled := false
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		cancelled = true
+		pc.conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	resp, err := c.writeAndRead(pc, req, func(headerDeadline time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !cancelled {
+			pc.conn.SetReadDeadline(earliest(headerDeadline, ctxDeadline))
+		}
+	})
+
+	if !stop() && err == nil {
+		// The cancellation fired after the response arrived; the connection
+		// now carries an expired deadline and cannot be reused.
+		pc.broken = true
+	}
+	if err != nil {
+		pc.conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// The connection deadline can fire just before the context notices.
+		if !ctxDeadline.IsZero() && !time.Now().Before(ctxDeadline) {
+			return nil, context.DeadlineExceeded
+		}
+		return nil, err
+	}
+
+	pc.conn.SetDeadline(time.Time{})
+	return resp, nil
+}
+
+// writeAndRead performs the exchange on pc. setReadDeadline is used to arm and
+// later lift the response header timeout; a zero time lifts it.
+func (c *Client) writeAndRead(pc *persistConn, req *request.Request, setReadDeadline func(time.Time)) (*response.Response, error) {
+	trace := ContextClientTrace(req.Context())
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		pc.onFirstByte = trace.GotFirstResponseByte
+		defer func() { pc.onFirstByte = nil }()
+	}
+
+	if c.expectsContinue(req) {
+		resp, err := c.awaitContinue(pc, req, setReadDeadline)
+		if err != nil || resp != nil {
+			return resp, err
+		}
+	} else {
+		err := req.Write(pc.conn)
+		trace.wroteRequest(err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	headersDone := false
+	if c.ResponseHeaderTimeout > 0 {
+		setReadDeadline(time.Now().Add(c.ResponseHeaderTimeout))
+	}
+
+	opts := response.Options{
+		RequestMethod: req.RequestLine.Method,
+		MaxBodySize:   c.MaxResponseBodySize,
+		BodyWriter:    c.streamBodyWriter(req),
+		OnHeaders: func() {
+			headersDone = true
+			if c.ResponseHeaderTimeout > 0 {
+				setReadDeadline(time.Time{})
+			}
+		},
+	}
+
+	for {
+		resp, err := pc.reader.ReadResponse(opts)
+		if err != nil {
+			var netErr net.Error
+			if !headersDone && c.ResponseHeaderTimeout > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, ErrResponseHeaderTimeout
+			}
+			return nil, err
+		}
+
+		// Interim responses other than 101 are skipped; the final
+		// response follows on the same connection.
+		if isInterim(resp.StatusLine.StatusCode) {
+			continue
+		}
+		return resp, nil
+	}
+}
+
+func isInterim(statusCode int) bool {
+	return statusCode >= 100 && statusCode < 200 && statusCode != 101
+}
+
+// earliest returns the earlier of two deadlines, treating zero as unset.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+func hasToken(headerValue, token string) bool {
+	for _, v := range strings.Split(headerValue, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) shouldKeepAlive(req *request.Request, resp *response.Response) bool {
+	if c.DisableKeepAlives {
+		return false
+	}
+	if hasToken(req.Headers.Get("connection"), "close") {
+		return false
+	}
+	// HTTP/1.0 servers close after each response unless they negotiate
+	// keep-alive, which this client does not.
+	if resp.StatusLine.HttpVersion == "1.0" {
+		return false
+	}
+	return !hasToken(resp.Headers.Get("connection"), "close")
+}
+
+// Get issues a GET request for rawURL.
+func (c *Client) Get(rawURL string) (*response.Response, error) {
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{
+		Method:        "GET",
+		RequestTarget: rawURL,
+		HttpVersion:   "1.1",
+	}
+	return c.Do(req)
+}
@@ -0,0 +1,621 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveOnce accepts a single connection on a loopback listener, parses the
+// request, hands it to received and writes rawResponse back.
+func serveOnce(t *testing.T, rawResponse string, received chan<- *request.Request) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := request.RequestFromReader(conn)
+		if err != nil {
+			return
+		}
+		if received != nil {
+			received <- req
+		}
+		io.WriteString(conn, rawResponse)
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClientDo(t *testing.T) {
+	// Test: GET with absolute URL
+	t.Run("GET with absolute URL", func(t *testing.T) {
+		received := make(chan *request.Request, 1)
+		addr := serveOnce(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", received)
+
+		resp, err := NewClient().Get("http://" + addr + "/coffee?size=large")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "hello", string(resp.Body))
+
+		req := <-received
+		assert.Equal(t, "GET", req.RequestLine.Method)
+		assert.Equal(t, "/coffee?size=large", req.RequestLine.RequestTarget)
+		assert.Equal(t, addr, req.Headers.Get("host"))
+	})
+
+	// Test: POST with origin-form target and body
+	t.Run("POST with origin-form target", func(t *testing.T) {
+		received := make(chan *request.Request, 1)
+		addr := serveOnce(t, "HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n", received)
+
+		req := request.NewRequest()
+		req.RequestLine = request.RequestLine{Method: "POST", RequestTarget: "/submit", HttpVersion: "1.1"}
+		req.Headers.Set("Host", addr)
+		req.Body = []byte(`{"a":1}`)
+
+		resp, err := NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 201, resp.StatusLine.StatusCode)
+
+		got := <-received
+		assert.Equal(t, "/submit", got.RequestLine.RequestTarget)
+		assert.Equal(t, "7", got.Headers.Get("content-length"))
+		assert.Equal(t, `{"a":1}`, string(got.Body))
+	})
+
+	// Test: Missing host
+	t.Run("Missing host", func(t *testing.T) {
+		req := request.NewRequest()
+		req.RequestLine = request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"}
+		_, err := NewClient().Do(req)
+		require.ErrorIs(t, err, ErrMissingHost)
+	})
+
+	// Test: Unsupported scheme
+	t.Run("Unsupported scheme", func(t *testing.T) {
+		_, err := NewClient().Get("ftp://example.com/file")
+		require.ErrorIs(t, err, ErrUnsupportedScheme)
+	})
+
+	// Test: Connection refused
+	t.Run("Connection refused", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := listener.Addr().String()
+		listener.Close()
+
+		_, err = NewClient().Get("http://" + addr + "/")
+		require.Error(t, err)
+	})
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	assert.Equal(t, "example.com:80", withDefaultPort("example.com", "80"))
+	assert.Equal(t, "example.com:8080", withDefaultPort("example.com:8080", "80"))
+	assert.Equal(t, "[::1]:443", withDefaultPort("[::1]", "443"))
+}
+
+// serveKeepAlive serves any number of requests per connection, answering each
+// with the response built by respond. It returns the address and a counter of
+// accepted connections.
+func serveKeepAlive(t *testing.T, respond func(req *request.Request) string) (string, *atomic.Int32) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	accepted := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := request.RequestFromReader(conn)
+					if err != nil || req.RequestLine.Method == "" {
+						return
+					}
+					io.WriteString(conn, respond(req))
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), accepted
+}
+
+func okResponse(req *request.Request) string {
+	return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+}
+
+func TestConnectionPool(t *testing.T) {
+	// Test: Sequential requests reuse one connection
+	t.Run("Sequential requests reuse one connection", func(t *testing.T) {
+		addr, accepted := serveKeepAlive(t, okResponse)
+		c := NewClient()
+		defer c.CloseIdleConnections()
+
+		for i := 0; i < 3; i++ {
+			resp, err := c.Get("http://" + addr + "/")
+			require.NoError(t, err)
+			assert.Equal(t, "ok", string(resp.Body))
+		}
+
+		stats := c.PoolStats()
+		assert.Equal(t, 1, stats.Dials)
+		assert.Equal(t, 2, stats.Reuses)
+		assert.Equal(t, 1, stats.Idle)
+		assert.Equal(t, int32(1), accepted.Load())
+	})
+
+	// Test: Connection: close in the response is honored
+	t.Run("Connection close in response", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, func(req *request.Request) string {
+			return "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"
+		})
+		c := NewClient()
+
+		for i := 0; i < 2; i++ {
+			_, err := c.Get("http://" + addr + "/")
+			require.NoError(t, err)
+		}
+
+		stats := c.PoolStats()
+		assert.Equal(t, 2, stats.Dials)
+		assert.Equal(t, 0, stats.Reuses)
+		assert.Equal(t, 0, stats.Idle)
+	})
+
+	// Test: DisableKeepAlives sends Connection: close
+	t.Run("DisableKeepAlives", func(t *testing.T) {
+		received := make(chan *request.Request, 1)
+		addr := serveOnce(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", received)
+		c := &Client{DisableKeepAlives: true}
+
+		_, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "close", (<-received).Headers.Get("connection"))
+		assert.Equal(t, 0, c.PoolStats().Idle)
+	})
+
+	// Test: Idle connections are evicted after the timeout
+	t.Run("Idle timeout", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, okResponse)
+		c := &Client{IdleConnTimeout: 20 * time.Millisecond}
+
+		_, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 1, c.PoolStats().Idle)
+
+		assert.Eventually(t, func() bool {
+			return c.PoolStats().Idle == 0
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	// Test: Max idle connections per host
+	t.Run("Max idle per host", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, okResponse)
+		c := &Client{MaxIdleConnsPerHost: 1}
+		defer c.CloseIdleConnections()
+
+		pcs := make([]*persistConn, 3)
+		for i := range pcs {
+			pc, err := c.dialConn(context.Background(), &target{scheme: "http", addr: addr})
+			require.NoError(t, err)
+			pcs[i] = pc
+		}
+		for _, pc := range pcs {
+			c.putIdleConn(pc)
+		}
+		assert.Equal(t, 1, c.PoolStats().Idle)
+	})
+
+	// Test: Stale pooled connection is replaced transparently
+	t.Run("Stale pooled connection", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				// Answer one request, then close as if the idle timer fired.
+				req, err := request.RequestFromReader(conn)
+				if err == nil && req.RequestLine.Method != "" {
+					io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+				}
+				conn.Close()
+			}
+		}()
+
+		c := NewClient()
+		addr := listener.Addr().String()
+
+		_, err = c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+		assert.Equal(t, 2, c.PoolStats().Dials)
+
+		// A POST is not sent again, as the server may have acted on it.
+		time.Sleep(20 * time.Millisecond)
+		_, err = c.Do(newTestRequest("POST", "http://"+addr+"/", "data"))
+		require.Error(t, err)
+		assert.Equal(t, 2, c.PoolStats().Dials)
+	})
+}
+
+// serveSlow accepts connections and answers after delay, optionally sending
+// the headers first and the body after the delay.
+func serveSlow(t *testing.T, delay time.Duration, headersFirst bool) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := request.RequestFromReader(conn); err != nil {
+					return
+				}
+				if headersFirst {
+					io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n")
+				}
+				time.Sleep(delay)
+				if headersFirst {
+					io.WriteString(conn, "ok")
+				} else {
+					io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClientTimeouts(t *testing.T) {
+	// Test: Overall client timeout
+	t.Run("Overall client timeout", func(t *testing.T) {
+		addr := serveSlow(t, 500*time.Millisecond, false)
+		c := &Client{Timeout: 50 * time.Millisecond}
+
+		start := time.Now()
+		_, err := c.Get("http://" + addr + "/")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 400*time.Millisecond)
+	})
+
+	// Test: Context deadline on the request
+	t.Run("Context deadline", func(t *testing.T) {
+		addr := serveSlow(t, 500*time.Millisecond, true)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		req := request.NewRequest()
+		req.RequestLine = request.RequestLine{Method: "GET", RequestTarget: "http://" + addr + "/", HttpVersion: "1.1"}
+		_, err := NewClient().Do(req.WithContext(ctx))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	// Test: Context cancellation
+	t.Run("Context cancellation", func(t *testing.T) {
+		addr := serveSlow(t, 500*time.Millisecond, false)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(30*time.Millisecond, cancel)
+
+		req := request.NewRequest()
+		req.RequestLine = request.RequestLine{Method: "GET", RequestTarget: "http://" + addr + "/", HttpVersion: "1.1"}
+		_, err := NewClient().Do(req.WithContext(ctx))
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	// Test: Response header timeout
+	t.Run("Response header timeout", func(t *testing.T) {
+		addr := serveSlow(t, 500*time.Millisecond, false)
+		c := &Client{ResponseHeaderTimeout: 50 * time.Millisecond}
+
+		_, err := c.Get("http://" + addr + "/")
+		require.ErrorIs(t, err, ErrResponseHeaderTimeout)
+	})
+
+	// Test: Response header timeout does not cover the body
+	t.Run("Header timeout does not cover the body", func(t *testing.T) {
+		addr := serveSlow(t, 100*time.Millisecond, true)
+		c := &Client{ResponseHeaderTimeout: 50 * time.Millisecond}
+
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+	})
+
+	// Test: Dial timeout
+	t.Run("Dial timeout", func(t *testing.T) {
+		// 10.255.255.1 is non-routable, so the dial hangs until the timeout.
+		c := &Client{DialTimeout: 50 * time.Millisecond}
+
+		start := time.Now()
+		_, err := c.Get("http://10.255.255.1:81/")
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+func redirectingServer(t *testing.T) string {
+	t.Helper()
+
+	addr, _ := serveKeepAlive(t, func(req *request.Request) string {
+		switch req.RequestLine.RequestTarget {
+		case "/old":
+			return "HTTP/1.1 301 Moved Permanently\r\nLocation: /new\r\nContent-Length: 0\r\n\r\n"
+		case "/see-other":
+			return "HTTP/1.1 303 See Other\r\nLocation: /echo\r\nContent-Length: 0\r\n\r\n"
+		case "/temporary":
+			return "HTTP/1.1 307 Temporary Redirect\r\nLocation: echo\r\nContent-Length: 0\r\n\r\n"
+		case "/loop":
+			return "HTTP/1.1 302 Found\r\nLocation: /loop\r\nContent-Length: 0\r\n\r\n"
+		case "/no-location":
+			return "HTTP/1.1 302 Found\r\nContent-Length: 0\r\n\r\n"
+		default:
+			body := req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body) +
+				" auth=" + req.Headers.Get("authorization")
+			return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+		}
+	})
+	return addr
+}
+
+func newTestRequest(method, target string, body string) *request.Request {
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"}
+	if body != "" {
+		req.Body = []byte(body)
+		req.Headers.Set("Content-Type", "text/plain")
+	}
+	return req
+}
+
+func TestRedirects(t *testing.T) {
+	addr := redirectingServer(t)
+
+	// Test: 301 is followed
+	t.Run("301 is followed", func(t *testing.T) {
+		resp, err := NewClient().Get("http://" + addr + "/old")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "GET /new  auth=", string(resp.Body))
+	})
+
+	// Test: 303 turns POST into GET and drops the body
+	t.Run("303 rewrites method", func(t *testing.T) {
+		resp, err := NewClient().Do(newTestRequest("POST", "http://"+addr+"/see-other", "payload"))
+		require.NoError(t, err)
+		assert.Equal(t, "GET /echo  auth=", string(resp.Body))
+	})
+
+	// Test: 307 preserves method and body, relative location
+	t.Run("307 preserves method and body", func(t *testing.T) {
+		resp, err := NewClient().Do(newTestRequest("POST", "http://"+addr+"/temporary", "payload"))
+		require.NoError(t, err)
+		assert.Equal(t, "POST /echo payload auth=", string(resp.Body))
+	})
+
+	// Test: Redirect loop stops at MaxRedirects
+	t.Run("Too many redirects", func(t *testing.T) {
+		c := &Client{MaxRedirects: 3}
+		_, err := c.Get("http://" + addr + "/loop")
+		require.ErrorIs(t, err, ErrTooManyRedirects)
+	})
+
+	// Test: Redirect without Location is returned as is
+	t.Run("Redirect without Location", func(t *testing.T) {
+		resp, err := NewClient().Get("http://" + addr + "/no-location")
+		require.NoError(t, err)
+		assert.Equal(t, 302, resp.StatusLine.StatusCode)
+	})
+
+	// Test: CheckRedirect can return the last response
+	t.Run("CheckRedirect ErrUseLastResponse", func(t *testing.T) {
+		c := &Client{
+			CheckRedirect: func(next *request.Request, via []*request.Request) error {
+				return ErrUseLastResponse
+			},
+		}
+		resp, err := c.Get("http://" + addr + "/old")
+		require.NoError(t, err)
+		assert.Equal(t, 301, resp.StatusLine.StatusCode)
+		assert.Equal(t, "/new", resp.Headers.Get("location"))
+	})
+
+	// Test: CheckRedirect can alter the next request
+	t.Run("CheckRedirect alters next request", func(t *testing.T) {
+		var seen []string
+		c := &Client{
+			CheckRedirect: func(next *request.Request, via []*request.Request) error {
+				seen = append(seen, via[len(via)-1].RequestLine.RequestTarget)
+				next.Headers.Set("Authorization", "Bearer added")
+				return nil
+			},
+		}
+		resp, err := c.Get("http://" + addr + "/old")
+		require.NoError(t, err)
+		assert.Equal(t, "GET /new  auth=Bearer added", string(resp.Body))
+		assert.Equal(t, []string{"http://" + addr + "/old"}, seen)
+	})
+
+	// Test: Authorization is dropped on cross-host redirects
+	t.Run("Cross-host redirect drops Authorization", func(t *testing.T) {
+		other := redirectingServer(t)
+		origin, _ := serveKeepAlive(t, func(req *request.Request) string {
+			return "HTTP/1.1 302 Found\r\nLocation: http://" + other + "/echo\r\nContent-Length: 0\r\n\r\n"
+		})
+
+		req := newTestRequest("GET", "http://"+origin+"/", "")
+		req.Headers.Set("Authorization", "Bearer secret")
+		resp, err := NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, "GET /echo  auth=", string(resp.Body))
+	})
+}
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	_, err := zw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return b.String()
+}
+
+func TestGzipDecoding(t *testing.T) {
+	body := gzipped(t, "hello, compressed world")
+	respondGzip := func(req *request.Request) string {
+		if req.Headers.Get("accept-encoding") != "gzip" {
+			return "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nplain"
+		}
+		return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	}
+
+	// Test: Body is decoded transparently
+	t.Run("Transparent decoding", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, respondGzip)
+		resp, err := NewClient().Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "hello, compressed world", string(resp.Body))
+		assert.True(t, resp.Uncompressed)
+		assert.Equal(t, "", resp.Headers.Get("content-encoding"))
+		assert.Equal(t, "", resp.Headers.Get("content-length"))
+	})
+
+	// Test: DisableCompression does not ask for gzip
+	t.Run("DisableCompression", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, respondGzip)
+		c := &Client{DisableCompression: true}
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "plain", string(resp.Body))
+		assert.False(t, resp.Uncompressed)
+	})
+
+	// Test: Caller-provided Accept-Encoding gets the raw body
+	t.Run("Caller-provided Accept-Encoding", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, respondGzip)
+		req := newTestRequest("GET", "http://"+addr+"/", "")
+		req.Headers.Set("Accept-Encoding", "gzip")
+
+		resp, err := NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(resp.Body))
+		assert.Equal(t, "gzip", resp.Headers.Get("content-encoding"))
+		assert.False(t, resp.Uncompressed)
+	})
+
+	// Test: Corrupt gzip body is an error
+	t.Run("Corrupt gzip body", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, func(req *request.Request) string {
+			return "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: 8\r\n\r\nnot gzip"
+		})
+		_, err := NewClient().Get("http://" + addr + "/")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "decoding gzip body")
+	})
+
+	// Test: Caller's request is not modified
+	t.Run("Request is not modified", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, respondGzip)
+		req := newTestRequest("GET", "http://"+addr+"/", "")
+		_, err := NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, "", req.Headers.Get("accept-encoding"))
+		assert.Equal(t, "", req.Headers.Get("host"))
+	})
+}
+
+func TestMaxResponseBodySize(t *testing.T) {
+	// Test: Oversized body is rejected
+	t.Run("Oversized body", func(t *testing.T) {
+		addr := serveOnce(t, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n"+strings.Repeat("x", 100), nil)
+		c := &Client{MaxResponseBodySize: 10}
+
+		_, err := c.Get("http://" + addr + "/")
+		require.ErrorIs(t, err, response.ErrBodyTooLarge)
+		assert.Equal(t, 0, c.PoolStats().Idle)
+	})
+
+	// Test: The limit applies to the decompressed body
+	t.Run("Gzip bomb", func(t *testing.T) {
+		body := gzipped(t, strings.Repeat("a", 10000))
+		addr := serveOnce(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(body), body), nil)
+		c := &Client{MaxResponseBodySize: 1000}
+
+		_, err := c.Get("http://" + addr + "/")
+		require.ErrorIs(t, err, response.ErrBodyTooLarge)
+	})
+}
+
+func TestChunkedTrailers(t *testing.T) {
+	// Test: Client exposes trailers from a chunked response
+	addr, _ := serveKeepAlive(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"6;name=first\r\nchunk1\r\n" +
+			"6\r\nchunk2\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n"
+	})
+
+	c := NewClient()
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "chunk1chunk2", string(resp.Body))
+		assert.Equal(t, "abc123", resp.Trailers.Get("x-checksum"))
+	}
+	// The connection survives a chunked response and is reused.
+	assert.Equal(t, 1, c.PoolStats().Dials)
+}
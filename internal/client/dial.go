@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultFallbackDelay is the head start each address gets before the next
+// one is tried, as recommended by RFC 8305.
+const DefaultFallbackDelay = 250 * time.Millisecond
+
+// Resolver looks up the addresses of a host. *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func (c *Client) resolver() Resolver {
+	if c.Resolver != nil {
+		return c.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// dialTCP resolves addr and connects to one of its addresses, or hands addr
+// to DialContext when that is set. DialTimeout bounds
+// the lookup and all attempts together.
+func (c *Client) dialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	if c.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.DialTimeout)
+		defer cancel()
+	}
+	trace := ContextClientTrace(ctx)
+
+	if c.DialContext != nil {
+		trace.connectStart("tcp", addr)
+		conn, err := c.DialContext(ctx, "tcp", addr)
+		trace.connectDone("tcp", addr, err)
+		return conn, err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IPAddr{{IP: ip}}
+	} else {
+		trace.dnsStart(host)
+		ips, err = c.resolver().LookupIPAddr(ctx, host)
+		trace.dnsDone(ips, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range interleaveFamilies(ips) {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	var dialer net.Dialer
+	return c.dialParallel(ctx, addrs, func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", addr)
+	})
+}
+
+// interleaveFamilies orders addresses so IPv6 and IPv4 alternate, starting
+// with the family of the first one (RFC 8305 section 4). Within a family the
+// resolver's order is kept.
+func interleaveFamilies(ips []net.IPAddr) []net.IPAddr {
+	if len(ips) == 0 {
+		return ips
+	}
+
+	var first, second []net.IPAddr
+	firstIsV4 := ips[0].IP.To4() != nil
+	for _, ip := range ips {
+		if (ip.IP.To4() != nil) == firstIsV4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+
+	out := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// dialParallel races connection attempts Happy Eyeballs style (RFC 8305):
+// the next address is tried when the current attempt fails or has not
+// succeeded within the fallback delay, whichever comes first. The first
+// connection established wins and the others are abandoned.
+func (c *Client) dialParallel(ctx context.Context, addrs []string, dial func(context.Context, string) (net.Conn, error)) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	trace := ContextClientTrace(ctx)
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+
+	next, pending := 0, 0
+	launch := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			trace.connectStart("tcp", addr)
+			conn, err := dial(ctx, addr)
+			trace.connectDone("tcp", addr, err)
+			results <- result{conn, err}
+		}()
+	}
+
+	launch()
+	var firstErr error
+	for pending > 0 {
+		var fallback <-chan time.Time
+		if next < len(addrs) && c.fallbackDelay() > 0 {
+			fallback = time.After(c.fallbackDelay())
+		}
+
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Attempts still in flight may connect too; close those.
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) && ctx.Err() == nil {
+				launch()
+			}
+		case <-fallback:
+			launch()
+		}
+	}
+	return nil, firstErr
+}
+
+func (c *Client) fallbackDelay() time.Duration {
+	if c.FallbackDelay == 0 {
+		return DefaultFallbackDelay
+	}
+	return c.FallbackDelay
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticResolver answers every lookup from a fixed table.
+type staticResolver map[string][]net.IPAddr
+
+func (r staticResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestCustomDialing(t *testing.T) {
+	// Test: DialContext connects to an in-memory server
+	t.Run("In-memory DialContext", func(t *testing.T) {
+		var dialed string
+		c := &Client{
+			DialContext: func(_ context.Context, _, addr string) (net.Conn, error) {
+				dialed = addr
+				client, server := net.Pipe()
+				go func() {
+					defer server.Close()
+					req, err := request.RequestFromReader(bufio.NewReader(server))
+					if err != nil {
+						return
+					}
+					io.WriteString(server, okResponse(req))
+				}()
+				return client, nil
+			},
+			DisableKeepAlives: true,
+		}
+
+		resp, err := c.Get("http://service.internal/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+		assert.Equal(t, "service.internal:80", dialed)
+	})
+
+	// Test: DialContext errors are returned
+	t.Run("DialContext error", func(t *testing.T) {
+		errRefused := errors.New("refused by test")
+		c := &Client{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return nil, errRefused
+			},
+		}
+		_, err := c.Get("http://service.internal/")
+		require.ErrorIs(t, err, errRefused)
+	})
+
+	// Test: A custom Resolver pins a name to an address
+	t.Run("Custom resolver", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, okResponse)
+		_, port, err := net.SplitHostPort(addr)
+		require.NoError(t, err)
+
+		c := &Client{Resolver: staticResolver{
+			"pinned.test": {{IP: net.ParseIP("127.0.0.1")}},
+		}}
+		defer c.CloseIdleConnections()
+
+		resp, err := c.Get("http://pinned.test:" + port + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+
+		_, err = c.Get("http://unknown.test:" + port + "/")
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		assert.True(t, dnsErr.IsNotFound)
+	})
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	ip := func(s string) net.IPAddr { return net.IPAddr{IP: net.ParseIP(s)} }
+	got := interleaveFamilies([]net.IPAddr{
+		ip("2001:db8::1"), ip("2001:db8::2"), ip("2001:db8::3"), ip("192.0.2.1"), ip("192.0.2.2"),
+	})
+
+	var order []string
+	for _, a := range got {
+		order = append(order, a.IP.String())
+	}
+	assert.Equal(t, []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "2001:db8::3"}, order)
+	assert.Empty(t, interleaveFamilies(nil))
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	// fakeDial hangs on addresses in hang until cancelled, refuses those in
+	// refuse and connects to the rest over an in-memory pipe.
+	fakeDial := func(hang, refuse map[string]bool, dialed chan<- string) func(context.Context, string) (net.Conn, error) {
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			dialed <- addr
+			switch {
+			case hang[addr]:
+				<-ctx.Done()
+				return nil, ctx.Err()
+			case refuse[addr]:
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+			}
+			conn, _ := net.Pipe()
+			return conn, nil
+		}
+	}
+
+	// Test: A hanging first address does not hold up the second one
+	t.Run("Fallback after delay", func(t *testing.T) {
+		dialed := make(chan string, 2)
+		c := &Client{FallbackDelay: 20 * time.Millisecond}
+
+		start := time.Now()
+		conn, err := c.dialParallel(context.Background(), []string{"[2001:db8::1]:80", "192.0.2.1:80"},
+			fakeDial(map[string]bool{"[2001:db8::1]:80": true}, nil, dialed))
+		require.NoError(t, err)
+		conn.Close()
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, "[2001:db8::1]:80", <-dialed)
+		assert.Equal(t, "192.0.2.1:80", <-dialed)
+	})
+
+	// Test: A failed attempt starts the next one immediately
+	t.Run("Failure skips the delay", func(t *testing.T) {
+		dialed := make(chan string, 2)
+		c := &Client{FallbackDelay: time.Hour}
+
+		conn, err := c.dialParallel(context.Background(), []string{"[2001:db8::1]:80", "192.0.2.1:80"},
+			fakeDial(nil, map[string]bool{"[2001:db8::1]:80": true}, dialed))
+		require.NoError(t, err)
+		conn.Close()
+	})
+
+	// Test: All attempts failing reports the first error
+	t.Run("All addresses fail", func(t *testing.T) {
+		dialed := make(chan string, 2)
+		refuse := map[string]bool{"192.0.2.1:80": true, "192.0.2.2:80": true}
+
+		_, err := (&Client{}).dialParallel(context.Background(), []string{"192.0.2.1:80", "192.0.2.2:80"},
+			fakeDial(nil, refuse, dialed))
+		var opErr *net.OpError
+		require.ErrorAs(t, err, &opErr)
+		assert.Len(t, dialed, 2)
+	})
+
+	// Test: The context bounds the race
+	t.Run("Context deadline", func(t *testing.T) {
+		dialed := make(chan string, 2)
+		hang := map[string]bool{"192.0.2.1:80": true, "192.0.2.2:80": true}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+
+		_, err := (&Client{FallbackDelay: 5 * time.Millisecond}).dialParallel(ctx, []string{"192.0.2.1:80", "192.0.2.2:80"},
+			fakeDial(hang, nil, dialed))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
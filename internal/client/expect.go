@@ -0,0 +1,69 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+const DefaultExpectContinueTimeout = time.Second
+
+func (c *Client) expectContinueTimeout() time.Duration {
+	if c.ExpectContinueTimeout > 0 {
+		return c.ExpectContinueTimeout
+	}
+	return DefaultExpectContinueTimeout
+}
+
+func (c *Client) expectsContinue(req *request.Request) bool {
+	return (len(req.Body) > 0 || req.BodyReader != nil) && hasToken(req.Headers.Get("expect"), "100-continue")
+}
+
+// awaitContinue writes the request head, waits for the server's verdict and
+// then sends the body. It returns a non-nil response when the server answered
+// with a final status instead of 100 Continue; the body is never sent and the
+// connection is not reused. A nil response means the caller should read the
+// final response as usual.
+func (c *Client) awaitContinue(pc *persistConn, req *request.Request, setReadDeadline func(time.Time)) (*response.Response, error) {
+	trace := ContextClientTrace(req.Context())
+	head := *req
+	head.Body = nil
+	head.BodyReader = nil
+	if err := head.Write(pc.conn); err != nil {
+		trace.wroteRequest(err)
+		return nil, err
+	}
+
+	setReadDeadline(time.Now().Add(c.expectContinueTimeout()))
+	resp, err := pc.reader.ReadResponse(response.Options{
+		RequestMethod: req.RequestLine.Method,
+		MaxBodySize:   c.MaxResponseBodySize,
+	})
+	setReadDeadline(time.Time{})
+
+	var netErr net.Error
+	switch {
+	case err == nil && resp.StatusLine.StatusCode == 100:
+		// Go ahead.
+	case err == nil && isInterim(resp.StatusLine.StatusCode):
+		// Some other interim response; the server has seen the headers,
+		// so stop waiting.
+	case err == nil:
+		pc.broken = true
+		return resp, nil
+	case errors.As(err, &netErr) && netErr.Timeout():
+		// No answer in time; servers that ignore Expect just want the body.
+	default:
+		return nil, err
+	}
+
+	err = req.WriteBody(pc.conn)
+	trace.wroteRequest(err)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
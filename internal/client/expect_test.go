@@ -0,0 +1,167 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveExpect reads the request head itself so it can react before the body
+// arrives. decide returns the interim or final response to send first, or ""
+// to stay silent; when it is a 1xx the body is then read and echoed back.
+func serveExpect(t *testing.T, decide func(head string) string, bodies chan<- string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+
+				var head strings.Builder
+				contentLength := 0
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					head.WriteString(line)
+					if line == "\r\n" {
+						break
+					}
+					if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "content-length") {
+						contentLength, _ = strconv.Atoi(strings.TrimSpace(value))
+					}
+				}
+
+				first := decide(head.String())
+				if first != "" {
+					io.WriteString(conn, first)
+					if !strings.HasPrefix(first, "HTTP/1.1 1") {
+						return
+					}
+				}
+
+				body := make([]byte, contentLength)
+				if _, err := io.ReadFull(r, body); err != nil {
+					return
+				}
+				bodies <- string(body)
+				fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestExpectContinue(t *testing.T) {
+	largeBody := strings.Repeat("x", 4096)
+
+	// Test: Body is sent after 100 Continue
+	t.Run("Body sent after 100 Continue", func(t *testing.T) {
+		bodies := make(chan string, 1)
+		var sawExpect bool
+		addr := serveExpect(t, func(head string) string {
+			sawExpect = strings.Contains(strings.ToLower(head), "expect: 100-continue")
+			return "HTTP/1.1 100 Continue\r\n\r\n"
+		}, bodies)
+
+		c := &Client{ExpectContinueThreshold: 1024}
+		resp, err := c.Do(newTestRequest("PUT", "http://"+addr+"/upload", largeBody))
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, largeBody, <-bodies)
+		assert.True(t, sawExpect)
+	})
+
+	// Test: Final status short-circuits the upload
+	t.Run("Final status skips the body", func(t *testing.T) {
+		bodies := make(chan string, 1)
+		addr := serveExpect(t, func(head string) string {
+			return "HTTP/1.1 413 Content Too Large\r\nContent-Length: 0\r\n\r\n"
+		}, bodies)
+
+		c := &Client{ExpectContinueThreshold: 1024}
+		resp, err := c.Do(newTestRequest("PUT", "http://"+addr+"/upload", largeBody))
+		require.NoError(t, err)
+		assert.Equal(t, 413, resp.StatusLine.StatusCode)
+		assert.Empty(t, bodies)
+		assert.Equal(t, 0, c.PoolStats().Idle)
+	})
+
+	// Test: Silent server gets the body after the timeout
+	t.Run("Timeout sends the body anyway", func(t *testing.T) {
+		bodies := make(chan string, 1)
+		addr := serveExpect(t, func(head string) string { return "" }, bodies)
+
+		c := &Client{ExpectContinueThreshold: 1024, ExpectContinueTimeout: 50 * time.Millisecond}
+		resp, err := c.Do(newTestRequest("PUT", "http://"+addr+"/upload", largeBody))
+		require.NoError(t, err)
+		assert.Equal(t, largeBody, string(resp.Body))
+	})
+
+	// Test: Small bodies skip Expect
+	t.Run("Small bodies skip Expect", func(t *testing.T) {
+		bodies := make(chan string, 1)
+		var head string
+		addr := serveExpect(t, func(h string) string {
+			head = h
+			return ""
+		}, bodies)
+
+		c := &Client{ExpectContinueThreshold: 1024}
+		resp, err := c.Do(newTestRequest("PUT", "http://"+addr+"/upload", "small"))
+		require.NoError(t, err)
+		assert.Equal(t, "small", string(resp.Body))
+		assert.NotContains(t, strings.ToLower(head), "expect")
+	})
+
+	// Test: Caller-supplied Expect header is honored
+	t.Run("Caller-supplied Expect header", func(t *testing.T) {
+		bodies := make(chan string, 1)
+		addr := serveExpect(t, func(head string) string {
+			return "HTTP/1.1 417 Expectation Failed\r\nContent-Length: 0\r\n\r\n"
+		}, bodies)
+
+		req := newTestRequest("POST", "http://"+addr+"/", "payload")
+		req.Headers.Set("Expect", "100-continue")
+		resp, err := NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 417, resp.StatusLine.StatusCode)
+	})
+}
+
+func TestInterimResponsesAreSkipped(t *testing.T) {
+	// Test: 103 Early Hints arriving with the final response
+	addr, _ := serveKeepAlive(t, func(req *request.Request) string {
+		return "HTTP/1.1 103 Early Hints\r\nLink: </app.js>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+
+	c := NewClient()
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "ok", string(resp.Body))
+	}
+	assert.Equal(t, 1, c.PoolStats().Dials)
+}
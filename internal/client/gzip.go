@@ -0,0 +1,46 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// decodeGzipBody replaces a gzip-encoded body with its decoded form and drops
+// the headers describing the encoded representation. A positive maxSize caps
+// the decoded body, so a small compressed body cannot expand without bound.
+func decodeGzipBody(resp *response.Response, maxSize int64) error {
+	if !strings.EqualFold(strings.TrimSpace(resp.Headers.Get("content-encoding")), "gzip") {
+		return nil
+	}
+
+	if len(resp.Body) > 0 {
+		zr, err := gzip.NewReader(bytes.NewReader(resp.Body))
+		if err != nil {
+			return fmt.Errorf("decoding gzip body: %w", err)
+		}
+		defer zr.Close()
+
+		var body io.Reader = zr
+		if maxSize > 0 {
+			body = io.LimitReader(zr, maxSize+1)
+		}
+		decoded, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("decoding gzip body: %w", err)
+		}
+		if maxSize > 0 && int64(len(decoded)) > maxSize {
+			return fmt.Errorf("%w: decoded body, limit %d", response.ErrBodyTooLarge, maxSize)
+		}
+		resp.Body = decoded
+	}
+
+	resp.Headers.Delete("content-encoding")
+	resp.Headers.Delete("content-length")
+	resp.Uncompressed = true
+	return nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// HTTP/1.0 origins cannot decode chunked request bodies. The client remembers
+// origins that answered with HTTP/1.0 and buffers streamed bodies for them so
+// they go out with a Content-Length instead.
+
+func (c *Client) noteVersion(t *target, resp *response.Response) {
+	if resp.StatusLine.HttpVersion != "1.0" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.http10 == nil {
+		c.http10 = make(map[string]bool)
+	}
+	c.http10[t.addr] = true
+}
+
+func (c *Client) isHTTP10(t *target) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.http10[t.addr]
+}
+
+// bufferBody returns a copy of req whose streamed body has been read into
+// memory and announced with Content-Length.
+func bufferBody(req *request.Request) (*request.Request, error) {
+	body, err := io.ReadAll(req.BodyReader)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := *req
+	buffered.Headers = *req.Headers.Clone()
+	buffered.Headers.Delete("transfer-encoding")
+	buffered.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+	buffered.Body = body
+	buffered.BodyReader = nil
+	return &buffered, nil
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveHTTP10 answers each connection's single request in HTTP/1.0 style: no
+// Content-Length, and the body ends when the connection closes.
+func serveHTTP10(t *testing.T, received chan<- *request.Request) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				received <- req
+				io.WriteString(conn, "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nlegacy body")
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestHTTP10Interop(t *testing.T) {
+	received := make(chan *request.Request, 4)
+	addr := serveHTTP10(t, received)
+	c := NewClient()
+
+	// Test: Close-delimited body is read and the connection is not pooled
+	resp, err := c.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	<-received
+	assert.Equal(t, "1.0", resp.StatusLine.HttpVersion)
+	assert.Equal(t, "legacy body", string(resp.Body))
+	assert.Equal(t, 0, c.PoolStats().Idle)
+
+	// Test: Streamed bodies are sent with Content-Length once the origin is known to be HTTP/1.0
+	req, err := NewRequest("POST", "http://"+addr+"/upload").
+		BodyReader(io.MultiReader(strings.NewReader("no "), strings.NewReader("chunks"))).
+		Build()
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.NoError(t, err)
+
+	got := <-received
+	assert.Equal(t, "9", got.Headers.Get("content-length"))
+	assert.Empty(t, got.Headers.Get("transfer-encoding"))
+	assert.Equal(t, "no chunks", string(got.Body))
+	assert.Equal(t, 2, c.PoolStats().Dials)
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+const (
+	DefaultMaxIdleConnsPerHost = 2
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// PoolStats is a snapshot of the client's connection pool counters.
+type PoolStats struct {
+	// Dials is the number of new connections opened.
+	Dials int
+	// Reuses is the number of requests sent over a pooled connection.
+	Reuses int
+	// Idle is the number of connections currently waiting in the pool.
+	Idle int
+}
+
+// persistConn is a connection that may outlive a single request.
+type persistConn struct {
+	conn      net.Conn
+	reader    *response.Reader
+	key       string
+	reused    bool
+	broken    bool
+	idleTimer *time.Timer
+	tlsState  *tls.ConnectionState
+	// onFirstByte, when set, is called by connReader on the next byte read.
+	onFirstByte func()
+}
+
+func (c *Client) maxIdleConnsPerHost() int {
+	if c.MaxIdleConnsPerHost > 0 {
+		return c.MaxIdleConnsPerHost
+	}
+	return DefaultMaxIdleConnsPerHost
+}
+
+func (c *Client) idleConnTimeout() time.Duration {
+	if c.IdleConnTimeout > 0 {
+		return c.IdleConnTimeout
+	}
+	return DefaultIdleConnTimeout
+}
+
+// getIdleConn pops the most recently used idle connection for key, if any.
+func (c *Client) getIdleConn(key string) *persistConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conns := c.idle[key]
+	if len(conns) == 0 {
+		return nil
+	}
+
+	pc := conns[len(conns)-1]
+	c.idle[key] = conns[:len(conns)-1]
+	pc.idleTimer.Stop()
+	pc.reused = true
+	c.stats.Reuses++
+	return pc
+}
+
+// putIdleConn returns pc to the pool, closing it instead when the host
+// already has enough idle connections.
+func (c *Client) putIdleConn(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.idle == nil {
+		c.idle = map[string][]*persistConn{}
+	}
+
+	if len(c.idle[pc.key]) >= c.maxIdleConnsPerHost() {
+		pc.conn.Close()
+		return
+	}
+
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+	pc.idleTimer = time.AfterFunc(c.idleConnTimeout(), func() {
+		c.removeIdleConn(pc)
+	})
+}
+
+func (c *Client) removeIdleConn(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conns := c.idle[pc.key]
+	for i, idle := range conns {
+		if idle == pc {
+			c.idle[pc.key] = append(conns[:i], conns[i+1:]...)
+			pc.conn.Close()
+			return
+		}
+	}
+}
+
+// CloseIdleConnections closes every connection sitting in the pool.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, conns := range c.idle {
+		for _, pc := range conns {
+			pc.idleTimer.Stop()
+			pc.conn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+// PoolStats returns a snapshot of the pool counters.
+func (c *Client) PoolStats() PoolStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	for _, conns := range c.idle {
+		stats.Idle += len(conns)
+	}
+	return stats
+}
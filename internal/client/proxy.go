@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+var ErrProxyConnect = fmt.Errorf("proxy refused CONNECT")
+
+// ProxyURL returns a Proxy function that always uses proxyURL.
+func ProxyURL(proxyURL *url.URL) func(*request.Request) (*url.URL, error) {
+	return func(*request.Request) (*url.URL, error) {
+		return proxyURL, nil
+	}
+}
+
+func (c *Client) proxyFor(req *request.Request) (*url.URL, error) {
+	if c.Proxy == nil {
+		return nil, nil
+	}
+
+	proxyURL, err := c.Proxy(req)
+	if err != nil || proxyURL == nil {
+		return nil, err
+	}
+	if proxyURL.Scheme != "http" {
+		return nil, fmt.Errorf("%w: proxy scheme %q", ErrUnsupportedScheme, proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("%w: proxy url %q", ErrMissingHost, proxyURL.String())
+	}
+	return proxyURL, nil
+}
+
+// proxyAuthorization returns the Basic credentials embedded in proxyURL, or
+// an empty string when it has none.
+func proxyAuthorization(proxyURL *url.URL) string {
+	if proxyURL.User == nil {
+		return ""
+	}
+	return basicAuthorization(proxyURL.User)
+}
+
+// connectTunnel asks the proxy on conn to open a tunnel to t.addr.
+func (c *Client) connectTunnel(ctx context.Context, conn net.Conn, t *target) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{
+		Method:        "CONNECT",
+		RequestTarget: t.addr,
+		HttpVersion:   "1.1",
+	}
+	req.Headers.Set("Host", t.addr)
+	if auth := proxyAuthorization(t.proxy); auth != "" {
+		req.Headers.Set("Proxy-Authorization", auth)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := response.ResponseFromReaderWithOptions(conn, response.Options{RequestMethod: "CONNECT"})
+	if err != nil {
+		return err
+	}
+	if resp.StatusLine.StatusCode < 200 || resp.StatusLine.StatusCode > 299 {
+		return fmt.Errorf("%w: %d %s", ErrProxyConnect, resp.StatusLine.StatusCode, resp.StatusLine.ReasonPhrase)
+	}
+	return nil
+}
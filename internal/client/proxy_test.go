@@ -0,0 +1,166 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveProxy runs a minimal forward proxy. Plain requests are answered
+// directly with a body describing what the proxy received; CONNECT requests
+// are tunnelled to their target. Every request line and Proxy-Authorization
+// value is sent on seen.
+func serveProxy(t *testing.T, seen chan<- string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleProxyConn(conn, seen)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func handleProxyConn(conn net.Conn, seen chan<- string) {
+	defer conn.Close()
+
+	for {
+		req, err := request.RequestFromReader(conn)
+		if err != nil || req.RequestLine.Method == "" {
+			return
+		}
+		seen <- req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + req.Headers.Get("proxy-authorization")
+
+		if req.RequestLine.Method != "CONNECT" {
+			body := "proxied " + req.RequestLine.RequestTarget
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+			continue
+		}
+
+		upstream, err := net.Dial("tcp", req.RequestLine.RequestTarget)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		defer upstream.Close()
+
+		io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+		return
+	}
+}
+
+func TestProxy(t *testing.T) {
+	// Test: Plain request uses absolute form
+	t.Run("Plain request uses absolute form", func(t *testing.T) {
+		seen := make(chan string, 10)
+		proxyAddr := serveProxy(t, seen)
+		c := &Client{Proxy: ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})}
+
+		resp, err := c.Get("http://example.com/path?q=1")
+		require.NoError(t, err)
+		assert.Equal(t, "proxied http://example.com/path?q=1", string(resp.Body))
+		assert.Equal(t, "GET http://example.com/path?q=1 ", <-seen)
+	})
+
+	// Test: Proxy credentials are sent
+	t.Run("Proxy credentials", func(t *testing.T) {
+		seen := make(chan string, 10)
+		proxyAddr := serveProxy(t, seen)
+		proxyURL, err := url.Parse("http://user:secret@" + proxyAddr)
+		require.NoError(t, err)
+		c := &Client{Proxy: ProxyURL(proxyURL)}
+
+		_, err = c.Get("http://example.com/")
+		require.NoError(t, err)
+		// base64("user:secret")
+		assert.Equal(t, "GET http://example.com/ Basic dXNlcjpzZWNyZXQ=", <-seen)
+	})
+
+	// Test: Plain requests to different hosts share the proxy connection
+	t.Run("Proxy connection is shared", func(t *testing.T) {
+		seen := make(chan string, 10)
+		proxyAddr := serveProxy(t, seen)
+		c := &Client{Proxy: ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})}
+		defer c.CloseIdleConnections()
+
+		_, err := c.Get("http://one.example/")
+		require.NoError(t, err)
+		_, err = c.Get("http://two.example/")
+		require.NoError(t, err)
+		assert.Equal(t, 1, c.PoolStats().Dials)
+	})
+
+	// Test: HTTPS through a CONNECT tunnel
+	t.Run("HTTPS through CONNECT", func(t *testing.T) {
+		cert, pool := selfSignedCert(t)
+		origin := serveTLS(t, cert, okResponse)
+
+		seen := make(chan string, 10)
+		proxyURL, err := url.Parse("http://user:secret@" + serveProxy(t, seen))
+		require.NoError(t, err)
+		c := &Client{
+			Proxy:           ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+		defer c.CloseIdleConnections()
+
+		for i := 0; i < 2; i++ {
+			resp, err := c.Get("https://" + origin + "/secure")
+			require.NoError(t, err)
+			assert.Equal(t, "ok", string(resp.Body))
+			require.NotNil(t, resp.TLS)
+		}
+		assert.Equal(t, "CONNECT "+origin+" Basic dXNlcjpzZWNyZXQ=", <-seen)
+		assert.Equal(t, 1, c.PoolStats().Dials)
+	})
+
+	// Test: CONNECT refused by the proxy
+	t.Run("CONNECT refused", func(t *testing.T) {
+		seen := make(chan string, 10)
+		proxyAddr := serveProxy(t, seen)
+		c := &Client{Proxy: ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		closedAddr := listener.Addr().String()
+		listener.Close()
+
+		_, err = c.Get("https://" + closedAddr + "/")
+		require.ErrorIs(t, err, ErrProxyConnect)
+	})
+
+	// Test: Proxy func can opt out per request
+	t.Run("Direct connection when Proxy returns nil", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, okResponse)
+		c := &Client{Proxy: func(*request.Request) (*url.URL, error) { return nil, nil }}
+
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+	})
+
+	// Test: Unsupported proxy scheme
+	t.Run("Unsupported proxy scheme", func(t *testing.T) {
+		c := &Client{Proxy: ProxyURL(&url.URL{Scheme: "socks5", Host: "127.0.0.1:1080"})}
+		_, err := c.Get("http://example.com/")
+		require.ErrorIs(t, err, ErrUnsupportedScheme)
+	})
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+const DefaultMaxRedirects = 10
+
+var (
+	// ErrUseLastResponse can be returned by CheckRedirect to stop following
+	// redirects and return the most recent response without an error.
+	ErrUseLastResponse  = fmt.Errorf("use last response")
+	ErrTooManyRedirects = fmt.Errorf("too many redirects")
+)
+
+func (c *Client) maxRedirects() int {
+	if c.MaxRedirects > 0 {
+		return c.MaxRedirects
+	}
+	return DefaultMaxRedirects
+}
+
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case 301, 302, 303, 307, 308:
+		return true
+	default:
+		return false
+	}
+}
+
+// redirectMethod returns the method for the follow-up request and whether the
+// original body is carried over.
+func redirectMethod(statusCode int, method string) (string, bool) {
+	switch statusCode {
+	case 301, 302:
+		// Historical user agents turn POST into GET; net/http does the same.
+		if method == "POST" {
+			return "GET", false
+		}
+		return method, true
+	case 303:
+		if method == "HEAD" {
+			return "HEAD", false
+		}
+		return "GET", false
+	default:
+		// 307 and 308 must repeat the request unchanged.
+		return method, true
+	}
+}
+
+// sensitiveHeaders are dropped when a redirect leaves the original host.
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"cookie":              true,
+	"proxy-authorization": true,
+	"www-authenticate":    true,
+}
+
+// nextRedirect builds the request that follows resp, or returns nil when resp
+// is not a redirect that should be followed.
+func (c *Client) nextRedirect(req *request.Request, t *target, resp *response.Response, via []*request.Request) (*request.Request, error) {
+	if !isRedirect(resp.StatusLine.StatusCode) {
+		return nil, nil
+	}
+
+	location := resp.Headers.Get("location")
+	if location == "" {
+		return nil, nil
+	}
+
+	current := t.url()
+	locURL, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect location %q: %w", location, err)
+	}
+	nextURL := current.ResolveReference(locURL)
+
+	method, keepBody := redirectMethod(resp.StatusLine.StatusCode, req.RequestLine.Method)
+	if keepBody && req.BodyReader != nil {
+		// The streamed body has been consumed and cannot be sent again, so
+		// the redirect is left to the caller.
+		return nil, nil
+	}
+	sameHost := nextURL.Host == current.Host
+
+	next := request.NewRequest()
+	next.RequestLine = request.RequestLine{
+		Method:        method,
+		RequestTarget: nextURL.String(),
+		HttpVersion:   req.RequestLine.HttpVersion,
+	}
+	req.Headers.ForEach(func(key, value string) {
+		switch {
+		case key == "host":
+		case !keepBody && (key == "content-length" || key == "content-type"):
+		case !sameHost && sensitiveHeaders[key]:
+		default:
+			next.Headers.Set(key, value)
+		}
+	})
+	if keepBody {
+		next.Body = req.Body
+	}
+	next = next.WithContext(req.Context())
+
+	via = append(via, req)
+	if c.CheckRedirect != nil {
+		if err := c.CheckRedirect(next, via); err != nil {
+			return nil, err
+		}
+	} else if len(via) >= c.maxRedirects() {
+		return nil, fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, len(via))
+	}
+
+	return next, nil
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+const (
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	DefaultRetryMaxDelay  = 2 * time.Second
+)
+
+// RetryPolicy configures automatic retries. The zero value disables them.
+type RetryPolicy struct {
+	// MaxRetries is the number of extra attempts after the first one.
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry; it doubles for every
+	// following one. Zero means DefaultRetryBaseDelay.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff. Zero means DefaultRetryMaxDelay.
+	MaxDelay time.Duration
+	// RetryNonIdempotent also retries methods such as POST and PATCH, which
+	// may have been applied by the server before the failure.
+	RetryNonIdempotent bool
+	// RespectRetryAfter retries 429 Too Many Requests too, and waits as
+	// long as the Retry-After of a 429 or 503 asks instead of backing off.
+	// A response asking for a longer wait than MaxDelay is returned rather
+	// than waited for.
+	RespectRetryAfter bool
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS", "TRACE":
+		return true
+	default:
+		return false
+	}
+}
+
+// replayable reports whether req may be sent again after an attempt whose
+// effect on the server is unknown. Its body must still be at hand, and its
+// method idempotent unless the policy opted in to repeating anything.
+func (p RetryPolicy) replayable(req *request.Request) bool {
+	if req.BodyReader != nil {
+		// A streamed body is consumed by the first attempt.
+		return false
+	}
+	return p.RetryNonIdempotent || isIdempotent(req.RequestLine.Method)
+}
+
+// shouldRetry reports whether an attempt that ended with resp or err is worth
+// repeating.
+func (p RetryPolicy) shouldRetry(resp *response.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return isStaleConnErr(err) || errors.Is(err, io.ErrUnexpectedEOF) ||
+			(errors.As(err, &netErr) && netErr.Timeout()) || errors.Is(err, ErrResponseHeaderTimeout)
+	}
+	code := resp.StatusLine.StatusCode
+	return code == 502 || code == 503 || (code == 429 && p.RespectRetryAfter)
+}
+
+// backoff returns the delay before retry number attempt (starting at 0):
+// exponential growth capped at MaxDelay, with the upper half randomized so
+// that clients retrying together spread out.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	maxDelay := p.maxDelay()
+
+	delay := base << attempt
+	if delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+func (p RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay <= 0 {
+		return DefaultRetryMaxDelay
+	}
+	return p.MaxDelay
+}
+
+// delay returns how long to wait before retry number attempt after resp,
+// and false if resp asks for a longer wait than the policy allows.
+func (p RetryPolicy) delay(attempt int, resp *response.Response) (time.Duration, bool) {
+	if resp == nil || !p.RespectRetryAfter {
+		return p.backoff(attempt), true
+	}
+	d, ok := response.ParseRetryAfter(resp.Headers.Get("retry-after"), time.Now())
+	if !ok {
+		return p.backoff(attempt), true
+	}
+	return d, d <= p.maxDelay()
+}
+
+// doWithRetry performs the exchange, repeating it according to c.Retry.
+func (c *Client) doWithRetry(req *request.Request, t *target) (*response.Response, error) {
+	ctx := req.Context()
+	canRetry := c.Retry.MaxRetries > 0 && c.Retry.replayable(req)
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(req, t)
+		if !canRetry || attempt >= c.Retry.MaxRetries || ctx.Err() != nil || !c.Retry.shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay, ok := c.Retry.delay(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
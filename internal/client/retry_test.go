@@ -0,0 +1,171 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveFlaky answers with 503 until failures requests have been seen, then
+// with 200. It returns the address and a counter of requests.
+func serveFlaky(t *testing.T, failures int32) (string, *atomic.Int32) {
+	t.Helper()
+
+	var seen atomic.Int32
+	addr, _ := serveKeepAlive(t, func(req *request.Request) string {
+		if seen.Add(1) <= failures {
+			return "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n"
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+	return addr, &seen
+}
+
+// serveDropping closes the first drops connections without answering and
+// serves the rest with a 200.
+func serveDropping(t *testing.T, drops int32) (string, *atomic.Int32) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := request.RequestFromReader(conn)
+				if err != nil || accepted.Add(1) <= drops {
+					return
+				}
+				conn.Write([]byte(okResponse(req)))
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), &accepted
+}
+
+func fastRetries(n int) RetryPolicy {
+	return RetryPolicy{MaxRetries: n, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+}
+
+func TestRetries(t *testing.T) {
+	// Test: 503 responses are retried until success
+	t.Run("Retry on 503", func(t *testing.T) {
+		addr, seen := serveFlaky(t, 2)
+		c := &Client{Retry: fastRetries(3)}
+
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, int32(3), seen.Load())
+	})
+
+	// Test: The last response is returned once retries run out
+	t.Run("Retries exhausted", func(t *testing.T) {
+		addr, seen := serveFlaky(t, 10)
+		c := &Client{Retry: fastRetries(2)}
+
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 503, resp.StatusLine.StatusCode)
+		assert.Equal(t, int32(3), seen.Load())
+	})
+
+	// Test: Dropped connections are retried
+	t.Run("Retry on dropped connection", func(t *testing.T) {
+		addr, accepted := serveDropping(t, 2)
+		c := &Client{Retry: fastRetries(3)}
+
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+		assert.Equal(t, int32(3), accepted.Load())
+	})
+
+	// Test: No retries by default
+	t.Run("Disabled by default", func(t *testing.T) {
+		addr, seen := serveFlaky(t, 1)
+
+		resp, err := NewClient().Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 503, resp.StatusLine.StatusCode)
+		assert.Equal(t, int32(1), seen.Load())
+	})
+
+	// Test: POST is not retried unless asked for
+	t.Run("Non-idempotent methods", func(t *testing.T) {
+		addr, seen := serveFlaky(t, 1)
+		c := &Client{Retry: fastRetries(3)}
+
+		resp, err := c.Do(newTestRequest("POST", "http://"+addr+"/", "data"))
+		require.NoError(t, err)
+		assert.Equal(t, 503, resp.StatusLine.StatusCode)
+		assert.Equal(t, int32(1), seen.Load())
+
+		c.Retry.RetryNonIdempotent = true
+		resp, err = c.Do(newTestRequest("POST", "http://"+addr+"/", "data"))
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+	})
+
+	// Test: Retry-After is waited for when respected, and 429 retried
+	t.Run("Retry-After", func(t *testing.T) {
+		var seen atomic.Int32
+		retryAfter := "0"
+		addr, _ := serveKeepAlive(t, func(req *request.Request) string {
+			if seen.Add(1) == 1 {
+				return "HTTP/1.1 429 Too Many Requests\r\nRetry-After: " + retryAfter + "\r\nContent-Length: 0\r\n\r\n"
+			}
+			return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+		})
+
+		c := &Client{Retry: fastRetries(3)}
+		resp, err := c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 429, resp.StatusLine.StatusCode, "429 is not retried by default")
+
+		seen.Store(0)
+		c.Retry.RespectRetryAfter = true
+		resp, err = c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, int32(2), seen.Load())
+
+		seen.Store(0)
+		retryAfter = "3600"
+		resp, err = c.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 429, resp.StatusLine.StatusCode, "a wait over MaxDelay is not made")
+		assert.Equal(t, int32(1), seen.Load())
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	for attempt, want := range []time.Duration{10, 20, 40, 50, 50} {
+		want *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			d := p.backoff(attempt)
+			assert.GreaterOrEqual(t, d, want/2)
+			assert.LessOrEqual(t, d, want)
+		}
+	}
+
+	// Test: Large attempt numbers do not overflow past the cap
+	d := RetryPolicy{}.backoff(100)
+	assert.GreaterOrEqual(t, d, DefaultRetryMaxDelay/2)
+	assert.LessOrEqual(t, d, DefaultRetryMaxDelay)
+}
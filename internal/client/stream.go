@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// streamState carries a DoStream callback through the exchanges of one call.
+type streamState struct {
+	body     func(resp *response.Response) io.Writer
+	streamed *response.Response
+}
+
+type streamKey struct{}
+
+func contextStream(ctx context.Context) *streamState {
+	st, _ := ctx.Value(streamKey{}).(*streamState)
+	return st
+}
+
+// DoStream is like Do, but the body of the final response is written to the
+// writer returned by body instead of being collected in Response.Body. body
+// is called once, with the status line and headers filled in, before any body
+// bytes are written; if it returns nil the body is collected as usual.
+//
+// Responses the client may still act on (redirects it follows, 401
+// challenges it answers, statuses it retries) are read in full first, so body
+// only ever sees the response DoStream returns. DoStream never asks for gzip
+// on its own, since it cannot decode a streamed body.
+func (c *Client) DoStream(req *request.Request, body func(resp *response.Response) io.Writer) (*response.Response, error) {
+	st := &streamState{body: body}
+	resp, err := c.Do(req.WithContext(context.WithValue(req.Context(), streamKey{}, st)))
+	if err != nil {
+		return nil, err
+	}
+
+	if st.streamed != resp {
+		// Buffered because the client might have followed it up.
+		if w := body(resp); w != nil {
+			if _, err := w.Write(resp.Body); err != nil {
+				return nil, err
+			}
+			resp.Body = nil
+		}
+	}
+	return resp, nil
+}
+
+// streamBodyWriter returns the response.Options.BodyWriter hook for an
+// exchange of req, or nil when req is not streamed.
+func (c *Client) streamBodyWriter(req *request.Request) func(*response.Response) io.Writer {
+	st := contextStream(req.Context())
+	if st == nil {
+		return nil
+	}
+
+	return func(resp *response.Response) io.Writer {
+		if isInterim(resp.StatusLine.StatusCode) || c.mayFollowUp(resp) {
+			return nil
+		}
+		st.streamed = resp
+		return st.body(resp)
+	}
+}
+
+// mayFollowUp reports whether Do might send another request after resp
+// instead of returning it.
+func (c *Client) mayFollowUp(resp *response.Response) bool {
+	code := resp.StatusLine.StatusCode
+	switch {
+	case isRedirect(code) && resp.Headers.Get("location") != "":
+		return true
+	case code == 401 && c.Credentials != nil:
+		return true
+	case c.Retry.MaxRetries > 0 && c.Retry.shouldRetry(resp, nil):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signalWriter collects writes and reports the first one on first.
+type signalWriter struct {
+	bytes.Buffer
+	first chan struct{}
+}
+
+func (w *signalWriter) Write(p []byte) (int, error) {
+	if w.Len() == 0 {
+		close(w.first)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestDoStream(t *testing.T) {
+	// Test: Body bytes reach the writer before the response is complete
+	t.Run("Streams as it arrives", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		w := &signalWriter{first: make(chan struct{})}
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if _, err := request.RequestFromReader(conn); err != nil {
+				return
+			}
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nfirst\r\n")
+			// Only finish once the client has seen the first chunk.
+			<-w.first
+			io.WriteString(conn, "7\r\n second\r\n0\r\n\r\n")
+		}()
+
+		var head *response.Response
+		resp, err := NewClient().DoStream(newTestRequest("GET", "http://"+listener.Addr().String()+"/", ""),
+			func(resp *response.Response) io.Writer {
+				head = resp
+				return w
+			})
+		require.NoError(t, err)
+		assert.Same(t, resp, head)
+		assert.Equal(t, "first second", w.String())
+		assert.Empty(t, resp.Body)
+	})
+
+	// Test: Only the final response after a redirect is streamed
+	t.Run("After redirect", func(t *testing.T) {
+		addr := redirectingServer(t)
+
+		var calls int
+		var buf bytes.Buffer
+		resp, err := NewClient().DoStream(newTestRequest("GET", "http://"+addr+"/old", ""),
+			func(*response.Response) io.Writer {
+				calls++
+				return &buf
+			})
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, "GET /new  auth=", buf.String())
+	})
+
+	// Test: An unfollowed redirect is still delivered to the writer
+	t.Run("Unfollowed redirect", func(t *testing.T) {
+		addr := serveOnce(t, "HTTP/1.1 302 Found\r\nLocation: /elsewhere\r\nContent-Length: 4\r\n\r\nmove", nil)
+		c := &Client{CheckRedirect: func(*request.Request, []*request.Request) error { return ErrUseLastResponse }}
+
+		var buf bytes.Buffer
+		resp, err := c.DoStream(newTestRequest("GET", "http://"+addr+"/", ""),
+			func(*response.Response) io.Writer { return &buf })
+		require.NoError(t, err)
+		assert.Equal(t, 302, resp.StatusLine.StatusCode)
+		assert.Equal(t, "move", buf.String())
+		assert.Empty(t, resp.Body)
+	})
+
+	// Test: No gzip is requested for streamed bodies
+	t.Run("No automatic gzip", func(t *testing.T) {
+		received := make(chan *request.Request, 1)
+		addr := serveOnce(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", received)
+
+		_, err := NewClient().DoStream(newTestRequest("GET", "http://"+addr+"/", ""),
+			func(*response.Response) io.Writer { return io.Discard })
+		require.NoError(t, err)
+		assert.Empty(t, (<-received).Headers.Get("accept-encoding"))
+	})
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// handshake wraps conn in a TLS client connection for addr and performs the
+// handshake, closing conn on failure.
+func (c *Client) handshake(ctx context.Context, conn net.Conn, addr string) (*tls.Conn, error) {
+	cfg := &tls.Config{}
+	if c.TLSClientConfig != nil {
+		cfg = c.TLSClientConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		cfg.ServerName = host
+	}
+	if len(cfg.NextProtos) == 0 {
+		// This client only speaks HTTP/1.1.
+		cfg.NextProtos = []string{"http/1.1"}
+	}
+
+	if c.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.TLSHandshakeTimeout)
+		defer cancel()
+	}
+
+	trace := ContextClientTrace(ctx)
+	trace.tlsHandshakeStart()
+	tlsConn := tls.Client(conn, cfg)
+	err := tlsConn.HandshakeContext(ctx)
+	trace.tlsHandshakeDone(tlsConn.ConnectionState(), err)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
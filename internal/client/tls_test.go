@@ -0,0 +1,172 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSignedCert returns a certificate valid for localhost and 127.0.0.1 and
+// a pool that trusts it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// serveTLS is serveKeepAlive over TLS.
+func serveTLS(t *testing.T, cert tls.Certificate, respond func(req *request.Request) string) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"http/1.1"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := request.RequestFromReader(conn)
+					if err != nil || req.RequestLine.Method == "" {
+						return
+					}
+					io.WriteString(conn, respond(req))
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	addr := serveTLS(t, cert, okResponse)
+	_, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+
+	// Test: Custom RootCAs
+	t.Run("Custom RootCAs", func(t *testing.T) {
+		c := &Client{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		defer c.CloseIdleConnections()
+
+		resp, err := c.Get("https://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+		require.NotNil(t, resp.TLS)
+		assert.True(t, resp.TLS.HandshakeComplete)
+		assert.Equal(t, "http/1.1", resp.TLS.NegotiatedProtocol)
+		assert.Equal(t, "localhost", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	})
+
+	// Test: Hostname used as ServerName
+	t.Run("Hostname as ServerName", func(t *testing.T) {
+		c := &Client{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		defer c.CloseIdleConnections()
+
+		resp, err := c.Get("https://localhost:" + port + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "localhost", resp.TLS.ServerName)
+	})
+
+	// Test: Explicit ServerName
+	t.Run("Explicit ServerName", func(t *testing.T) {
+		c := &Client{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "wrong.example"}}
+		_, err := c.Get("https://" + addr + "/")
+		require.Error(t, err)
+	})
+
+	// Test: Unknown authority is rejected
+	t.Run("Unknown authority", func(t *testing.T) {
+		_, err := NewClient().Get("https://" + addr + "/")
+		require.Error(t, err)
+		var unknownAuthority x509.UnknownAuthorityError
+		assert.ErrorAs(t, err, &unknownAuthority)
+	})
+
+	// Test: InsecureSkipVerify
+	t.Run("InsecureSkipVerify", func(t *testing.T) {
+		c := &Client{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		resp, err := c.Get("https://" + addr + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+	})
+
+	// Test: TLS connections are pooled separately and reused
+	t.Run("TLS connections are reused", func(t *testing.T) {
+		c := &Client{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		defer c.CloseIdleConnections()
+
+		for i := 0; i < 3; i++ {
+			resp, err := c.Get("https://" + addr + "/")
+			require.NoError(t, err)
+			require.NotNil(t, resp.TLS)
+		}
+		assert.Equal(t, 1, c.PoolStats().Dials)
+	})
+
+	// Test: Handshake timeout
+	t.Run("Handshake timeout", func(t *testing.T) {
+		// A plain TCP listener never answers the ClientHello.
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		go func() {
+			conn, err := listener.Accept()
+			if err == nil {
+				defer conn.Close()
+				time.Sleep(time.Second)
+			}
+		}()
+
+		c := &Client{TLSHandshakeTimeout: 50 * time.Millisecond}
+		start := time.Now()
+		_, err = c.Get("https://" + listener.Addr().String() + "/")
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// ClientTrace is a set of hooks called at the stages of a request made by
+// Client, in the spirit of net/http/httptrace. Any hook may be nil. Unless
+// noted otherwise, hooks run on the goroutine performing the request.
+type ClientTrace struct {
+	// GetConn is called before a connection to hostPort is taken from the
+	// pool or dialled.
+	GetConn func(hostPort string)
+	// GotConn is called once a connection is ready to carry the request.
+	GotConn func(info GotConnInfo)
+	// DNSStart and DNSDone surround the host name lookup. They are not
+	// called when the address is already an IP.
+	DNSStart func(host string)
+	DNSDone  func(addrs []net.IPAddr, err error)
+	// ConnectStart and ConnectDone surround each TCP dial attempt. When
+	// addresses are raced they are called from the dialling goroutines,
+	// possibly concurrently.
+	ConnectStart func(network, addr string)
+	ConnectDone  func(network, addr string, err error)
+	// TLSHandshakeStart and TLSHandshakeDone surround the TLS handshake of
+	// https requests.
+	TLSHandshakeStart func()
+	TLSHandshakeDone  func(state tls.ConnectionState, err error)
+	// WroteRequest is called after the whole request, body included, has
+	// been written, or with the error that stopped it.
+	WroteRequest func(err error)
+	// GotFirstResponseByte is called when the first byte of the response
+	// arrives.
+	GotFirstResponseByte func()
+}
+
+// GotConnInfo describes the connection passed to ClientTrace.GotConn.
+type GotConnInfo struct {
+	Conn net.Conn
+	// Reused is true when the connection came from the idle pool.
+	Reused bool
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace returns a context derived from ctx that makes the client
+// call trace's hooks for requests carrying it.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the trace attached to ctx, or nil.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// The helpers below make a nil trace, or nil hooks, safe to call.
+
+func (t *ClientTrace) getConn(hostPort string) {
+	if t != nil && t.GetConn != nil {
+		t.GetConn(hostPort)
+	}
+}
+
+func (t *ClientTrace) gotConn(pc *persistConn) {
+	if t != nil && t.GotConn != nil {
+		t.GotConn(GotConnInfo{Conn: pc.conn, Reused: pc.reused})
+	}
+}
+
+func (t *ClientTrace) dnsStart(host string) {
+	if t != nil && t.DNSStart != nil {
+		t.DNSStart(host)
+	}
+}
+
+func (t *ClientTrace) dnsDone(addrs []net.IPAddr, err error) {
+	if t != nil && t.DNSDone != nil {
+		t.DNSDone(addrs, err)
+	}
+}
+
+func (t *ClientTrace) connectStart(network, addr string) {
+	if t != nil && t.ConnectStart != nil {
+		t.ConnectStart(network, addr)
+	}
+}
+
+func (t *ClientTrace) connectDone(network, addr string, err error) {
+	if t != nil && t.ConnectDone != nil {
+		t.ConnectDone(network, addr, err)
+	}
+}
+
+func (t *ClientTrace) tlsHandshakeStart() {
+	if t != nil && t.TLSHandshakeStart != nil {
+		t.TLSHandshakeStart()
+	}
+}
+
+func (t *ClientTrace) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	if t != nil && t.TLSHandshakeDone != nil {
+		t.TLSHandshakeDone(state, err)
+	}
+}
+
+func (t *ClientTrace) wroteRequest(err error) {
+	if t != nil && t.WroteRequest != nil {
+		t.WroteRequest(err)
+	}
+}
+
+// connReader feeds a persistConn's response reader, reporting the first byte
+// read after onFirstByte was armed.
+type connReader struct {
+	pc *persistConn
+}
+
+func (r connReader) Read(p []byte) (int, error) {
+	n, err := r.pc.conn.Read(p)
+	if n > 0 && r.pc.onFirstByte != nil {
+		hook := r.pc.onFirstByte
+		r.pc.onFirstByte = nil
+		hook()
+	}
+	return n, err
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// traceRecorder collects the names of the hooks called, in order.
+type traceRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *traceRecorder) add(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *traceRecorder) trace() *ClientTrace {
+	return &ClientTrace{
+		GetConn:              func(string) { r.add("GetConn") },
+		GotConn:              func(info GotConnInfo) { r.add("GotConn reused=%v", info.Reused) },
+		DNSStart:             func(host string) { r.add("DNSStart %s", host) },
+		DNSDone:              func(_ []net.IPAddr, err error) { r.add("DNSDone err=%v", err) },
+		ConnectStart:         func(string, string) { r.add("ConnectStart") },
+		ConnectDone:          func(_, _ string, err error) { r.add("ConnectDone err=%v", err != nil) },
+		TLSHandshakeStart:    func() { r.add("TLSHandshakeStart") },
+		TLSHandshakeDone:     func(_ tls.ConnectionState, err error) { r.add("TLSHandshakeDone err=%v", err) },
+		WroteRequest:         func(err error) { r.add("WroteRequest err=%v", err) },
+		GotFirstResponseByte: func() { r.add("GotFirstResponseByte") },
+	}
+}
+
+// withoutFailedConnects drops the ConnectStart/ConnectDone pairs of
+// addresses that refused the connection, such as ::1 for localhost when the
+// server only listens on IPv4.
+func withoutFailedConnects(events []string) []string {
+	var out []string
+	for i := 0; i < len(events); i++ {
+		if events[i] == "ConnectStart" && i+1 < len(events) && events[i+1] == "ConnectDone err=true" {
+			i++
+			continue
+		}
+		out = append(out, events[i])
+	}
+	return out
+}
+
+func TestClientTrace(t *testing.T) {
+	// Test: Hooks fire in order for a fresh and then a reused connection
+	t.Run("Plain HTTP", func(t *testing.T) {
+		addr, _ := serveKeepAlive(t, okResponse)
+		_, port, err := net.SplitHostPort(addr)
+		require.NoError(t, err)
+		c := NewClient()
+		defer c.CloseIdleConnections()
+
+		rec := &traceRecorder{}
+		ctx := WithClientTrace(context.Background(), rec.trace())
+		for i := 0; i < 2; i++ {
+			req, err := NewRequest("GET", "http://localhost:"+port+"/").Context(ctx).Build()
+			require.NoError(t, err)
+			_, err = c.Do(req)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, []string{
+			"GetConn",
+			"DNSStart localhost",
+			"DNSDone err=<nil>",
+			"ConnectStart",
+			"ConnectDone err=false",
+			"GotConn reused=false",
+			"WroteRequest err=<nil>",
+			"GotFirstResponseByte",
+			"GetConn",
+			"GotConn reused=true",
+			"WroteRequest err=<nil>",
+			"GotFirstResponseByte",
+		}, withoutFailedConnects(rec.events))
+	})
+
+	// Test: IP addresses skip DNS; TLS handshake is reported
+	t.Run("TLS", func(t *testing.T) {
+		cert, pool := selfSignedCert(t)
+		addr := serveTLS(t, cert, okResponse)
+		c := &Client{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		defer c.CloseIdleConnections()
+
+		rec := &traceRecorder{}
+		req, err := NewRequest("GET", "https://"+addr+"/").
+			Context(WithClientTrace(context.Background(), rec.trace())).
+			Build()
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"GetConn",
+			"ConnectStart",
+			"ConnectDone err=false",
+			"TLSHandshakeStart",
+			"TLSHandshakeDone err=<nil>",
+			"GotConn reused=false",
+			"WroteRequest err=<nil>",
+			"GotFirstResponseByte",
+		}, rec.events)
+	})
+
+	// Test: A request without a trace is unaffected
+	t.Run("No trace", func(t *testing.T) {
+		assert.Nil(t, ContextClientTrace(context.Background()))
+		addr, _ := serveKeepAlive(t, okResponse)
+		_, err := NewClient().Get("http://" + addr + "/")
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/chunked"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveChunkedUpload reads one request whose body is chunked, decodes it and
+// hands the head and body to received before answering 200.
+func serveChunkedUpload(t *testing.T, received chan<- [2]string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var raw []byte
+		buf := make([]byte, 4096)
+		for !bytes.HasSuffix(raw, []byte("0\r\n\r\n")) {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			raw = append(raw, buf[:n]...)
+		}
+
+		head, rest, _ := strings.Cut(string(raw), "\r\n\r\n")
+		d := chunked.NewDecoder()
+		var body []byte
+		data := []byte(rest)
+		for !d.Done() {
+			n, payload, _, err := d.Parse(data)
+			if err != nil {
+				return
+			}
+			body = append(body, payload...)
+			data = data[n:]
+		}
+
+		received <- [2]string{head, string(body)}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestStreamingUpload(t *testing.T) {
+	// Test: A body of unknown length is sent chunked
+	t.Run("Chunked upload", func(t *testing.T) {
+		received := make(chan [2]string, 1)
+		addr := serveChunkedUpload(t, received)
+
+		// io.MultiReader hides the length from the client.
+		body := io.MultiReader(strings.NewReader("first part, "), strings.NewReader(strings.Repeat("x", 50000)))
+		req, err := NewRequest("POST", "http://"+addr+"/upload").BodyReader(body).Build()
+		require.NoError(t, err)
+
+		resp, err := NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+
+		got := <-received
+		head := strings.ToLower(got[0])
+		assert.Contains(t, head, "transfer-encoding: chunked")
+		assert.NotContains(t, head, "content-length")
+		assert.Equal(t, "first part, "+strings.Repeat("x", 50000), got[1])
+	})
+
+	// Test: Redirects that would resend the streamed body are not followed
+	t.Run("307 not followed", func(t *testing.T) {
+		addr := serveOnce(t, "HTTP/1.1 307 Temporary Redirect\r\nLocation: /elsewhere\r\nContent-Length: 0\r\n\r\n", nil)
+
+		req, err := NewRequest("PUT", "http://"+addr+"/").
+			Header("Content-Length", "4").
+			BodyReader(strings.NewReader("data")).
+			Build()
+		require.NoError(t, err)
+
+		resp, err := NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 307, resp.StatusLine.StatusCode)
+	})
+}
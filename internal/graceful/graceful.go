@@ -0,0 +1,54 @@
+// Package graceful gives the commands a common way to stop on SIGINT or
+// SIGTERM: stop taking new work, let work in flight finish, then exit.
+package graceful
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultTimeout bounds how long Wait lets in-flight work run after a
+// signal.
+const DefaultTimeout = 10 * time.Second
+
+// signals are the signals that ask a command to stop.
+var signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// NotifyContext returns a copy of parent that is cancelled on the first
+// SIGINT or SIGTERM. Calling stop restores the default signal behaviour, so
+// a second Ctrl-C kills the process.
+func NotifyContext(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(parent, signals...)
+}
+
+// Wait blocks until SIGINT or SIGTERM, then calls shutdown with a context
+// that ends after timeout or on a second signal, whichever comes first.
+// It returns shutdown's error.
+func Wait(timeout time.Duration, shutdown func(ctx context.Context) error) error {
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, signals...)
+	defer signal.Stop(sigChan)
+
+	sig := <-sigChan
+	log.Printf("received %v, shutting down (send again to force)", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := shutdown(ctx)
+	if err != nil {
+		log.Printf("shutdown incomplete: %v", err)
+	}
+	return err
+}
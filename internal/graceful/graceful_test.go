@@ -0,0 +1,60 @@
+package graceful
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWait(t *testing.T) {
+	// Test: A signal runs shutdown with a live context
+	t.Run("Signal triggers shutdown", func(t *testing.T) {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		}()
+
+		called := false
+		err := Wait(time.Second, func(ctx context.Context) error {
+			called = true
+			assert.NoError(t, ctx.Err())
+			return nil
+		})
+		require.NoError(t, err)
+		assert.True(t, called)
+	})
+
+	// Test: A second signal cancels the shutdown context
+	t.Run("Second signal forces", func(t *testing.T) {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+		}()
+
+		err := Wait(time.Minute, func(ctx context.Context) error {
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	// Test: The timeout bounds shutdown
+	t.Run("Timeout", func(t *testing.T) {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		}()
+
+		err := Wait(30*time.Millisecond, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,754 @@
+// Package h2 serves HTTP/2 (RFC 9113) on connections that negotiated it
+// with ALPN, handing every stream to the same handlers as HTTP/1.1.
+//
+// A stream's request is checked against the HTTP/2 rules and then read by
+// the request package as if it had come over HTTP/1.1, so handlers see the
+// same Request, with HttpVersion "2". The response a handler writes through
+// its response.Writer is parsed as it is written and goes out as HEADERS
+// and DATA frames, framing fields such as Connection and Transfer-Encoding
+// dropped. Handlers cannot hijack the connection or switch protocols.
+//
+// Support is experimental: priorities are ignored, nothing is pushed, and
+// header blocks are sent without Huffman coding or the dynamic table.
+package h2
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// ALPN is the protocol ID of HTTP/2 over TLS.
+const ALPN = "h2"
+
+// ClientPreface is what a client sends first on every connection (RFC 9113
+// section 3.4), followed by a SETTINGS frame.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	// DefaultMaxConcurrentStreams is the default for
+	// Options.MaxConcurrentStreams.
+	DefaultMaxConcurrentStreams = 100
+	// maxHeaderListSize bounds a request's header block, compressed and
+	// decoded, and is announced as SETTINGS_MAX_HEADER_LIST_SIZE.
+	maxHeaderListSize = 1 << 20
+	// headerTableSize is the HPACK dynamic table size the client's encoder
+	// may use.
+	headerTableSize = 4096
+)
+
+// Handler responds to a request; it has the method set of server.Handler,
+// so any handler of the server package serves HTTP/2 as well.
+type Handler interface {
+	ServeHTTP(w *response.Writer, req *request.Request)
+}
+
+// Options configures a connection.
+type Options struct {
+	// MaxConcurrentStreams is how many streams the client may have open at
+	// once; more are refused with REFUSED_STREAM. Zero means
+	// DefaultMaxConcurrentStreams.
+	MaxConcurrentStreams uint32
+	// Request holds the options requests are parsed with, as for HTTP/1.1.
+	// Bodies are always read whole before the handler runs, so StreamBody
+	// is ignored.
+	Request request.Options
+	// OnActive, if set, is called with true when the first stream opens
+	// and with false when the last one has been answered, so the server can
+	// tell a busy connection from an idle one. It is called with the
+	// connection's lock held and must not call back into the Conn.
+	OnActive func(active bool)
+}
+
+// Conn is one HTTP/2 connection, serving its streams concurrently.
+type Conn struct {
+	conn    net.Conn
+	handler Handler
+	opts    Options
+	br      *bufio.Reader
+	dec     *decoder
+	// pending is the header block of a HEADERS frame awaiting its
+	// CONTINUATION frames.
+	pending *pendingHeaders
+	wg      sync.WaitGroup
+
+	// wmu serialises writes, so that a HEADERS frame and its CONTINUATION
+	// frames go out together.
+	wmu sync.Mutex
+	bw  *bufio.Writer
+	buf []byte
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// streams holds the streams not yet answered completely.
+	streams map[uint32]*stream
+	// lastStreamID is the highest stream the client has opened.
+	lastStreamID uint32
+	// sendWindow is the connection's flow-control window for DATA sent.
+	sendWindow int64
+	// initialWindow and maxFrameSize are the client's settings.
+	initialWindow int64
+	maxFrameSize  uint32
+	goingAway     bool
+	closed        bool
+}
+
+// pendingHeaders is a header block split over several frames.
+type pendingHeaders struct {
+	streamID  uint32
+	block     []byte
+	endStream bool
+}
+
+// stream is a request-response exchange on the connection.
+type stream struct {
+	id     uint32
+	fields []headerField
+	// fieldsErr is set when the header list was over maxHeaderListSize.
+	fieldsErr error
+	body      []byte
+	bodyLen   int64
+	// dispatched is set once the request is complete and its handler
+	// started.
+	dispatched bool
+	// sendWindow is the stream's flow-control window for DATA sent.
+	sendWindow int64
+	reset      bool
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// NewConn returns a Conn serving conn, whose TLS handshake, if any, is
+// done, with handler. Nothing is read or written before Serve.
+func NewConn(conn net.Conn, handler Handler, opts Options) *Conn {
+	if opts.MaxConcurrentStreams == 0 {
+		opts.MaxConcurrentStreams = DefaultMaxConcurrentStreams
+	}
+	c := &Conn{
+		conn:          conn,
+		handler:       handler,
+		opts:          opts,
+		br:            bufio.NewReader(conn),
+		bw:            bufio.NewWriter(conn),
+		dec:           newDecoder(headerTableSize),
+		streams:       map[uint32]*stream{},
+		sendWindow:    defaultWindowSize,
+		initialWindow: defaultWindowSize,
+		maxFrameSize:  defaultMaxFrameSize,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Serve reads the client's preface and then its frames until the
+// connection ends, running a handler for each request. It returns once
+// every handler has returned and the connection is closed: nil when the
+// client closed it, went away or GoAway was called, and otherwise the
+// error that ended it, a *ConnError for protocol errors.
+func (c *Conn) Serve() error {
+	defer c.shutdown()
+
+	c.writeFrame(frameSettings, 0, 0, appendSettings(nil,
+		setting{settingMaxConcurrentStreams, c.opts.MaxConcurrentStreams},
+		setting{settingMaxHeaderListSize, maxHeaderListSize},
+	))
+
+	preface := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(c.br, preface); err != nil {
+		return err
+	}
+	if string(preface) != ClientPreface {
+		return c.fail(connError(ErrCodeProtocol, "invalid client preface"))
+	}
+
+	for first := true; ; first = false {
+		f, err := readFrame(c.br, defaultMaxFrameSize)
+		if err != nil {
+			var ce *ConnError
+			if errors.As(err, &ce) {
+				return c.fail(ce)
+			}
+			if c.isClosed() || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if first && f.typ != frameSettings {
+			return c.fail(connError(ErrCodeProtocol, "preface not followed by SETTINGS"))
+		}
+
+		err = c.processFrame(f)
+		var se *streamError
+		switch {
+		case errors.As(err, &se):
+			c.resetStream(se.id, se.code)
+		case err != nil:
+			var ce *ConnError
+			if !errors.As(err, &ce) {
+				ce = connError(ErrCodeInternal, "%v", err)
+			}
+			return c.fail(ce)
+		}
+	}
+}
+
+// GoAway starts a graceful shutdown: the client is told with GOAWAY that
+// no stream after those it has opened will be served, and the connection
+// is closed once they have been answered.
+func (c *Conn) GoAway() {
+	c.mu.Lock()
+	if c.goingAway || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.goingAway = true
+	last, idle := c.lastStreamID, len(c.streams) == 0
+	c.mu.Unlock()
+
+	c.writeGoAway(last, ErrCodeNo)
+	if idle {
+		c.conn.Close()
+	}
+}
+
+// fail sends GOAWAY for a connection error and returns it.
+func (c *Conn) fail(err *ConnError) error {
+	c.mu.Lock()
+	last := c.lastStreamID
+	c.mu.Unlock()
+	c.writeGoAway(last, err.Code)
+	return err
+}
+
+// shutdown closes the connection, cancels the streams still open and waits
+// for their handlers.
+func (c *Conn) shutdown() {
+	c.mu.Lock()
+	c.closed = true
+	for _, st := range c.streams {
+		st.cancel()
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	c.conn.Close()
+	c.wg.Wait()
+}
+
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// processFrame acts on one frame from the client.
+func (c *Conn) processFrame(f *frame) error {
+	if c.pending != nil && (f.typ != frameContinuation || f.streamID != c.pending.streamID) {
+		return connError(ErrCodeProtocol, "header block of stream %d interrupted", c.pending.streamID)
+	}
+
+	switch f.typ {
+	case frameHeaders:
+		return c.processHeaders(f)
+	case frameContinuation:
+		return c.processContinuation(f)
+	case frameData:
+		return c.processData(f)
+	case frameRSTStream:
+		return c.processRSTStream(f)
+	case frameSettings:
+		return c.processSettings(f)
+	case framePing:
+		return c.processPing(f)
+	case frameGoAway:
+		return c.processGoAway(f)
+	case frameWindowUpdate:
+		return c.processWindowUpdate(f)
+	case framePriority:
+		if f.streamID == 0 {
+			return connError(ErrCodeProtocol, "PRIORITY on stream 0")
+		}
+		if len(f.payload) != 5 {
+			return &streamError{f.streamID, ErrCodeFrameSize, "PRIORITY frame size"}
+		}
+		return nil
+	case framePushPromise:
+		return connError(ErrCodeProtocol, "PUSH_PROMISE from a client")
+	}
+	// Frames of unknown types are ignored (section 4.1).
+	return nil
+}
+
+func (c *Conn) processHeaders(f *frame) error {
+	if f.streamID == 0 || f.streamID%2 == 0 {
+		return connError(ErrCodeProtocol, "HEADERS on stream %d", f.streamID)
+	}
+	block, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	if f.has(flagPriority) {
+		if len(block) < 5 {
+			return connError(ErrCodeFrameSize, "HEADERS priority truncated")
+		}
+		block = block[5:]
+	}
+	p := &pendingHeaders{streamID: f.streamID, block: block, endStream: f.has(flagEndStream)}
+	if !f.has(flagEndHeaders) {
+		c.pending = p
+		return nil
+	}
+	return c.processHeaderBlock(p)
+}
+
+func (c *Conn) processContinuation(f *frame) error {
+	p := c.pending
+	if p == nil {
+		return connError(ErrCodeProtocol, "CONTINUATION without HEADERS")
+	}
+	if len(p.block)+len(f.payload) > maxHeaderListSize {
+		return connError(ErrCodeEnhanceYourCalm, "header block over %d bytes", maxHeaderListSize)
+	}
+	p.block = append(p.block, f.payload...)
+	if !f.has(flagEndHeaders) {
+		return nil
+	}
+	c.pending = nil
+	return c.processHeaderBlock(p)
+}
+
+// processHeaderBlock opens a stream with a complete header block, or ends
+// one with trailers.
+func (c *Conn) processHeaderBlock(p *pendingHeaders) error {
+	// The block is decoded whatever becomes of the stream, to keep the
+	// dynamic table in step with the client's.
+	fields, err := c.dec.decode(p.block, maxHeaderListSize)
+	var fieldsErr error
+	switch {
+	case errors.Is(err, errHeaderListSize):
+		fieldsErr = err
+	case err != nil:
+		return connError(ErrCodeCompression, "%v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st, ok := c.streams[p.streamID]; ok {
+		// Trailers, which the request package has no place for.
+		if st.dispatched || !p.endStream {
+			return &streamError{p.streamID, ErrCodeProtocol, "HEADERS after the request"}
+		}
+		c.dispatch(st)
+		return nil
+	}
+	if p.streamID <= c.lastStreamID {
+		return connError(ErrCodeStreamClosed, "HEADERS on closed stream %d", p.streamID)
+	}
+	c.lastStreamID = p.streamID
+	if c.goingAway || c.closed {
+		return &streamError{p.streamID, ErrCodeRefusedStream, "going away"}
+	}
+	if uint32(len(c.streams)) >= c.opts.MaxConcurrentStreams {
+		return &streamError{p.streamID, ErrCodeRefusedStream, "too many streams"}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st := &stream{
+		id:         p.streamID,
+		fields:     fields,
+		fieldsErr:  fieldsErr,
+		sendWindow: c.initialWindow,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	c.streams[st.id] = st
+	if len(c.streams) == 1 && c.opts.OnActive != nil {
+		c.opts.OnActive(true)
+	}
+	if p.endStream {
+		c.dispatch(st)
+	}
+	return nil
+}
+
+// dispatch starts the handler of a complete request. c.mu is held.
+func (c *Conn) dispatch(st *stream) {
+	st.dispatched = true
+	c.wg.Add(1)
+	go c.runStream(st)
+}
+
+func (c *Conn) processData(f *frame) error {
+	if f.streamID == 0 {
+		return connError(ErrCodeProtocol, "DATA on stream 0")
+	}
+	data, err := unpad(f)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	st, ok := c.streams[f.streamID]
+	idle := !ok && f.streamID > c.lastStreamID
+	c.mu.Unlock()
+	if idle {
+		return connError(ErrCodeProtocol, "DATA on idle stream %d", f.streamID)
+	}
+	// The window is given back at once, the whole frame counted, padding
+	// included; bodies are bounded by the request's MaxBodySize instead.
+	if n := uint32(len(f.payload)); n > 0 {
+		c.writeWindowUpdate(0, n)
+		if ok && !st.dispatched && !f.has(flagEndStream) {
+			c.writeWindowUpdate(f.streamID, n)
+		}
+	}
+	if !ok || st.dispatched {
+		return &streamError{f.streamID, ErrCodeStreamClosed, "DATA after the request"}
+	}
+
+	limit := c.opts.Request.MaxBodySize
+	if limit <= 0 {
+		limit = request.MaxContentLength
+	}
+	st.bodyLen += int64(len(data))
+	// Past the limit the body is only counted, for the parser to refuse.
+	if st.bodyLen <= limit {
+		st.body = append(st.body, data...)
+	}
+	if f.has(flagEndStream) {
+		c.mu.Lock()
+		c.dispatch(st)
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *Conn) processRSTStream(f *frame) error {
+	if f.streamID == 0 {
+		return connError(ErrCodeProtocol, "RST_STREAM on stream 0")
+	}
+	if len(f.payload) != 4 {
+		return connError(ErrCodeFrameSize, "RST_STREAM frame size")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.streamID > c.lastStreamID {
+		return connError(ErrCodeProtocol, "RST_STREAM on idle stream %d", f.streamID)
+	}
+	if st, ok := c.streams[f.streamID]; ok {
+		st.reset = true
+		st.cancel()
+		if !st.dispatched {
+			c.removeStream(st)
+		}
+		c.cond.Broadcast()
+	}
+	return nil
+}
+
+func (c *Conn) processSettings(f *frame) error {
+	if f.streamID != 0 {
+		return connError(ErrCodeProtocol, "SETTINGS on stream %d", f.streamID)
+	}
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return connError(ErrCodeFrameSize, "SETTINGS ack with a payload")
+		}
+		return nil
+	}
+	if len(f.payload)%6 != 0 {
+		return connError(ErrCodeFrameSize, "SETTINGS frame size")
+	}
+
+	c.mu.Lock()
+	for p := f.payload; len(p) > 0; p = p[6:] {
+		id, value := binary.BigEndian.Uint16(p), binary.BigEndian.Uint32(p[2:])
+		switch id {
+		case settingEnablePush:
+			if value > 1 {
+				c.mu.Unlock()
+				return connError(ErrCodeProtocol, "SETTINGS_ENABLE_PUSH %d", value)
+			}
+		case settingInitialWindowSize:
+			if value > maxWindowSize {
+				c.mu.Unlock()
+				return connError(ErrCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE %d", value)
+			}
+			// A new initial size shifts every open stream's window by the
+			// difference (section 6.9.2).
+			delta := int64(value) - c.initialWindow
+			for _, st := range c.streams {
+				st.sendWindow += delta
+			}
+			c.initialWindow = int64(value)
+		case settingMaxFrameSize:
+			if value < defaultMaxFrameSize || value > maxFrameSizeLimit {
+				c.mu.Unlock()
+				return connError(ErrCodeProtocol, "SETTINGS_MAX_FRAME_SIZE %d", value)
+			}
+			c.maxFrameSize = value
+		}
+		// The encoder keeps no dynamic table, so SETTINGS_HEADER_TABLE_SIZE
+		// needs nothing; the rest only bind the client.
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	c.writeFrame(frameSettings, flagAck, 0, nil)
+	return nil
+}
+
+func (c *Conn) processPing(f *frame) error {
+	if f.streamID != 0 {
+		return connError(ErrCodeProtocol, "PING on stream %d", f.streamID)
+	}
+	if len(f.payload) != 8 {
+		return connError(ErrCodeFrameSize, "PING frame size")
+	}
+	if !f.has(flagAck) {
+		c.writeFrame(framePing, flagAck, 0, f.payload)
+	}
+	return nil
+}
+
+func (c *Conn) processGoAway(f *frame) error {
+	if f.streamID != 0 {
+		return connError(ErrCodeProtocol, "GOAWAY on stream %d", f.streamID)
+	}
+	if len(f.payload) < 8 {
+		return connError(ErrCodeFrameSize, "GOAWAY frame size")
+	}
+	// The client opens no more streams; those open are still answered.
+	c.mu.Lock()
+	c.goingAway = true
+	idle := len(c.streams) == 0
+	c.mu.Unlock()
+	if idle {
+		c.conn.Close()
+	}
+	return nil
+}
+
+func (c *Conn) processWindowUpdate(f *frame) error {
+	if len(f.payload) != 4 {
+		return connError(ErrCodeFrameSize, "WINDOW_UPDATE frame size")
+	}
+	increment := int64(binary.BigEndian.Uint32(f.payload) & (1<<31 - 1))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.streamID == 0 {
+		if increment == 0 {
+			return connError(ErrCodeProtocol, "WINDOW_UPDATE of 0")
+		}
+		if c.sendWindow+increment > maxWindowSize {
+			return connError(ErrCodeFlowControl, "connection window over 2^31-1")
+		}
+		c.sendWindow += increment
+		c.cond.Broadcast()
+		return nil
+	}
+
+	if f.streamID > c.lastStreamID {
+		return connError(ErrCodeProtocol, "WINDOW_UPDATE on idle stream %d", f.streamID)
+	}
+	st, ok := c.streams[f.streamID]
+	if !ok {
+		// The stream has just been answered; the update came too late.
+		return nil
+	}
+	if increment == 0 {
+		return &streamError{f.streamID, ErrCodeProtocol, "WINDOW_UPDATE of 0"}
+	}
+	if st.sendWindow+increment > maxWindowSize {
+		return &streamError{f.streamID, ErrCodeFlowControl, "stream window over 2^31-1"}
+	}
+	st.sendWindow += increment
+	c.cond.Broadcast()
+	return nil
+}
+
+// resetStream ends a stream with RST_STREAM.
+func (c *Conn) resetStream(id uint32, code ErrCode) {
+	c.mu.Lock()
+	if st, ok := c.streams[id]; ok {
+		st.reset = true
+		st.cancel()
+		if !st.dispatched {
+			c.removeStream(st)
+		}
+		c.cond.Broadcast()
+	}
+	c.mu.Unlock()
+	c.writeFrame(frameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+// removeStream forgets a stream that is done with. c.mu is held.
+func (c *Conn) removeStream(st *stream) {
+	if _, ok := c.streams[st.id]; !ok {
+		return
+	}
+	delete(c.streams, st.id)
+	st.cancel()
+	if len(c.streams) > 0 {
+		return
+	}
+	if c.opts.OnActive != nil {
+		c.opts.OnActive(false)
+	}
+	if c.goingAway {
+		c.conn.Close()
+	}
+}
+
+// reserve waits until up to want bytes of DATA may be sent on st, within
+// both flow-control windows and the client's frame size, and takes them
+// from the windows. It fails once the stream is reset or the connection
+// closed.
+func (c *Conn) reserve(st *stream, want int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if st.reset || c.closed {
+			return 0, errStreamClosed
+		}
+		n := min(int64(want), c.sendWindow, st.sendWindow, int64(c.maxFrameSize))
+		if n > 0 {
+			c.sendWindow -= n
+			st.sendWindow -= n
+			return int(n), nil
+		}
+		c.cond.Wait()
+	}
+}
+
+var errStreamClosed = errors.New("h2: stream closed")
+
+// writeFrame sends one frame. Write errors close the connection, which
+// ends Serve.
+func (c *Conn) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.buf = appendFrame(c.buf[:0], typ, flags, streamID, payload)
+	return c.flush()
+}
+
+// writeHeaders sends a header block as HEADERS followed by as many
+// CONTINUATION frames as the client's frame size calls for.
+func (c *Conn) writeHeaders(streamID uint32, fields []headerField, endStream bool) error {
+	var block []byte
+	for _, f := range fields {
+		block = appendField(block, f)
+	}
+	c.mu.Lock()
+	size := int(c.maxFrameSize)
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.buf = c.buf[:0]
+	typ, flags := frameHeaders, uint8(0)
+	if endStream {
+		flags |= flagEndStream
+	}
+	for {
+		n := min(size, len(block))
+		if n == len(block) {
+			flags |= flagEndHeaders
+		}
+		c.buf = appendFrame(c.buf, typ, flags, streamID, block[:n])
+		block = block[n:]
+		if len(block) == 0 {
+			break
+		}
+		typ, flags = frameContinuation, 0
+	}
+	return c.flush()
+}
+
+func (c *Conn) writeWindowUpdate(streamID, increment uint32) {
+	c.writeFrame(frameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+func (c *Conn) writeGoAway(lastStreamID uint32, code ErrCode) {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	c.writeFrame(frameGoAway, 0, 0, binary.BigEndian.AppendUint32(payload, uint32(code)))
+}
+
+// flush writes c.buf out. c.wmu is held.
+func (c *Conn) flush() error {
+	_, err := c.bw.Write(c.buf)
+	if err == nil {
+		err = c.bw.Flush()
+	}
+	if err != nil {
+		c.conn.Close()
+	}
+	return err
+}
+
+// runStream runs the handler of a complete request and sends its response.
+func (c *Conn) runStream(st *stream) {
+	defer c.wg.Done()
+	defer func() {
+		c.mu.Lock()
+		c.removeStream(st)
+		c.mu.Unlock()
+	}()
+
+	req, err := st.request(c.opts.Request)
+	var se *streamError
+	if errors.As(err, &se) {
+		c.resetStream(st.id, se.code)
+		return
+	}
+
+	method := ""
+	if req != nil {
+		method = req.RequestLine.Method
+	}
+	pr, pw := io.Pipe()
+	sent := make(chan error, 1)
+	go func() {
+		err := c.sendResponse(st, pr, method)
+		// Whatever the handler writes after a failure is dropped.
+		io.Copy(io.Discard, pr)
+		sent <- err
+	}()
+
+	w := response.NewWriter(pw)
+	if err != nil {
+		writeRequestError(w, err)
+		pw.Close()
+	} else {
+		pw.CloseWithError(c.serve(w, req.WithContext(st.ctx)))
+	}
+	if err := <-sent; err != nil && !errors.Is(err, errStreamClosed) {
+		c.resetStream(st.id, ErrCodeInternal)
+	}
+}
+
+// errHandlerPanic ends the response of a handler that panicked, so that
+// the stream is reset rather than the response left looking complete.
+var errHandlerPanic = errors.New("h2: handler panicked")
+
+// serve calls the handler, returning errHandlerPanic if it panics rather
+// than losing the connection.
+func (c *Conn) serve(w *response.Writer, req *request.Request) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("h2: panic serving %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, v)
+			err = errHandlerPanic
+		}
+	}()
+	c.handler.ServeHTTP(w, req)
+	return nil
+}
@@ -0,0 +1,392 @@
+package h2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type handlerFunc func(w *response.Writer, req *request.Request)
+
+func (f handlerFunc) ServeHTTP(w *response.Writer, req *request.Request) {
+	f(w, req)
+}
+
+// serveTLS serves h on a TLS listener offering h2 only and returns its
+// address.
+func serveTLS(t *testing.T, h Handler, opts Options) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{ALPN},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go NewConn(conn, h, opts).Serve()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// h2Client returns a net/http client that only speaks HTTP/2.
+func h2Client() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, NextProtos: []string{ALPN}},
+		ForceAttemptHTTP2: true,
+	}}
+}
+
+func TestConn(t *testing.T) {
+	addr := serveTLS(t, handlerFunc(func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/big":
+			body := bytes.Repeat([]byte("0123456789"), 100_000)
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		case "/chunked":
+			h := response.GetDefaultHeaders(0)
+			h.Delete("content-length")
+			h.Replace("transfer-encoding", "chunked")
+			h.Replace("trailer", "x-checksum")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*h)
+			w.WriteChunkedBody([]byte("hello, "))
+			w.WriteChunkedBody([]byte("world"))
+			w.WriteTrailers(headers.NewHeadersFromPairs("x-checksum", "abc"))
+		case "/hints":
+			response.EarlyHints(w, "</style.css>; rel=preload")
+			w.WriteStatusLine(response.StatusNoContent)
+			w.WriteHeaders(*response.GetDefaultHeaders(0))
+		case "/panic":
+			panic("boom")
+		default:
+			body := []byte(fmt.Sprintf("%s %s HTTP/%s host=%s cookie=%s body=%s",
+				req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion,
+				req.Headers.Get("host"), req.Headers.Get("cookie"), req.Body))
+			h := response.GetDefaultHeaders(len(body))
+			h.Replace("keep-alive", "timeout=5")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*h)
+			w.WriteBody(body)
+		}
+	}), Options{})
+	client := h2Client()
+	url := "https://" + addr
+
+	// Test: A request reaches the handler as HTTP/2 and the answer comes back
+	t.Run("GET", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", url+"/a?b=c", nil)
+		req.Header.Add("Cookie", "a=1")
+		req.Header.Add("Cookie", "b=2")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "HTTP/2.0", resp.Proto)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "GET /a?b=c HTTP/2 host="+addr+" cookie=a=1; b=2 body=", string(body))
+		assert.Empty(t, resp.Header.Get("Connection"))
+		assert.Empty(t, resp.Header.Get("Keep-Alive"))
+	})
+
+	// Test: A request body arrives whole
+	t.Run("POST", func(t *testing.T) {
+		resp, err := client.Post(url+"/", "text/plain", strings.NewReader("ping"))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "POST / HTTP/2 host="+addr+" cookie= body=ping", string(body))
+	})
+
+	// Test: A body larger than the flow-control window is sent as the client reads
+	t.Run("Flow control", func(t *testing.T) {
+		resp, err := client.Get(url + "/big")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Len(t, body, 1_000_000)
+		assert.Equal(t, int64(1_000_000), resp.ContentLength)
+	})
+
+	// Test: Chunked bodies lose their framing and keep their trailers
+	t.Run("Trailers", func(t *testing.T) {
+		resp, err := client.Get(url + "/chunked")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "hello, world", string(body))
+		assert.Empty(t, resp.Header.Get("Transfer-Encoding"))
+		assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+	})
+
+	// Test: Interim responses come before the final one
+	t.Run("Early hints", func(t *testing.T) {
+		resp, err := client.Get(url + "/hints")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 204, resp.StatusCode)
+	})
+
+	// Test: A panicking handler resets its stream only
+	t.Run("Panic", func(t *testing.T) {
+		_, err := client.Get(url + "/panic")
+		require.Error(t, err)
+		resp, err := client.Get(url + "/")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+	})
+
+	// Test: Streams are served concurrently on one connection
+	t.Run("Multiplexing", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Get(fmt.Sprintf("%s/%d", url, i))
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+				assert.Contains(t, string(body), fmt.Sprintf("GET /%d HTTP/2", i))
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+// rawConn is a client speaking frames directly, for what net/http would
+// never send.
+type rawConn struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func dialRaw(t *testing.T, addr string) *rawConn {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{ALPN}})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, ClientPreface)
+	c := &rawConn{t: t, conn: conn}
+	c.write(frameSettings, 0, 0, nil)
+	return c
+}
+
+func (c *rawConn) write(typ frameType, flags uint8, streamID uint32, payload []byte) {
+	_, err := c.conn.Write(appendFrame(nil, typ, flags, streamID, payload))
+	require.NoError(c.t, err)
+}
+
+func (c *rawConn) writeHeaders(streamID uint32, flags uint8, fields ...headerField) {
+	var block []byte
+	for _, f := range fields {
+		block = appendField(block, f)
+	}
+	c.write(frameHeaders, flags|flagEndHeaders, streamID, block)
+}
+
+// next returns the next frame that is not SETTINGS, WINDOW_UPDATE or PING.
+func (c *rawConn) next() *frame {
+	for {
+		f, err := readFrame(c.conn, maxFrameSizeLimit)
+		require.NoError(c.t, err)
+		switch f.typ {
+		case frameSettings, frameWindowUpdate, framePing:
+			continue
+		}
+		return f
+	}
+}
+
+func TestConnErrors(t *testing.T) {
+	addr := serveTLS(t, handlerFunc(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}), Options{MaxConcurrentStreams: 1})
+	get := []headerField{{":method", "GET"}, {":scheme", "https"}, {":path", "/"}, {":authority", "x"}}
+
+	// Test: A header block the client could not have meant is a stream error
+	t.Run("Malformed request", func(t *testing.T) {
+		for name, fields := range map[string][]headerField{
+			"uppercase name":      append(get[:4:4], headerField{"X-Upper", "1"}),
+			"connection field":    append(get[:4:4], headerField{"connection", "close"}),
+			"te":                  append(get[:4:4], headerField{"te", "gzip"}),
+			"missing :path":       get[:2],
+			"pseudo after field":  {get[0], {"accept", "*/*"}, get[1], get[2]},
+			"CR in value":         append(get[:4:4], headerField{"x-a", "1\r\nx-b: 2"}),
+			"unknown pseudo":      append(get[:4:4], headerField{":protocol", "websocket"}),
+			"content-length lies": append(get[:4:4], headerField{"content-length", "5"}),
+		} {
+			c := dialRaw(t, addr)
+			c.writeHeaders(1, flagEndStream, fields...)
+			f := c.next()
+			assert.Equal(t, frameRSTStream, f.typ, name)
+			assert.Equal(t, uint32(ErrCodeProtocol), binary.BigEndian.Uint32(f.payload), name)
+		}
+	})
+
+	// Test: A well-formed request gets HEADERS ending the stream
+	t.Run("Response", func(t *testing.T) {
+		c := dialRaw(t, addr)
+		c.writeHeaders(1, flagEndStream, get...)
+		f := c.next()
+		assert.Equal(t, frameHeaders, f.typ)
+		assert.True(t, f.has(flagEndStream))
+		fields, err := newDecoder(4096).decode(f.payload, maxHeaderListSize)
+		require.NoError(t, err)
+		assert.Equal(t, headerField{":status", "200"}, fields[0])
+	})
+
+	// Test: Streams past MaxConcurrentStreams are refused
+	t.Run("Refused stream", func(t *testing.T) {
+		c := dialRaw(t, addr)
+		c.writeHeaders(1, 0, get...)
+		c.writeHeaders(3, flagEndStream, get...)
+		f := c.next()
+		assert.Equal(t, frameRSTStream, f.typ)
+		assert.Equal(t, uint32(3), f.streamID)
+		assert.Equal(t, uint32(ErrCodeRefusedStream), binary.BigEndian.Uint32(f.payload))
+	})
+
+	// Test: Protocol violations end the connection with GOAWAY
+	t.Run("Connection error", func(t *testing.T) {
+		for name, send := range map[string]func(c *rawConn){
+			"even stream":  func(c *rawConn) { c.writeHeaders(2, flagEndStream, get...) },
+			"DATA on idle": func(c *rawConn) { c.write(frameData, 0, 5, []byte("x")) },
+			"bad HPACK":    func(c *rawConn) { c.write(frameHeaders, flagEndHeaders, 1, []byte{0x80}) },
+			"PUSH_PROMISE": func(c *rawConn) { c.write(framePushPromise, 0, 1, make([]byte, 4)) },
+			"interrupted block": func(c *rawConn) {
+				c.write(frameHeaders, 0, 1, appendField(nil, get[0]))
+				c.write(framePing, 0, 0, make([]byte, 8))
+			},
+			"window overflow": func(c *rawConn) {
+				c.write(frameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, maxWindowSize))
+			},
+		} {
+			c := dialRaw(t, addr)
+			send(c)
+			f := c.next()
+			assert.Equal(t, frameGoAway, f.typ, name)
+		}
+	})
+
+	// Test: A connection not starting with the preface is closed
+	t.Run("Bad preface", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{ALPN}})
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		c := &rawConn{t: t, conn: conn}
+		assert.Equal(t, frameGoAway, c.next().typ)
+	})
+}
+
+func TestGoAway(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	conns := make(chan *Conn, 1)
+	var active []bool
+	var mu sync.Mutex
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := NewConn(conn, handlerFunc(func(w *response.Writer, req *request.Request) {
+			started <- struct{}{}
+			<-release
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(0))
+		}), Options{OnActive: func(a bool) {
+			mu.Lock()
+			active = append(active, a)
+			mu.Unlock()
+		}})
+		conns <- c
+		c.Serve()
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, ClientPreface)
+	c := &rawConn{t: t, conn: conn}
+	c.write(frameSettings, 0, 0, nil)
+	c.writeHeaders(1, flagEndStream, headerField{":method", "GET"}, headerField{":scheme", "http"}, headerField{":path", "/"})
+	<-started
+	(<-conns).GoAway()
+
+	// Test: GOAWAY names the last stream, which is still answered
+	f := c.next()
+	require.Equal(t, frameGoAway, f.typ)
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(f.payload))
+
+	// Test: New streams are refused while going away
+	c.writeHeaders(3, flagEndStream, headerField{":method", "GET"}, headerField{":scheme", "http"}, headerField{":path", "/"})
+	f = c.next()
+	assert.Equal(t, frameRSTStream, f.typ)
+	assert.Equal(t, uint32(3), f.streamID)
+
+	close(release)
+	f = c.next()
+	assert.Equal(t, frameHeaders, f.typ)
+	assert.Equal(t, uint32(1), f.streamID)
+
+	// Test: The connection closes once the last stream is answered
+	_, err = readFrame(conn, maxFrameSizeLimit)
+	assert.Error(t, err)
+	mu.Lock()
+	assert.Equal(t, []bool{true, false}, active)
+	mu.Unlock()
+}
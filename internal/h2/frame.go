@@ -0,0 +1,201 @@
+package h2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// frameType is the type of a frame (RFC 9113 section 6).
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+// Frame flags. ACK shares its bit with END_STREAM on the frames it
+// applies to.
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// Settings identifiers (RFC 9113 section 6.5.2).
+const (
+	settingHeaderTableSize      = 0x1
+	settingEnablePush           = 0x2
+	settingMaxConcurrentStreams = 0x3
+	settingInitialWindowSize    = 0x4
+	settingMaxFrameSize         = 0x5
+	settingMaxHeaderListSize    = 0x6
+)
+
+const (
+	// frameHeaderLen is the size of the header every frame starts with.
+	frameHeaderLen = 9
+	// defaultMaxFrameSize is the largest payload either side may send
+	// until the other raises it.
+	defaultMaxFrameSize = 1 << 14
+	// maxFrameSizeLimit is the largest value SETTINGS_MAX_FRAME_SIZE may
+	// take.
+	maxFrameSizeLimit = 1<<24 - 1
+	// defaultWindowSize is the initial flow-control window of the
+	// connection and of each stream.
+	defaultWindowSize = 1<<16 - 1
+	// maxWindowSize is the largest a flow-control window may grow.
+	maxWindowSize = 1<<31 - 1
+)
+
+// ErrCode is an error code carried by RST_STREAM and GOAWAY (RFC 9113
+// section 7).
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNo:                 "NO_ERROR",
+	ErrCodeProtocol:           "PROTOCOL_ERROR",
+	ErrCodeInternal:           "INTERNAL_ERROR",
+	ErrCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	ErrCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	ErrCodeStreamClosed:       "STREAM_CLOSED",
+	ErrCodeFrameSize:          "FRAME_SIZE_ERROR",
+	ErrCodeRefusedStream:      "REFUSED_STREAM",
+	ErrCodeCancel:             "CANCEL",
+	ErrCodeCompression:        "COMPRESSION_ERROR",
+	ErrCodeConnect:            "CONNECT_ERROR",
+	ErrCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	ErrCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	ErrCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint32(c))
+}
+
+// ConnError is an error that ends the whole connection with GOAWAY.
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e *ConnError) Error() string {
+	return fmt.Sprintf("h2: connection error %s: %s", e.Code, e.Reason)
+}
+
+// streamError is an error that ends one stream with RST_STREAM.
+type streamError struct {
+	id     uint32
+	code   ErrCode
+	reason string
+}
+
+func (e *streamError) Error() string {
+	return fmt.Sprintf("h2: stream %d error %s: %s", e.id, e.code, e.reason)
+}
+
+func connError(code ErrCode, format string, args ...any) *ConnError {
+	return &ConnError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// frame is one frame as read from the connection.
+type frame struct {
+	typ      frameType
+	flags    uint8
+	streamID uint32
+	payload  []byte
+}
+
+func (f *frame) has(flag uint8) bool {
+	return f.flags&flag != 0
+}
+
+// readFrame reads the next frame from r, refusing payloads over maxSize.
+func readFrame(r io.Reader, maxSize uint32) (*frame, error) {
+	var hdr [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	length := uint32(hdr[0])<<16 | uint32(hdr[1])<<8 | uint32(hdr[2])
+	f := &frame{
+		typ:      frameType(hdr[3]),
+		flags:    hdr[4],
+		streamID: binary.BigEndian.Uint32(hdr[5:]) & (1<<31 - 1),
+	}
+	if length > maxSize {
+		return nil, connError(ErrCodeFrameSize, "frame of %d bytes exceeds %d", length, maxSize)
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// appendFrame appends a frame with payload to dst.
+func appendFrame(dst []byte, typ frameType, flags uint8, streamID uint32, payload []byte) []byte {
+	n := len(payload)
+	dst = append(dst, byte(n>>16), byte(n>>8), byte(n), byte(typ), flags)
+	dst = binary.BigEndian.AppendUint32(dst, streamID&(1<<31-1))
+	return append(dst, payload...)
+}
+
+// unpad strips the padding of a DATA or HEADERS frame with the PADDED
+// flag from its payload.
+func unpad(f *frame) ([]byte, error) {
+	p := f.payload
+	if !f.has(flagPadded) {
+		return p, nil
+	}
+	if len(p) == 0 || int(p[0]) >= len(p) {
+		return nil, connError(ErrCodeProtocol, "padding exceeds the payload of %d bytes", len(p))
+	}
+	return p[1 : len(p)-int(p[0])], nil
+}
+
+// setting is one parameter of a SETTINGS frame.
+type setting struct {
+	id    uint16
+	value uint32
+}
+
+func appendSettings(dst []byte, settings ...setting) []byte {
+	for _, s := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, s.id)
+		dst = binary.BigEndian.AppendUint32(dst, s.value)
+	}
+	return dst
+}
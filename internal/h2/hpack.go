@@ -0,0 +1,330 @@
+package h2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// headerField is one field of a header list, pseudo-header fields such as
+// ":path" included.
+type headerField struct {
+	name, value string
+}
+
+// size is the field's size as HPACK accounts for it (RFC 7541 section
+// 4.1).
+func (f headerField) size() int {
+	return len(f.name) + len(f.value) + 32
+}
+
+// staticTable is the HPACK static table (RFC 7541 Appendix A). Index 1 is
+// staticTable[0].
+var staticTable = []headerField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// staticIndex maps the names and the name-value pairs of staticTable to
+// their lowest index, for the encoder.
+var staticIndex = func() map[headerField]int {
+	m := map[headerField]int{}
+	for i, f := range staticTable {
+		for _, key := range []headerField{f, {name: f.name}} {
+			if _, ok := m[key]; !ok {
+				m[key] = i + 1
+			}
+		}
+	}
+	return m
+}()
+
+var (
+	ErrHPACKInteger = errors.New("hpack: integer overflow")
+	ErrHPACKIndex   = errors.New("hpack: invalid index")
+	ErrHPACKString  = errors.New("hpack: truncated string")
+	ErrHPACKSize    = errors.New("hpack: invalid dynamic table size update")
+	ErrHuffman      = errors.New("hpack: invalid Huffman-encoded string")
+
+	// errHeaderListSize reports a header list over the size decode was
+	// given. It leaves the decoder in step, so only the stream fails.
+	errHeaderListSize = errors.New("hpack: header list too large")
+)
+
+// decoder decodes header blocks, keeping the dynamic table that the
+// peer's encoder fills between them (RFC 7541).
+type decoder struct {
+	// dynamic holds the dynamic table, oldest entry first.
+	dynamic []headerField
+	size    int
+	// maxSize is the table size the encoder last chose; limit is the most
+	// it may choose, the SETTINGS_HEADER_TABLE_SIZE sent to the peer.
+	maxSize int
+	limit   int
+}
+
+func newDecoder(limit int) *decoder {
+	return &decoder{maxSize: limit, limit: limit}
+}
+
+// decode decodes a complete header block. A list whose fields add up to
+// more than maxListSize is decoded all the same, to keep the dynamic table
+// right, but dropped with errHeaderListSize. Any other error leaves the
+// decoder out of step with the peer, which is a connection error.
+func (d *decoder) decode(block []byte, maxListSize int) ([]headerField, error) {
+	var fields []headerField
+	listSize := 0
+	sizeUpdateAllowed := true
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0: // indexed field
+			idx, rest, err := decodeInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err := d.field(idx)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+			block = rest
+
+		case b&0xc0 == 0x40: // literal with incremental indexing
+			f, rest, err := d.literal(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.add(f)
+			fields = append(fields, f)
+			block = rest
+
+		case b&0xe0 == 0x20: // dynamic table size update
+			if !sizeUpdateAllowed {
+				return nil, fmt.Errorf("%w: after a field", ErrHPACKSize)
+			}
+			size, rest, err := decodeInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.limit) {
+				return nil, fmt.Errorf("%w: %d over %d", ErrHPACKSize, size, d.limit)
+			}
+			d.maxSize = int(size)
+			d.evict()
+			block = rest
+			continue
+
+		default: // literal without indexing or never indexed
+			f, rest, err := d.literal(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+			block = rest
+		}
+		sizeUpdateAllowed = false
+		if listSize += fields[len(fields)-1].size(); listSize > maxListSize {
+			fields = fields[:0]
+		}
+	}
+	if listSize > maxListSize {
+		return nil, errHeaderListSize
+	}
+	return fields, nil
+}
+
+// field returns the entry at idx in the static and dynamic tables.
+func (d *decoder) field(idx uint64) (headerField, error) {
+	switch {
+	case idx == 0:
+		return headerField{}, fmt.Errorf("%w: 0", ErrHPACKIndex)
+	case idx <= uint64(len(staticTable)):
+		return staticTable[idx-1], nil
+	case idx-uint64(len(staticTable)) <= uint64(len(d.dynamic)):
+		return d.dynamic[len(d.dynamic)-int(idx-uint64(len(staticTable)))], nil
+	}
+	return headerField{}, fmt.Errorf("%w: %d", ErrHPACKIndex, idx)
+}
+
+// literal decodes a literal field whose name index has an n-bit prefix.
+func (d *decoder) literal(block []byte, n uint) (headerField, []byte, error) {
+	idx, rest, err := decodeInt(block, n)
+	if err != nil {
+		return headerField{}, nil, err
+	}
+	var f headerField
+	if idx > 0 {
+		named, err := d.field(idx)
+		if err != nil {
+			return headerField{}, nil, err
+		}
+		f.name = named.name
+	} else if f.name, rest, err = decodeString(rest); err != nil {
+		return headerField{}, nil, err
+	}
+	f.value, rest, err = decodeString(rest)
+	return f, rest, err
+}
+
+// add inserts f into the dynamic table, evicting the oldest entries to
+// make room. An entry larger than the table empties it.
+func (d *decoder) add(f headerField) {
+	d.dynamic = append(d.dynamic, f)
+	d.size += f.size()
+	d.evict()
+}
+
+func (d *decoder) evict() {
+	n := 0
+	for d.size > d.maxSize && n < len(d.dynamic) {
+		d.size -= d.dynamic[n].size()
+		n++
+	}
+	d.dynamic = append(d.dynamic[:0], d.dynamic[n:]...)
+}
+
+// decodeInt decodes an integer with an n-bit prefix (RFC 7541 section
+// 5.1) from the start of p.
+func decodeInt(p []byte, n uint) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, ErrHPACKString
+	}
+	mask := uint64(1)<<n - 1
+	v := uint64(p[0]) & mask
+	p = p[1:]
+	if v < mask {
+		return v, p, nil
+	}
+	for shift := uint(0); ; shift += 7 {
+		if len(p) == 0 {
+			return 0, nil, ErrHPACKString
+		}
+		if shift > 28 {
+			return 0, nil, ErrHPACKInteger
+		}
+		b := p[0]
+		p = p[1:]
+		v += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, p, nil
+		}
+	}
+}
+
+// decodeString decodes a string literal (RFC 7541 section 5.2).
+func decodeString(p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, ErrHPACKString
+	}
+	huffman := p[0]&0x80 != 0
+	length, rest, err := decodeInt(p, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > uint64(len(rest)) {
+		return "", nil, ErrHPACKString
+	}
+	s, rest := rest[:length], rest[length:]
+	if !huffman {
+		return string(s), rest, nil
+	}
+	decoded, err := huffmanDecode(s)
+	return decoded, rest, err
+}
+
+// appendInt appends v with an n-bit prefix, the bits above the prefix in
+// the first byte set to first.
+func appendInt(dst []byte, first byte, n uint, v uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if v < mask {
+		return append(dst, first|byte(v))
+	}
+	dst = append(dst, first|byte(mask))
+	v -= mask
+	for v >= 0x80 {
+		dst = append(dst, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// appendField encodes f without touching any dynamic table: as an index
+// when the static table holds it whole, and otherwise as a literal without
+// indexing, naming it by index where the static table has the name. The
+// encoder thus keeps no state and never needs the peer's table size.
+func appendField(dst []byte, f headerField) []byte {
+	if idx, ok := staticIndex[f]; ok {
+		return appendInt(dst, 0x80, 7, uint64(idx))
+	}
+	if idx, ok := staticIndex[headerField{name: f.name}]; ok {
+		dst = appendInt(dst, 0, 4, uint64(idx))
+	} else {
+		dst = append(dst, 0)
+		dst = appendString(dst, f.name)
+	}
+	return appendString(dst, f.value)
+}
@@ -0,0 +1,127 @@
+package h2
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func TestDecoder(t *testing.T) {
+	// Test: The requests of RFC 7541 C.3, sharing one dynamic table
+	t.Run("Without Huffman", func(t *testing.T) {
+		d := newDecoder(4096)
+		fields, err := d.decode(unhex(t, "8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d"), maxHeaderListSize)
+		require.NoError(t, err)
+		assert.Equal(t, []headerField{
+			{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+		}, fields)
+
+		fields, err = d.decode(unhex(t, "8286 84be 5808 6e6f 2d63 6163 6865"), maxHeaderListSize)
+		require.NoError(t, err)
+		assert.Equal(t, []headerField{
+			{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+			{"cache-control", "no-cache"},
+		}, fields)
+
+		fields, err = d.decode(unhex(t, "8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65"), maxHeaderListSize)
+		require.NoError(t, err)
+		assert.Equal(t, []headerField{
+			{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"},
+			{"custom-key", "custom-value"},
+		}, fields)
+		assert.Equal(t, 164, d.size)
+	})
+
+	// Test: The requests of RFC 7541 C.4, Huffman-coded
+	t.Run("Huffman", func(t *testing.T) {
+		d := newDecoder(4096)
+		fields, err := d.decode(unhex(t, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff"), maxHeaderListSize)
+		require.NoError(t, err)
+		assert.Equal(t, headerField{":authority", "www.example.com"}, fields[3])
+
+		fields, err = d.decode(unhex(t, "8286 84be 5886 a8eb 1064 9cbf"), maxHeaderListSize)
+		require.NoError(t, err)
+		assert.Equal(t, headerField{"cache-control", "no-cache"}, fields[4])
+
+		fields, err = d.decode(unhex(t, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"), maxHeaderListSize)
+		require.NoError(t, err)
+		assert.Equal(t, headerField{"custom-key", "custom-value"}, fields[4])
+	})
+
+	// Test: Entries are evicted once the table is full, as in RFC 7541 C.5
+	t.Run("Eviction", func(t *testing.T) {
+		d := newDecoder(256)
+		_, err := d.decode(unhex(t, "4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d"), maxHeaderListSize)
+		require.NoError(t, err)
+		assert.Equal(t, 222, d.size)
+
+		fields, err := d.decode(unhex(t, "4803 3330 37c1 c0bf"), maxHeaderListSize)
+		require.NoError(t, err)
+		assert.Equal(t, headerField{":status", "307"}, fields[0])
+		assert.Equal(t, headerField{"location", "https://www.example.com"}, fields[3])
+		assert.Equal(t, 222, d.size)
+		assert.Len(t, d.dynamic, 4)
+	})
+
+	// Test: Malformed blocks are refused
+	t.Run("Errors", func(t *testing.T) {
+		for name, block := range map[string]string{
+			"index 0":           "80",
+			"index past tables": "be",
+			"truncated string":  "4005 6162",
+			"integer overflow":  "ffff ffff ffff ff",
+			"size over limit":   "3fe2 1f",
+			"late size update":  "8220",
+			"huffman padding":   "4081 0081 00",
+			"huffman long pad":  "4081 ff81 00",
+			"huffman EOS":       "4084 ffff ffff 00",
+		} {
+			_, err := newDecoder(4096).decode(unhex(t, block), maxHeaderListSize)
+			assert.Error(t, err, name)
+		}
+	})
+
+	// Test: An oversized list is dropped but still indexed
+	t.Run("List size", func(t *testing.T) {
+		d := newDecoder(4096)
+		_, err := d.decode(unhex(t, "400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65"), 40)
+		assert.ErrorIs(t, err, errHeaderListSize)
+		fields, err := d.decode(unhex(t, "be"), 100)
+		require.NoError(t, err)
+		assert.Equal(t, []headerField{{"custom-key", "custom-value"}}, fields)
+	})
+}
+
+func TestAppendField(t *testing.T) {
+	fields := []headerField{
+		{":status", "200"},
+		{":status", "418"},
+		{"content-type", "text/plain"},
+		{"x-custom", "value"},
+		{"x-long", strings.Repeat("a", 300)},
+	}
+	var block []byte
+	for _, f := range fields {
+		block = appendField(block, f)
+	}
+
+	// Test: Fully indexed fields take one byte
+	assert.Equal(t, byte(0x88), block[0])
+
+	// Test: The block decodes back without touching the dynamic table
+	d := newDecoder(4096)
+	decoded, err := d.decode(block, maxHeaderListSize)
+	require.NoError(t, err)
+	assert.Equal(t, fields, decoded)
+	assert.Empty(t, d.dynamic)
+}
@@ -0,0 +1,270 @@
+package h2
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// connectionFields are the fields that only make sense on an HTTP/1.1
+// connection; a request carrying one is malformed (RFC 9113 section
+// 8.2.2), and a response's are dropped.
+var connectionFields = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// request checks the stream's header list and body against the rules of
+// RFC 9113 section 8 and parses them as an HTTP/1.1 request with opts. A
+// malformed request is a *streamError; any other error is the parser's,
+// for writeRequestError to answer.
+func (st *stream) request(opts request.Options) (*request.Request, error) {
+	if st.fieldsErr != nil {
+		return nil, fmt.Errorf("%w: over %d bytes", st.fieldsErr, maxHeaderListSize)
+	}
+	malformed := func(format string, args ...any) error {
+		return &streamError{st.id, ErrCodeProtocol, fmt.Sprintf(format, args...)}
+	}
+
+	pseudo := map[string]string{}
+	var regular []headerField
+	for _, f := range st.fields {
+		if err := checkFieldValue(f.value); err != nil {
+			return nil, malformed("field %q: %v", f.name, err)
+		}
+		if strings.HasPrefix(f.name, ":") {
+			switch f.name {
+			case ":method", ":scheme", ":path", ":authority":
+			default:
+				return nil, malformed("pseudo-header field %q", f.name)
+			}
+			if regular != nil {
+				return nil, malformed("pseudo-header field %q after regular fields", f.name)
+			}
+			if _, ok := pseudo[f.name]; ok {
+				return nil, malformed("repeated pseudo-header field %q", f.name)
+			}
+			pseudo[f.name] = f.value
+			continue
+		}
+		if !validFieldName(f.name) {
+			return nil, malformed("field name %q", f.name)
+		}
+		if connectionFields[f.name] {
+			return nil, malformed("connection-specific field %q", f.name)
+		}
+		if f.name == "te" && f.value != "trailers" {
+			return nil, malformed("te: %q", f.value)
+		}
+		regular = append(regular, f)
+	}
+
+	method, authority := pseudo[":method"], pseudo[":authority"]
+	target := pseudo[":path"]
+	if method == "CONNECT" {
+		if authority == "" || len(pseudo) != 2 {
+			return nil, malformed("CONNECT needs :authority alone")
+		}
+		target = authority
+	} else if method == "" || pseudo[":scheme"] == "" || target == "" {
+		return nil, malformed("missing :method, :scheme or :path")
+	}
+
+	// The request goes to the parser as HTTP/1.1, every value checked above
+	// so that none can break out of its line.
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", method, target)
+	if authority != "" {
+		fmt.Fprintf(&b, "host: %s\r\n", authority)
+	}
+	var cookies []string
+	contentLength := ""
+	for _, f := range regular {
+		switch f.name {
+		case "host":
+			if authority != "" {
+				continue
+			}
+		case "cookie":
+			// Cookies may be split into several fields to compress better;
+			// HTTP/1.1 wants them on one line (RFC 9113 section 8.2.3).
+			cookies = append(cookies, f.value)
+			continue
+		case "content-length":
+			contentLength = f.value
+		}
+		fmt.Fprintf(&b, "%s: %s\r\n", f.name, f.value)
+	}
+	if cookies != nil {
+		fmt.Fprintf(&b, "cookie: %s\r\n", strings.Join(cookies, "; "))
+	}
+	if contentLength == "" {
+		if st.bodyLen > 0 {
+			fmt.Fprintf(&b, "content-length: %d\r\n", st.bodyLen)
+		}
+	} else if n, err := strconv.ParseInt(contentLength, 10, 64); err == nil && n != st.bodyLen {
+		return nil, malformed("content-length %d with a body of %d bytes", n, st.bodyLen)
+	}
+	b.WriteString("\r\n")
+	b.Write(st.body)
+
+	opts.StreamBody = false
+	req, err := request.RequestFromReaderWithOptions(bufio.NewReader(&b), opts)
+	if err != nil {
+		return nil, err
+	}
+	if !req.Done() {
+		return nil, malformed("incomplete request")
+	}
+	req.RequestLine.HttpVersion = "2"
+	return req, nil
+}
+
+// checkFieldValue refuses the values that HTTP/1.1 could not carry on one
+// line (RFC 9113 section 8.2.1).
+func checkFieldValue(v string) error {
+	if strings.ContainsAny(v, "\x00\r\n") {
+		return errors.New("NUL, CR or LF in value")
+	}
+	if v != strings.Trim(v, " \t") {
+		return errors.New("whitespace around value")
+	}
+	return nil
+}
+
+// validFieldName reports whether name is a lowercase token.
+func validFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// writeRequestError answers a request the parser refused.
+func writeRequestError(w *response.Writer, err error) {
+	statusCode := response.StatusBadRequest
+	switch {
+	case errors.Is(err, request.ErrContentLengthTooLarge):
+		statusCode = response.StatusContentTooLarge
+	case errors.Is(err, errHeaderListSize):
+		statusCode = response.StatusRequestHeaderFieldsTooLarge
+	}
+	body := []byte(err.Error() + "\n")
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// sendResponse reads the HTTP/1.1 response the handler writes to r and
+// sends it on the stream: interim responses and the final one as HEADERS,
+// the body as DATA and trailers as a last HEADERS frame.
+func (c *Conn) sendResponse(st *stream, r io.Reader, method string) error {
+	rr := response.NewReader(r)
+	for {
+		var headersErr error
+		data := &dataWriter{c: c, st: st}
+		resp, err := rr.ReadResponse(response.Options{
+			RequestMethod: method,
+			BodyWriter: func(resp *response.Response) io.Writer {
+				code := resp.StatusLine.StatusCode
+				if code >= 100 && code < 200 {
+					return nil
+				}
+				noBody := method == "HEAD" || code == 204 || code == 304 ||
+					resp.Headers.Get("content-length") == "0" ||
+					(method == "CONNECT" && code < 300)
+				headersErr = c.writeHeaders(st.id, responseFields(resp), noBody)
+				data.ended = noBody
+				return data
+			},
+		})
+		if err == nil {
+			err = headersErr
+		}
+		if err == nil {
+			err = data.err
+		}
+		if err != nil {
+			return err
+		}
+
+		code := resp.StatusLine.StatusCode
+		if code == 101 {
+			return errors.New("h2: 101 Switching Protocols has no place in HTTP/2")
+		}
+		if code >= 100 && code < 200 {
+			if err := c.writeHeaders(st.id, responseFields(resp), false); err != nil {
+				return err
+			}
+			continue
+		}
+		if data.ended {
+			return nil
+		}
+
+		var trailers []headerField
+		resp.Trailers.ForEach(func(name, value string) {
+			if !connectionFields[name] {
+				trailers = append(trailers, headerField{name, value})
+			}
+		})
+		if trailers != nil {
+			return c.writeHeaders(st.id, trailers, true)
+		}
+		return c.writeFrame(frameData, flagEndStream, st.id, nil)
+	}
+}
+
+// responseFields returns the header list of resp, the HTTP/1.1 framing
+// fields left out.
+func responseFields(resp *response.Response) []headerField {
+	fields := []headerField{{":status", strconv.Itoa(resp.StatusLine.StatusCode)}}
+	resp.Headers.ForEach(func(name, value string) {
+		if !connectionFields[name] {
+			fields = append(fields, headerField{name, value})
+		}
+	})
+	return fields
+}
+
+// dataWriter sends the body it is written as DATA frames, as flow control
+// allows.
+type dataWriter struct {
+	c  *Conn
+	st *stream
+	// ended is set when the stream has been ended with the HEADERS frame.
+	ended bool
+	err   error
+}
+
+func (d *dataWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) && d.err == nil {
+		var n int
+		n, d.err = d.c.reserve(d.st, len(p)-written)
+		if d.err == nil {
+			d.err = d.c.writeFrame(frameData, 0, d.st.id, p[written:written+n])
+			written += n
+		}
+	}
+	return written, d.err
+}
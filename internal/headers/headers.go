@@ -3,39 +3,192 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 )
 
 var CRLF = []byte("\r\n")
 
+// NonASCIIPolicy decides how Parse treats field values containing bytes
+// outside the ASCII range.
+type NonASCIIPolicy int
+
+const (
+	// NonASCIIPassThrough keeps high-bit bytes as opaque bytes in the value.
+	NonASCIIPassThrough NonASCIIPolicy = iota
+	// NonASCIIReject makes Parse fail on any high-bit byte in a value.
+	NonASCIIReject
+	// NonASCIILatin1 decodes values as ISO-8859-1 and stores them as UTF-8.
+	NonASCIILatin1
+)
+
+// inlineFields is how many fields a Headers holds without allocating.
+// Requests rarely carry more, so a Headers reused across the requests on a
+// connection parses most header blocks into storage it already has.
+const inlineFields = 16
+
+type field struct {
+	key, value string
+}
+
+// Headers is a set of fields keyed by lowercase name. The first
+// inlineFields are kept in arrival order in a fixed array; any beyond
+// those spill into a map. The zero value is an empty set ready to use.
+// Since the array is held by value, copies made by assignment do not
+// share fields; use Clone for a copy that is meant to be changed.
 type Headers struct {
-	headers map[string]string
+	fields         [inlineFields]field
+	n              int
+	spill          map[string]string
+	nonASCIIPolicy NonASCIIPolicy
+	strict         bool
+}
+
+// find returns the index of key, which must be lowercase, in h.fields.
+func (h *Headers) find(key string) int {
+	for i := range h.n {
+		if h.fields[i].key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// add stores a field known not to be present.
+func (h *Headers) add(key, value string) {
+	if h.n < len(h.fields) {
+		h.fields[h.n] = field{key, value}
+		h.n++
+		return
+	}
+	if h.spill == nil {
+		h.spill = map[string]string{}
+	}
+	h.spill[key] = value
 }
 
 func (h *Headers) Get(key string) string {
-	return h.headers[strings.ToLower(key)]
+	key = strings.ToLower(key)
+	if i := h.find(key); i != -1 {
+		return h.fields[i].value
+	}
+	return h.spill[key]
 }
 
 func (h *Headers) Set(key, value string) {
 	key = strings.ToLower(key)
 
-	if v, ok := h.headers[key]; ok {
-		h.headers[key] = fmt.Sprintf("%s, %s", v, value)
+	if i := h.find(key); i != -1 {
+		h.fields[i].value = fmt.Sprintf("%s, %s", h.fields[i].value, value)
+	} else if v, ok := h.spill[key]; ok {
+		h.spill[key] = fmt.Sprintf("%s, %s", v, value)
+	} else {
+		h.add(key, value)
+	}
+}
+
+// Replace sets key to value, discarding any values it had.
+func (h *Headers) Replace(key, value string) {
+	key = strings.ToLower(key)
+
+	if i := h.find(key); i != -1 {
+		h.fields[i].value = value
+	} else if _, ok := h.spill[key]; ok {
+		h.spill[key] = value
 	} else {
-		h.headers[key] = value
+		h.add(key, value)
 	}
 }
 
+// Delete removes key and all its values.
+func (h *Headers) Delete(key string) {
+	key = strings.ToLower(key)
+
+	if i := h.find(key); i != -1 {
+		copy(h.fields[i:h.n], h.fields[i+1:h.n])
+		h.n--
+		h.fields[h.n] = field{}
+		return
+	}
+	delete(h.spill, key)
+}
+
+// Clone returns an independent copy of h.
+func (h *Headers) Clone() *Headers {
+	c := NewHeaders()
+	c.nonASCIIPolicy = h.nonASCIIPolicy
+	c.strict = h.strict
+	c.fields = h.fields
+	c.n = h.n
+	if len(h.spill) > 0 {
+		c.spill = maps.Clone(h.spill)
+	}
+	return c
+}
+
+// Reset removes all fields and restores the default parsing policies,
+// keeping the storage for reuse.
+func (h *Headers) Reset() {
+	clear(h.fields[:h.n])
+	h.n = 0
+	clear(h.spill)
+	h.nonASCIIPolicy = NonASCIIPassThrough
+	h.strict = false
+}
+
+// SetStrict makes subsequent Parse calls reject what they otherwise let
+// through: whitespace around a field name, underscores in names, which
+// some proxies take for dashes, and control characters other than tab in
+// values, bare CR and LF included.
+func (h *Headers) SetStrict(strict bool) {
+	h.strict = strict
+}
+
+// SetNonASCIIPolicy sets how subsequent Parse calls handle non-ASCII values.
+func (h *Headers) SetNonASCIIPolicy(policy NonASCIIPolicy) {
+	h.nonASCIIPolicy = policy
+}
+
+// ForEach calls fn for every field, those held inline first in the order
+// they were added.
 func (h *Headers) ForEach(fn func(key, value string)) {
-	for k, v := range h.headers {
+	for _, f := range h.fields[:h.n] {
+		fn(f.key, f.value)
+	}
+	for k, v := range h.spill {
 		fn(k, v)
 	}
 }
 
 func NewHeaders() *Headers {
-	return &Headers{
-		headers: map[string]string{},
+	return &Headers{}
+}
+
+// NewHeadersFromMap builds a Headers set from m. Keys are matched
+// case-insensitively, so keys differing only in case are combined, in the
+// byte order of the keys rather than the map's random one.
+func NewHeadersFromMap(m map[string]string) *Headers {
+	h := NewHeaders()
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		h.Set(k, m[k])
+	}
+	return h
+}
+
+// NewHeadersFromPairs builds a Headers set from alternating names and values,
+// e.g. NewHeadersFromPairs("Content-Type", "text/html", "Content-Length", "0").
+// It panics if given an odd number of arguments.
+func NewHeadersFromPairs(pairs ...string) *Headers {
+	if len(pairs)%2 == 1 {
+		panic("headers: NewHeadersFromPairs: odd argument count")
+	}
+
+	h := NewHeaders()
+	for i := 0; i < len(pairs); i += 2 {
+		h.Set(pairs[i], pairs[i+1])
 	}
+	return h
 }
 
 func isValidTokenChar(char byte) bool {
@@ -56,6 +209,19 @@ func isValidTokenChar(char byte) bool {
 	}
 }
 
+// ValidateField checks that key and value can be written as a field line
+// as they are: key must be a token, and value must not hold CR, LF or NUL,
+// any of which would let it end the line and start another of its own.
+func ValidateField(key, value string) error {
+	if err := validateFieldName(key); err != nil {
+		return err
+	}
+	if i := strings.IndexAny(value, "\r\n\x00"); i != -1 {
+		return fmt.Errorf("invalid character in field value: 0x%02x", value[i])
+	}
+	return nil
+}
+
 func validateFieldName(name string) error {
 	if len(name) == 0 {
 		return fmt.Errorf("field name cannot be empty")
@@ -69,40 +235,102 @@ func validateFieldName(name string) error {
 	return nil
 }
 
-func parseHeader(fieldLine []byte) (string, string, error) {
-	parts := bytes.SplitN(fieldLine, []byte(":"), 2)
-
-	if len(parts) != 2 {
+// parseHeader splits a field line into its lowercase name and its value.
+// Both are substrings of fieldLine unless the name needs lowercasing, so
+// the line's one string conversion covers them.
+func parseHeader(fieldLine string) (string, string, error) {
+	colonIdx := strings.IndexByte(fieldLine, ':')
+	if colonIdx == -1 {
 		return "", "", fmt.Errorf("malformed header")
 	}
 
-	if bytes.HasSuffix(parts[0], []byte(" ")) {
+	rawName := fieldLine[:colonIdx]
+	if strings.HasSuffix(rawName, " ") {
 		return "", "", fmt.Errorf("invalid spacing: space before colon")
 	}
 
-	name := strings.ToLower(string(bytes.TrimSpace(parts[0])))
-	value := string(bytes.TrimSpace(parts[1]))
-
+	name := strings.TrimSpace(rawName)
 	if err := validateFieldName(name); err != nil {
 		return "", "", err
 	}
 
-	return name, value, nil
+	value := strings.TrimSpace(fieldLine[colonIdx+1:])
+
+	return lowerFieldName(name), value, nil
+}
+
+// checkStrict applies the SetStrict rules to a field line.
+func checkStrict(fieldLine string) error {
+	colonIdx := strings.IndexByte(fieldLine, ':')
+	name := fieldLine[:colonIdx]
+	if len(name) > 0 && (name[0] == ' ' || name[0] == '\t' || name[len(name)-1] == '\t') {
+		return fmt.Errorf("invalid spacing: whitespace around field name")
+	}
+	if strings.IndexByte(name, '_') != -1 {
+		return fmt.Errorf("invalid character in field name: _")
+	}
+	for i := colonIdx + 1; i < len(fieldLine); i++ {
+		if c := fieldLine[i]; (c < 0x20 && c != '\t') || c == 0x7f {
+			return fmt.Errorf("control character in field value: 0x%02x", c)
+		}
+	}
+	return nil
+}
+
+func (h *Headers) applyNonASCIIPolicy(value string) (string, error) {
+	if h.nonASCIIPolicy == NonASCIIPassThrough {
+		return value, nil
+	}
+
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x80 {
+			continue
+		}
+		if h.nonASCIIPolicy == NonASCIIReject {
+			return "", fmt.Errorf("non-ASCII byte in field value: 0x%02x", value[i])
+		}
+		return decodeLatin1(value), nil
+	}
+	return value, nil
+}
+
+func decodeLatin1(value string) string {
+	runes := make([]rune, len(value))
+	for i := 0; i < len(value); i++ {
+		runes[i] = rune(value[i])
+	}
+	return string(runes)
 }
 
 func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 	readIdx := bytes.Index(data, CRLF)
+	if readIdx == -1 {
+		return 0, false, nil
+	}
+	return h.parseLine(lowerNames(data[:readIdx+len(CRLF)]))
+}
 
+// parseLine parses the field line, or the empty line ending the block, at
+// the start of block, which must hold at least one whole line. Keeping
+// block a string lets ParseAll convert many lines with one allocation.
+func (h *Headers) parseLine(block string) (n int, done bool, err error) {
+	readIdx := strings.Index(block, "\r\n")
 	if readIdx == 0 {
-		readIdx += len(CRLF)
 		return len(CRLF), true, nil
 	}
 
-	if readIdx == -1 {
-		return 0, false, nil
+	fieldLine := block[:readIdx]
+	fieldName, fieldValue, err := parseHeader(fieldLine)
+	if err != nil {
+		return 0, false, err
+	}
+	if h.strict {
+		if err := checkStrict(fieldLine); err != nil {
+			return 0, false, err
+		}
 	}
 
-	fieldName, fieldValue, err := parseHeader(data[:readIdx])
+	fieldValue, err = h.applyNonASCIIPolicy(fieldValue)
 	if err != nil {
 		return 0, false, err
 	}
@@ -111,3 +339,36 @@ func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 
 	return readIdx + len(CRLF), false, nil
 }
+
+// ParseAll consumes every complete field line in data, stopping early once the
+// empty line that ends the header block has been consumed. It reports the total
+// number of bytes consumed; on error n covers the lines parsed before the
+// offending one.
+func (h *Headers) ParseAll(data []byte) (n int, done bool, err error) {
+	// Convert every complete line up to the end of the block at once;
+	// names and values are then substrings of that one string.
+	end := len(CRLF)
+	if !bytes.HasPrefix(data, CRLF) {
+		if idx := bytes.Index(data, []byte("\r\n\r\n")); idx != -1 {
+			end = idx + 2*len(CRLF)
+		} else if idx := bytes.LastIndex(data, CRLF); idx != -1 {
+			end = idx + len(CRLF)
+		} else {
+			return 0, false, nil
+		}
+	}
+	block := lowerNames(data[:end])
+
+	for n < len(block) {
+		consumed, done, err := h.parseLine(block[n:])
+		if err != nil {
+			return n, false, err
+		}
+
+		n += consumed
+		if done {
+			return n, true, nil
+		}
+	}
+	return n, false, nil
+}
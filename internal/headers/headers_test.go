@@ -1,6 +1,9 @@
 package headers
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -215,3 +218,464 @@ func TestHeaderParse(t *testing.T) {
 		assert.False(t, done2)
 	})
 }
+
+func TestHeaderConstructors(t *testing.T) {
+	// Test: From map
+	t.Run("From map", func(t *testing.T) {
+		headers := NewHeadersFromMap(map[string]string{
+			"Content-Type":   "text/html",
+			"Content-Length": "42",
+		})
+		assert.Equal(t, "text/html", headers.Get("content-type"))
+		assert.Equal(t, "42", headers.Get("Content-Length"))
+	})
+
+	// Test: Keys differing only in case combine in a fixed order
+	t.Run("From map with case duplicates", func(t *testing.T) {
+		for range 20 {
+			headers := NewHeadersFromMap(map[string]string{"x-a": "2", "X-A": "1", "X-a": "3"})
+			assert.Equal(t, "1, 3, 2", headers.Get("x-a"))
+		}
+	})
+
+	// Test: From empty map
+	t.Run("From empty map", func(t *testing.T) {
+		headers := NewHeadersFromMap(nil)
+		require.NotNil(t, headers)
+		assert.Equal(t, "", headers.Get("host"))
+	})
+
+	// Test: From pairs
+	t.Run("From pairs", func(t *testing.T) {
+		headers := NewHeadersFromPairs("Content-Type", "text/html", "Connection", "close")
+		assert.Equal(t, "text/html", headers.Get("content-type"))
+		assert.Equal(t, "close", headers.Get("connection"))
+	})
+
+	// Test: Repeated names in pairs are combined
+	t.Run("Repeated names in pairs", func(t *testing.T) {
+		headers := NewHeadersFromPairs("Accept", "text/html", "accept", "application/json")
+		assert.Equal(t, "text/html, application/json", headers.Get("Accept"))
+	})
+
+	// Test: Odd number of pairs panics
+	t.Run("Odd number of pairs", func(t *testing.T) {
+		assert.Panics(t, func() {
+			NewHeadersFromPairs("Content-Type")
+		})
+	})
+}
+
+func TestLowerFieldName(t *testing.T) {
+	// Test: Common names are interned
+	t.Run("Common names are interned", func(t *testing.T) {
+		name := lowerFieldName("Content-Length")
+		assert.Equal(t, "content-length", name)
+		assert.Equal(t, 0, int(testing.AllocsPerRun(100, func() {
+			lowerFieldName("Content-Length")
+		})))
+	})
+
+	// Test: Uncommon names are lowercased
+	t.Run("Uncommon names are lowercased", func(t *testing.T) {
+		assert.Equal(t, "x-custom-header", lowerFieldName("X-Custom-Header"))
+	})
+
+	// Test: Lowercase names are returned without a copy
+	t.Run("Lowercase names are not copied", func(t *testing.T) {
+		assert.Equal(t, 0, int(testing.AllocsPerRun(100, func() {
+			lowerFieldName("x-custom-header")
+		})))
+	})
+
+	// Test: A block is converted with its names lowercased
+	t.Run("Block names are lowercased", func(t *testing.T) {
+		assert.Equal(t, "x-a: Mixed Case\r\nhost: B\r\n\r\n", lowerNames([]byte("X-A: Mixed Case\r\nHOST: B\r\n\r\n")))
+	})
+
+	// Test: Long names are lowercased
+	t.Run("Long names are lowercased", func(t *testing.T) {
+		assert.Equal(t, "x-a-very-long-custom-header-name-that-exceeds-the-buffer",
+			lowerFieldName("X-A-Very-Long-Custom-Header-Name-That-Exceeds-The-Buffer"))
+	})
+}
+
+var benchFieldLines = [][]byte{
+	[]byte("Host: localhost:42069\r\n"),
+	[]byte("User-Agent: curl/7.81.0\r\n"),
+	[]byte("Accept: */*\r\n"),
+	[]byte("Content-Type: application/json\r\n"),
+	[]byte("Content-Length: 42\r\n"),
+}
+
+func BenchmarkHeaderParseCommonNames(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		headers := NewHeaders()
+		for _, line := range benchFieldLines {
+			if _, _, err := headers.Parse(line); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkHeaderParseAll(b *testing.B) {
+	block := bytes.Join(benchFieldLines, nil)
+	block = append(block, "\r\n"...)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(block)))
+	for b.Loop() {
+		headers := NewHeaders()
+		if _, done, err := headers.ParseAll(block); err != nil || !done {
+			b.Fatalf("parse failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkHeaderParseAllReuse parses into one Headers reset between
+// blocks, as a Request reused across a keep-alive connection does.
+func BenchmarkHeaderParseAllReuse(b *testing.B) {
+	block := bytes.Join(benchFieldLines, nil)
+	block = append(block, "\r\n"...)
+	headers := NewHeaders()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(block)))
+	for b.Loop() {
+		headers.Reset()
+		if _, done, err := headers.ParseAll(block); err != nil || !done {
+			b.Fatalf("parse failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkHeaderParseAllMany parses a block of 100 uncommon fields, where
+// each name and value would otherwise cost its own string.
+func BenchmarkHeaderParseAllMany(b *testing.B) {
+	var block []byte
+	for i := 0; i < 100; i++ {
+		block = fmt.Appendf(block, "X-Header-%d: %s\r\n", i, strings.Repeat("v", 40))
+	}
+	block = append(block, "\r\n"...)
+	headers := NewHeaders()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(block)))
+	for b.Loop() {
+		headers.Reset()
+		if _, done, err := headers.ParseAll(block); err != nil || !done {
+			b.Fatalf("parse failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkFieldNameInterned and BenchmarkFieldNameToLower compare the interned
+// lookup against the previous string conversion plus strings.ToLower.
+func BenchmarkFieldNameInterned(b *testing.B) {
+	name := "Content-Length"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = lowerFieldName(name)
+	}
+}
+
+func BenchmarkFieldNameToLower(b *testing.B) {
+	name := "Content-Length"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = strings.ToLower(name)
+	}
+}
+
+func TestHeaderParseAll(t *testing.T) {
+	// Test: Complete header block
+	t.Run("Complete header block", func(t *testing.T) {
+		headers := NewHeaders()
+		data := []byte("Host: localhost:42069\r\nUser-Agent: curl/7.81.0\r\n\r\nbody")
+		n, done, err := headers.ParseAll(data)
+		require.NoError(t, err)
+		assert.Equal(t, 50, n) // everything except "body"
+		assert.True(t, done)
+		assert.Equal(t, "localhost:42069", headers.Get("Host"))
+		assert.Equal(t, "curl/7.81.0", headers.Get("User-Agent"))
+	})
+
+	// Test: Partial trailing line is left unconsumed
+	t.Run("Partial trailing line", func(t *testing.T) {
+		headers := NewHeaders()
+		data := []byte("Host: localhost:42069\r\nUser-Ag")
+		n, done, err := headers.ParseAll(data)
+		require.NoError(t, err)
+		assert.Equal(t, 23, n)
+		assert.False(t, done)
+		assert.Equal(t, "localhost:42069", headers.Get("Host"))
+		assert.Equal(t, "", headers.Get("User-Agent"))
+	})
+
+	// Test: No complete line
+	t.Run("No complete line", func(t *testing.T) {
+		headers := NewHeaders()
+		n, done, err := headers.ParseAll([]byte("Host: local"))
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	})
+
+	// Test: Error after valid lines
+	t.Run("Error after valid lines", func(t *testing.T) {
+		headers := NewHeaders()
+		data := []byte("Host: localhost:42069\r\nBad Header: value\r\n\r\n")
+		n, done, err := headers.ParseAll(data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid character in field name")
+		assert.Equal(t, 23, n)
+		assert.False(t, done)
+	})
+}
+
+func TestNonASCIIPolicy(t *testing.T) {
+	data := []byte("X-Name: caf\xe9\r\n")
+
+	// Test: Pass-through keeps raw bytes
+	t.Run("Pass-through keeps raw bytes", func(t *testing.T) {
+		headers := NewHeaders()
+		n, _, err := headers.Parse(data)
+		require.NoError(t, err)
+		assert.Equal(t, len(data), n)
+		assert.Equal(t, "caf\xe9", headers.Get("x-name"))
+	})
+
+	// Test: Reject fails on high-bit bytes
+	t.Run("Reject fails on high-bit bytes", func(t *testing.T) {
+		headers := NewHeaders()
+		headers.SetNonASCIIPolicy(NonASCIIReject)
+		n, done, err := headers.Parse(data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "non-ASCII byte in field value")
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	})
+
+	// Test: Reject allows plain ASCII
+	t.Run("Reject allows plain ASCII", func(t *testing.T) {
+		headers := NewHeaders()
+		headers.SetNonASCIIPolicy(NonASCIIReject)
+		_, _, err := headers.Parse([]byte("Host: localhost\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "localhost", headers.Get("host"))
+	})
+
+	// Test: Latin-1 decodes to UTF-8
+	t.Run("Latin-1 decodes to UTF-8", func(t *testing.T) {
+		headers := NewHeaders()
+		headers.SetNonASCIIPolicy(NonASCIILatin1)
+		_, _, err := headers.Parse(data)
+		require.NoError(t, err)
+		assert.Equal(t, "café", headers.Get("x-name"))
+	})
+}
+
+func TestHeaderDeleteAndClone(t *testing.T) {
+	// Test: Delete is case-insensitive
+	t.Run("Delete", func(t *testing.T) {
+		headers := NewHeadersFromPairs("Content-Length", "42", "Host", "localhost")
+		headers.Delete("CONTENT-LENGTH")
+		assert.Equal(t, "", headers.Get("content-length"))
+		assert.Equal(t, "localhost", headers.Get("host"))
+
+		// Deleting a missing header is a no-op
+		headers.Delete("x-missing")
+	})
+
+	// Test: Replace overwrites instead of appending
+	t.Run("Replace", func(t *testing.T) {
+		headers := NewHeadersFromPairs("Content-Type", "text/plain", "Content-Type", "text/html")
+		headers.Replace("content-type", "application/json")
+		assert.Equal(t, "application/json", headers.Get("Content-Type"))
+
+		headers.Replace("X-New", "1")
+		assert.Equal(t, "1", headers.Get("x-new"))
+	})
+
+	// Test: Clone is independent
+	t.Run("Clone", func(t *testing.T) {
+		headers := NewHeadersFromPairs("Host", "localhost")
+		clone := headers.Clone()
+		clone.Set("Accept", "*/*")
+		clone.Delete("host")
+
+		assert.Equal(t, "localhost", headers.Get("host"))
+		assert.Equal(t, "", headers.Get("accept"))
+		assert.Equal(t, "*/*", clone.Get("accept"))
+	})
+	// Test: Reset empties the set and restores the default policy
+	t.Run("Reset", func(t *testing.T) {
+		headers := NewHeadersFromPairs("Host", "localhost")
+		headers.SetNonASCIIPolicy(NonASCIIReject)
+		headers.Reset()
+
+		assert.Equal(t, "", headers.Get("host"))
+		_, _, err := headers.Parse([]byte("X-Name: caf\xe9\r\n\r\n"))
+		require.NoError(t, err)
+	})
+}
+
+func TestHeaderSpill(t *testing.T) {
+	// fill sets more fields than are held inline.
+	fill := func() *Headers {
+		headers := NewHeaders()
+		for i := range inlineFields + 4 {
+			headers.Set(fmt.Sprintf("X-Field-%d", i), fmt.Sprint(i))
+		}
+		return headers
+	}
+
+	// Test: Fields past the inline array are still found
+	t.Run("Get", func(t *testing.T) {
+		headers := fill()
+		for i := range inlineFields + 4 {
+			assert.Equal(t, fmt.Sprint(i), headers.Get(fmt.Sprintf("x-field-%d", i)))
+		}
+		headers.Set("X-Field-19", "again")
+		assert.Equal(t, "19, again", headers.Get("x-field-19"))
+	})
+
+	// Test: ForEach visits inline fields in order, then the rest
+	t.Run("ForEach", func(t *testing.T) {
+		var keys []string
+		fill().ForEach(func(key, value string) { keys = append(keys, key) })
+		require.Len(t, keys, inlineFields+4)
+		for i := range inlineFields {
+			assert.Equal(t, fmt.Sprintf("x-field-%d", i), keys[i])
+		}
+	})
+
+	// Test: Delete works on both inline and spilled fields
+	t.Run("Delete", func(t *testing.T) {
+		headers := fill()
+		headers.Delete("X-Field-0")
+		headers.Delete("X-Field-18")
+		assert.Equal(t, "", headers.Get("x-field-0"))
+		assert.Equal(t, "", headers.Get("x-field-18"))
+		assert.Equal(t, "1", headers.Get("x-field-1"))
+		assert.Equal(t, "19", headers.Get("x-field-19"))
+
+		headers.Replace("X-New", "1")
+		assert.Equal(t, "1", headers.Get("x-new"))
+	})
+
+	// Test: A clone shares no storage with the original
+	t.Run("Clone", func(t *testing.T) {
+		headers := fill()
+		clone := headers.Clone()
+		clone.Replace("X-Field-0", "changed")
+		clone.Replace("X-Field-19", "changed")
+		assert.Equal(t, "0", headers.Get("x-field-0"))
+		assert.Equal(t, "19", headers.Get("x-field-19"))
+	})
+
+	// Test: Reset empties spilled fields too
+	t.Run("Reset", func(t *testing.T) {
+		headers := fill()
+		headers.Reset()
+		count := 0
+		headers.ForEach(func(key, value string) { count++ })
+		assert.Zero(t, count)
+		assert.Equal(t, "", headers.Get("x-field-19"))
+	})
+}
+
+func TestHeaderStrict(t *testing.T) {
+	// Test: Strict parsing accepts ordinary fields
+	t.Run("Valid", func(t *testing.T) {
+		headers := NewHeaders()
+		headers.SetStrict(true)
+		_, _, err := headers.Parse([]byte("Accept:\t*/*\r\nX-Empty:\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "*/*", headers.Get("accept"))
+	})
+
+	// Test: Strict parsing rejects ambiguous field lines
+	for name, line := range map[string]string{
+		"Leading space":    " Host: x\r\n\r\n",
+		"Tab before colon": "Host\t: x\r\n\r\n",
+		"Underscore":       "Content_Length: 3\r\n\r\n",
+		"Bare CR":          "X-A: a\rb\r\n\r\n",
+		"Bare LF":          "X-A: a\nb\r\n\r\n",
+		"NUL":              "X-A: a\x00b\r\n\r\n",
+		"DEL":              "X-A: a\x7fb\r\n\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			headers := NewHeaders()
+			headers.SetStrict(true)
+			_, _, err := headers.Parse([]byte(line))
+			assert.Error(t, err)
+		})
+	}
+
+	// Test: A clone parses as strictly as the original
+	t.Run("Clone", func(t *testing.T) {
+		headers := NewHeaders()
+		headers.SetStrict(true)
+		_, _, err := headers.Clone().Parse([]byte("X_A: a\r\n\r\n"))
+		assert.Error(t, err)
+	})
+
+	// Test: Reset turns strict parsing off
+	t.Run("Reset", func(t *testing.T) {
+		headers := NewHeaders()
+		headers.SetStrict(true)
+		headers.Reset()
+		_, _, err := headers.Parse([]byte("X_A: a\r\n\r\n"))
+		require.NoError(t, err)
+	})
+}
+
+// FuzzHeadersParse checks that ParseAll never panics, consumes only whole
+// field lines, and reaches the same fields whether it sees the block at once
+// or a byte at a time.
+func FuzzHeadersParse(f *testing.F) {
+	f.Add(append(bytes.Join(benchFieldLines, nil), "\r\n"...))
+	f.Add([]byte("Host: localhost:42069\r\nX-Name: caf\xe9\r\n\r\n"))
+	f.Add([]byte("       Host : localhost:42069       \r\n\r\n"))
+	f.Add([]byte("H©st: localhost:42069\r\n\r\n"))
+	f.Add([]byte("Set-Person: a\r\nSet-Person: b\r\n\r\nbody"))
+	f.Add([]byte("X-A: a\nb\r\nX_B: \x00\r\n\r\n"))
+
+	fields := func(h *Headers) map[string]string {
+		m := map[string]string{}
+		h.ForEach(func(k, v string) { m[k] = v })
+		return m
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		whole := NewHeaders()
+		n, done, err := whole.ParseAll(data)
+		if n < 0 || n > len(data) {
+			t.Fatalf("consumed %d of %d bytes", n, len(data))
+		}
+		if n > 0 && !bytes.HasSuffix(data[:n], CRLF) {
+			t.Fatalf("consumed %q, which does not end a line", data[:n])
+		}
+
+		split := NewHeaders()
+		var buf []byte
+		var splitN int
+		var splitDone bool
+		var splitErr error
+		for i := 0; i < len(data) && !splitDone && splitErr == nil; i++ {
+			buf = append(buf, data[i])
+			var m int
+			m, splitDone, splitErr = split.ParseAll(buf)
+			buf = buf[m:]
+			splitN += m
+		}
+
+		if (err == nil) != (splitErr == nil) || done != splitDone || n != splitN {
+			t.Fatalf("whole: n=%d done=%v err=%v, byte at a time: n=%d done=%v err=%v",
+				n, done, err, splitN, splitDone, splitErr)
+		}
+		if w, s := fields(whole), fields(split); !assert.ObjectsAreEqual(w, s) {
+			t.Fatalf("whole parsed %v, byte at a time %v", w, s)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package headers
+
+import (
+	"bytes"
+	"strings"
+)
+
+// commonFieldNames maps the lowercase form of frequently seen field names to a
+// shared string, so parsing them does not allocate a new string per request.
+var commonFieldNames = map[string]string{}
+
+func init() {
+	for _, name := range []string{
+		"accept",
+		"accept-charset",
+		"accept-encoding",
+		"accept-language",
+		"accept-ranges",
+		"age",
+		"allow",
+		"authorization",
+		"cache-control",
+		"connection",
+		"content-disposition",
+		"content-encoding",
+		"content-language",
+		"content-length",
+		"content-range",
+		"content-type",
+		"cookie",
+		"date",
+		"etag",
+		"expect",
+		"expires",
+		"forwarded",
+		"host",
+		"if-match",
+		"if-modified-since",
+		"if-none-match",
+		"if-range",
+		"if-unmodified-since",
+		"keep-alive",
+		"last-modified",
+		"location",
+		"origin",
+		"pragma",
+		"proxy-authorization",
+		"range",
+		"referer",
+		"server",
+		"set-cookie",
+		"te",
+		"trailer",
+		"transfer-encoding",
+		"upgrade",
+		"user-agent",
+		"vary",
+		"via",
+		"www-authenticate",
+		"x-forwarded-for",
+		"x-forwarded-proto",
+		"x-request-id",
+	} {
+		commonFieldNames[name] = name
+	}
+}
+
+// maxInternedNameLen bounds the stack buffer used to lowercase a field name
+// before looking it up; every entry in commonFieldNames fits.
+const maxInternedNameLen = 32
+
+// lowerFieldName returns the lowercase form of name, reusing the interned
+// string from commonFieldNames when there is one, or name itself when it is
+// lowercase already.
+func lowerFieldName(name string) string {
+	if interned, ok := commonFieldNames[name]; ok {
+		return interned
+	}
+	if len(name) <= maxInternedNameLen {
+		var buf [maxInternedNameLen]byte
+		upper := false
+		for i := 0; i < len(name); i++ {
+			c := name[i]
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+				upper = true
+			}
+			buf[i] = c
+		}
+		if !upper {
+			return name
+		}
+		if interned, ok := commonFieldNames[string(buf[:len(name)])]; ok {
+			return interned
+		}
+		return string(buf[:len(name)])
+	}
+	return strings.ToLower(name)
+}
+
+// lowerNames converts a block of whole field lines to a string, lowercasing
+// each field name on the way, so that lowerFieldName can hand back
+// substrings of the block and a block costs a single allocation.
+func lowerNames(block []byte) string {
+	var b strings.Builder
+	b.Grow(len(block))
+	for len(block) > 0 {
+		end := bytes.Index(block, CRLF) + len(CRLF)
+		if end < len(CRLF) {
+			end = len(block)
+		}
+		line := block[:end]
+		nameEnd := bytes.IndexByte(line, ':')
+		if nameEnd == -1 {
+			nameEnd = len(line)
+		}
+		var buf [maxInternedNameLen]byte
+		for name := line[:nameEnd]; len(name) > 0; {
+			n := copy(buf[:], name)
+			for i, c := range buf[:n] {
+				if c >= 'A' && c <= 'Z' {
+					buf[i] = c + 'a' - 'A'
+				}
+			}
+			b.Write(buf[:n])
+			name = name[n:]
+		}
+		b.Write(line[nameEnd:])
+		block = block[end:]
+	}
+	return b.String()
+}
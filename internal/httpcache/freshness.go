@@ -0,0 +1,123 @@
+package httpcache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+// cacheControl holds Cache-Control directives by lowercase name, with
+// quoted values unquoted. Directives without a value map to "".
+type cacheControl map[string]string
+
+func parseCacheControl(h *headers.Headers) cacheControl {
+	cc := cacheControl{}
+	for _, part := range strings.Split(h.Get("cache-control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the delta-seconds value of directive. Values too large
+// to hold are taken as very large, as RFC 9111 section 1.2.2 asks.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange && !strings.HasPrefix(v, "-") {
+			return maxAge, true
+		}
+		return 0, false
+	}
+	if n < 0 {
+		return 0, false
+	}
+	if n > int64(maxAge/time.Second) {
+		return maxAge, true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// maxAge is the largest age or lifetime the cache deals in, 2^31 seconds.
+const maxAge = (1 << 31) * time.Second
+
+// heuristicFraction of the time since Last-Modified is the freshness
+// lifetime given to responses without an explicit one (RFC 9111 section
+// 4.2.2), up to heuristicLimit.
+const (
+	heuristicFraction = 10
+	heuristicLimit    = 24 * time.Hour
+)
+
+// heuristicStatuses are the status codes that may be given a heuristic
+// freshness lifetime (RFC 9110 section 15.1).
+var heuristicStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// httpDate parses the Date, Expires or Last-Modified field key of h, in
+// the IMF-fixdate format senders are required to use.
+func httpDate(h *headers.Headers, key string) (time.Time, bool) {
+	t, err := time.Parse(server.TimeFormat, h.Get(key))
+	return t, err == nil
+}
+
+// freshnessLifetime is how long e stays fresh after it was generated
+// (RFC 9111 section 4.2.1), for a shared cache.
+func freshnessLifetime(e *Entry) time.Duration {
+	cc := parseCacheControl(&e.Header)
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	date, ok := httpDate(&e.Header, "date")
+	if !ok {
+		date = e.ResponseTime
+	}
+	if e.Header.Get("expires") != "" {
+		// An invalid Expires, such as "0", means already expired.
+		expires, ok := httpDate(&e.Header, "expires")
+		if !ok || !expires.After(date) {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lastModified, ok := httpDate(&e.Header, "last-modified"); ok && heuristicStatuses[e.StatusCode] && lastModified.Before(date) {
+		return min(date.Sub(lastModified)/heuristicFraction, heuristicLimit)
+	}
+	return 0
+}
+
+// currentAge is e's age at now (RFC 9111 section 4.2.3).
+func currentAge(e *Entry, now time.Time) time.Duration {
+	var ageValue time.Duration
+	if n, err := strconv.ParseInt(e.Header.Get("age"), 10, 64); err == nil && n > 0 {
+		ageValue = min(time.Duration(n)*time.Second, maxAge)
+	}
+	dateValue, ok := httpDate(&e.Header, "date")
+	if !ok {
+		dateValue = e.ResponseTime
+	}
+	apparentAge := max(0, e.ResponseTime.Sub(dateValue))
+	responseDelay := e.ResponseTime.Sub(e.RequestTime)
+	correctedInitialAge := max(apparentAge, ageValue+responseDelay)
+	return correctedInitialAge + now.Sub(e.ResponseTime)
+}
@@ -0,0 +1,278 @@
+// Package httpcache is a shared HTTP cache (RFC 9111) for proxies. It
+// answers GET and HEAD requests from stored responses while they are
+// fresh, revalidates stale ones upstream with conditional requests, and
+// passes everything else through to a client.Client.
+package httpcache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// Name identifies the cache in the Cache-Status fields it adds (RFC 9211).
+const Name = "httpfromtcp"
+
+// DefaultMaxBodySize is the largest body stored when Cache.MaxBodySize is
+// zero.
+const DefaultMaxBodySize = 1 << 20
+
+// unstoredFields describe the connection a response came on, not the
+// response, or, like Set-Cookie, the one client it was sent to, and are
+// dropped before it is stored so no other client is served them.
+var unstoredFields = []string{"connection", "keep-alive", "transfer-encoding", "trailer", "proxy-authenticate", "upgrade", "cache-status", "set-cookie"}
+
+// Cache sends requests through Client, keeping what RFC 9111 lets a shared
+// cache keep in Store. Responses it returns carry a Cache-Status field
+// saying how they were answered: "hit" from the store, or "fwd=" with the
+// reason they went upstream. Those from the store also carry Age.
+type Cache struct {
+	Client *client.Client
+	Store  Store
+	// MaxBodySize is the largest body stored. Zero means
+	// DefaultMaxBodySize.
+	MaxBodySize int64
+
+	// now is the clock; nil means time.Now.
+	now func() time.Time
+}
+
+func (c *Cache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// Do answers req, whose target must be an absolute URL as Client.Do
+// expects; the URL is the cache key. A GET or HEAD is answered from a
+// stored response that is fresh and was fetched with the same values for
+// the fields it varies on, unless the request's Cache-Control says
+// otherwise. A stale one with a validator is revalidated with
+// If-None-Match or If-Modified-Since and served again on 304. Other
+// requests go upstream, and a GET's response is stored when
+// Cache-Control, Expires or Last-Modified allow it. Unsafe methods that
+// succeed evict the stored response for their URL.
+func (c *Cache) Do(req *request.Request) (*response.Response, error) {
+	method := req.RequestLine.Method
+	key := req.RequestLine.RequestTarget
+	if method != "GET" && method != "HEAD" {
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if !isSafe(method) && resp.StatusLine.StatusCode < 400 {
+			c.Store.Delete(key)
+		}
+		setStatus(resp, "fwd=method")
+		return resp, nil
+	}
+
+	reqCC := parseCacheControl(&req.Headers)
+	fwd := "uri-miss"
+	e, ok := c.Store.Get(key)
+	if ok && !varyMatches(e, req) {
+		ok, fwd = false, "vary-miss"
+	}
+	if ok {
+		age := currentAge(e, c.clock())
+		lifetime := freshnessLifetime(e)
+		if fresh(e, reqCC, age, lifetime) {
+			return fromEntry(e, method, age, fmt.Sprintf("hit; ttl=%d", int64((lifetime-age)/time.Second))), nil
+		}
+		fwd = "stale"
+		if reqCC.has("no-cache") {
+			fwd = "request"
+		}
+		if e.Header.Get("etag") != "" || e.Header.Get("last-modified") != "" {
+			return c.revalidate(req, e, fwd)
+		}
+	} else if reqCC.has("no-cache") {
+		fwd = "request"
+	}
+	return c.forward(req, fwd)
+}
+
+// fresh reports whether e, of age and freshness lifetime, may answer a
+// request with the Cache-Control directives reqCC without revalidation.
+func fresh(e *Entry, reqCC cacheControl, age, lifetime time.Duration) bool {
+	if parseCacheControl(&e.Header).has("no-cache") || reqCC.has("no-cache") || age >= lifetime {
+		return false
+	}
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+	return true
+}
+
+// forward sends req upstream and stores the response if it may be.
+func (c *Cache) forward(req *request.Request, fwd string) (*response.Response, error) {
+	requestTime := c.clock()
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	status := fmt.Sprintf("fwd=%s; fwd-status=%d", fwd, resp.StatusLine.StatusCode)
+	if c.store(req, resp, requestTime, c.clock()) {
+		status += "; stored"
+	} else if req.RequestLine.Method == "GET" {
+		c.Store.Delete(req.RequestLine.RequestTarget)
+	}
+	setStatus(resp, status)
+	return resp, nil
+}
+
+// revalidate asks upstream whether e is still current. A 304 refreshes e's
+// fields and times and serves it; any other answer replaces it.
+func (c *Cache) revalidate(req *request.Request, e *Entry, fwd string) (*response.Response, error) {
+	cond := *req
+	cond.Headers = *req.Headers.Clone()
+	if etag := e.Header.Get("etag"); etag != "" {
+		cond.Headers.Replace("If-None-Match", etag)
+	}
+	if lastModified := e.Header.Get("last-modified"); lastModified != "" {
+		cond.Headers.Replace("If-Modified-Since", lastModified)
+	}
+
+	requestTime := c.clock()
+	resp, err := c.Client.Do(&cond)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusLine.StatusCode != int(response.StatusNotModified) {
+		// A HEAD's answer has no body to store, but the old response is
+		// not current any more either way.
+		status := fmt.Sprintf("fwd=%s; fwd-status=%d", fwd, resp.StatusLine.StatusCode)
+		if c.store(req, resp, requestTime, c.clock()) {
+			status += "; stored"
+		} else {
+			c.Store.Delete(req.RequestLine.RequestTarget)
+		}
+		setStatus(resp, status)
+		return resp, nil
+	}
+
+	updated := *e
+	updated.Header = *e.Header.Clone()
+	resp.Headers.ForEach(func(key, value string) {
+		if key != "content-length" {
+			updated.Header.Replace(key, value)
+		}
+	})
+	for _, key := range unstoredFields {
+		updated.Header.Delete(key)
+	}
+	updated.RequestTime = requestTime
+	updated.ResponseTime = c.clock()
+	c.Store.Set(req.RequestLine.RequestTarget, &updated)
+	age := currentAge(&updated, updated.ResponseTime)
+	return fromEntry(&updated, req.RequestLine.Method, age, fmt.Sprintf("fwd=%s; fwd-status=304", fwd)), nil
+}
+
+// store keeps resp, the answer to req, if a shared cache may reuse it, and
+// reports whether it did.
+func (c *Cache) store(req *request.Request, resp *response.Response, requestTime, responseTime time.Time) bool {
+	maxBody := c.MaxBodySize
+	if maxBody == 0 {
+		maxBody = DefaultMaxBodySize
+	}
+	if req.RequestLine.Method != "GET" || !storable(req, resp) || int64(len(resp.Body)) > maxBody {
+		return false
+	}
+	e := &Entry{
+		StatusCode:   resp.StatusLine.StatusCode,
+		Header:       *resp.Headers.Clone(),
+		Body:         resp.Body,
+		Vary:         map[string]string{},
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+	for _, key := range unstoredFields {
+		e.Header.Delete(key)
+	}
+	for _, name := range strings.Split(resp.Headers.Get("vary"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			e.Vary[name] = req.Headers.Get(name)
+		}
+	}
+	if freshnessLifetime(e) == 0 && e.Header.Get("etag") == "" && e.Header.Get("last-modified") == "" {
+		// It could never be served without going upstream anyway.
+		return false
+	}
+	c.Store.Set(req.RequestLine.RequestTarget, e)
+	return true
+}
+
+// storable reports whether a shared cache may store resp, the answer to
+// req (RFC 9111 section 3).
+func storable(req *request.Request, resp *response.Response) bool {
+	reqCC := parseCacheControl(&req.Headers)
+	respCC := parseCacheControl(&resp.Headers)
+	if reqCC.has("no-store") || respCC.has("no-store") || respCC.has("private") {
+		return false
+	}
+	if strings.Contains(resp.Headers.Get("vary"), "*") {
+		return false
+	}
+	if req.Headers.Get("authorization") != "" && !respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return false
+	}
+	code := resp.StatusLine.StatusCode
+	if code < 200 || code == int(response.StatusPartialContent) || code == int(response.StatusNotModified) {
+		return false
+	}
+	return heuristicStatuses[code] || respCC.has("public") || respCC.has("max-age") || respCC.has("s-maxage") || resp.Headers.Get("expires") != ""
+}
+
+// varyMatches reports whether req has the values e was fetched with for
+// the fields e varies on.
+func varyMatches(e *Entry, req *request.Request) bool {
+	for name, value := range e.Vary {
+		if req.Headers.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// fromEntry builds the response to a method request from e, at age.
+func fromEntry(e *Entry, method string, age time.Duration, status string) *response.Response {
+	resp := &response.Response{
+		StatusLine: response.StatusLine{
+			HttpVersion:  "1.1",
+			StatusCode:   e.StatusCode,
+			ReasonPhrase: response.StatusCode(e.StatusCode).ReasonPhrase(),
+		},
+		Headers: *e.Header.Clone(),
+	}
+	if method != "HEAD" {
+		resp.Body = e.Body
+	}
+	resp.Headers.Replace("Age", strconv.FormatInt(int64(min(age, maxAge)/time.Second), 10))
+	setStatus(resp, status)
+	return resp
+}
+
+// setStatus adds this cache's entry to resp's Cache-Status, after those of
+// caches further upstream.
+func setStatus(resp *response.Response, params string) {
+	resp.Headers.Set("Cache-Status", Name+"; "+params)
+}
+
+// isSafe reports whether method is safe, so that it leaves stored
+// responses alone (RFC 9110 section 9.2.1).
+func isSafe(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
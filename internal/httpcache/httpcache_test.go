@@ -0,0 +1,346 @@
+package httpcache
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// origin is an upstream server whose responses the tests change between
+// requests.
+type origin struct {
+	base string
+
+	mu       sync.Mutex
+	requests int
+	// header and body answer every request; a request whose
+	// If-None-Match is the ETag in header gets 304 instead.
+	header map[string]string
+	body   string
+	status response.StatusCode
+	// seen is the last request's If-None-Match and If-Modified-Since.
+	seen string
+}
+
+func startOrigin(t *testing.T) *origin {
+	t.Helper()
+	o := &origin{status: response.StatusOK}
+	s, err := server.Serve(0, server.HandlerFunc(o.serve))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	o.base = fmt.Sprintf("http://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+	return o
+}
+
+func (o *origin) serve(w *response.Writer, req *request.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests++
+	o.seen = req.Headers.Get("if-none-match") + "|" + req.Headers.Get("if-modified-since")
+
+	h := response.GetDefaultHeaders(len(o.body))
+	h.Delete("Connection")
+	for k, v := range o.header {
+		h.Replace(k, v)
+	}
+	if etag := o.header["ETag"]; etag != "" && req.Headers.Get("if-none-match") == etag {
+		w.WriteStatusLine(response.StatusNotModified)
+		h.Delete("Content-Length")
+		w.WriteHeaders(*h)
+		return
+	}
+	w.WriteStatusLine(o.status)
+	w.WriteHeaders(*h)
+	w.WriteBody([]byte(o.body))
+}
+
+func (o *origin) set(status response.StatusCode, body string, header ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.status, o.body, o.header = status, body, map[string]string{}
+	for i := 0; i+1 < len(header); i += 2 {
+		o.header[header[i]] = header[i+1]
+	}
+}
+
+func (o *origin) count() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests
+}
+
+// newCache returns a Cache in front of o whose clock moves only with the
+// returned advance.
+func newCache(t *testing.T) (*Cache, func(time.Duration)) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := &Cache{Client: client.NewClient(), Store: NewMemoryStore(1 << 20)}
+	c.now = func() time.Time { return now }
+	t.Cleanup(c.Client.CloseIdleConnections)
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func do(t *testing.T, c *Cache, method, url string, header ...string) *response.Response {
+	t.Helper()
+	b := client.NewRequest(method, url)
+	for i := 0; i+1 < len(header); i += 2 {
+		b.Header(header[i], header[i+1])
+	}
+	req, err := b.Build()
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestCache(t *testing.T) {
+	// Test: A fresh response is served from the store with its age
+	t.Run("Fresh hit", func(t *testing.T) {
+		o := startOrigin(t)
+		o.set(response.StatusOK, "v1", "Cache-Control", "max-age=60")
+		c, advance := newCache(t)
+
+		resp := do(t, c, "GET", o.base+"/a")
+		assert.Equal(t, "httpfromtcp; fwd=uri-miss; fwd-status=200; stored", resp.Headers.Get("cache-status"))
+		assert.Equal(t, "v1", string(resp.Body))
+
+		advance(30 * time.Second)
+		resp = do(t, c, "GET", o.base+"/a")
+		assert.Equal(t, "httpfromtcp; hit; ttl=30", resp.Headers.Get("cache-status"))
+		assert.Equal(t, "30", resp.Headers.Get("age"))
+		assert.Equal(t, "v1", string(resp.Body))
+		assert.Equal(t, "2", resp.Headers.Get("content-length"))
+
+		resp = do(t, c, "HEAD", o.base+"/a")
+		assert.Equal(t, "httpfromtcp; hit; ttl=30", resp.Headers.Get("cache-status"))
+		assert.Empty(t, resp.Body)
+		assert.Equal(t, 1, o.count())
+
+		advance(31 * time.Second)
+		resp = do(t, c, "GET", o.base+"/a")
+		assert.Equal(t, "httpfromtcp; fwd=stale; fwd-status=200; stored", resp.Headers.Get("cache-status"))
+		assert.Equal(t, 2, o.count())
+	})
+
+	// Test: A stale response is revalidated and served again on 304
+	t.Run("Revalidation", func(t *testing.T) {
+		o := startOrigin(t)
+		o.set(response.StatusOK, "v1", "Cache-Control", "max-age=10", "ETag", `"1"`, "X-Version", "1")
+		c, advance := newCache(t)
+		do(t, c, "GET", o.base+"/r")
+
+		advance(11 * time.Second)
+		o.set(response.StatusOK, "v1", "Cache-Control", "max-age=20", "ETag", `"1"`, "X-Version", "2")
+		resp := do(t, c, "GET", o.base+"/r")
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "httpfromtcp; fwd=stale; fwd-status=304", resp.Headers.Get("cache-status"))
+		assert.Equal(t, `"1"|`, o.seen)
+		assert.Equal(t, "v1", string(resp.Body))
+		assert.Equal(t, "2", resp.Headers.Get("x-version"), "fields from the 304 replace stored ones")
+		assert.Equal(t, "0", resp.Headers.Get("age"))
+
+		advance(15 * time.Second)
+		resp = do(t, c, "GET", o.base+"/r")
+		assert.Equal(t, "httpfromtcp; hit; ttl=5", resp.Headers.Get("cache-status"), "the 304 extends freshness")
+
+		advance(10 * time.Second)
+		o.set(response.StatusOK, "v2", "Cache-Control", "max-age=20", "ETag", `"2"`)
+		resp = do(t, c, "GET", o.base+"/r")
+		assert.Equal(t, "httpfromtcp; fwd=stale; fwd-status=200; stored", resp.Headers.Get("cache-status"))
+		assert.Equal(t, "v2", string(resp.Body))
+		resp = do(t, c, "GET", o.base+"/r")
+		assert.Equal(t, "v2", string(resp.Body))
+		assert.Equal(t, 3, o.count())
+	})
+
+	// Test: Last-Modified revalidates and gives a heuristic lifetime
+	t.Run("Last-Modified", func(t *testing.T) {
+		o := startOrigin(t)
+		c, advance := newCache(t)
+		modified := c.clock().Add(-100 * time.Second).Format(server.TimeFormat)
+		o.set(response.StatusOK, "v1", "Last-Modified", modified)
+		do(t, c, "GET", o.base+"/lm")
+
+		resp := do(t, c, "GET", o.base+"/lm")
+		assert.Equal(t, "httpfromtcp; hit; ttl=10", resp.Headers.Get("cache-status"))
+
+		advance(10 * time.Second)
+		resp = do(t, c, "GET", o.base+"/lm")
+		assert.Equal(t, "httpfromtcp; fwd=stale; fwd-status=200; stored", resp.Headers.Get("cache-status"))
+		assert.Equal(t, "|"+modified, o.seen)
+	})
+
+	// Test: Request directives can demand a fresher response
+	t.Run("Request directives", func(t *testing.T) {
+		o := startOrigin(t)
+		o.set(response.StatusOK, "v1", "Cache-Control", "max-age=60", "ETag", `"1"`)
+		c, advance := newCache(t)
+		do(t, c, "GET", o.base+"/q")
+		advance(20 * time.Second)
+
+		resp := do(t, c, "GET", o.base+"/q", "Cache-Control", "no-cache")
+		assert.Equal(t, "httpfromtcp; fwd=request; fwd-status=304", resp.Headers.Get("cache-status"))
+		advance(20 * time.Second)
+		resp = do(t, c, "GET", o.base+"/q", "Cache-Control", "max-age=10")
+		assert.Equal(t, "httpfromtcp; fwd=stale; fwd-status=304", resp.Headers.Get("cache-status"))
+		resp = do(t, c, "GET", o.base+"/q", "Cache-Control", "min-fresh=30")
+		assert.Equal(t, "httpfromtcp; hit; ttl=60", resp.Headers.Get("cache-status"))
+		resp = do(t, c, "GET", o.base+"/q", "Cache-Control", "min-fresh=90")
+		assert.Equal(t, "httpfromtcp; fwd=stale; fwd-status=304", resp.Headers.Get("cache-status"))
+	})
+
+	// Test: Responses a shared cache may not keep go upstream every time
+	t.Run("Not stored", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			status response.StatusCode
+			header []string
+			req    []string
+		}{
+			{"no-store", response.StatusOK, []string{"Cache-Control", "no-store, max-age=60"}, nil},
+			{"private", response.StatusOK, []string{"Cache-Control", "private, max-age=60"}, nil},
+			{"vary star", response.StatusOK, []string{"Cache-Control", "max-age=60", "Vary", "*"}, nil},
+			{"authorization", response.StatusOK, []string{"Cache-Control", "max-age=60"}, []string{"Authorization", "Bearer x"}},
+			{"request no-store", response.StatusOK, []string{"Cache-Control", "max-age=60"}, []string{"Cache-Control", "no-store"}},
+			{"no freshness or validator", response.StatusOK, nil, nil},
+			{"uncacheable status", response.StatusInternalServerError, []string{"ETag", `"1"`}, nil},
+		}
+		for _, tc := range cases {
+			o := startOrigin(t)
+			o.set(tc.status, "x", tc.header...)
+			c, _ := newCache(t)
+			do(t, c, "GET", o.base+"/n", tc.req...)
+			resp := do(t, c, "GET", o.base+"/n", tc.req...)
+			assert.NotContains(t, resp.Headers.Get("cache-status"), "hit", tc.name)
+			assert.Equal(t, 2, o.count(), tc.name)
+		}
+
+		// public lets a response to an authorized request be stored.
+		o := startOrigin(t)
+		o.set(response.StatusOK, "x", "Cache-Control", "public, max-age=60")
+		c, _ := newCache(t)
+		do(t, c, "GET", o.base+"/p", "Authorization", "Bearer x")
+		resp := do(t, c, "GET", o.base+"/p", "Authorization", "Bearer x")
+		assert.Contains(t, resp.Headers.Get("cache-status"), "hit")
+	})
+
+	// Test: A stored response is served to others without the cookie it set
+	t.Run("Set-Cookie", func(t *testing.T) {
+		o := startOrigin(t)
+		o.set(response.StatusOK, "x", "Cache-Control", "max-age=60", "Set-Cookie", "session=alice")
+		c, _ := newCache(t)
+
+		resp := do(t, c, "GET", o.base+"/c")
+		assert.Equal(t, "session=alice", resp.Headers.Get("set-cookie"))
+		resp = do(t, c, "GET", o.base+"/c")
+		assert.Contains(t, resp.Headers.Get("cache-status"), "hit")
+		assert.Empty(t, resp.Headers.Get("set-cookie"))
+		assert.Equal(t, "x", string(resp.Body))
+	})
+
+	// Test: Responses are only reused for requests matching their Vary
+	t.Run("Vary", func(t *testing.T) {
+		o := startOrigin(t)
+		o.set(response.StatusOK, "hello", "Cache-Control", "max-age=60", "Vary", "Accept-Language")
+		c, _ := newCache(t)
+		do(t, c, "GET", o.base+"/v", "Accept-Language", "en")
+
+		resp := do(t, c, "GET", o.base+"/v", "Accept-Language", "en")
+		assert.Contains(t, resp.Headers.Get("cache-status"), "hit")
+		resp = do(t, c, "GET", o.base+"/v", "Accept-Language", "tr")
+		assert.Equal(t, "httpfromtcp; fwd=vary-miss; fwd-status=200; stored", resp.Headers.Get("cache-status"))
+		resp = do(t, c, "GET", o.base+"/v")
+		assert.Contains(t, resp.Headers.Get("cache-status"), "fwd=vary-miss")
+	})
+
+	// Test: A successful unsafe request evicts the URL's response
+	t.Run("Invalidation", func(t *testing.T) {
+		o := startOrigin(t)
+		o.set(response.StatusOK, "v1", "Cache-Control", "max-age=60")
+		c, _ := newCache(t)
+		do(t, c, "GET", o.base+"/i")
+
+		resp := do(t, c, "POST", o.base+"/i")
+		assert.Equal(t, "httpfromtcp; fwd=method", resp.Headers.Get("cache-status"))
+		resp = do(t, c, "GET", o.base+"/i")
+		assert.Contains(t, resp.Headers.Get("cache-status"), "fwd=uri-miss")
+	})
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	date := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	entry := func(status int, pairs ...string) *Entry {
+		return &Entry{StatusCode: status, Header: *headers.NewHeadersFromPairs(pairs...), ResponseTime: date}
+	}
+	at := func(d time.Duration) string { return date.Add(d).Format(server.TimeFormat) }
+
+	// Test: Explicit lifetimes in order of precedence, then the heuristic
+	t.Run("Precedence", func(t *testing.T) {
+		cases := []struct {
+			name  string
+			entry *Entry
+			want  time.Duration
+		}{
+			{"s-maxage", entry(200, "Cache-Control", "max-age=10, s-maxage=20"), 20 * time.Second},
+			{"max-age", entry(200, "Cache-Control", "max-age=10", "Expires", at(time.Hour)), 10 * time.Second},
+			{"quoted max-age", entry(200, "Cache-Control", `max-age="10"`), 10 * time.Second},
+			{"huge max-age", entry(200, "Cache-Control", "max-age=99999999999999999999"), maxAge},
+			{"expires", entry(200, "Date", at(0), "Expires", at(time.Hour)), time.Hour},
+			{"invalid expires", entry(200, "Expires", "0"), 0},
+			{"past expires", entry(200, "Expires", at(-time.Hour)), 0},
+			{"heuristic", entry(200, "Last-Modified", at(-50*time.Second)), 5 * time.Second},
+			{"heuristic cap", entry(200, "Last-Modified", at(-30*24*time.Hour)), heuristicLimit},
+			{"no heuristic for 500", entry(500, "Last-Modified", at(-50*time.Second)), 0},
+			{"nothing", entry(200), 0},
+		}
+		for _, tc := range cases {
+			assert.Equal(t, tc.want, freshnessLifetime(tc.entry), tc.name)
+		}
+	})
+
+	// Test: Age counts upstream age, transit delay and residence
+	t.Run("Current age", func(t *testing.T) {
+		e := entry(200, "Age", "10", "Date", at(-5*time.Second))
+		e.RequestTime = date.Add(-2 * time.Second)
+		assert.Equal(t, 12*time.Second, currentAge(e, date))
+		assert.Equal(t, 42*time.Second, currentAge(e, date.Add(30*time.Second)))
+
+		e = entry(200, "Date", at(-5*time.Second))
+		e.RequestTime = date
+		assert.Equal(t, 5*time.Second, currentAge(e, date))
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	// Test: The least recently used entries go first once over the limit
+	t.Run("Eviction", func(t *testing.T) {
+		s := NewMemoryStore(25)
+		body := func(n int) *Entry { return &Entry{Body: make([]byte, n)} }
+		s.Set("a", body(10))
+		s.Set("b", body(10))
+		_, ok := s.Get("a")
+		require.True(t, ok)
+		s.Set("c", body(10))
+
+		_, ok = s.Get("b")
+		assert.False(t, ok, "b was least recently used")
+		_, ok = s.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, 2, s.Len())
+
+		s.Set("big", body(30))
+		_, ok = s.Get("big")
+		assert.False(t, ok, "entries over the limit are not kept")
+		s.Delete("a")
+		assert.Equal(t, 1, s.Len())
+	})
+}
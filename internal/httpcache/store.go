@@ -0,0 +1,112 @@
+package httpcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+)
+
+// Entry is a stored response and what is needed to tell its age and which
+// requests it may answer.
+type Entry struct {
+	StatusCode int
+	Header     headers.Headers
+	Body       []byte
+	// Vary holds the values the request that fetched the response had for
+	// the fields named in its Vary header, by lowercase name.
+	Vary map[string]string
+	// RequestTime and ResponseTime are when the request that fetched or
+	// last revalidated the response was sent and its answer received.
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+// size approximates the memory e holds.
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body))
+	e.Header.ForEach(func(key, value string) {
+		n += int64(len(key) + len(value))
+	})
+	return n
+}
+
+// Store keeps entries by key. Implementations must be safe for concurrent
+// use; entries handed to Set are not changed afterwards.
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, e *Entry)
+	Delete(key string)
+}
+
+// MemoryStore is a Store in memory that evicts the least recently used
+// entries once they hold more than its byte limit.
+type MemoryStore struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// NewMemoryStore returns a MemoryStore holding up to maxBytes of bodies and
+// headers. Entries larger than that on their own are not kept.
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{maxBytes: maxBytes, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (s *MemoryStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*memoryItem).entry, true
+}
+
+func (s *MemoryStore) Set(key string, e *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	item := &memoryItem{key: key, entry: e, size: e.size()}
+	if item.size > s.maxBytes {
+		return
+	}
+	s.items[key] = s.order.PushFront(item)
+	s.bytes += item.size
+	for s.bytes > s.maxBytes {
+		s.remove(s.order.Back().Value.(*memoryItem).key)
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+// Len returns the number of entries held.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// remove drops key; s.mu must be held.
+func (s *MemoryStore) remove(key string) {
+	if el, ok := s.items[key]; ok {
+		s.bytes -= el.Value.(*memoryItem).size
+		s.order.Remove(el)
+		delete(s.items, key)
+	}
+}
@@ -0,0 +1,104 @@
+// Package recording saves parsed requests to disk as JSON lines and reads
+// them back, so traffic seen by a server can be replayed later.
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+// Entry is one recorded request. Wire holds the request as the parser
+// understood it, re-serialized; Method and Target repeat its request line
+// so a recording can be skimmed without decoding Wire.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote,omitempty"`
+	Method string    `json:"method"`
+	Target string    `json:"target"`
+	// Status is the status code the server answered with, or zero if it
+	// was not known when recording.
+	Status int    `json:"status,omitempty"`
+	Wire   []byte `json:"wire"`
+}
+
+// Request parses Wire back into a request.
+func (e *Entry) Request() (*request.Request, error) {
+	req, err := request.RequestFromReader(bytes.NewReader(e.Wire))
+	if err != nil {
+		return nil, err
+	}
+	if !req.Done() {
+		return nil, fmt.Errorf("recorded request is incomplete")
+	}
+	return req, nil
+}
+
+// Recorder appends entries to a writer, one JSON object per line. It is
+// safe for concurrent use.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Record writes an entry for req, received from remote, which may be empty,
+// and answered with status, which may be zero.
+func (r *Recorder) Record(req *request.Request, remote string, status int) error {
+	var wire bytes.Buffer
+	if err := req.Write(&wire); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(Entry{
+		Time:   time.Now(),
+		Remote: remote,
+		Method: req.RequestLine.Method,
+		Target: req.RequestLine.RequestTarget,
+		Status: status,
+		Wire:   wire.Bytes(),
+	})
+}
+
+// Handler records every request next serves, along with the status it
+// answered with. Recording errors are reported through onError, which may
+// be nil, and never affect the response.
+func Handler(rec *Recorder, next server.Handler, onError func(error)) server.Handler {
+	return server.HandlerFunc(func(w *response.Writer, req *request.Request) {
+		next.ServeHTTP(w, req)
+		if err := rec.Record(req, req.RemoteAddr, int(w.StatusCode())); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+// ReadEntries reads every entry in a recording.
+func ReadEntries(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, request.MaxContentLength*2)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,72 @@
+package recording
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, raw string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func TestRecording(t *testing.T) {
+	// Test: Entries round trip through the file format
+	t.Run("Round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		rec := NewRecorder(&buf)
+		require.NoError(t, rec.Record(parse(t, "GET /a?x=1 HTTP/1.1\r\nHost: example.com\r\n\r\n"), "10.0.0.1:5000", 200))
+		require.NoError(t, rec.Record(parse(t, "POST /b HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello"), "", 0))
+		assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+
+		entries, err := ReadEntries(&buf)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+
+		assert.Equal(t, "GET", entries[0].Method)
+		assert.Equal(t, "/a?x=1", entries[0].Target)
+		assert.Equal(t, "10.0.0.1:5000", entries[0].Remote)
+		assert.Equal(t, 200, entries[0].Status)
+		assert.False(t, entries[0].Time.IsZero())
+
+		req, err := entries[1].Request()
+		require.NoError(t, err)
+		assert.Equal(t, "POST", req.RequestLine.Method)
+		assert.Equal(t, "example.com", req.Headers.Get("Host"))
+		assert.Equal(t, "hello", string(req.Body))
+	})
+
+	// Test: Handler records the status the wrapped handler wrote
+	t.Run("Handler", func(t *testing.T) {
+		var buf bytes.Buffer
+		h := Handler(NewRecorder(&buf), server.HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusNotFound)
+			w.WriteHeaders(*response.GetDefaultHeaders(0))
+		}), nil)
+
+		var out bytes.Buffer
+		h.ServeHTTP(response.NewWriter(&out), parse(t, "GET /missing HTTP/1.1\r\nHost: x\r\n\r\n"))
+
+		entries, err := ReadEntries(&buf)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, 404, entries[0].Status)
+		assert.Equal(t, "/missing", entries[0].Target)
+	})
+
+	// Test: A corrupt line is reported with its number
+	t.Run("Corrupt recording", func(t *testing.T) {
+		_, err := ReadEntries(strings.NewReader("{\"method\":\"GET\",\"wire\":\"\"}\nnot json\n"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 2")
+	})
+}
@@ -0,0 +1,91 @@
+// Package relay gives the proxying commands a common way to pass a message
+// on: drop the fields that only describe the connection it came in on, and
+// stream the answer's body back as it arrives.
+package relay
+
+import (
+	"io"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// hopByHop lists headers that describe a single connection and are not
+// forwarded (RFC 9110 section 7.6.1).
+var hopByHop = map[string]bool{
+	"connection":          true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"proxy-connection":    true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// ForEachEndToEnd calls fn for every field of h meant for the next hop as
+// well: all but the standard hop-by-hop fields and those the sender named
+// in its Connection field as describing the connection only.
+func ForEachEndToEnd(h *headers.Headers, fn func(key, value string)) {
+	var named map[string]bool
+	for _, token := range strings.Split(h.Get("connection"), ",") {
+		if token = strings.ToLower(strings.TrimSpace(token)); token != "" {
+			if named == nil {
+				named = map[string]bool{}
+			}
+			named[token] = true
+		}
+	}
+	h.ForEach(func(key, value string) {
+		if !hopByHop[key] && !named[key] {
+			fn(key, value)
+		}
+	})
+}
+
+// WriteHead starts passing resp, the answer to a request with method, back
+// on w: its status line, its end-to-end fields with those of extra, which
+// may be nil, on top, and Connection: close. It returns the writer for the
+// body and whether there is one. A body is sent chunked, as it streams in
+// without a known length, and is ended with w.WriteChunkedBodyDone.
+func WriteHead(w *response.Writer, resp *response.Response, method string, extra *headers.Headers) (body io.Writer, hasBody bool) {
+	statusCode := resp.StatusLine.StatusCode
+	hasBody = method != "HEAD" && statusCode != 204 && statusCode != 304
+
+	h := headers.NewHeaders()
+	ForEachEndToEnd(&resp.Headers, func(key, value string) {
+		// Without a body the length is only informational and is kept.
+		if key != "content-length" || !hasBody {
+			h.Set(key, value)
+		}
+	})
+	if extra != nil {
+		extra.ForEach(h.Set)
+	}
+	h.Set("Connection", "close")
+	if hasBody {
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	w.WriteStatusLine(response.StatusCode(statusCode))
+	w.WriteHeaders(*h)
+	if !hasBody {
+		return io.Discard, false
+	}
+	return NewChunkWriter(w), true
+}
+
+// ChunkWriter sends each write as one chunk of a response body.
+type ChunkWriter struct {
+	w *response.Writer
+}
+
+func NewChunkWriter(w *response.Writer) *ChunkWriter {
+	return &ChunkWriter{w: w}
+}
+
+func (c *ChunkWriter) Write(p []byte) (int, error) {
+	return c.w.WriteChunkedBody(p)
+}
@@ -0,0 +1,91 @@
+package relay
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachEndToEnd(t *testing.T) {
+	collect := func(h *headers.Headers) map[string]string {
+		m := map[string]string{}
+		ForEachEndToEnd(h, func(key, value string) { m[key] = value })
+		return m
+	}
+
+	// Test: The standard hop-by-hop fields are dropped
+	t.Run("Standard", func(t *testing.T) {
+		h := headers.NewHeadersFromPairs(
+			"Host", "example.com",
+			"Keep-Alive", "timeout=5",
+			"Transfer-Encoding", "chunked",
+			"Proxy-Authorization", "Basic eDp5",
+			"Accept", "*/*",
+		)
+		assert.Equal(t, map[string]string{"host": "example.com", "accept": "*/*"}, collect(h))
+	})
+
+	// Test: Fields named in Connection are dropped too, whatever their case
+	t.Run("Named by Connection", func(t *testing.T) {
+		h := headers.NewHeadersFromPairs(
+			"Connection", "X-Secret, , keep-alive",
+			"Connection", "x-trace",
+			"X-Secret", "1",
+			"X-Trace", "2",
+			"X-Kept", "3",
+		)
+		assert.Equal(t, map[string]string{"x-kept": "3"}, collect(h))
+	})
+}
+
+func TestWriteHead(t *testing.T) {
+	upstream := func(status int, pairs ...string) *response.Response {
+		return &response.Response{
+			StatusLine: response.StatusLine{StatusCode: status},
+			Headers:    *headers.NewHeadersFromPairs(pairs...),
+		}
+	}
+
+	// Test: A body is re-chunked and end-to-end fields are kept, with extra
+	// ones added
+	t.Run("Body", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := response.NewWriter(&buf)
+		body, hasBody := WriteHead(w, upstream(200, "Content-Length", "5", "Connection", "X-A", "X-A", "1", "ETag", `"e"`),
+			"GET", headers.NewHeadersFromPairs("Via", "1.1 test"))
+		require.True(t, hasBody)
+		io.WriteString(body, "hello")
+		require.NoError(t, w.WriteChunkedBodyDone())
+
+		resp, err := response.ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "hello", string(resp.Body))
+		assert.Equal(t, "chunked", resp.Headers.Get("Transfer-Encoding"))
+		assert.Equal(t, "close", resp.Headers.Get("Connection"))
+		assert.Equal(t, `"e"`, resp.Headers.Get("ETag"))
+		assert.Equal(t, "1.1 test", resp.Headers.Get("Via"))
+		assert.Empty(t, resp.Headers.Get("X-A"))
+		assert.Empty(t, resp.Headers.Get("Content-Length"))
+	})
+
+	// Test: Without a body the length is kept and nothing is chunked
+	t.Run("No body", func(t *testing.T) {
+		for method, status := range map[string]int{"HEAD": 200, "GET": 304} {
+			var buf bytes.Buffer
+			body, hasBody := WriteHead(response.NewWriter(&buf), upstream(status, "Content-Length", "5"), method, nil)
+			assert.False(t, hasBody, method)
+			assert.Equal(t, io.Discard, body, method)
+
+			resp, err := response.ResponseFromReaderWithOptions(&buf, response.Options{RequestMethod: "HEAD"})
+			require.NoError(t, err, method)
+			assert.Equal(t, "5", resp.Headers.Get("Content-Length"), method)
+			assert.Empty(t, resp.Headers.Get("Transfer-Encoding"), method)
+		}
+	})
+}
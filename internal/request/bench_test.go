@@ -0,0 +1,96 @@
+package request
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// benchRequests are wire-format requests of typical and large shapes. Run
+// with -benchmem and compare against BenchmarkNetHTTPReadRequest, which
+// parses the same inputs with net/http as a reference point.
+var benchRequests = map[string]string{
+	"SmallGET": "GET /index.html HTTP/1.1\r\nHost: example.com\r\nUser-Agent: bench\r\nAccept: */*\r\n\r\n",
+	"ManyHeaders": func() string {
+		var b strings.Builder
+		b.WriteString("GET / HTTP/1.1\r\nHost: example.com\r\n")
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(&b, "X-Header-%d: %s\r\n", i, strings.Repeat("v", 40))
+		}
+		b.WriteString("\r\n")
+		return b.String()
+	}(),
+	"LargeBody": "POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 1048576\r\n\r\n" + strings.Repeat("x", 1<<20),
+}
+
+var benchNames = []string{"SmallGET", "ManyHeaders", "LargeBody"}
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	for _, name := range benchNames {
+		raw := benchRequests[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(raw)))
+			for b.Loop() {
+				r, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: 4096})
+				if err != nil || !r.Done() {
+					b.Fatalf("parse failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReadRequestReuse parses into one Request and bufio.Reader reset
+// between requests, as the server's pools do, against fresh ones each time.
+func BenchmarkReadRequestReuse(b *testing.B) {
+	raw := benchRequests["SmallGET"]
+	b.Run("Fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			br := bufio.NewReader(strings.NewReader(raw))
+			if _, err := ReadRequest(br, Options{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Reused", func(b *testing.B) {
+		b.ReportAllocs()
+		sr := strings.NewReader(raw)
+		br := bufio.NewReader(sr)
+		req := NewRequest()
+		for b.Loop() {
+			sr.Reset(raw)
+			br.Reset(sr)
+			req.Reset()
+			if err := ReadRequestInto(req, br, Options{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkNetHTTPReadRequest reads benchRequests with net/http, body
+// included, for comparison with BenchmarkRequestFromReader.
+func BenchmarkNetHTTPReadRequest(b *testing.B) {
+	for _, name := range benchNames {
+		raw := benchRequests[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(raw)))
+			for b.Loop() {
+				br := bufio.NewReader(&chunkReader{data: raw, numBytesPerRead: 4096})
+				r, err := http.ReadRequest(br)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadAll(r.Body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package request
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// TrustProxies makes ClientIP and Scheme believe the Forwarded and
+// X-Forwarded-For/Proto headers added by peers in trusted. Headers from any
+// other peer are ignored, since a client can send them too.
+func (r *Request) TrustProxies(trusted []netip.Prefix) {
+	r.trustedProxies = trusted
+}
+
+// ClientIP returns the address of the client that sent the request. When
+// the peer is a trusted proxy, the chain of addresses it forwarded is
+// walked back from the peer past every trusted proxy to the first address
+// that is not one. Otherwise it is the host of RemoteAddr.
+func (r *Request) ClientIP() string {
+	peer, ok := remoteIP(r.RemoteAddr)
+	if !ok {
+		return remoteHost(r.RemoteAddr)
+	}
+	if !r.trusted(peer) {
+		return peer.String()
+	}
+
+	last := peer
+	hops := r.forwardedFor()
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseNode(hops[i])
+		if !ok {
+			// "unknown" or an obfuscated node: the nearest proxy is as
+			// far back as can be told.
+			return last.String()
+		}
+		if !r.trusted(ip) {
+			return ip.String()
+		}
+		last = ip
+	}
+	return last.String()
+}
+
+// Scheme returns "https" or "http", as the client used it. When the peer
+// is a trusted proxy that says which, that is believed; otherwise it is
+// "https" for a request read over TLS. Only the last Forwarded element or
+// X-Forwarded-Proto value counts: a proxy appends its own to whatever the
+// client sent, and the rest cannot be told apart from the client's.
+func (r *Request) Scheme() string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	peer, ok := remoteIP(r.RemoteAddr)
+	if !ok || !r.trusted(peer) {
+		return scheme
+	}
+
+	var proto string
+	if fwd := r.Headers.Get("forwarded"); fwd != "" {
+		proto = forwardedParam(fwd[strings.LastIndex(fwd, ",")+1:], "proto")
+	} else {
+		xfp := r.Headers.Get("x-forwarded-proto")
+		proto = xfp[strings.LastIndex(xfp, ",")+1:]
+	}
+	switch proto = strings.ToLower(strings.TrimSpace(proto)); proto {
+	case "http", "https":
+		return proto
+	}
+	return scheme
+}
+
+func (r *Request) trusted(ip netip.Addr) bool {
+	for _, p := range r.trustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor lists the forwarded client and proxy addresses, client
+// first, from Forwarded if it is present and X-Forwarded-For if not.
+func (r *Request) forwardedFor() []string {
+	var hops []string
+	if fwd := r.Headers.Get("forwarded"); fwd != "" {
+		for _, elem := range strings.Split(fwd, ",") {
+			hops = append(hops, forwardedParam(elem, "for"))
+		}
+		return hops
+	}
+	xff := r.Headers.Get("x-forwarded-for")
+	if xff == "" {
+		return nil
+	}
+	for _, hop := range strings.Split(xff, ",") {
+		hops = append(hops, strings.TrimSpace(hop))
+	}
+	return hops
+}
+
+// forwardedParam returns the value of the named parameter in one element of
+// a Forwarded header, such as `for="[2001:db8::1]:80";proto=https`, with
+// any quotes removed.
+func forwardedParam(elem, name string) string {
+	for _, pair := range strings.Split(elem, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// parseNode parses a forwarded node: an IPv4 address or a bracketed IPv6
+// one, either with an optional port, or a bare IPv6 address as
+// X-Forwarded-For carries it.
+func parseNode(node string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(node); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	ip, err := netip.ParseAddr(node)
+	return ip.Unmap(), err == nil
+}
+
+func remoteIP(addr string) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(remoteHost(addr))
+	return ip.Unmap(), err == nil
+}
+
+// remoteHost strips the port from a host:port address.
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,78 @@
+package request
+
+import (
+	"crypto/tls"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIPAndScheme(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	newReq := func(remote string, pairs ...string) *Request {
+		req := NewRequest()
+		req.RemoteAddr = remote
+		for i := 0; i < len(pairs); i += 2 {
+			req.Headers.Set(pairs[i], pairs[i+1])
+		}
+		req.TrustProxies(trusted)
+		return req
+	}
+
+	// Test: Headers from an untrusted peer are ignored
+	t.Run("Untrusted peer", func(t *testing.T) {
+		req := newReq("203.0.113.7:5000", "X-Forwarded-For", "198.51.100.1", "X-Forwarded-Proto", "https")
+		assert.Equal(t, "203.0.113.7", req.ClientIP())
+		assert.Equal(t, "http", req.Scheme())
+	})
+
+	// Test: X-Forwarded-For is walked back past trusted proxies
+	t.Run("X-Forwarded-For", func(t *testing.T) {
+		req := newReq("10.0.0.1:5000", "X-Forwarded-For", "192.0.2.9, 198.51.100.1, 10.0.0.2", "X-Forwarded-Proto", "https")
+		assert.Equal(t, "198.51.100.1", req.ClientIP())
+		assert.Equal(t, "https", req.Scheme())
+
+		// Every hop trusted: the first one is the client
+		req = newReq("10.0.0.1:5000", "X-Forwarded-For", "10.0.0.3, 10.0.0.2")
+		assert.Equal(t, "10.0.0.3", req.ClientIP())
+	})
+
+	// Test: Forwarded wins over X-Forwarded-For and may quote IPv6 with ports
+	t.Run("Forwarded", func(t *testing.T) {
+		req := newReq("[2001:db8::1]:5000",
+			"Forwarded", `for="[2001:db8:cafe::17]:4711";proto=http, for=10.1.1.1;by=10.0.0.9;proto=HTTPS`,
+			"X-Forwarded-For", "192.0.2.1")
+		assert.Equal(t, "2001:db8:cafe::17", req.ClientIP())
+		assert.Equal(t, "https", req.Scheme())
+	})
+
+	// Test: A proto the client sent ahead of a trusted proxy's is not believed
+	t.Run("Client-supplied proto", func(t *testing.T) {
+		req := newReq("10.0.0.1:5000", "Forwarded", "for=192.0.2.9;proto=https, for=198.51.100.1;proto=http")
+		assert.Equal(t, "http", req.Scheme())
+
+		req = newReq("10.0.0.1:5000", "Forwarded", "proto=https, for=198.51.100.1")
+		assert.Equal(t, "http", req.Scheme())
+
+		req = newReq("10.0.0.1:5000", "X-Forwarded-For", "198.51.100.1", "X-Forwarded-Proto", "https, http")
+		assert.Equal(t, "http", req.Scheme())
+	})
+
+	// Test: An unknown node stops the walk at the proxy that reported it
+	t.Run("Unknown node", func(t *testing.T) {
+		req := newReq("10.0.0.1:5000", "Forwarded", "for=unknown, for=10.0.0.2")
+		assert.Equal(t, "10.0.0.2", req.ClientIP())
+	})
+
+	// Test: Without forwarding headers, the peer and the connection decide
+	t.Run("Direct", func(t *testing.T) {
+		req := newReq("10.0.0.1:5000")
+		req.TLS = &tls.ConnectionState{}
+		assert.Equal(t, "10.0.0.1", req.ClientIP())
+		assert.Equal(t, "https", req.Scheme())
+
+		req = newReq("@unix")
+		assert.Equal(t, "@unix", req.ClientIP())
+	})
+}
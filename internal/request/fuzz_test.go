@@ -0,0 +1,91 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/chunked"
+)
+
+// FuzzRequestFromReader checks that the parser never panics, reaches the
+// same result however the input is split across reads, and consumes exactly
+// the request line, headers and body of a complete request, leaving what
+// follows for the next one.
+func FuzzRequestFromReader(f *testing.F) {
+	f.Add([]byte(benchRequests["SmallGET"]))
+	f.Add([]byte(benchRequests["ManyHeaders"]))
+	for _, tc := range smugglingCorpus {
+		f.Add([]byte(tc.raw))
+	}
+	f.Add([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\nGET /next HTTP/1.1\r\nHost: a\r\n\r\n"))
+	f.Add([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\nshort"))
+	f.Add([]byte("\r\n\r\nGET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	f.Add([]byte("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3;ext=" + strings.Repeat("e", 40) + "\r\nabc\r\n0\r\nX-T: 1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+	f.Add([]byte("POST / HTTP/1.1\r\nX-Long: " + strings.Repeat("v", 2000) + "\r\nContent-Length: 1\r\n\r\nxGET / HTTP/1.1\r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		opts := Options{KeepRawHeaders: true, MaxBodySize: 1 << 20}
+		// The smallest buffer bufio allows sends most lines down the path
+		// for lines longer than the buffer.
+		br := bufio.NewReaderSize(bytes.NewReader(data), 16)
+		req, err := ReadRequest(br, opts)
+
+		split, splitErr := RequestFromReaderWithOptions(&chunkReader{data: string(data), numBytesPerRead: 1}, opts)
+		if (err == nil) != (splitErr == nil) {
+			t.Fatalf("whole read: %v, byte at a time: %v", err, splitErr)
+		}
+
+		strictOpts := opts
+		strictOpts.Profile = Strict
+		if _, strictErr := RequestFromReaderWithOptions(bytes.NewReader(data), strictOpts); strictErr == nil && err != nil {
+			t.Fatalf("accepted under Strict but refused otherwise: %v", err)
+		}
+		if err != nil {
+			return
+		}
+
+		if req.RequestLine != split.RequestLine || req.Done() != split.Done() ||
+			!bytes.Equal(req.Body, split.Body) || !bytes.Equal(req.RawHeaders, split.RawHeaders) {
+			t.Fatalf("whole read and byte at a time disagree:\n%+v\n%+v", req, split)
+		}
+		if !req.Done() {
+			return
+		}
+
+		rest, _ := io.ReadAll(br)
+		// Empty lines ahead of the request line are skipped.
+		start := 0
+		for bytes.HasPrefix(data[start:], []byte("\r\n")) {
+			start += 2
+		}
+		lineEnd := start + bytes.Index(data[start:], []byte("\r\n")) + 2
+		consumed := len(data) - len(rest)
+		bodyLen := len(req.Body)
+		if req.Trailers != nil {
+			bodyLen = chunkedLen(data[lineEnd+len(req.RawHeaders):])
+		}
+		if want := lineEnd + len(req.RawHeaders) + bodyLen; consumed != want {
+			t.Fatalf("consumed %d bytes, want %d", consumed, want)
+		}
+		if !bytes.Equal(data[lineEnd:lineEnd+len(req.RawHeaders)], req.RawHeaders) {
+			t.Fatalf("RawHeaders %q are not the bytes received", req.RawHeaders)
+		}
+	})
+}
+
+// chunkedLen returns the length of the chunked body data starts with.
+func chunkedLen(data []byte) int {
+	d := chunked.NewDecoder()
+	total := 0
+	for !d.Done() {
+		n, _, _, err := d.Parse(data[total:])
+		if err != nil || n == 0 {
+			break
+		}
+		total += n
+	}
+	return total
+}
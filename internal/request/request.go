@@ -1,12 +1,19 @@
 package request
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
 
+	"github.com/kahvecikaan/httpfromtcp/internal/chunked"
 	"github.com/kahvecikaan/httpfromtcp/internal/headers"
 )
 
@@ -35,22 +42,114 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
-	state       ParserState
+	// Trailers holds the trailer fields sent after a chunked body. When the
+	// body is streamed it is complete once BodyReader has reached EOF; it
+	// is nil for bodies that are not chunked.
+	Trailers *headers.Headers
+	// BodyReader, when set, is streamed by Write in place of Body, for
+	// bodies whose length is not known up front. It is sent with chunked
+	// coding when the Transfer-Encoding header says so, and as is otherwise.
+	BodyReader io.Reader
+	// RawHeaders holds the header block exactly as received, including the
+	// terminating empty line. It is only populated when Options.KeepRawHeaders
+	// is set.
+	RawHeaders []byte
+	// RemoteAddr is the network address of the client, set by the server.
+	RemoteAddr string
+	// TLS describes the TLS connection the request arrived on, set by the
+	// server; it is nil for plain HTTP.
+	TLS *tls.ConnectionState
+
+	state          ParserState
+	opts           Options
+	ctx            context.Context
+	pathValues     map[string]string
+	trustedProxies []netip.Prefix
+}
+
+// Options controls optional parser behaviour. The zero value matches the
+// behaviour of RequestFromReader.
+type Options struct {
+	// KeepRawHeaders retains the unparsed header block on Request.RawHeaders.
+	KeepRawHeaders bool
+	// NonASCIIPolicy controls how header values with high-bit bytes are
+	// handled. The default passes them through untouched.
+	NonASCIIPolicy headers.NonASCIIPolicy
+	// MaxBodySize overrides MaxContentLength as the largest body accepted.
+	// Zero means MaxContentLength.
+	MaxBodySize int64
+	// Profile sets how much the request line, header and body parsers
+	// tolerate. The default is Lenient.
+	Profile ParserProfile
+	// StreamBody leaves the body in the reader: parsing stops after the
+	// headers, and BodyReader reads the body from the reader as it is
+	// called, ending with io.ErrUnexpectedEOF if the reader ends first. Body
+	// stays nil. The body must be read or abandoned before the next
+	// request on the same reader; see BodyConsumed.
+	StreamBody bool
+}
+
+// ParserProfile is a preset of what the parser tolerates, applied alike to
+// the request line, the headers and the body.
+type ParserProfile int
+
+const (
+	// Lenient tolerates what clients commonly get wrong where the request
+	// can still be read one way only: empty lines before the request line
+	// (RFC 9112 section 2.2), a request cut short by EOF, which is
+	// returned without error but not Done, and bytes past ASCII in the
+	// request-target. Control characters there are refused all the same.
+	Lenient ParserProfile = iota
+	// Strict rejects what Lenient tolerates and what request smuggling
+	// relies on: empty lines before the request line, a request cut short
+	// by EOF, with ErrIncompleteRequest, the field lines headers.SetStrict
+	// refuses, anything but visible ASCII in the request-target, a missing or
+	// repeated Host, Content-Length with anything but digits, and
+	// Transfer-Encoding, which intermediaries are apt to read differently.
+	Strict
+)
+
+// String returns "lenient" or "strict".
+func (p ParserProfile) String() string {
+	if p == Strict {
+		return "strict"
+	}
+	return "lenient"
 }
 
 var (
-	ErrMalformedReqLine         = fmt.Errorf("malformed request-line")
-	ErrInvalidMethod            = fmt.Errorf("invalid method")
-	ErrUnsupportedHttpVer       = fmt.Errorf("unsupported http version")
-	ErrInvalidHttpFormat        = fmt.Errorf("invalid http version format")
-	ErrParserDone               = fmt.Errorf("trying to read data in done state")
-	ErrUnknownState             = fmt.Errorf("unknown parser state")
-	ErrInvalidContentLength     = fmt.Errorf("invalid content-length value")
-	ErrContentLengthTooLarge    = fmt.Errorf("content-length exceeds maximum allowed")
-	ErrBodyExceedsContentLength = fmt.Errorf("body length exceeds content-length")
-	ErrMultipleContentLength    = fmt.Errorf("multiple content-length values")
+	ErrMalformedReqLine      = fmt.Errorf("malformed request-line")
+	ErrInvalidMethod         = fmt.Errorf("invalid method")
+	ErrUnsupportedHttpVer    = fmt.Errorf("unsupported http version")
+	ErrInvalidHttpFormat     = fmt.Errorf("invalid http version format")
+	ErrParserDone            = fmt.Errorf("trying to read data in done state")
+	ErrUnknownState          = fmt.Errorf("unknown parser state")
+	ErrInvalidContentLength  = fmt.Errorf("invalid content-length value")
+	ErrContentLengthTooLarge = fmt.Errorf("content-length exceeds maximum allowed")
+	ErrMultipleContentLength = fmt.Errorf("multiple content-length values")
+	ErrInvalidTarget         = fmt.Errorf("invalid request-target")
+	ErrMissingHost           = fmt.Errorf("missing host header")
+	ErrMultipleHost          = fmt.Errorf("multiple host values")
+	ErrTransferEncoding      = fmt.Errorf("transfer-encoding not supported")
+	// ErrIncompleteRequest is returned under the Strict profile when the
+	// reader ends before the request does.
+	ErrIncompleteRequest = fmt.Errorf("incomplete request")
+	// ErrInvalidTransferEncoding is returned when chunked is missing from
+	// Transfer-Encoding, repeated or not the final coding, leaving the body
+	// without a length (RFC 9112 section 6.3).
+	ErrInvalidTransferEncoding = fmt.Errorf("invalid transfer-encoding")
+	// ErrUnsupportedTransferCoding is returned for a transfer coding other
+	// than chunked, gzip and deflate; servers answer it with 501.
+	ErrUnsupportedTransferCoding = fmt.Errorf("unsupported transfer-coding")
+	// ErrHTTP2Preface is returned when the connection opens with the
+	// HTTP/2 client preface, whose first line parses as a PRI request.
+	ErrHTTP2Preface = fmt.Errorf("%w: HTTP/2 connection preface", ErrUnsupportedHttpVer)
 )
 
+// http2PrefaceLine is the first line of the HTTP/2 client preface
+// (RFC 9113 section 3.4).
+const http2PrefaceLine = "PRI * HTTP/2.0"
+
 func NewRequest() *Request {
 	return &Request{
 		Headers: *headers.NewHeaders(),
@@ -58,6 +157,78 @@ func NewRequest() *Request {
 	}
 }
 
+// Reset clears r for reuse by ReadRequestInto, keeping its header storage.
+// Nothing may hold on to r, its Headers or its RawHeaders afterwards.
+func (r *Request) Reset() {
+	r.Headers.Reset()
+	*r = Request{
+		Headers:    r.Headers,
+		RawHeaders: r.RawHeaders[:0],
+	}
+}
+
+// Context returns the request's context, defaulting to context.Background.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context set to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("request: nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// PathValue returns the value a router matched for the named wildcard in
+// its route pattern, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue sets name to value, so that PathValue(name) returns it.
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = map[string]string{}
+	}
+	r.pathValues[name] = value
+}
+
+// VerifiedChain returns the client's certificate chain, leaf first, as the
+// server verified it against its ClientCAs, or nil when the request did not
+// arrive over TLS with a verified client certificate.
+func (r *Request) VerifiedChain() []*x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0]
+}
+
+// Done reports whether the request was parsed completely. RequestFromReader
+// returns whatever it has when the reader reaches EOF, which may be less.
+func (r *Request) Done() bool {
+	return r.state == StateDone
+}
+
+// HeadersDone reports whether the request line and headers have been
+// parsed, so that only the body, if any, is left to read.
+func (r *Request) HeadersDone() bool {
+	return r.state == StateBody || r.state == StateDone
+}
+
+// maxBodySize returns the largest body the parser accepts.
+func (r *Request) maxBodySize() int64 {
+	if r.opts.MaxBodySize > 0 {
+		return r.opts.MaxBodySize
+	}
+	return MaxContentLength
+}
+
 func (r *Request) getAndValidateContentLength() (int64, error) {
 	contentLengthStr := r.Headers.Get("content-length")
 
@@ -78,9 +249,10 @@ func (r *Request) getAndValidateContentLength() (int64, error) {
 		return 0, fmt.Errorf("%w: negative value %d", ErrInvalidContentLength, contentLength)
 	}
 
-	if contentLength > MaxContentLength {
+	maxLength := r.maxBodySize()
+	if contentLength > maxLength {
 		return 0, fmt.Errorf("%w: %d bytes (max %d)",
-			ErrContentLengthTooLarge, contentLength, MaxContentLength)
+			ErrContentLengthTooLarge, contentLength, maxLength)
 	}
 
 	return contentLength, nil
@@ -89,6 +261,11 @@ func (r *Request) getAndValidateContentLength() (int64, error) {
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.state {
 	case StateInitialized:
+		if r.opts.Profile == Lenient && bytes.HasPrefix(data, []byte(CRLF)) {
+			// An empty line left over from the previous request, or sent
+			// ahead of this one.
+			return len(CRLF), nil
+		}
 		rl, bytesConsumed, err := parseRequestLine(data)
 		if err != nil {
 			return 0, err
@@ -96,43 +273,26 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		if bytesConsumed == 0 {
 			return 0, nil
 		}
-		r.RequestLine = *rl
+		if err := validateTarget(rl.RequestTarget, r.opts.Profile); err != nil {
+			return 0, err
+		}
+		r.RequestLine = rl
 		r.state = StateHeaders
 		return bytesConsumed, nil
 
 	case StateHeaders:
-		bytesConsumed, done, err := r.Headers.Parse(data)
+		bytesConsumed, done, err := r.Headers.ParseAll(data)
 		if err != nil {
 			return 0, err
 		}
+		if r.opts.KeepRawHeaders {
+			r.RawHeaders = append(r.RawHeaders, data[:bytesConsumed]...)
+		}
 		if done {
 			r.state = StateBody
 		}
 		return bytesConsumed, nil
 
-	case StateBody:
-		contentLength, err := r.getAndValidateContentLength()
-		if err != nil {
-			return 0, err
-		}
-
-		if contentLength == 0 {
-			r.state = StateDone
-			return 0, nil
-		}
-
-		r.Body = append(r.Body, data...)
-
-		if int64(len(r.Body)) > contentLength {
-			return 0, ErrBodyExceedsContentLength
-		}
-
-		if int64(len(r.Body)) == contentLength {
-			r.state = StateDone
-		}
-
-		return len(data), nil
-
 	case StateDone:
 		return 0, ErrParserDone
 
@@ -141,10 +301,13 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
-func (r *Request) parse(data []byte) (int, error) {
+// parseHead parses as much of the request line and headers in data as is
+// complete and returns the bytes consumed. It stops once the headers end,
+// leaving the body to the caller.
+func (r *Request) parseHead(data []byte) (int, error) {
 	totalBytesParsed := 0
 
-	for r.state != StateDone {
+	for r.state == StateInitialized || r.state == StateHeaders {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return totalBytesParsed, err
@@ -161,7 +324,7 @@ func (r *Request) parse(data []byte) (int, error) {
 	return totalBytesParsed, nil
 }
 
-func validateMethod(method string) error {
+func validateMethod(method []byte) error {
 	if len(method) == 0 {
 		return ErrInvalidMethod
 	}
@@ -174,93 +337,382 @@ func validateMethod(method string) error {
 	return nil
 }
 
-func validateHttpVersion(version string) error {
-	parts := strings.Split(version, "/")
-	if len(parts) != 2 {
+// methodString returns method as a string, without allocating for the
+// standard methods.
+func methodString(method []byte) string {
+	switch string(method) {
+	case "GET":
+		return "GET"
+	case "HEAD":
+		return "HEAD"
+	case "POST":
+		return "POST"
+	case "PUT":
+		return "PUT"
+	case "DELETE":
+		return "DELETE"
+	case "OPTIONS":
+		return "OPTIONS"
+	case "PATCH":
+		return "PATCH"
+	case "CONNECT":
+		return "CONNECT"
+	case "TRACE":
+		return "TRACE"
+	}
+	return string(method)
+}
+
+// validateTarget checks the bytes of a request-target. No profile lets
+// control characters through, bare CR and LF among them, which a target
+// would otherwise carry into the responses and logs that echo it; the
+// Strict profile allows only visible ASCII.
+func validateTarget(target string, profile ParserProfile) error {
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		if c < ' ' || c == 0x7f || (profile == Strict && (c == ' ' || c > 0x7f)) {
+			return fmt.Errorf("%w: byte 0x%02x", ErrInvalidTarget, c)
+		}
+	}
+	return nil
+}
+
+// checkStrictHeaders applies the Strict profile's rules that span the header
+// block.
+func (r *Request) checkStrictHeaders() error {
+	if r.Headers.Get("transfer-encoding") != "" {
+		return ErrTransferEncoding
+	}
+	host := r.Headers.Get("host")
+	if host == "" {
+		return ErrMissingHost
+	}
+	if strings.Contains(host, ",") {
+		return ErrMultipleHost
+	}
+	cl := r.Headers.Get("content-length")
+	for i := 0; i < len(cl); i++ {
+		if cl[i] < '0' || cl[i] > '9' {
+			return fmt.Errorf("%w: %s", ErrInvalidContentLength, cl)
+		}
+	}
+	return nil
+}
+
+func validateHttpVersion(version []byte) error {
+	name, number, ok := bytes.Cut(version, []byte("/"))
+	if !ok || bytes.IndexByte(number, '/') != -1 {
 		return ErrInvalidHttpFormat
 	}
 
-	if parts[0] != "HTTP" {
+	if string(name) != "HTTP" {
 		return ErrInvalidHttpFormat
 	}
 
-	if parts[1] != "1.1" {
-		return ErrUnsupportedHttpVer
+	if string(number) != "1.1" {
+		return fmt.Errorf("%w: %s", ErrUnsupportedHttpVer, version)
 	}
 
 	return nil
 }
 
-func parseRequestLine(data []byte) (*RequestLine, int, error) {
-	crlfBytes := []byte(CRLF)
-	idx := bytes.Index(data, crlfBytes)
+// parseRequestLine works on the bytes in data, converting only the method
+// and target to strings; the version can only be 1.1.
+func parseRequestLine(data []byte) (RequestLine, int, error) {
+	idx := bytes.Index(data, []byte(CRLF))
 	if idx == -1 {
-		return nil, 0, nil
+		return RequestLine{}, 0, nil
 	}
 
 	reqLineBytes := data[:idx]
-	bytesConsumed := idx + len(crlfBytes)
-
-	parts := bytes.Split(reqLineBytes, []byte(" "))
-	if len(parts) != 3 {
-		return nil, 0, ErrMalformedReqLine
+	bytesConsumed := idx + len(CRLF)
+	if string(reqLineBytes) == http2PrefaceLine {
+		return RequestLine{}, 0, ErrHTTP2Preface
 	}
 
-	method := string(parts[0])
-	target := string(parts[1])
-	version := string(parts[2])
+	method, rest, ok := bytes.Cut(reqLineBytes, []byte(" "))
+	if !ok {
+		return RequestLine{}, 0, ErrMalformedReqLine
+	}
+	target, version, ok := bytes.Cut(rest, []byte(" "))
+	if !ok || bytes.IndexByte(version, ' ') != -1 {
+		return RequestLine{}, 0, ErrMalformedReqLine
+	}
 
 	if err := validateMethod(method); err != nil {
-		return nil, 0, err
+		return RequestLine{}, 0, err
+	}
+	// The asterisk-form only asks OPTIONS about the server as a whole
+	// (RFC 9112 section 3.2.4).
+	if string(target) == "*" && string(method) != "OPTIONS" {
+		return RequestLine{}, 0, fmt.Errorf("%w: * is only for OPTIONS", ErrInvalidTarget)
 	}
 
 	if err := validateHttpVersion(version); err != nil {
-		return nil, 0, err
+		return RequestLine{}, 0, err
 	}
 
-	versionParts := strings.Split(version, "/")
-
-	rl := &RequestLine{
-		Method:        method,
-		RequestTarget: target,
-		HttpVersion:   versionParts[1],
+	rl := RequestLine{
+		Method:        methodString(method),
+		RequestTarget: string(target),
+		HttpVersion:   "1.1",
 	}
 
 	return rl, bytesConsumed, nil
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderWithOptions(reader, Options{})
+}
+
+// RequestFromReaderWithOptions reads one request from reader. Bytes read
+// past its end are lost; use ReadRequest to read several requests from one
+// connection.
+func RequestFromReaderWithOptions(reader io.Reader, opts Options) (*Request, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(reader, bufferSize)
+	}
+	return ReadRequest(br, opts)
+}
+
+// ReadRequest reads one request from br, leaving any bytes that follow it,
+// such as the next request on the connection, unread. The request line and
+// headers are parsed in place in br's buffer; only a line longer than the
+// buffer is copied aside. The body is read straight into its final slice.
+// As with RequestFromReader, EOF before the end of the request is not an
+// error under the Lenient profile; Done reports whether the request is
+// whole.
+func ReadRequest(br *bufio.Reader, opts Options) (*Request, error) {
 	req := NewRequest()
-	buf := make([]byte, bufferSize)
-	readToIdx := 0
-
-	for req.state != StateDone {
-		if readToIdx >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+	if err := ReadRequestInto(req, br, opts); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ReadRequestInto is ReadRequest parsing into req, which must be new or
+// Reset, instead of a fresh Request.
+func ReadRequestInto(req *Request, br *bufio.Reader, opts Options) error {
+	req.opts = opts
+	req.Headers.SetNonASCIIPolicy(opts.NonASCIIPolicy)
+	req.Headers.SetStrict(opts.Profile == Strict)
+
+	// long accumulates a line that does not fit in br's buffer.
+	var long []byte
+	for req.state == StateInitialized || req.state == StateHeaders {
+		data, err := br.Peek(max(br.Buffered(), 1))
+		if len(data) == 0 {
+			if err == io.EOF && req.state == StateInitialized && long == nil {
+				return nil
+			}
+			if err == io.EOF {
+				return req.cutShort()
+			}
+			return err
 		}
 
-		n, err := reader.Read(buf[readToIdx:])
+		if long != nil {
+			// Take no more than the next line end, so that nothing past the
+			// headers is pulled out of br.
+			if i := bytes.IndexByte(data, '\n'); i != -1 {
+				data = data[:i+1]
+			}
+			long = append(long, data...)
+			br.Discard(len(data))
+			n, err := req.parseHead(long)
+			if err != nil {
+				return err
+			}
+			if long = long[n:]; len(long) == 0 {
+				long = nil
+			}
+			continue
+		}
+
+		n, err := req.parseHead(data)
 		if err != nil {
+			return err
+		}
+		if n > 0 {
+			br.Discard(n)
+			continue
+		}
+		if len(data) == br.Size() {
+			// A line longer than the buffer: go on in a copy that can grow.
+			long = append([]byte(nil), data...)
+			br.Discard(len(data))
+			continue
+		}
+		// Nothing complete yet: wait for more bytes behind those buffered.
+		if _, err := br.Peek(len(data) + 1); err != nil && err != bufio.ErrBufferFull {
 			if err == io.EOF {
-				break
+				return req.cutShort()
 			}
-			return nil, err
+			return err
 		}
+	}
+
+	return req.readBody(br)
+}
 
-		readToIdx += n
+// initialBodyBuffer bounds what is allocated for a body up front, so that a
+// large Content-Length alone does not cost memory the client never sends.
+const initialBodyBuffer = 64 * 1024
 
-		bytesConsumed, err := req.parse(buf[:readToIdx])
+// readBody reads the body that follows the headers, as framed by
+// Transfer-Encoding or Content-Length.
+func (r *Request) readBody(body *bufio.Reader) error {
+	if r.opts.Profile == Strict {
+		if err := r.checkStrictHeaders(); err != nil {
+			return err
+		}
+	}
+	if r.Headers.Get("transfer-encoding") != "" {
+		return r.readCodedBody(body)
+	}
+	contentLength, err := r.getAndValidateContentLength()
+	if err != nil {
+		return err
+	}
+	if contentLength == 0 {
+		r.state = StateDone
+		return nil
+	}
+	if r.opts.StreamBody {
+		r.BodyReader = &bodyReader{r: body, remaining: contentLength}
+		r.state = StateDone
+		return nil
+	}
+
+	// Grow the buffer as bytes arrive, doubling up to the announced length.
+	buf := make([]byte, 0, min(contentLength, initialBodyBuffer))
+	for int64(len(buf)) < contentLength {
+		if len(buf) == cap(buf) {
+			buf = slices.Grow(buf, int(min(contentLength, int64(2*cap(buf))))-len(buf))
+		}
+		n, err := body.Read(buf[len(buf):min(int64(cap(buf)), contentLength)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			// The body is cut short; Done stays false.
+			break
+		}
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
+	if len(buf) > 0 {
+		r.Body = buf
+	}
+	if int64(len(buf)) < contentLength {
+		return r.cutShort()
+	}
+	r.state = StateDone
+	return nil
+}
+
+// cutShort returns what reading gives when the reader ends inside the
+// request: nothing under Lenient, leaving Done false, and
+// ErrIncompleteRequest under Strict.
+func (r *Request) cutShort() error {
+	if r.opts.Profile == Strict {
+		return ErrIncompleteRequest
+	}
+	return nil
+}
+
+// bodyReader reads a streamed body of known length from the connection's
+// reader. Nothing is read ahead, so a handler that reads slowly slows the
+// client down rather than having the body pile up in memory.
+type bodyReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if err == io.EOF && b.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && b.remaining == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// BodyConsumed reports whether a body streamed under Options.StreamBody has
+// been read to its end, so that the next request on the connection can be
+// read. It is true for requests without a streamed body.
+func (r *Request) BodyConsumed() bool {
+	switch b := r.BodyReader.(type) {
+	case *bodyReader:
+		return b.remaining == 0
+	case *codedBody:
+		return b.chunks.dec.Done()
+	}
+	return true
+}
+
+// Write serializes the request in wire format: request line, headers, the
+// empty line and the body. Headers are written as stored, so callers are
+// responsible for Host and Content-Length or Transfer-Encoding.
+func (r *Request) Write(w io.Writer) error {
+	version := r.RequestLine.HttpVersion
+	if version == "" {
+		version = "1.1"
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/%s%s", r.RequestLine.Method, r.RequestLine.RequestTarget, version, CRLF)
+	r.Headers.ForEach(func(key, value string) {
+		fmt.Fprintf(&b, "%s: %s%s", key, value, CRLF)
+	})
+	b.WriteString(CRLF)
+
+	if r.BodyReader == nil {
+		b.Write(r.Body)
+		_, err := w.Write(b.Bytes())
+		return err
+	}
 
-		if bytesConsumed > 0 {
-			copy(buf, buf[bytesConsumed:readToIdx])
-			readToIdx -= bytesConsumed
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+	return r.WriteBody(w)
+}
+
+// WriteBody writes only the body of the request, as Write would.
+func (r *Request) WriteBody(w io.Writer) error {
+	if r.BodyReader == nil {
+		_, err := w.Write(r.Body)
+		return err
+	}
+
+	// Buffer so each chunk goes out in a single write.
+	bw := bufio.NewWriter(w)
+	if r.isChunked() {
+		cw := chunked.NewWriter(bw)
+		if _, err := io.Copy(cw, r.BodyReader); err != nil {
+			return err
+		}
+		if err := cw.Close(); err != nil {
+			return err
 		}
+	} else if _, err := io.Copy(bw, r.BodyReader); err != nil {
+		return err
 	}
+	return bw.Flush()
+}
 
-	return req, nil
+// isChunked reports whether chunked is the final transfer coding.
+func (r *Request) isChunked() bool {
+	codings := strings.Split(r.Headers.Get("transfer-encoding"), ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
 }
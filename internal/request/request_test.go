@@ -1,11 +1,15 @@
 package request
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -101,6 +105,11 @@ func TestRequestLineParse(t *testing.T) {
 	_, err = RequestFromReader(strings.NewReader("GET /coffee HTTP/1.0\r\nHost: localhost:42069\r\n\r\n"))
 	require.Error(t, err)
 
+	// Test: HTTP/2 connection preface
+	_, err = RequestFromReader(strings.NewReader("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"))
+	require.ErrorIs(t, err, ErrHTTP2Preface)
+	require.ErrorIs(t, err, ErrUnsupportedHttpVer)
+
 	// Test: Malformed HTTP version (no slash)
 	_, err = RequestFromReader(strings.NewReader("GET /coffee HTTP1.1\r\nHost: localhost:42069\r\n\r\n"))
 	require.Error(t, err)
@@ -171,6 +180,7 @@ func TestRequestLineParseStreaming(t *testing.T) {
 		{"Invalid method numbers", "GET123 /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"},
 		{"Invalid HTTP version", "GET /coffee HTTP/2.0\r\nHost: localhost:42069\r\n\r\n"},
 		{"Malformed request line", "/coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"},
+		{"Asterisk target without OPTIONS", "GET * HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"},
 	}
 
 	for _, tc := range errorCases {
@@ -526,3 +536,310 @@ func TestBodyParsing(t *testing.T) {
 		assert.Contains(t, err.Error(), "multiple content-length")
 	})
 }
+
+func TestRawHeaders(t *testing.T) {
+	// Test: Raw headers kept verbatim
+	t.Run("Raw headers kept verbatim", func(t *testing.T) {
+		rawHeaders := "Host:   localhost:42069  \r\nUser-Agent: curl/7.81.0\r\n\r\n"
+		reader := &chunkReader{
+			data:            "GET / HTTP/1.1\r\n" + rawHeaders,
+			numBytesPerRead: 3,
+		}
+		r, err := RequestFromReaderWithOptions(reader, Options{KeepRawHeaders: true})
+		require.NoError(t, err)
+		require.NotNil(t, r)
+		assert.Equal(t, rawHeaders, string(r.RawHeaders))
+		assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	})
+
+	// Test: Raw headers do not include the body
+	t.Run("Raw headers do not include the body", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Content-Length: 5\r\n" +
+				"\r\n" +
+				"hello",
+			numBytesPerRead: 4,
+		}
+		r, err := RequestFromReaderWithOptions(reader, Options{KeepRawHeaders: true})
+		require.NoError(t, err)
+		assert.Equal(t, "Content-Length: 5\r\n\r\n", string(r.RawHeaders))
+		assert.Equal(t, "hello", string(r.Body))
+	})
+
+	// Test: Raw headers are not kept by default
+	t.Run("Raw headers not kept by default", func(t *testing.T) {
+		r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Nil(t, r.RawHeaders)
+	})
+}
+
+func TestNonASCIIHeaderValues(t *testing.T) {
+	data := "GET / HTTP/1.1\r\nX-Name: caf\xe9\r\n\r\n"
+
+	// Test: Default passes values through
+	t.Run("Default passes values through", func(t *testing.T) {
+		r, err := RequestFromReader(strings.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, "caf\xe9", r.Headers.Get("x-name"))
+	})
+
+	// Test: Reject policy fails the request
+	t.Run("Reject policy fails the request", func(t *testing.T) {
+		reader := &chunkReader{data: data, numBytesPerRead: 4}
+		_, err := RequestFromReaderWithOptions(reader, Options{NonASCIIPolicy: headers.NonASCIIReject})
+		require.Error(t, err)
+	})
+
+	// Test: Latin-1 policy decodes values
+	t.Run("Latin-1 policy decodes values", func(t *testing.T) {
+		reader := &chunkReader{data: data, numBytesPerRead: 4}
+		r, err := RequestFromReaderWithOptions(reader, Options{NonASCIIPolicy: headers.NonASCIILatin1})
+		require.NoError(t, err)
+		assert.Equal(t, "café", r.Headers.Get("x-name"))
+	})
+}
+
+func TestRequestWrite(t *testing.T) {
+	// Test: Round trip through Write and RequestFromReader
+	t.Run("Round trip", func(t *testing.T) {
+		req := NewRequest()
+		req.RequestLine = RequestLine{Method: "POST", RequestTarget: "/submit", HttpVersion: "1.1"}
+		req.Headers.Set("Host", "localhost:42069")
+		req.Headers.Set("Content-Length", "5")
+		req.Body = []byte("hello")
+
+		var b strings.Builder
+		require.NoError(t, req.Write(&b))
+		assert.True(t, strings.HasPrefix(b.String(), "POST /submit HTTP/1.1\r\n"))
+
+		r, err := RequestFromReader(strings.NewReader(b.String()))
+		require.NoError(t, err)
+		assert.Equal(t, "POST", r.RequestLine.Method)
+		assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+		assert.Equal(t, "hello", string(r.Body))
+	})
+
+	// Test: Missing version defaults to 1.1
+	t.Run("Missing version defaults to 1.1", func(t *testing.T) {
+		req := NewRequest()
+		req.RequestLine = RequestLine{Method: "GET", RequestTarget: "/"}
+
+		var b strings.Builder
+		require.NoError(t, req.Write(&b))
+		assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", b.String())
+	})
+
+	// Test: BodyReader is streamed with chunked coding
+	t.Run("Chunked BodyReader", func(t *testing.T) {
+		req := NewRequest()
+		req.RequestLine = RequestLine{Method: "PUT", RequestTarget: "/upload"}
+		req.Headers.Set("Transfer-Encoding", "chunked")
+		req.BodyReader = strings.NewReader("streamed")
+
+		var b strings.Builder
+		require.NoError(t, req.Write(&b))
+		assert.Equal(t, "PUT /upload HTTP/1.1\r\ntransfer-encoding: chunked\r\n\r\n8\r\nstreamed\r\n0\r\n\r\n", b.String())
+	})
+
+	// Test: BodyReader with Content-Length is written as is
+	t.Run("Sized BodyReader", func(t *testing.T) {
+		req := NewRequest()
+		req.RequestLine = RequestLine{Method: "PUT", RequestTarget: "/upload"}
+		req.Headers.Set("Content-Length", "3")
+		req.BodyReader = strings.NewReader("abc")
+
+		var b strings.Builder
+		require.NoError(t, req.Write(&b))
+		assert.Equal(t, "PUT /upload HTTP/1.1\r\ncontent-length: 3\r\n\r\nabc", b.String())
+	})
+}
+
+func TestRequestContext(t *testing.T) {
+	// Test: Default context
+	req := NewRequest()
+	assert.Equal(t, context.Background(), req.Context())
+
+	// Test: WithContext returns a copy
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	req2 := req.WithContext(ctx)
+	assert.Equal(t, "value", req2.Context().Value(ctxKey{}))
+	assert.Equal(t, context.Background(), req.Context())
+
+	// Test: Nil context panics
+	assert.Panics(t, func() {
+		req.WithContext(nil)
+	})
+}
+
+func TestMaxBodySize(t *testing.T) {
+	// Test: Lower limit rejects a body MaxContentLength would allow
+	reader := strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 20\r\n\r\n")
+	_, err := RequestFromReaderWithOptions(reader, Options{MaxBodySize: 10})
+	require.ErrorIs(t, err, ErrContentLengthTooLarge)
+
+	// Test: Higher limit accepts a body beyond MaxContentLength
+	req := &Request{Headers: *headers.NewHeaders(), opts: Options{MaxBodySize: 2 * MaxContentLength}}
+	req.Headers.Set("Content-Length", strconv.Itoa(MaxContentLength+1))
+	n, err := req.getAndValidateContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(MaxContentLength+1), n)
+}
+
+func TestReadRequest(t *testing.T) {
+	// Test: Bytes after the request are left for the next one
+	br := bufio.NewReader(strings.NewReader(
+		"POST /a HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	req, err := ReadRequest(br, Options{})
+	require.NoError(t, err)
+	assert.True(t, req.Done())
+	assert.Equal(t, "/a", req.RequestLine.RequestTarget)
+	assert.Equal(t, "abc", string(req.Body))
+	req, err = ReadRequest(br, Options{})
+	require.NoError(t, err)
+	assert.True(t, req.Done())
+	assert.Equal(t, "/b", req.RequestLine.RequestTarget)
+
+	// Test: A header line longer than the read buffer
+	long := strings.Repeat("v", 3*bufferSize)
+	reader := &chunkReader{
+		data:            "POST / HTTP/1.1\r\nX-Long: " + long + "\r\nContent-Length: 2\r\n\r\nhi",
+		numBytesPerRead: 100,
+	}
+	req, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, req.Done())
+	assert.Equal(t, long, req.Headers.Get("x-long"))
+	assert.Equal(t, "hi", string(req.Body))
+
+	// Test: Body cut short by EOF is not done
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc",
+		numBytesPerRead: 2,
+	}
+	req, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, req.Done())
+	assert.Equal(t, "abc", string(req.Body))
+}
+
+func TestStreamBody(t *testing.T) {
+	// Test: The body is left for BodyReader and the next request follows it
+	t.Run("Read", func(t *testing.T) {
+		br := bufio.NewReader(strings.NewReader(
+			"POST /a HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc" +
+				"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		req, err := ReadRequest(br, Options{StreamBody: true})
+		require.NoError(t, err)
+		assert.True(t, req.Done())
+		assert.Nil(t, req.Body)
+		assert.False(t, req.BodyConsumed())
+
+		body, err := io.ReadAll(req.BodyReader)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", string(body))
+		assert.True(t, req.BodyConsumed())
+
+		req, err = ReadRequest(br, Options{StreamBody: true})
+		require.NoError(t, err)
+		assert.Equal(t, "/b", req.RequestLine.RequestTarget)
+		assert.Nil(t, req.BodyReader)
+		assert.True(t, req.BodyConsumed())
+	})
+
+	// Test: A body cut short ends with io.ErrUnexpectedEOF
+	t.Run("Short", func(t *testing.T) {
+		req, err := RequestFromReaderWithOptions(strings.NewReader(
+			"POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc"), Options{StreamBody: true})
+		require.NoError(t, err)
+		body, err := io.ReadAll(req.BodyReader)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Equal(t, "abc", string(body))
+		assert.False(t, req.BodyConsumed())
+	})
+
+	// Test: Content-Length is still checked up front
+	t.Run("Too large", func(t *testing.T) {
+		_, err := RequestFromReaderWithOptions(strings.NewReader(
+			"POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\n"), Options{StreamBody: true, MaxBodySize: 5})
+		assert.ErrorIs(t, err, ErrContentLengthTooLarge)
+	})
+}
+
+func TestRequestReset(t *testing.T) {
+	raw := "POST /a HTTP/1.1\r\nX-A: 1\r\nContent-Length: 3\r\n\r\nabc"
+	req := NewRequest()
+	require.NoError(t, ReadRequestInto(req, bufio.NewReader(strings.NewReader(raw)), Options{KeepRawHeaders: true}))
+	require.True(t, req.Done())
+	req.SetPathValue("id", "1")
+
+	// Test: Reset leaves nothing of the previous request behind
+	req.Reset()
+	assert.False(t, req.Done())
+	assert.Empty(t, req.RequestLine.Method)
+	assert.Empty(t, req.Headers.Get("x-a"))
+	assert.Nil(t, req.Body)
+	assert.Empty(t, req.RawHeaders)
+	assert.Empty(t, req.PathValue("id"))
+
+	// Test: A reset request parses the next one
+	raw = "GET /b HTTP/1.1\r\nX-B: 2\r\n\r\n"
+	require.NoError(t, ReadRequestInto(req, bufio.NewReader(strings.NewReader(raw)), Options{}))
+	assert.True(t, req.Done())
+	assert.Equal(t, "/b", req.RequestLine.RequestTarget)
+	assert.Equal(t, "2", req.Headers.Get("x-b"))
+	assert.Empty(t, req.RawHeaders)
+}
+
+func TestParserProfile(t *testing.T) {
+	read := func(raw string, profile ParserProfile) (*Request, error) {
+		return RequestFromReaderWithOptions(&chunkReader{data: raw, numBytesPerRead: 3}, Options{Profile: profile})
+	}
+
+	// Test: Lenient skips empty lines before the request line; Strict
+	// refuses them
+	raw := "\r\n\r\nGET / HTTP/1.1\r\nHost: x\r\n\r\n"
+	req, err := read(raw, Lenient)
+	require.NoError(t, err)
+	assert.True(t, req.Done())
+	assert.Equal(t, "/", req.RequestLine.RequestTarget)
+	_, err = read(raw, Strict)
+	assert.ErrorIs(t, err, ErrMalformedReqLine)
+
+	// Test: A request cut short is returned unfinished under Lenient and
+	// refused under Strict, whichever part EOF lands in
+	for name, raw := range map[string]string{
+		"request line": "GET / HT",
+		"headers":      "GET / HTTP/1.1\r\nHost: x\r\n",
+		"body":         "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nab",
+	} {
+		req, err := read(raw, Lenient)
+		require.NoError(t, err, name)
+		assert.False(t, req.Done(), name)
+		_, err = read(raw, Strict)
+		assert.ErrorIs(t, err, ErrIncompleteRequest, name)
+	}
+
+	// Test: Control characters in the request-target are refused under
+	// either profile; bytes past ASCII only under Strict
+	for _, target := range []string{"/a?x\nSet-Cookie:a=1", "/a\rb", "/a\tb", "/a\x00", "/a\x7f"} {
+		for _, profile := range []ParserProfile{Lenient, Strict} {
+			_, err := read("GET "+target+" HTTP/1.1\r\nHost: x\r\n\r\n", profile)
+			assert.ErrorIs(t, err, ErrInvalidTarget, "%q %s", target, profile)
+		}
+	}
+	_, err = read("GET /caf\xe9 HTTP/1.1\r\nHost: x\r\n\r\n", Lenient)
+	require.NoError(t, err)
+	_, err = read("GET /caf\xe9 HTTP/1.1\r\nHost: x\r\n\r\n", Strict)
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: No bytes at all is not a request cut short
+	req, err = read("", Strict)
+	require.NoError(t, err)
+	assert.False(t, req.Done())
+	assert.Equal(t, "strict", Strict.String())
+	assert.Equal(t, "lenient", Lenient.String())
+}
@@ -0,0 +1,64 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smugglingCorpus holds requests that front ends and back ends are known to
+// read differently, letting a client hide one request inside another. Each
+// one must be refused under the Strict profile.
+var smugglingCorpus = []struct {
+	name string
+	raw  string
+}{
+	{"CL.TE", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG"},
+	{"TE.CL", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n5c\r\nGPOST / HTTP/1.1\r\n\r\n0\r\n\r\n"},
+	{"TE only", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+	{"TE with tab", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding:\tchunked\r\n\r\n"},
+	{"TE obfuscated value", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: xchunked\r\nContent-Length: 3\r\n\r\nabc"},
+	{"TE leading space", "POST / HTTP/1.1\r\nHost: x\r\n Transfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\nabc"},
+	{"TE tab before colon", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding\t: chunked\r\nContent-Length: 3\r\n\r\nabc"},
+	{"TE space before colon", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding : chunked\r\nContent-Length: 3\r\n\r\nabc"},
+	{"TE with underscore", "POST / HTTP/1.1\r\nHost: x\r\nTransfer_Encoding: chunked\r\nContent-Length: 3\r\n\r\nabc"},
+	{"CL with underscore", "POST / HTTP/1.1\r\nHost: x\r\nContent_Length: 3\r\n\r\nabc"},
+	{"Conflicting CL", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\nabcd"},
+	{"Repeated CL", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc"},
+	{"CL list", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 3, 3\r\n\r\nabc"},
+	{"CL with plus", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: +3\r\n\r\nabc"},
+	{"CL with vertical tab", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: \v3\r\n\r\nabc"},
+	{"CL negative", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: -1\r\n\r\n"},
+	{"CL hex", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 0x3\r\n\r\nabc"},
+	{"Bare LF in value", "POST / HTTP/1.1\r\nHost: x\r\nX-A: a\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\nabc"},
+	{"Bare CR in value", "POST / HTTP/1.1\r\nHost: x\r\nX-A: a\rContent-Length: 9\r\nContent-Length: 3\r\n\r\nabc"},
+	{"NUL in value", "GET / HTTP/1.1\r\nHost: x\r\nX-A: a\x00b\r\n\r\n"},
+	{"Line folding", "GET / HTTP/1.1\r\nHost: x\r\nX-A: a\r\n\tContent-Length: 3\r\n\r\nabc"},
+	{"Missing Host", "GET / HTTP/1.1\r\nX-A: a\r\n\r\n"},
+	{"Repeated Host", "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n"},
+	{"Tab in target", "GET /a\tHTTP/1.1 HTTP/1.1\r\nHost: x\r\n\r\n"},
+	{"Control byte in target", "GET /a\x01b HTTP/1.1\r\nHost: x\r\n\r\n"},
+	{"Double space in request line", "GET  / HTTP/1.1\r\nHost: x\r\n\r\n"},
+	{"Lowercase method", "get / HTTP/1.1\r\nHost: x\r\n\r\n"},
+	{"HTTP/1.0 downgrade", "GET / HTTP/1.0\r\nHost: x\r\n\r\n"},
+}
+
+func TestStrictRejectsSmuggling(t *testing.T) {
+	for _, tc := range smugglingCorpus {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := RequestFromReaderWithOptions(strings.NewReader(tc.raw), Options{Profile: Strict})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestStrictAcceptsValid(t *testing.T) {
+	raw := "POST /a?b=c HTTP/1.1\r\nHost: example.com\r\nX-Empty:\r\nAccept:\t*/*\r\nContent-Length: 3\r\n\r\nabc"
+	req, err := RequestFromReaderWithOptions(strings.NewReader(raw), Options{Profile: Strict})
+	require.NoError(t, err)
+	assert.True(t, req.Done())
+	assert.Equal(t, "*/*", req.Headers.Get("accept"))
+	assert.Equal(t, "abc", string(req.Body))
+}
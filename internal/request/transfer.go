@@ -0,0 +1,222 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/chunked"
+)
+
+// transferCodings returns the codings listed in Transfer-Encoding, in the
+// order they were applied. Only those the parser can undo are accepted:
+// gzip or deflate, any number of times, then chunked once and last, which
+// is what gives the body its length (RFC 9112 section 6.1).
+func (r *Request) transferCodings() ([]string, error) {
+	var codings []string
+	for _, c := range strings.Split(r.Headers.Get("transfer-encoding"), ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		switch c {
+		case "":
+			// Empty list elements are allowed and mean nothing
+			// (RFC 9110 section 5.6.1).
+			continue
+		case "chunked", "gzip", "x-gzip", "deflate":
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedTransferCoding, c)
+		}
+		codings = append(codings, c)
+	}
+	if len(codings) == 0 || codings[len(codings)-1] != "chunked" {
+		return nil, fmt.Errorf("%w: chunked is not the final coding", ErrInvalidTransferEncoding)
+	}
+	if slices.Index(codings, "chunked") != len(codings)-1 {
+		return nil, fmt.Errorf("%w: chunked applied more than once", ErrInvalidTransferEncoding)
+	}
+	return codings, nil
+}
+
+// readCodedBody reads a body sent with Transfer-Encoding, undoing each of
+// its codings. Transfer-Encoding overrides Content-Length, which is dropped
+// along with the connection after this request (RFC 9112 section 6.3). The
+// framing headers are rewritten to describe the decoded body: a buffered
+// body gets its Content-Length, a streamed one plain chunked.
+func (r *Request) readCodedBody(br *bufio.Reader) error {
+	codings, err := r.transferCodings()
+	if err != nil {
+		return err
+	}
+	if r.Headers.Get("content-length") != "" {
+		r.Headers.Delete("content-length")
+		r.Headers.Set("Connection", "close")
+	}
+
+	chunks := &chunkedReader{br: br, dec: chunked.NewDecoder()}
+	r.Trailers = chunks.dec.Trailers()
+	b := &codedBody{chunks: chunks, codings: codings[:len(codings)-1], limit: r.maxBodySize()}
+	if r.opts.StreamBody {
+		r.Headers.Replace("Transfer-Encoding", "chunked")
+		r.BodyReader = b
+		r.state = StateDone
+		return nil
+	}
+
+	body, err := io.ReadAll(b)
+	if len(body) > 0 {
+		r.Body = body
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) && !chunks.dec.Done() {
+		return r.cutShort()
+	}
+	if err != nil {
+		return err
+	}
+	r.Headers.Delete("transfer-encoding")
+	r.Headers.Replace("Content-Length", strconv.Itoa(len(body)))
+	r.state = StateDone
+	return nil
+}
+
+// maxFramingLine bounds a chunk-size or trailer line that does not fit in
+// the reader's buffer.
+const maxFramingLine = 64 * 1024
+
+// chunkedReader reads the payload of a chunked body from the connection's
+// reader, consuming nothing past the body's end.
+type chunkedReader struct {
+	br  *bufio.Reader
+	dec *chunked.Decoder
+	// long accumulates a framing line that does not fit in br's buffer.
+	long []byte
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for !c.dec.Done() {
+		data, err := c.br.Peek(max(c.br.Buffered(), 1))
+		if len(data) == 0 {
+			return 0, noEOF(err)
+		}
+
+		if c.long != nil {
+			// As with header lines, take no more than the line's end.
+			if i := bytes.IndexByte(data, '\n'); i != -1 {
+				data = data[:i+1]
+			}
+			if len(c.long)+len(data) > maxFramingLine {
+				return 0, fmt.Errorf("%w: chunked framing line over %d bytes", ErrInvalidTransferEncoding, maxFramingLine)
+			}
+			c.long = append(c.long, data...)
+			c.br.Discard(len(data))
+			n, _, _, err := c.dec.Parse(c.long)
+			if err != nil {
+				return 0, err
+			}
+			if c.long = c.long[n:]; len(c.long) == 0 {
+				c.long = nil
+			}
+			continue
+		}
+
+		// Chunk data is taken no more than p holds; the framing may need
+		// more than that.
+		n, payload, _, err := c.dec.Parse(data[:min(len(data), len(p))])
+		if n == 0 && err == nil && len(data) > len(p) {
+			n, payload, _, err = c.dec.Parse(data)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			if len(data) == c.br.Size() {
+				c.long = append([]byte(nil), data...)
+				c.br.Discard(len(data))
+				continue
+			}
+			if _, err := c.br.Peek(len(data) + 1); err != nil && err != bufio.ErrBufferFull {
+				return 0, noEOF(err)
+			}
+			continue
+		}
+		copy(p, payload)
+		c.br.Discard(n)
+		if len(payload) > 0 {
+			return len(payload), nil
+		}
+	}
+	return 0, io.EOF
+}
+
+// noEOF turns the reader ending inside the body into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// codedBody undoes the codings applied before chunked, capping the decoded
+// size at limit so that a small compressed body cannot expand without
+// bound. The decoders are set up on the first Read, so that nothing is read
+// from the connection before the body is asked for.
+type codedBody struct {
+	chunks  *chunkedReader
+	codings []string
+	limit   int64
+
+	r    io.Reader
+	read int64
+	err  error
+}
+
+func (b *codedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.r == nil {
+		b.r = b.chunks
+		for _, coding := range slices.Backward(b.codings) {
+			var err error
+			if coding == "deflate" {
+				b.r, err = zlib.NewReader(b.r)
+			} else {
+				b.r, err = gzip.NewReader(b.r)
+			}
+			if err != nil {
+				b.err = fmt.Errorf("decoding %s body: %w", coding, err)
+				return 0, b.err
+			}
+		}
+	}
+
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	switch {
+	case b.read > b.limit:
+		err = fmt.Errorf("%w: decoded body over %d bytes", ErrContentLengthTooLarge, b.limit)
+	case err == io.EOF && !b.chunks.dec.Done():
+		// The codings ended before the chunked body did; anything left
+		// in it is not part of the content.
+		if _, err = b.chunks.Read(make([]byte, 1)); err == nil {
+			err = errors.New("data after the end of the coded content")
+		}
+		if err != io.EOF {
+			err = fmt.Errorf("decoding body: %w", err)
+		}
+	case err != nil && err != io.EOF:
+		err = fmt.Errorf("decoding body: %w", err)
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
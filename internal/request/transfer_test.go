@@ -0,0 +1,162 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunk frames data as a chunked body in pieces of size bytes.
+func chunk(data []byte, size int) string {
+	var b strings.Builder
+	for i := 0; i < len(data); i += size {
+		piece := data[i:min(i+size, len(data))]
+		fmt.Fprintf(&b, "%x\r\n%s\r\n", len(piece), piece)
+	}
+	return b.String() + "0\r\n\r\n"
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	_, err := io.WriteString(zw, data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return b.Bytes()
+}
+
+func TestTransferEncoding(t *testing.T) {
+	head := func(te string) string {
+		return "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: " + te + "\r\n\r\n"
+	}
+
+	// Test: A chunked body is decoded at any read size, its trailers kept
+	// and its framing headers rewritten
+	t.Run("Chunked", func(t *testing.T) {
+		raw := head("chunked") + "5;a=b\r\nhello\r\n7\r\n, world\r\n0\r\nX-Sum: 12\r\n\r\n"
+		for _, size := range []int{1, 2, 7, len(raw)} {
+			req, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: size})
+			require.NoError(t, err)
+			assert.True(t, req.Done(), "read size %d", size)
+			assert.Equal(t, "hello, world", string(req.Body), "read size %d", size)
+			assert.Equal(t, "12", req.Trailers.Get("x-sum"))
+			assert.Empty(t, req.Headers.Get("transfer-encoding"))
+			assert.Equal(t, "12", req.Headers.Get("content-length"))
+		}
+	})
+
+	// Test: gzip, deflate and both are undone before chunked
+	t.Run("Layered", func(t *testing.T) {
+		content := strings.Repeat("layered codings ", 100)
+		var deflated bytes.Buffer
+		zw := zlib.NewWriter(&deflated)
+		io.WriteString(zw, content)
+		zw.Close()
+
+		var both bytes.Buffer
+		zw = zlib.NewWriter(&both)
+		zw.Write(gzipped(t, content))
+		zw.Close()
+
+		for te, body := range map[string][]byte{
+			"gzip, chunked":          gzipped(t, content),
+			"x-gzip, chunked":        gzipped(t, content),
+			"deflate,chunked":        deflated.Bytes(),
+			"gzip, deflate, chunked": both.Bytes(),
+			"GZIP, , Chunked":        gzipped(t, content),
+		} {
+			req, err := RequestFromReader(strings.NewReader(head(te) + chunk(body, 17)))
+			require.NoError(t, err, te)
+			assert.True(t, req.Done(), te)
+			assert.Equal(t, content, string(req.Body), te)
+		}
+	})
+
+	// Test: Codings the parser cannot undo, or that leave no length, are
+	// refused
+	t.Run("Refused", func(t *testing.T) {
+		for te, want := range map[string]error{
+			"br, chunked":      ErrUnsupportedTransferCoding,
+			"identity":         ErrUnsupportedTransferCoding,
+			"gzip":             ErrInvalidTransferEncoding,
+			"chunked, gzip":    ErrInvalidTransferEncoding,
+			"chunked, chunked": ErrInvalidTransferEncoding,
+		} {
+			_, err := RequestFromReader(strings.NewReader(head(te) + "0\r\n\r\n"))
+			assert.ErrorIs(t, err, want, te)
+		}
+	})
+
+	// Test: Malformed framing and corrupt codings are errors
+	t.Run("Malformed", func(t *testing.T) {
+		for name, raw := range map[string]string{
+			"chunk size":     head("chunked") + "x\r\nabc\r\n0\r\n\r\n",
+			"chunk CRLF":     head("chunked") + "3\r\nabcd\r\n0\r\n\r\n",
+			"not gzip":       head("gzip, chunked") + chunk([]byte("plain"), 5),
+			"after the gzip": head("gzip, chunked") + chunk(append(gzipped(t, "a"), "junk"...), 64),
+		} {
+			_, err := RequestFromReader(strings.NewReader(raw))
+			assert.Error(t, err, name)
+		}
+	})
+
+	// Test: Transfer-Encoding wins over Content-Length, and the connection
+	// is to close after the request
+	t.Run("With Content-Length", func(t *testing.T) {
+		raw := "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"
+		req, err := RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		assert.Equal(t, "abc", string(req.Body))
+		assert.Equal(t, "close", req.Headers.Get("connection"))
+	})
+
+	// Test: MaxBodySize caps the decoded body, not what was sent
+	t.Run("Too large", func(t *testing.T) {
+		raw := head("gzip, chunked") + chunk(gzipped(t, strings.Repeat("a", 1000)), 64)
+		_, err := RequestFromReaderWithOptions(strings.NewReader(raw), Options{MaxBodySize: 999})
+		assert.ErrorIs(t, err, ErrContentLengthTooLarge)
+	})
+
+	// Test: A body cut short by EOF is not done
+	t.Run("Short", func(t *testing.T) {
+		req, err := RequestFromReader(strings.NewReader(head("chunked") + "5\r\nabc"))
+		require.NoError(t, err)
+		assert.False(t, req.Done())
+		assert.Equal(t, "abc", string(req.Body))
+	})
+
+	// Test: Streamed, the body is decoded as it is read and the next
+	// request follows it
+	t.Run("Stream", func(t *testing.T) {
+		br := bufio.NewReader(strings.NewReader(head("gzip, chunked") + chunk(gzipped(t, "streamed"), 4) +
+			"GET /b HTTP/1.1\r\nHost: x\r\n\r\n"))
+		req, err := ReadRequest(br, Options{StreamBody: true})
+		require.NoError(t, err)
+		assert.Equal(t, "chunked", req.Headers.Get("transfer-encoding"))
+		assert.False(t, req.BodyConsumed())
+
+		body, err := io.ReadAll(req.BodyReader)
+		require.NoError(t, err)
+		assert.Equal(t, "streamed", string(body))
+		assert.True(t, req.BodyConsumed())
+
+		req, err = ReadRequest(br, Options{StreamBody: true})
+		require.NoError(t, err)
+		assert.Equal(t, "/b", req.RequestLine.RequestTarget)
+	})
+
+	// Test: The Strict profile still refuses Transfer-Encoding
+	t.Run("Strict", func(t *testing.T) {
+		_, err := RequestFromReaderWithOptions(strings.NewReader(head("chunked")+"0\r\n\r\n"), Options{Profile: Strict})
+		assert.ErrorIs(t, err, ErrTransferEncoding)
+	})
+}
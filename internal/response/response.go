@@ -0,0 +1,450 @@
+package response
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/chunked"
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+)
+
+type parserState int
+
+const (
+	stateInitialized parserState = iota
+	stateHeaders
+	stateBody
+	stateChunkedBody
+	stateBodyUntilClose
+	stateDone
+)
+
+const (
+	CRLF       = "\r\n"
+	bufferSize = 1024
+)
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   int
+	ReasonPhrase string
+}
+
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	// Trailers holds the trailer fields of a chunked body. It is filled in
+	// once the body has been read completely.
+	Trailers headers.Headers
+	// TLS describes the connection the response arrived on, or is nil for
+	// plain-text connections.
+	TLS *tls.ConnectionState
+	// Uncompressed is set by the client when it transparently decoded a
+	// compressed body; Content-Encoding and Content-Length are removed.
+	Uncompressed bool
+	state        parserState
+	opts         Options
+	chunked      *chunked.Decoder
+	bodyWriter   io.Writer
+	bodyRead     int64
+}
+
+// Options controls optional parser behaviour. The zero value matches the
+// behaviour of ResponseFromReader.
+type Options struct {
+	// RequestMethod is the method of the request this response answers. A
+	// response to HEAD, or a successful response to CONNECT, never carries a
+	// body, whatever its headers say.
+	RequestMethod string
+	// OnHeaders, when set, is called once the header section has been parsed
+	// and before any more body bytes are read.
+	OnHeaders func()
+	// MaxBodySize caps the body, after removing chunked framing, at this many
+	// bytes; larger bodies fail with ErrBodyTooLarge. Zero means no limit.
+	MaxBodySize int64
+	// BodyWriter, when set, is called after OnHeaders with the response
+	// parsed so far. If it returns a writer, body bytes are written to it as
+	// they arrive instead of being collected in Body.
+	BodyWriter func(resp *Response) io.Writer
+}
+
+var (
+	ErrMalformedStatusLine     = fmt.Errorf("malformed status-line")
+	ErrInvalidStatusCode       = fmt.Errorf("invalid status code")
+	ErrUnsupportedHttpVer      = fmt.Errorf("unsupported http version")
+	ErrInvalidHttpFormat       = fmt.Errorf("invalid http version format")
+	ErrParserDone              = fmt.Errorf("trying to read data in done state")
+	ErrUnknownState            = fmt.Errorf("unknown parser state")
+	ErrInvalidContentLength    = fmt.Errorf("invalid content-length value")
+	ErrMultipleContentLength   = fmt.Errorf("multiple content-length values")
+	ErrUnsupportedTransferCode = fmt.Errorf("unsupported transfer-encoding")
+	ErrBodyTooLarge            = fmt.Errorf("response body exceeds maximum size")
+)
+
+func NewResponse() *Response {
+	return &Response{
+		Headers:  *headers.NewHeaders(),
+		Trailers: *headers.NewHeaders(),
+		state:    stateInitialized,
+	}
+}
+
+// bodyAllowed reports whether the response can carry a body at all
+// (RFC 9112 section 6.3).
+func (r *Response) bodyAllowed() bool {
+	code := r.StatusLine.StatusCode
+	if r.opts.RequestMethod == "HEAD" {
+		return false
+	}
+	if r.opts.RequestMethod == "CONNECT" && code >= 200 && code < 300 {
+		return false
+	}
+	return !(code >= 100 && code < 200) && code != 204 && code != 304
+}
+
+// isChunked reports whether the body uses chunked framing. Transfer-Encoding
+// takes precedence over Content-Length, and chunked must be the final coding.
+func (r *Response) isChunked() (bool, error) {
+	te := r.Headers.Get("transfer-encoding")
+	if te == "" {
+		return false, nil
+	}
+
+	codings := strings.Split(te, ",")
+	if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+		return false, fmt.Errorf("%w: %s", ErrUnsupportedTransferCode, te)
+	}
+	return true, nil
+}
+
+// closeDelimited reports whether a body without Content-Length or
+// Transfer-Encoding runs until the server closes the connection. That is the
+// norm for HTTP/1.0 and for responses announcing Connection: close; other
+// HTTP/1.1 responses without framing are taken to have no body.
+func (r *Response) closeDelimited() bool {
+	if r.StatusLine.HttpVersion == "1.0" {
+		return true
+	}
+	for _, v := range strings.Split(r.Headers.Get("connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), "close") {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Response) getAndValidateContentLength() (int64, error) {
+	contentLengthStr := r.Headers.Get("content-length")
+
+	if contentLengthStr == "" {
+		return 0, nil
+	}
+
+	if strings.Contains(contentLengthStr, ",") {
+		return 0, ErrMultipleContentLength
+	}
+
+	contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
+	if err != nil || contentLength < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidContentLength, contentLengthStr)
+	}
+
+	return contentLength, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.state {
+	case stateInitialized:
+		sl, bytesConsumed, err := parseStatusLine(data)
+		if err != nil {
+			return 0, err
+		}
+		if bytesConsumed == 0 {
+			return 0, nil
+		}
+		r.StatusLine = *sl
+		r.state = stateHeaders
+		return bytesConsumed, nil
+
+	case stateHeaders:
+		bytesConsumed, done, err := r.Headers.ParseAll(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = stateBody
+			if r.opts.OnHeaders != nil {
+				r.opts.OnHeaders()
+			}
+			if r.opts.BodyWriter != nil {
+				r.bodyWriter = r.opts.BodyWriter(r)
+			}
+		}
+		return bytesConsumed, nil
+
+	case stateBody:
+		if !r.bodyAllowed() {
+			r.state = stateDone
+			return 0, nil
+		}
+
+		isChunked, err := r.isChunked()
+		if err != nil {
+			return 0, err
+		}
+		if isChunked {
+			r.chunked = chunked.NewDecoder()
+			r.state = stateChunkedBody
+			return r.parseSingle(data)
+		}
+
+		if r.Headers.Get("content-length") == "" && r.closeDelimited() {
+			r.state = stateBodyUntilClose
+			return r.parseSingle(data)
+		}
+
+		contentLength, err := r.getAndValidateContentLength()
+		if err != nil {
+			return 0, err
+		}
+
+		if r.opts.MaxBodySize > 0 && contentLength > r.opts.MaxBodySize {
+			return 0, fmt.Errorf("%w: content-length %d, limit %d",
+				ErrBodyTooLarge, contentLength, r.opts.MaxBodySize)
+		}
+
+		if contentLength == 0 {
+			r.state = stateDone
+			return 0, nil
+		}
+
+		// Anything past Content-Length belongs to the next message on the
+		// connection and is left unconsumed.
+		remaining := contentLength - r.bodyRead
+		if int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+
+		if err := r.appendBody(data); err != nil {
+			return 0, err
+		}
+
+		if r.bodyRead == contentLength {
+			r.state = stateDone
+		}
+
+		return len(data), nil
+
+	case stateChunkedBody:
+		bytesConsumed, payload, done, err := r.chunked.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if err := r.appendBody(payload); err != nil {
+			return 0, err
+		}
+		if done {
+			r.Trailers = *r.chunked.Trailers()
+			r.state = stateDone
+		}
+		return bytesConsumed, nil
+
+	case stateBodyUntilClose:
+		if err := r.appendBody(data); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+
+	case stateDone:
+		return 0, ErrParserDone
+
+	default:
+		return 0, ErrUnknownState
+	}
+}
+
+// appendBody adds p to the body, or hands it to the body writer, enforcing
+// MaxBodySize.
+func (r *Response) appendBody(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if r.opts.MaxBodySize > 0 && r.bodyRead+int64(len(p)) > r.opts.MaxBodySize {
+		return fmt.Errorf("%w: limit %d", ErrBodyTooLarge, r.opts.MaxBodySize)
+	}
+	r.bodyRead += int64(len(p))
+
+	if r.bodyWriter != nil {
+		_, err := r.bodyWriter.Write(p)
+		return err
+	}
+	r.Body = append(r.Body, p...)
+	return nil
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	totalBytesParsed := 0
+
+	for r.state != stateDone {
+		n, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return totalBytesParsed, err
+		}
+
+		if n == 0 {
+			// need more data
+			break
+		}
+
+		totalBytesParsed += n
+	}
+
+	return totalBytesParsed, nil
+}
+
+func validateHttpVersion(version string) error {
+	parts := strings.Split(version, "/")
+	if len(parts) != 2 {
+		return ErrInvalidHttpFormat
+	}
+
+	if parts[0] != "HTTP" {
+		return ErrInvalidHttpFormat
+	}
+
+	if parts[1] != "1.1" && parts[1] != "1.0" {
+		return ErrUnsupportedHttpVer
+	}
+
+	return nil
+}
+
+func parseStatusLine(data []byte) (*StatusLine, int, error) {
+	crlfBytes := []byte(CRLF)
+	idx := bytes.Index(data, crlfBytes)
+	if idx == -1 {
+		return nil, 0, nil
+	}
+
+	statusLineBytes := data[:idx]
+	bytesConsumed := idx + len(crlfBytes)
+
+	// The reason phrase may contain spaces or be empty altogether.
+	parts := bytes.SplitN(statusLineBytes, []byte(" "), 3)
+	if len(parts) < 2 {
+		return nil, 0, ErrMalformedStatusLine
+	}
+
+	version := string(parts[0])
+	if err := validateHttpVersion(version); err != nil {
+		return nil, 0, err
+	}
+
+	if len(parts[1]) != 3 {
+		return nil, 0, ErrInvalidStatusCode
+	}
+	statusCode, err := strconv.Atoi(string(parts[1]))
+	if err != nil || statusCode < 100 {
+		return nil, 0, ErrInvalidStatusCode
+	}
+
+	reasonPhrase := ""
+	if len(parts) == 3 {
+		reasonPhrase = string(parts[2])
+	}
+
+	sl := &StatusLine{
+		HttpVersion:  strings.Split(version, "/")[1],
+		StatusCode:   statusCode,
+		ReasonPhrase: reasonPhrase,
+	}
+
+	return sl, bytesConsumed, nil
+}
+
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	return ResponseFromReaderWithOptions(reader, Options{})
+}
+
+func ResponseFromReaderWithOptions(reader io.Reader, opts Options) (*Response, error) {
+	return NewReader(reader).ReadResponse(opts)
+}
+
+// Reader parses consecutive responses from one connection. Bytes that arrive
+// after the end of one response, such as a final response following a 1xx
+// interim one, are kept for the next ReadResponse call.
+type Reader struct {
+	reader    io.Reader
+	buf       []byte
+	readToIdx int
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, bufferSize),
+	}
+}
+
+// Buffered returns the number of bytes read from the connection but not yet
+// consumed by a response.
+func (rr *Reader) Buffered() int {
+	return rr.readToIdx
+}
+
+func (rr *Reader) ReadResponse(opts Options) (*Response, error) {
+	resp := NewResponse()
+	resp.opts = opts
+	readAny := rr.readToIdx > 0
+
+	for {
+		bytesConsumed, err := resp.parse(rr.buf[:rr.readToIdx])
+		if err != nil {
+			return nil, err
+		}
+
+		if bytesConsumed > 0 {
+			copy(rr.buf, rr.buf[bytesConsumed:rr.readToIdx])
+			rr.readToIdx -= bytesConsumed
+		}
+
+		if resp.state == stateDone {
+			return resp, nil
+		}
+
+		if rr.readToIdx >= len(rr.buf) {
+			newBuf := make([]byte, len(rr.buf)*2)
+			copy(newBuf, rr.buf)
+			rr.buf = newBuf
+		}
+
+		n, err := rr.reader.Read(rr.buf[rr.readToIdx:])
+		rr.readToIdx += n
+		readAny = readAny || n > 0
+
+		if err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			if n > 0 {
+				// Parse what arrived together with EOF before giving up.
+				continue
+			}
+			if resp.state == stateBodyUntilClose {
+				// The close is what ends this body.
+				resp.state = stateDone
+				return resp, nil
+			}
+			if !readAny {
+				// The peer closed the connection without sending anything.
+				return nil, io.EOF
+			}
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package response
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// it's useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+func TestStatusLineParse(t *testing.T) {
+	// Test: Good status line
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, 200, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+
+	// Test: Reason phrase with spaces
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 500 Internal Server Error\r\nContent-Length: 0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 500, r.StatusLine.StatusCode)
+	assert.Equal(t, "Internal Server Error", r.StatusLine.ReasonPhrase)
+
+	// Test: Empty reason phrase
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 204 \r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 204, r.StatusLine.StatusCode)
+	assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+
+	// Test: Missing reason phrase
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 204\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 204, r.StatusLine.StatusCode)
+
+	// Test: Missing status code
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1\r\n\r\n"))
+	require.ErrorIs(t, err, ErrMalformedStatusLine)
+
+	// Test: Non-numeric status code
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 abc OK\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidStatusCode)
+
+	// Test: Status code with wrong number of digits
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 2000 OK\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidStatusCode)
+
+	// Test: Unsupported version
+	_, err = ResponseFromReader(strings.NewReader("HTTP/2.0 200 OK\r\n\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedHttpVer)
+
+	// Test: Malformed version
+	_, err = ResponseFromReader(strings.NewReader("HTTPS/1.1 200 OK\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidHttpFormat)
+}
+
+func TestResponseBodyParsing(t *testing.T) {
+	// Test: Content-Length body
+	t.Run("Content-Length body", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"Content-Length: 13\r\n" +
+				"\r\n" +
+				"hello world!\n",
+			numBytesPerRead: 3,
+		}
+		r, err := ResponseFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain", r.Headers.Get("content-type"))
+		assert.Equal(t, "hello world!\n", string(r.Body))
+	})
+
+	// Test: No Content-Length means no body
+	t.Run("No Content-Length", func(t *testing.T) {
+		r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Nil(t, r.Body)
+	})
+
+	// Test: HEAD response ignores Content-Length
+	t.Run("HEAD response", func(t *testing.T) {
+		reader := strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n")
+		r, err := ResponseFromReaderWithOptions(reader, Options{RequestMethod: "HEAD"})
+		require.NoError(t, err)
+		assert.Equal(t, "100", r.Headers.Get("content-length"))
+		assert.Nil(t, r.Body)
+	})
+
+	// Test: 304 response has no body
+	t.Run("304 response", func(t *testing.T) {
+		r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 304 Not Modified\r\nContent-Length: 100\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Nil(t, r.Body)
+	})
+
+	// Test: Truncated body
+	t.Run("Truncated body", func(t *testing.T) {
+		reader := &chunkReader{
+			data:            "HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial",
+			numBytesPerRead: 4,
+		}
+		_, err := ResponseFromReader(reader)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	// Test: Bytes past Content-Length are left unconsumed
+	t.Run("Body longer than Content-Length", func(t *testing.T) {
+		rr := NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\ntoo long"))
+		r, err := rr.ReadResponse(Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "to", string(r.Body))
+		assert.Equal(t, 6, rr.Buffered())
+	})
+
+	// Test: Invalid Content-Length
+	t.Run("Invalid Content-Length", func(t *testing.T) {
+		_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n"))
+		require.ErrorIs(t, err, ErrInvalidContentLength)
+	})
+}
+
+func TestChunkedResponseBody(t *testing.T) {
+	chunkedResponse := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n" +
+		"7\r\n world!\r\n" +
+		"0\r\n" +
+		"\r\n"
+
+	// Test: Chunked body with various chunk sizes
+	for _, chunkSize := range []int{1, 2, 3, 5, 8, 16, 50, 100} {
+		t.Run(fmt.Sprintf("ChunkSize_%d", chunkSize), func(t *testing.T) {
+			reader := &chunkReader{
+				data:            chunkedResponse,
+				numBytesPerRead: chunkSize,
+			}
+			r, err := ResponseFromReader(reader)
+			require.NoError(t, err)
+			assert.Equal(t, 200, r.StatusLine.StatusCode)
+			assert.Equal(t, "hello world!", string(r.Body))
+		})
+	}
+
+	// Test: Transfer-Encoding overrides Content-Length
+	t.Run("Transfer-Encoding overrides Content-Length", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Content-Length: 100\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"3\r\nabc\r\n0\r\n\r\n",
+			numBytesPerRead: 4,
+		}
+		r, err := ResponseFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", string(r.Body))
+	})
+
+	// Test: Large chunked body
+	t.Run("Large chunked body", func(t *testing.T) {
+		bodyContent := strings.Repeat("a", 5000)
+		reader := &chunkReader{
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				fmt.Sprintf("%x\r\n%s\r\n", len(bodyContent), bodyContent) +
+				"0\r\n\r\n",
+			numBytesPerRead: 333,
+		}
+		r, err := ResponseFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, bodyContent, string(r.Body))
+	})
+
+	// Test: Truncated chunked body
+	t.Run("Truncated chunked body", func(t *testing.T) {
+		reader := &chunkReader{
+			data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel",
+			numBytesPerRead: 3,
+		}
+		_, err := ResponseFromReader(reader)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	// Test: Invalid chunk size
+	t.Run("Invalid chunk size", func(t *testing.T) {
+		reader := &chunkReader{
+			data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		_, err := ResponseFromReader(reader)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid chunk size")
+	})
+
+	// Test: Unsupported final transfer coding
+	t.Run("Unsupported transfer coding", func(t *testing.T) {
+		_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\n"))
+		require.ErrorIs(t, err, ErrUnsupportedTransferCode)
+	})
+
+	// Test: HEAD response with chunked encoding has no body
+	t.Run("HEAD response with chunked encoding", func(t *testing.T) {
+		reader := strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n")
+		r, err := ResponseFromReaderWithOptions(reader, Options{RequestMethod: "HEAD"})
+		require.NoError(t, err)
+		assert.Nil(t, r.Body)
+	})
+}
+
+func TestEmptyResponse(t *testing.T) {
+	// Test: Connection closed before any byte is io.EOF
+	_, err := ResponseFromReader(strings.NewReader(""))
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Connection closed mid status line is io.ErrUnexpectedEOF
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200"))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestChunkedResponseTrailers(t *testing.T) {
+	data := "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: X-Content-SHA256, X-Content-Length\r\n" +
+		"\r\n" +
+		"5;ext=1\r\nhello\r\n" +
+		"0\r\n" +
+		"X-Content-SHA256: 2cf24dba\r\n" +
+		"X-Content-Length: 5\r\n" +
+		"\r\n"
+
+	// Test: Trailers with various chunk sizes
+	for _, chunkSize := range []int{1, 3, 10, 200} {
+		t.Run(fmt.Sprintf("ChunkSize_%d", chunkSize), func(t *testing.T) {
+			r, err := ResponseFromReader(&chunkReader{data: data, numBytesPerRead: chunkSize})
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(r.Body))
+			assert.Equal(t, "2cf24dba", r.Trailers.Get("x-content-sha256"))
+			assert.Equal(t, "5", r.Trailers.Get("x-content-length"))
+			assert.Equal(t, "", r.Headers.Get("x-content-sha256"))
+		})
+	}
+
+	// Test: No trailers
+	t.Run("No trailers", func(t *testing.T) {
+		r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "", r.Trailers.Get("x-anything"))
+	})
+
+	// Test: Missing final CRLF after trailers
+	t.Run("Missing final CRLF", func(t *testing.T) {
+		_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-A: b\r\n"))
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestReaderConsecutiveResponses(t *testing.T) {
+	data := "HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst" +
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nsecond\r\n0\r\n\r\n" +
+		"HTTP/1.1 204 No Content\r\n\r\n"
+
+	// Test: Responses arriving back to back are split correctly
+	for _, chunkSize := range []int{1, 7, 40, 1000} {
+		t.Run(fmt.Sprintf("ChunkSize_%d", chunkSize), func(t *testing.T) {
+			rr := NewReader(&chunkReader{data: data, numBytesPerRead: chunkSize})
+
+			r, err := rr.ReadResponse(Options{})
+			require.NoError(t, err)
+			assert.Equal(t, 100, r.StatusLine.StatusCode)
+
+			r, err = rr.ReadResponse(Options{})
+			require.NoError(t, err)
+			assert.Equal(t, 103, r.StatusLine.StatusCode)
+			assert.Equal(t, "</style.css>; rel=preload", r.Headers.Get("link"))
+
+			r, err = rr.ReadResponse(Options{})
+			require.NoError(t, err)
+			assert.Equal(t, "first", string(r.Body))
+
+			r, err = rr.ReadResponse(Options{})
+			require.NoError(t, err)
+			assert.Equal(t, "second", string(r.Body))
+
+			r, err = rr.ReadResponse(Options{})
+			require.NoError(t, err)
+			assert.Equal(t, 204, r.StatusLine.StatusCode)
+
+			_, err = rr.ReadResponse(Options{})
+			require.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	// Test: Content-Length over the limit fails before the body is read
+	t.Run("Content-Length over limit", func(t *testing.T) {
+		reader := strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 1000000\r\n\r\n")
+		_, err := ResponseFromReaderWithOptions(reader, Options{MaxBodySize: 10})
+		require.ErrorIs(t, err, ErrBodyTooLarge)
+	})
+
+	// Test: Body exactly at the limit
+	t.Run("At limit", func(t *testing.T) {
+		reader := strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")
+		r, err := ResponseFromReaderWithOptions(reader, Options{MaxBodySize: 5})
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+	})
+
+	// Test: Chunked body growing past the limit
+	t.Run("Chunked over limit", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"4\r\nabcd\r\n4\r\nefgh\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		_, err := ResponseFromReaderWithOptions(reader, Options{MaxBodySize: 6})
+		require.ErrorIs(t, err, ErrBodyTooLarge)
+	})
+}
+
+func TestCloseDelimitedBody(t *testing.T) {
+	// Test: HTTP/1.0 body runs until EOF
+	t.Run("HTTP/1.0", func(t *testing.T) {
+		reader := &chunkReader{
+			data:            "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end",
+			numBytesPerRead: 4,
+		}
+		r, err := ResponseFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+		assert.Equal(t, "until the end", string(r.Body))
+	})
+
+	// Test: HTTP/1.1 with Connection: close
+	t.Run("Connection close", func(t *testing.T) {
+		r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nbye"))
+		require.NoError(t, err)
+		assert.Equal(t, "bye", string(r.Body))
+	})
+
+	// Test: Content-Length still wins for HTTP/1.0
+	t.Run("HTTP/1.0 with Content-Length", func(t *testing.T) {
+		r, err := ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\nContent-Length: 2\r\n\r\nhi"))
+		require.NoError(t, err)
+		assert.Equal(t, "hi", string(r.Body))
+	})
+
+	// Test: MaxBodySize applies
+	t.Run("Over limit", func(t *testing.T) {
+		reader := strings.NewReader("HTTP/1.0 200 OK\r\n\r\n" + strings.Repeat("x", 100))
+		_, err := ResponseFromReaderWithOptions(reader, Options{MaxBodySize: 10})
+		require.ErrorIs(t, err, ErrBodyTooLarge)
+	})
+}
+
+func TestBodyWriter(t *testing.T) {
+	// Test: Chunked body goes to the writer, not Body
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	var buf strings.Builder
+	var sawStatus int
+	r, err := ResponseFromReaderWithOptions(reader, Options{
+		BodyWriter: func(resp *Response) io.Writer {
+			sawStatus = resp.StatusLine.StatusCode
+			return &buf
+		},
+		MaxBodySize: 11,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 200, sawStatus)
+	assert.Equal(t, "hello world", buf.String())
+	assert.Empty(t, r.Body)
+
+	// Test: The size limit still applies
+	_, err = ResponseFromReaderWithOptions(strings.NewReader("HTTP/1.1 200 OK\r\nConnection: close\r\n\r\ntoo long"), Options{
+		BodyWriter:  func(*Response) io.Writer { return io.Discard },
+		MaxBodySize: 3,
+	})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
@@ -0,0 +1,50 @@
+package response
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+)
+
+// TimeFormat is the IMF-fixdate layout of HTTP dates (RFC 9110 section
+// 5.6.7), as sent in Date, Last-Modified and Retry-After.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// SetRetryAfter sets Retry-After on h to d as delay-seconds, rounded up so
+// that a client waiting as long is never early. It goes with 429, with 503
+// and with 3xx redirects (RFC 9110 section 10.2.3).
+func SetRetryAfter(h *headers.Headers, d time.Duration) {
+	seconds := (max(d, 0) + time.Second - 1) / time.Second
+	h.Replace("Retry-After", strconv.FormatInt(int64(seconds), 10))
+}
+
+// SetRetryAfterDate sets Retry-After on h to t as an HTTP-date, for a wait
+// that ends at a known time such as the close of a maintenance window.
+func SetRetryAfterDate(h *headers.Headers, t time.Time) {
+	h.Replace("Retry-After", t.UTC().Format(TimeFormat))
+}
+
+// ParseRetryAfter returns how long a Retry-After value asks the client to
+// wait, taking a date relative to now; a date already past means no wait.
+// It reports false for a value that is neither delay-seconds nor an
+// HTTP-date.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value != "" && strings.Trim(value, "0123456789") == "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds > int64(maxRetryAfter/time.Second) {
+			return maxRetryAfter, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := time.Parse(TimeFormat, value)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// maxRetryAfter is what delay-seconds too large for a Duration read as.
+const maxRetryAfter = time.Duration(1<<63 - 1)
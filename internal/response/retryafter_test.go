@@ -0,0 +1,54 @@
+package response
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Test: Delays are sent as whole seconds, rounded up
+	t.Run("Set seconds", func(t *testing.T) {
+		h := headers.NewHeaders()
+		for d, want := range map[time.Duration]string{
+			0:                       "0",
+			time.Millisecond:        "1",
+			2 * time.Second:         "2",
+			2500 * time.Millisecond: "3",
+			-time.Second:            "0",
+		} {
+			SetRetryAfter(h, d)
+			assert.Equal(t, want, h.Get("Retry-After"), d)
+		}
+	})
+
+	// Test: Dates are sent in IMF-fixdate
+	t.Run("Set date", func(t *testing.T) {
+		h := headers.NewHeaders()
+		SetRetryAfterDate(h, now.In(time.FixedZone("CET", 3600)))
+		assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", h.Get("Retry-After"))
+	})
+
+	// Test: Both forms parse relative to now
+	t.Run("Parse", func(t *testing.T) {
+		for value, want := range map[string]time.Duration{
+			"120":                           2 * time.Minute,
+			" 0 ":                           0,
+			"Fri, 01 Mar 2024 12:00:30 GMT": 30 * time.Second,
+			"Fri, 01 Mar 2024 11:00:00 GMT": 0,
+			"99999999999999999999":          maxRetryAfter,
+		} {
+			d, ok := ParseRetryAfter(value, now)
+			assert.True(t, ok, value)
+			assert.Equal(t, want, d, value)
+		}
+		for _, value := range []string{"", "-1", "1.5", "soon"} {
+			_, ok := ParseRetryAfter(value, now)
+			assert.False(t, ok, value)
+		}
+	})
+}
@@ -0,0 +1,619 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/chunked"
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+)
+
+type StatusCode int
+
+const (
+	StatusContinue                    StatusCode = 100
+	StatusSwitchingProtocols          StatusCode = 101
+	StatusEarlyHints                  StatusCode = 103
+	StatusOK                          StatusCode = 200
+	StatusCreated                     StatusCode = 201
+	StatusNoContent                   StatusCode = 204
+	StatusPartialContent              StatusCode = 206
+	StatusMovedPermanently            StatusCode = 301
+	StatusFound                       StatusCode = 302
+	StatusSeeOther                    StatusCode = 303
+	StatusNotModified                 StatusCode = 304
+	StatusTemporaryRedirect           StatusCode = 307
+	StatusPermanentRedirect           StatusCode = 308
+	StatusBadRequest                  StatusCode = 400
+	StatusUnauthorized                StatusCode = 401
+	StatusForbidden                   StatusCode = 403
+	StatusNotFound                    StatusCode = 404
+	StatusMethodNotAllowed            StatusCode = 405
+	StatusRequestTimeout              StatusCode = 408
+	StatusContentTooLarge             StatusCode = 413
+	StatusRangeNotSatisfiable         StatusCode = 416
+	StatusMisdirectedRequest          StatusCode = 421
+	StatusUpgradeRequired             StatusCode = 426
+	StatusTooManyRequests             StatusCode = 429
+	StatusRequestHeaderFieldsTooLarge StatusCode = 431
+	StatusInternalServerError         StatusCode = 500
+	StatusNotImplemented              StatusCode = 501
+	StatusBadGateway                  StatusCode = 502
+	StatusServiceUnavailable          StatusCode = 503
+	StatusGatewayTimeout              StatusCode = 504
+	StatusHTTPVersionNotSupported     StatusCode = 505
+)
+
+var reasonPhrases = map[StatusCode]string{
+	StatusContinue:                    "Continue",
+	StatusSwitchingProtocols:          "Switching Protocols",
+	StatusEarlyHints:                  "Early Hints",
+	StatusOK:                          "OK",
+	StatusCreated:                     "Created",
+	StatusNoContent:                   "No Content",
+	StatusPartialContent:              "Partial Content",
+	StatusMovedPermanently:            "Moved Permanently",
+	StatusFound:                       "Found",
+	StatusSeeOther:                    "See Other",
+	StatusNotModified:                 "Not Modified",
+	StatusTemporaryRedirect:           "Temporary Redirect",
+	StatusPermanentRedirect:           "Permanent Redirect",
+	StatusBadRequest:                  "Bad Request",
+	StatusUnauthorized:                "Unauthorized",
+	StatusForbidden:                   "Forbidden",
+	StatusNotFound:                    "Not Found",
+	StatusMethodNotAllowed:            "Method Not Allowed",
+	StatusRequestTimeout:              "Request Timeout",
+	StatusContentTooLarge:             "Content Too Large",
+	StatusRangeNotSatisfiable:         "Range Not Satisfiable",
+	StatusMisdirectedRequest:          "Misdirected Request",
+	StatusUpgradeRequired:             "Upgrade Required",
+	StatusTooManyRequests:             "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	StatusInternalServerError:         "Internal Server Error",
+	StatusNotImplemented:              "Not Implemented",
+	StatusBadGateway:                  "Bad Gateway",
+	StatusServiceUnavailable:          "Service Unavailable",
+	StatusGatewayTimeout:              "Gateway Timeout",
+	StatusHTTPVersionNotSupported:     "HTTP Version Not Supported",
+}
+
+// ReasonPhrase returns the standard reason phrase for the code, or an empty
+// string for codes it does not know.
+func (s StatusCode) ReasonPhrase() string {
+	return reasonPhrases[s]
+}
+
+type writerState int
+
+const (
+	writerStateStatusLine writerState = iota
+	writerStateHeaders
+	writerStateBody
+	writerStateDone
+)
+
+var (
+	ErrWriterState   = fmt.Errorf("response parts written out of order")
+	ErrNotHijackable = fmt.Errorf("connection cannot be hijacked")
+	ErrInvalidReason = fmt.Errorf("invalid reason phrase")
+	ErrInvalidHeader = fmt.Errorf("invalid header field")
+)
+
+// GetDefaultHeaders returns the headers of a plain-text response with a body
+// of contentLen bytes on a connection that is closed afterwards.
+func GetDefaultHeaders(contentLen int) *headers.Headers {
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.Itoa(contentLen))
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/plain")
+	return h
+}
+
+// Redirect answers with a redirect of the given 3xx status to location and
+// an empty body. 301 and 302 let clients turn a POST into a GET; 307 and 308
+// keep the method.
+func Redirect(w *Writer, statusCode StatusCode, location string) error {
+	if err := headers.ValidateField("Location", location); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	h := GetDefaultHeaders(0)
+	h.Set("Location", location)
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	return w.WriteHeaders(*h)
+}
+
+// EscapeLocation percent-encodes the bytes of a target taken from a
+// request that may not appear in a URI as they are: controls, space and
+// bytes past ASCII. Escapes already present are kept. Passed through it, a
+// target the parser let through cannot make Redirect fail or end the
+// Location field and add one of its own.
+func EscapeLocation(target string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(target); i++ {
+		if c := target[i]; c <= ' ' || c >= 0x7f {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// EarlyHints sends a 103 Early Hints interim response with links as its
+// Link field, such as "</style.css>; rel=preload; as=style", so the client
+// can start fetching them while the final response is prepared (RFC 8297).
+// The final response should repeat the links that still apply, since
+// clients may ignore the hints. With no links nothing is sent.
+func EarlyHints(w *Writer, links ...string) error {
+	if len(links) == 0 {
+		return nil
+	}
+	h := headers.NewHeaders()
+	for _, link := range links {
+		h.Set("Link", link)
+	}
+	return w.WriteInterim(StatusEarlyHints, h)
+}
+
+// Writer writes a response to w one part at a time: the status line, the
+// headers, then the body, either as is or chunked. Writing parts out of order
+// fails with ErrWriterState.
+type Writer struct {
+	w          io.Writer
+	state      writerState
+	statusCode StatusCode
+	chunked    *chunked.Writer
+	hijacked   bool
+	buffered   *bufio.Reader
+	bodyBytes  int64
+	header     *headers.Headers
+	bodyCopy   io.Writer
+	// framing, recorded by WriteHeaders for KeepAlive.
+	closeConn     bool
+	chunkedBody   bool
+	contentLength int64
+	// err is the first write error; every write after it fails with it.
+	err      error
+	deadline time.Time
+	stall    time.Duration
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, header: headers.NewHeaders()}
+}
+
+// Header returns headers sent with the response on top of those passed to
+// WriteHeaders, which win when both set a field. Middleware uses it to add
+// fields to responses it does not write itself. Changes after WriteHeaders
+// have no effect.
+func (w *Writer) Header() *headers.Headers {
+	return w.header
+}
+
+// CopyBodyTo makes the Writer copy every body byte it sends to dst as well,
+// before chunk framing, for middleware that logs or inspects bodies without
+// holding the response back. Errors from dst are ignored. Calling it again
+// replaces dst; nil stops the copying.
+func (w *Writer) CopyBodyTo(dst io.Writer) {
+	w.bodyCopy = dst
+}
+
+// StatusCode returns the status written so far, or zero if the status line
+// has not been written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BodyBytes returns the number of body bytes written so far, not counting
+// chunk framing.
+func (w *Writer) BodyBytes() int64 {
+	return w.bodyBytes
+}
+
+// SetBufferedReader tells the Writer which reader the request came through,
+// so that Hijack can hand over bytes it read past the request, such as the
+// first frames of an upgraded protocol, along with the connection.
+func (w *Writer) SetBufferedReader(br *bufio.Reader) {
+	w.buffered = br
+}
+
+// Hijack hands the network connection under the Writer to the caller, who
+// then owns it and must close it. Whatever was written before, such as a 200
+// answering CONNECT, has already been sent; nothing more is written by the
+// Writer or the server. Bytes already read past the request are read from
+// the returned connection first. The connection's deadlines are cleared.
+// Hijack fails with ErrNotHijackable when the Writer does not write to a
+// net.Conn or the connection was already hijacked.
+func (w *Writer) Hijack() (net.Conn, error) {
+	conn, ok := w.w.(net.Conn)
+	if !ok || w.hijacked {
+		return nil, ErrNotHijackable
+	}
+	conn.SetDeadline(time.Time{})
+	w.hijacked = true
+	w.state = writerStateDone
+	if w.buffered != nil && w.buffered.Buffered() > 0 {
+		rest, _ := w.buffered.Peek(w.buffered.Buffered())
+		return &bufferedConn{Conn: conn, r: io.MultiReader(bytes.NewReader(bytes.Clone(rest)), conn)}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a hijacked connection that first returns the bytes read
+// ahead of the handler.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite half-closes the connection underneath, where it supports
+// that, so the wrapper does not hide it from callers such as a tunnel.
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// Hijacked reports whether Hijack has taken the connection.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineReason(statusCode, statusCode.ReasonPhrase())
+}
+
+// WriteStatusLineReason is WriteStatusLine with reason in place of the
+// standard reason phrase, as in "200 Connection Established". Clients
+// ignore it; a reason with control characters fails with ErrInvalidReason.
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
+	if w.state != writerStateStatusLine {
+		return fmt.Errorf("%w: status line already written", ErrWriterState)
+	}
+	if isInterim(statusCode) {
+		return fmt.Errorf("%w: %d is an interim status; use WriteInterim", ErrWriterState, statusCode)
+	}
+	for i := 0; i < len(reason); i++ {
+		if c := reason[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return fmt.Errorf("%w: %q", ErrInvalidReason, reason)
+		}
+	}
+
+	line := fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reason)
+	if _, err := w.write([]byte(line)); err != nil {
+		return err
+	}
+	w.statusCode = statusCode
+	w.state = writerStateHeaders
+	return nil
+}
+
+// WriteInterim sends an interim 1xx response with the fields in h, which
+// may be nil, ahead of the final response: 100 Continue, or 103 Early
+// Hints with Link fields, say. Any number may be sent, each straight away,
+// but only before WriteStatusLine; after it, or for a status outside 1xx,
+// WriteInterim fails with ErrWriterState. 101 is not interim here: it ends
+// HTTP on the connection and is written with WriteStatusLine. Fields from
+// Header are kept for the final response.
+func (w *Writer) WriteInterim(statusCode StatusCode, h *headers.Headers) error {
+	if w.state != writerStateStatusLine {
+		return fmt.Errorf("%w: interim response after the status line", ErrWriterState)
+	}
+	if !isInterim(statusCode) {
+		return fmt.Errorf("%w: %d is not an interim status", ErrWriterState, statusCode)
+	}
+	if err := checkFields(h); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s%s", statusCode, statusCode.ReasonPhrase(), CRLF)
+	if h != nil {
+		h.ForEach(func(key, value string) {
+			fmt.Fprintf(&b, "%s: %s%s", key, value, CRLF)
+		})
+	}
+	b.WriteString(CRLF)
+	_, err := w.write(b.Bytes())
+	return err
+}
+
+// isInterim reports whether statusCode is a 1xx that a final response
+// follows.
+func isInterim(statusCode StatusCode) bool {
+	return statusCode >= 100 && statusCode < 200 && statusCode != StatusSwitchingProtocols
+}
+
+// checkFields fails with ErrInvalidHeader on the first field of hs, any of
+// which may be nil, that cannot be written as it is: a name that is not a
+// token or a value with CR, LF or NUL, as a handler copying request data
+// into a field might otherwise use to add fields of its own.
+func checkFields(hs ...*headers.Headers) error {
+	var err error
+	for _, h := range hs {
+		if h == nil {
+			continue
+		}
+		h.ForEach(func(key, value string) {
+			if err == nil {
+				if e := headers.ValidateField(key, value); e != nil {
+					err = fmt.Errorf("%w: %s: %v", ErrInvalidHeader, key, e)
+				}
+			}
+		})
+	}
+	return err
+}
+
+// WriteHeaders writes the fields in h, and those of Header that h lacks,
+// after the status line. A field that cannot be written as it is fails
+// with ErrInvalidHeader, before anything is written.
+func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if w.state != writerStateHeaders {
+		return fmt.Errorf("%w: headers must follow the status line", ErrWriterState)
+	}
+	if err := checkFields(&h, w.header); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	h.ForEach(func(key, value string) {
+		fmt.Fprintf(&b, "%s: %s%s", key, value, CRLF)
+	})
+	w.header.ForEach(func(key, value string) {
+		if h.Get(key) == "" {
+			fmt.Fprintf(&b, "%s: %s%s", key, value, CRLF)
+		}
+	})
+	b.WriteString(CRLF)
+	if _, err := w.write(b.Bytes()); err != nil {
+		return err
+	}
+	w.recordFraming(h)
+	w.state = writerStateBody
+	return nil
+}
+
+// recordFraming notes how the headers in h, with w.header under them,
+// delimit the body and whether they close the connection.
+func (w *Writer) recordFraming(h headers.Headers) {
+	get := func(key string) string {
+		if v := h.Get(key); v != "" {
+			return v
+		}
+		return w.header.Get(key)
+	}
+	for _, token := range strings.Split(get("connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "close") {
+			w.closeConn = true
+		}
+	}
+	codings := strings.Split(get("transfer-encoding"), ",")
+	w.chunkedBody = strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+	w.contentLength = -1
+	if n, err := strconv.ParseInt(get("content-length"), 10, 64); err == nil && n >= 0 {
+		w.contentLength = n
+	}
+}
+
+// KeepAlive reports whether the connection can carry another response after
+// this one, the answer to a request with the given method: the headers did
+// not say Connection: close and the body ended where they said it would.
+// A body delimited only by closing the connection, a short or unfinished
+// body, a hijacked connection, a 101 switching it to another protocol or
+// missing headers all rule it out.
+func (w *Writer) KeepAlive(method string) bool {
+	if w.hijacked || w.state < writerStateBody || w.closeConn || w.statusCode == StatusSwitchingProtocols {
+		return false
+	}
+	if method == "HEAD" || w.statusCode < 200 || w.statusCode == StatusNoContent || w.statusCode == StatusNotModified {
+		return w.bodyBytes == 0
+	}
+	if w.chunkedBody {
+		return w.state == writerStateDone
+	}
+	return w.contentLength >= 0 && w.bodyBytes == w.contentLength
+}
+
+// WriteBody writes p unframed; the headers must have announced its length
+// with Content-Length.
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if w.state != writerStateBody || w.chunked != nil {
+		return 0, fmt.Errorf("%w: body must follow the headers", ErrWriterState)
+	}
+	n, err := w.write(p)
+	w.copyBody(p[:n])
+	return n, err
+}
+
+// WriteBodyFrom writes the body from r unframed until EOF, like WriteBody.
+// When the Writer writes straight to a connection implementing
+// io.ReaderFrom, such as a *net.TCPConn, and no CopyBodyTo writer is set,
+// the copy is left to it, which sends an *os.File, or an io.LimitedReader
+// over one, with sendfile instead of through a user-space buffer.
+func (w *Writer) WriteBodyFrom(r io.Reader) (int64, error) {
+	if w.state != writerStateBody || w.chunked != nil {
+		return 0, fmt.Errorf("%w: body must follow the headers", ErrWriterState)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if rf, ok := w.w.(io.ReaderFrom); ok && w.bodyCopy == nil {
+		n, err := w.readFrom(rf, r)
+		w.bodyBytes += n
+		return n, err
+	}
+	return io.Copy(bodyWriter{w}, r)
+}
+
+// stallPiece is how much of a WriteBodyFrom body goes out under one stall
+// deadline.
+const stallPiece = 256 * 1024
+
+// readFrom copies r with rf. Under a stall timeout it goes in pieces, each
+// with a fresh deadline, keeping a LimitedReader over a file in the shape
+// sendfile needs.
+func (w *Writer) readFrom(rf io.ReaderFrom, r io.Reader) (int64, error) {
+	if w.stall <= 0 {
+		n, err := rf.ReadFrom(r)
+		if err != nil {
+			w.err = err
+		}
+		return n, err
+	}
+
+	lr, ok := r.(*io.LimitedReader)
+	if !ok {
+		lr = &io.LimitedReader{R: r, N: math.MaxInt64}
+	}
+	var total int64
+	for lr.N > 0 {
+		w.setDeadline()
+		piece := &io.LimitedReader{R: lr.R, N: min(lr.N, stallPiece)}
+		n, err := rf.ReadFrom(piece)
+		total += n
+		lr.N -= n
+		if err != nil {
+			w.err = err
+			return total, err
+		}
+		if piece.N > 0 {
+			// r ran out before the piece did.
+			break
+		}
+	}
+	return total, nil
+}
+
+// SetWriteTimeout bounds writing the response on a network connection:
+// all of it by deadline, unless that is zero, and each write to the
+// connection by stall, unless that is zero, so that a client that stops
+// reading cannot hold the writer forever while one that keeps up can take
+// as long as the body needs. Once a write has failed, every later one fails
+// with the same error at once; Err returns it.
+func (w *Writer) SetWriteTimeout(deadline time.Time, stall time.Duration) {
+	w.deadline = deadline
+	w.stall = stall
+	w.setDeadline()
+}
+
+// Err returns the error of the first failed write, if any.
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// setDeadline sets the connection's write deadline for the next write.
+func (w *Writer) setDeadline() {
+	conn, ok := w.w.(net.Conn)
+	if !ok {
+		return
+	}
+	d := w.deadline
+	if w.stall > 0 {
+		if t := time.Now().Add(w.stall); d.IsZero() || t.Before(d) {
+			d = t
+		}
+	}
+	conn.SetWriteDeadline(d)
+}
+
+// write writes p to the underlying writer, failing fast after an error.
+func (w *Writer) write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.stall > 0 {
+		w.setDeadline()
+	}
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// connWriter is the io.Writer for the chunked writer, so that its writes get
+// the same deadlines and error handling as the Writer's own.
+type connWriter struct {
+	w *Writer
+}
+
+func (c connWriter) Write(p []byte) (int, error) {
+	return c.w.write(p)
+}
+
+// bodyWriter adapts a Writer to io.Writer for WriteBodyFrom. It hides any
+// io.ReaderFrom of the underlying writer from io.Copy.
+type bodyWriter struct {
+	w *Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.w.WriteBody(p)
+}
+
+// WriteChunkedBody writes p as one chunk of a body sent with
+// Transfer-Encoding: chunked. Empty writes are skipped, since an empty chunk
+// would end the body.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.state != writerStateBody {
+		return 0, fmt.Errorf("%w: body must follow the headers", ErrWriterState)
+	}
+	if w.chunked == nil {
+		w.chunked = chunked.NewWriter(connWriter{w})
+	}
+	n, err := w.chunked.Write(p)
+	w.copyBody(p[:n])
+	return n, err
+}
+
+// copyBody counts p as sent and hands it to the CopyBodyTo writer.
+func (w *Writer) copyBody(p []byte) {
+	w.bodyBytes += int64(len(p))
+	if w.bodyCopy != nil {
+		w.bodyCopy.Write(p)
+	}
+}
+
+// WriteChunkedBodyDone ends a chunked body without trailers.
+func (w *Writer) WriteChunkedBodyDone() error {
+	return w.WriteTrailers(nil)
+}
+
+// WriteTrailers ends a chunked body with the given trailer fields, which
+// may be nil.
+func (w *Writer) WriteTrailers(trailers *headers.Headers) error {
+	if w.state != writerStateBody {
+		return fmt.Errorf("%w: trailers must follow the headers", ErrWriterState)
+	}
+	if err := checkFields(trailers); err != nil {
+		return err
+	}
+	if w.chunked == nil {
+		w.chunked = chunked.NewWriter(connWriter{w})
+	}
+	if err := w.chunked.WriteTrailers(trailers); err != nil {
+		return err
+	}
+	w.state = writerStateDone
+	return nil
+}
@@ -0,0 +1,335 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	// Test: Fixed-length response round trips through the parser
+	t.Run("Fixed length", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+
+		require.NoError(t, w.WriteStatusLine(StatusNotFound))
+		require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(9)))
+		_, err := w.WriteBody([]byte("not found"))
+		require.NoError(t, err)
+		assert.Equal(t, StatusNotFound, w.StatusCode())
+
+		assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("HTTP/1.1 404 Not Found\r\n")))
+		r, err := ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, 404, r.StatusLine.StatusCode)
+		assert.Equal(t, "close", r.Headers.Get("connection"))
+		assert.Equal(t, "text/plain", r.Headers.Get("content-type"))
+		assert.Equal(t, "not found", string(r.Body))
+	})
+
+	// Test: Chunked response with trailers
+	t.Run("Chunked with trailers", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*headers.NewHeadersFromPairs("Transfer-Encoding", "chunked", "Trailer", "X-Sum")))
+		for _, part := range []string{"hello ", "", "world"} {
+			_, err := w.WriteChunkedBody([]byte(part))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.WriteTrailers(headers.NewHeadersFromPairs("X-Sum", "42")))
+
+		r, err := ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(r.Body))
+		assert.Equal(t, "42", r.Trailers.Get("x-sum"))
+	})
+
+	// Test: Unknown status codes get an empty reason phrase
+	t.Run("Unknown status code", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, NewWriter(&buf).WriteStatusLine(StatusCode(599)))
+		assert.Equal(t, "HTTP/1.1 599 \r\n", buf.String())
+	})
+
+	// Test: A custom reason phrase replaces the standard one
+	t.Run("Custom reason", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, NewWriter(&buf).WriteStatusLineReason(StatusOK, "Connection Established"))
+		assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", buf.String())
+
+		buf.Reset()
+		require.ErrorIs(t, NewWriter(&buf).WriteStatusLineReason(StatusOK, "OK\r\nX-Evil: 1"), ErrInvalidReason)
+		assert.Empty(t, buf.String())
+	})
+
+	// Test: Interim responses precede exactly one final response
+	t.Run("Interim responses", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.Header().Set("X-Final", "only")
+		require.NoError(t, w.WriteInterim(StatusContinue, nil))
+		require.NoError(t, w.WriteInterim(StatusEarlyHints, headers.NewHeadersFromPairs("Link", "</a.css>; rel=preload")))
+		require.NoError(t, w.WriteInterim(StatusEarlyHints, headers.NewHeadersFromPairs("Link", "</b.js>; rel=preload")))
+		assert.Zero(t, w.StatusCode())
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*headers.NewHeadersFromPairs("Content-Length", "2")))
+		_, err := w.WriteBody([]byte("ok"))
+		require.NoError(t, err)
+		assert.True(t, w.KeepAlive("GET"))
+
+		rr := NewReader(&buf)
+		var codes []int
+		for range 4 {
+			r, err := rr.ReadResponse(Options{})
+			require.NoError(t, err)
+			codes = append(codes, r.StatusLine.StatusCode)
+			if r.StatusLine.StatusCode == 200 {
+				assert.Equal(t, "only", r.Headers.Get("x-final"))
+				assert.Equal(t, "ok", string(r.Body))
+			} else {
+				assert.Empty(t, r.Headers.Get("x-final"))
+			}
+		}
+		assert.Equal(t, []int{100, 103, 103, 200}, codes)
+	})
+
+	// Test: EarlyHints sends the links in a 103
+	t.Run("Early hints", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.NoError(t, EarlyHints(w))
+		assert.Empty(t, buf.String())
+		require.NoError(t, EarlyHints(w, "</a.css>; rel=preload; as=style", "</b.js>; rel=preload; as=script"))
+		assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nlink: </a.css>; rel=preload; as=style, </b.js>; rel=preload; as=script\r\n\r\n", buf.String())
+	})
+
+	// Test: Interim statuses only go through WriteInterim, before the final one
+	t.Run("Interim misuse", func(t *testing.T) {
+		w := NewWriter(&bytes.Buffer{})
+		require.ErrorIs(t, w.WriteInterim(StatusOK, nil), ErrWriterState)
+		require.ErrorIs(t, w.WriteInterim(StatusSwitchingProtocols, nil), ErrWriterState)
+		require.ErrorIs(t, w.WriteStatusLine(StatusEarlyHints), ErrWriterState)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.ErrorIs(t, w.WriteInterim(StatusContinue, nil), ErrWriterState)
+	})
+
+	// Test: Parts out of order are rejected
+	t.Run("Out of order", func(t *testing.T) {
+		w := NewWriter(&bytes.Buffer{})
+		require.ErrorIs(t, w.WriteHeaders(*headers.NewHeaders()), ErrWriterState)
+		_, err := w.WriteBody([]byte("x"))
+		require.ErrorIs(t, err, ErrWriterState)
+
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrWriterState)
+		require.NoError(t, w.WriteHeaders(*headers.NewHeaders()))
+		require.NoError(t, w.WriteChunkedBodyDone())
+		_, err = w.WriteChunkedBody([]byte("late"))
+		require.ErrorIs(t, err, ErrWriterState)
+	})
+	// Test: Hijack hands over a net.Conn once
+	t.Run("Hijack", func(t *testing.T) {
+		server, peer := net.Pipe()
+		defer peer.Close()
+		w := NewWriter(server)
+
+		conn, err := w.Hijack()
+		require.NoError(t, err)
+		assert.Equal(t, server, conn)
+		assert.True(t, w.Hijacked())
+
+		_, err = w.Hijack()
+		assert.ErrorIs(t, err, ErrNotHijackable)
+		assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrWriterState)
+		conn.Close()
+	})
+
+	// Test: Only writers over a connection can be hijacked
+	t.Run("Not hijackable", func(t *testing.T) {
+		w := NewWriter(&bytes.Buffer{})
+		_, err := w.Hijack()
+		assert.ErrorIs(t, err, ErrNotHijackable)
+		assert.False(t, w.Hijacked())
+	})
+	// Test: Header adds fields without overriding WriteHeaders
+	t.Run("Extra headers", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.Header().Set("X-Request-ID", "abc")
+		w.Header().Set("Content-Type", "application/json")
+
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(0)))
+
+		resp, err := ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", resp.Headers.Get("X-Request-ID"))
+		assert.Equal(t, "text/plain", resp.Headers.Get("Content-Type"))
+	})
+
+	// Test: Fields that would end their line early or are not tokens are
+	// refused before anything is written
+	t.Run("Invalid headers", func(t *testing.T) {
+		for name, h := range map[string]*headers.Headers{
+			"CRLF in value": headers.NewHeadersFromPairs("X-Name", "a\r\nSet-Cookie: pwned=1"),
+			"LF in value":   headers.NewHeadersFromPairs("X-Name", "a\nSet-Cookie: pwned=1"),
+			"NUL in value":  headers.NewHeadersFromPairs("X-Name", "a\x00b"),
+			"Space in name": headers.NewHeadersFromPairs("X Name", "a"),
+			"Colon in name": headers.NewHeadersFromPairs("X-Name: a\r\nX-B", "b"),
+		} {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			require.NoError(t, w.WriteStatusLine(StatusOK))
+			buf.Reset()
+			assert.ErrorIs(t, w.WriteHeaders(*h), ErrInvalidHeader, name)
+			assert.Empty(t, buf.String(), name)
+
+			w = NewWriter(&buf)
+			h.ForEach(func(key, value string) { w.Header().Set(key, value) })
+			require.NoError(t, w.WriteStatusLine(StatusOK))
+			assert.ErrorIs(t, w.WriteHeaders(*GetDefaultHeaders(0)), ErrInvalidHeader, name)
+
+			assert.ErrorIs(t, NewWriter(&buf).WriteInterim(StatusEarlyHints, h), ErrInvalidHeader, name)
+		}
+
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*headers.NewHeadersFromPairs("Transfer-Encoding", "chunked")))
+		assert.ErrorIs(t, w.WriteTrailers(headers.NewHeadersFromPairs("X-Sum", "1\r\nX-Evil: 1")), ErrInvalidHeader)
+
+		buf.Reset()
+		assert.ErrorIs(t, Redirect(NewWriter(&buf), StatusFound, "/a\nSet-Cookie: pwned=1"), ErrInvalidHeader)
+		assert.Empty(t, buf.String())
+	})
+
+	// Test: Redirect sends the status and Location with an empty body
+	t.Run("Redirect", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Redirect(NewWriter(&buf), StatusPermanentRedirect, "/new?x=1"))
+
+		resp, err := ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, 308, resp.StatusLine.StatusCode)
+		assert.Equal(t, "Permanent Redirect", resp.StatusLine.ReasonPhrase)
+		assert.Equal(t, "/new?x=1", resp.Headers.Get("Location"))
+		assert.Empty(t, resp.Body)
+	})
+
+	// Test: CopyBodyTo sees the body without chunk framing
+	t.Run("Copy body", func(t *testing.T) {
+		var buf, copied bytes.Buffer
+		w := NewWriter(&buf)
+		w.CopyBodyTo(&copied)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*headers.NewHeadersFromPairs("Transfer-Encoding", "chunked")))
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		require.NoError(t, w.WriteChunkedBodyDone())
+
+		assert.Equal(t, "hello world", copied.String())
+		assert.Equal(t, int64(11), w.BodyBytes())
+	})
+	// Test: WriteBodyFrom hands the copy to the destination's ReadFrom, or
+	// copies through WriteBody when the body is also copied elsewhere
+	t.Run("Body from reader", func(t *testing.T) {
+		for _, copyBody := range []bool{false, true} {
+			var buf, copied bytes.Buffer
+			w := NewWriter(&buf)
+			if copyBody {
+				w.CopyBodyTo(&copied)
+			}
+			require.NoError(t, w.WriteStatusLine(StatusOK))
+			require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(11)))
+			n, err := w.WriteBodyFrom(strings.NewReader("hello world"))
+			require.NoError(t, err)
+			assert.Equal(t, int64(11), n)
+			assert.Equal(t, int64(11), w.BodyBytes())
+			assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhello world")))
+			if copyBody {
+				assert.Equal(t, "hello world", copied.String())
+			}
+		}
+
+		// Not after a chunked body has begun
+		w := NewWriter(io.Discard)
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(*headers.NewHeadersFromPairs("Transfer-Encoding", "chunked"))
+		w.WriteChunkedBody([]byte("x"))
+		_, err := w.WriteBodyFrom(strings.NewReader("y"))
+		assert.ErrorIs(t, err, ErrWriterState)
+	})
+	// Test: KeepAlive only for responses framed so another can follow
+	t.Run("Keep alive", func(t *testing.T) {
+		respond := func(method string, statusCode StatusCode, h *headers.Headers, body string, finish bool) bool {
+			w := NewWriter(io.Discard)
+			w.WriteStatusLine(statusCode)
+			w.WriteHeaders(*h)
+			if h.Get("Transfer-Encoding") != "" {
+				w.WriteChunkedBody([]byte(body))
+				if finish {
+					w.WriteChunkedBodyDone()
+				}
+			} else if body != "" {
+				w.WriteBody([]byte(body))
+			}
+			return w.KeepAlive(method)
+		}
+		length := func(n string) *headers.Headers { return headers.NewHeadersFromPairs("Content-Length", n) }
+
+		assert.True(t, respond("GET", StatusOK, length("2"), "ok", true))
+		assert.False(t, respond("GET", StatusOK, length("3"), "ok", true))
+		assert.False(t, respond("GET", StatusOK, GetDefaultHeaders(2), "ok", true))
+		assert.False(t, respond("GET", StatusOK, headers.NewHeaders(), "ok", true))
+		assert.True(t, respond("HEAD", StatusOK, length("10"), "", true))
+		assert.True(t, respond("GET", StatusNotModified, headers.NewHeaders(), "", true))
+		assert.False(t, respond("GET", StatusSwitchingProtocols, headers.NewHeadersFromPairs("Upgrade", "h2c"), "", true))
+		assert.True(t, respond("GET", StatusOK, headers.NewHeadersFromPairs("Transfer-Encoding", "chunked"), "ok", true))
+		assert.False(t, respond("GET", StatusOK, headers.NewHeadersFromPairs("Transfer-Encoding", "chunked"), "ok", false))
+
+		// Connection: close set through Header counts too
+		w := NewWriter(io.Discard)
+		w.Header().Set("Connection", "close")
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(*length("0"))
+		assert.False(t, w.KeepAlive("GET"))
+	})
+	// Test: After a failed write every later one fails at once
+	t.Run("Sticky error", func(t *testing.T) {
+		fw := &failingWriter{}
+		w := NewWriter(fw)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*headers.NewHeadersFromPairs("Transfer-Encoding", "chunked")))
+		fw.err = io.ErrClosedPipe
+		_, err := w.WriteChunkedBody([]byte("a"))
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+
+		fw.err = nil
+		_, err = w.WriteChunkedBody([]byte("b"))
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+		assert.ErrorIs(t, w.Err(), io.ErrClosedPipe)
+		assert.Equal(t, 3, fw.writes)
+	})
+}
+
+// failingWriter fails its writes with err when that is set.
+type failingWriter struct {
+	err    error
+	writes int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	if f.err != nil {
+		return 0, f.err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AbuseTracker bans client addresses that keep sending bad requests. The
+// server reports every request it cannot parse or that is too large; once
+// an address has Threshold of them within Window, its connections are
+// refused for Cooldown. Create one with NewAbuseTracker.
+type AbuseTracker struct {
+	Threshold int
+	Window    time.Duration
+	Cooldown  time.Duration
+	// Tarpit, if positive, holds a banned client's connections open this
+	// long before closing them, which slows down clients that retry at
+	// once. Zero closes them straight away.
+	Tarpit time.Duration
+	// OnBan, if set, is called with each new ban, for operators to log or
+	// export it. It must not call back into the tracker.
+	OnBan func(Ban)
+
+	mu        sync.Mutex
+	strikes   map[netip.Addr]strikeCount
+	bans      map[netip.Addr]time.Time
+	lastSweep time.Time
+}
+
+// Ban is an address refused until a point in time.
+type Ban struct {
+	Addr  netip.Addr
+	Until time.Time
+}
+
+// strikeCount is the bad requests from one address in the window that
+// began at since.
+type strikeCount struct {
+	n     int
+	since time.Time
+}
+
+// NewAbuseTracker returns a tracker banning an address for cooldown after
+// threshold bad requests within window.
+func NewAbuseTracker(threshold int, window, cooldown time.Duration) *AbuseTracker {
+	return &AbuseTracker{
+		Threshold: threshold,
+		Window:    window,
+		Cooldown:  cooldown,
+		strikes:   map[netip.Addr]strikeCount{},
+		bans:      map[netip.Addr]time.Time{},
+	}
+}
+
+// Report counts a bad request from addr, banning it when that reaches the
+// threshold. Addresses that are not IP addresses are ignored.
+func (a *AbuseTracker) Report(addr net.Addr) {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return
+	}
+	now := time.Now()
+
+	a.mu.Lock()
+	a.sweep(now)
+	sc := a.strikes[ip]
+	if now.Sub(sc.since) > a.Window {
+		sc = strikeCount{since: now}
+	}
+	sc.n++
+	if sc.n < a.Threshold {
+		a.strikes[ip] = sc
+		a.mu.Unlock()
+		return
+	}
+	delete(a.strikes, ip)
+	ban := Ban{Addr: ip, Until: now.Add(a.Cooldown)}
+	a.bans[ip] = ban.Until
+	a.mu.Unlock()
+
+	if a.OnBan != nil {
+		a.OnBan(ban)
+	}
+}
+
+// Banned reports whether connections from addr are to be refused.
+func (a *AbuseTracker) Banned(addr net.Addr) bool {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	until, ok := a.bans[ip]
+	return ok && time.Now().Before(until)
+}
+
+// Bans returns the bans in force, soonest to expire first.
+func (a *AbuseTracker) Bans() []Ban {
+	now := time.Now()
+	a.mu.Lock()
+	var bans []Ban
+	for ip, until := range a.bans {
+		if now.Before(until) {
+			bans = append(bans, Ban{Addr: ip, Until: until})
+		}
+	}
+	a.mu.Unlock()
+
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// Unban lifts any ban on ip and forgets its bad requests.
+func (a *AbuseTracker) Unban(ip netip.Addr) {
+	ip = ip.Unmap()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.bans, ip)
+	delete(a.strikes, ip)
+}
+
+// sweep drops expired bans and stale counts, at most once per window, so
+// that addresses seen once do not pile up.
+func (a *AbuseTracker) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < a.Window {
+		return
+	}
+	a.lastSweep = now
+	for ip, until := range a.bans {
+		if !now.Before(until) {
+			delete(a.bans, ip)
+		}
+	}
+	for ip, sc := range a.strikes {
+		if now.Sub(sc.since) > a.Window {
+			delete(a.strikes, ip)
+		}
+	}
+}
+
+// tarpit holds conn open for d before closing it.
+func tarpit(conn net.Conn, d time.Duration) {
+	time.Sleep(d)
+	conn.Close()
+}
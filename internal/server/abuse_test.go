@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbuseTracker(t *testing.T) {
+	tcp := func(addr string) net.Addr {
+		return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))
+	}
+
+	// Test: An address is banned at the threshold, and only that address
+	t.Run("Threshold", func(t *testing.T) {
+		a := NewAbuseTracker(3, time.Minute, time.Minute)
+		var bans []Ban
+		a.OnBan = func(b Ban) { bans = append(bans, b) }
+
+		a.Report(tcp("10.0.0.1:1000"))
+		a.Report(tcp("10.0.0.1:1001"))
+		assert.False(t, a.Banned(tcp("10.0.0.1:1002")))
+		a.Report(tcp("10.0.0.1:1002"))
+		assert.True(t, a.Banned(tcp("10.0.0.1:2000")))
+		assert.True(t, a.Banned(tcp("[::ffff:10.0.0.1]:2000")))
+		assert.False(t, a.Banned(tcp("10.0.0.2:2000")))
+
+		require.Len(t, bans, 1)
+		assert.Equal(t, netip.MustParseAddr("10.0.0.1"), bans[0].Addr)
+		assert.Equal(t, bans, a.Bans())
+	})
+
+	// Test: Bad requests spread wider than the window do not add up
+	t.Run("Window", func(t *testing.T) {
+		a := NewAbuseTracker(2, 20*time.Millisecond, time.Minute)
+		a.Report(tcp("10.0.0.1:1000"))
+		time.Sleep(30 * time.Millisecond)
+		a.Report(tcp("10.0.0.1:1000"))
+		assert.False(t, a.Banned(tcp("10.0.0.1:1000")))
+	})
+
+	// Test: Bans expire after the cooldown, or when lifted
+	t.Run("Cooldown and Unban", func(t *testing.T) {
+		a := NewAbuseTracker(1, time.Minute, 20*time.Millisecond)
+		a.Report(tcp("10.0.0.1:1000"))
+		assert.True(t, a.Banned(tcp("10.0.0.1:1000")))
+		time.Sleep(30 * time.Millisecond)
+		assert.False(t, a.Banned(tcp("10.0.0.1:1000")))
+		assert.Empty(t, a.Bans())
+
+		a.Cooldown = time.Minute
+		a.Report(tcp("10.0.0.1:1000"))
+		a.Unban(netip.MustParseAddr("10.0.0.1"))
+		assert.False(t, a.Banned(tcp("10.0.0.1:1000")))
+	})
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// MethodLister is implemented by handlers that know every method they
+// serve, such as Router. The server answers OPTIONS * from it; handlers
+// never see that request. Middleware wrapped around a MethodLister hides
+// its methods.
+type MethodLister interface {
+	Methods() []string
+}
+
+// serveAsterisk answers OPTIONS *, which the parser only accepts with
+// OPTIONS (RFC 9112 section 3.2.4). It asks about the server as a whole
+// rather than any resource, so it is answered here: 200 with Allow
+// listing the methods the server handles, from the handler if it is a
+// MethodLister, plus OPTIONS and, when enabled, TRACE.
+func (s *Server) serveAsterisk(w *response.Writer) {
+	methods := []string{"OPTIONS"}
+	if s.opts.EnableTrace {
+		methods = append(methods, "TRACE")
+	}
+	if ml, ok := s.handler.(MethodLister); ok {
+		methods = append(methods, ml.Methods()...)
+	}
+	slices.Sort(methods)
+	methods = slices.Compact(methods)
+
+	h := response.GetDefaultHeaders(0)
+	h.Delete("Content-Type")
+	h.Set("Allow", strings.Join(methods, ", "))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsterisk(t *testing.T) {
+	r := NewRouter()
+	r.GET("/", reply(func(*request.Request) string { return "home" }))
+	r.POST("/items", reply(func(*request.Request) string { return "created" }))
+	r.DELETE("/items/:id", reply(func(*request.Request) string { return "deleted" }))
+
+	start := func(h Handler, opts Options) string {
+		s, err := ServeWithOptions("127.0.0.1:0", h, opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s.Addr().String()
+	}
+
+	// Test: OPTIONS * lists the router's methods
+	t.Run("Router", func(t *testing.T) {
+		resp, err := liveResponse(start(r, Options{}), "OPTIONS * HTTP/1.1\r\nHost: x\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "DELETE, GET, OPTIONS, POST", resp.Headers.Get("allow"))
+		assert.Equal(t, "0", resp.Headers.Get("content-length"))
+		assert.Empty(t, resp.Body)
+	})
+
+	// Test: TRACE is listed when the server answers it
+	t.Run("Trace", func(t *testing.T) {
+		resp, err := liveResponse(start(r, Options{EnableTrace: true}), "OPTIONS * HTTP/1.1\r\nHost: x\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, "DELETE, GET, OPTIONS, POST, TRACE", resp.Headers.Get("allow"))
+	})
+
+	// Test: Handlers that cannot list methods never see OPTIONS *
+	t.Run("Other handler", func(t *testing.T) {
+		h := HandlerFunc(func(w *response.Writer, req *request.Request) {
+			writeError(w, response.StatusNotFound, "handler saw "+req.RequestLine.RequestTarget)
+		})
+		resp, err := liveResponse(start(h, Options{}), "OPTIONS * HTTP/1.1\r\nHost: x\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "OPTIONS", resp.Headers.Get("allow"))
+	})
+
+	// Test: Other methods may not ask for * and get 400
+	t.Run("Other method", func(t *testing.T) {
+		resp, err := liveResponse(start(r, Options{}), "GET * HTTP/1.1\r\nHost: x\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusLine.StatusCode)
+	})
+}
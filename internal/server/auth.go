@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+type userKey struct{}
+
+// BasicAuth is middleware that requires HTTP Basic credentials (RFC 7617)
+// accepted by check. Requests without them get 401 with a WWW-Authenticate
+// challenge for realm; the rest reach next with the user name on their
+// context, see UserFromContext.
+func BasicAuth(realm string, check func(user, password string) bool) Middleware {
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			user, password, ok := parseBasicAuth(req.Headers.Get("Authorization"))
+			if !ok || !check(user, password) {
+				body := []byte("unauthorized\n")
+				h := response.GetDefaultHeaders(len(body))
+				h.Set("WWW-Authenticate", challenge)
+				w.WriteStatusLine(response.StatusUnauthorized)
+				w.WriteHeaders(*h)
+				w.WriteBody(body)
+				return
+			}
+
+			ctx := context.WithValue(req.Context(), userKey{}, user)
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+}
+
+// StaticCredentials returns a BasicAuth check accepting the user names and
+// passwords in users. Passwords are compared in constant time.
+func StaticCredentials(users map[string]string) func(user, password string) bool {
+	hashes := make(map[string][32]byte, len(users))
+	for user, password := range users {
+		hashes[user] = sha256.Sum256([]byte(password))
+	}
+	return func(user, password string) bool {
+		want, ok := hashes[user]
+		// Hash even for unknown users so they take as long as wrong passwords.
+		got := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(got[:], want[:]) == 1 && ok
+	}
+}
+
+// UserFromContext returns the user name BasicAuth, or the certificate
+// identity ClientCert, stored in ctx, or "".
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// parseBasicAuth decodes an "Authorization: Basic ..." header value.
+func parseBasicAuth(value string) (user, password string, ok bool) {
+	scheme, encoded, found := strings.Cut(value, " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBasicAuth(t *testing.T) {
+	h := BasicAuth("admin area", StaticCredentials(map[string]string{"alice": "s3cret:x"}))(
+		reply(func(req *request.Request) string { return "hello " + UserFromContext(req.Context()) }))
+	withAuth := func(value string) string {
+		return "GET / HTTP/1.1\r\nHost: x\r\nAuthorization: " + value + "\r\n\r\n"
+	}
+	basic := func(credentials string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	// Test: Missing credentials get a challenge
+	t.Run("Missing", func(t *testing.T) {
+		resp := serve(t, h, get("/"))
+		assert.Equal(t, 401, resp.StatusLine.StatusCode)
+		assert.Equal(t, `Basic realm="admin area", charset="UTF-8"`, resp.Headers.Get("WWW-Authenticate"))
+	})
+
+	// Test: Valid credentials reach the handler with the user name
+	t.Run("Valid", func(t *testing.T) {
+		resp := serve(t, h, withAuth(basic("alice:s3cret:x")))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "hello alice", string(resp.Body))
+	})
+
+	// Test: The scheme name is case-insensitive
+	t.Run("Scheme case", func(t *testing.T) {
+		resp := serve(t, h, withAuth("basic "+base64.StdEncoding.EncodeToString([]byte("alice:s3cret:x"))))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+	})
+
+	// Test: Wrong passwords, unknown users and garbage are refused
+	t.Run("Refused", func(t *testing.T) {
+		for _, value := range []string{basic("alice:wrong"), basic("bob:s3cret:x"), basic("alice"), "Basic !!!", "Bearer abc"} {
+			resp := serve(t, h, withAuth(value))
+			assert.Equal(t, 401, resp.StatusLine.StatusCode, value)
+			assert.NotEmpty(t, resp.Headers.Get("WWW-Authenticate"))
+		}
+	})
+
+	// Test: A callback decides instead of a static map
+	t.Run("Callback", func(t *testing.T) {
+		h := BasicAuth("x", func(user, password string) bool { return user == password })(
+			reply(func(req *request.Request) string { return UserFromContext(req.Context()) }))
+		resp := serve(t, h, withAuth(basic("bob:bob")))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "bob", string(resp.Body))
+	})
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// redacted replaces secret header and field values in body logs.
+const redacted = "[REDACTED]"
+
+// DefaultRedactHeaders are the request headers BodyLog hides unless told
+// otherwise.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// BodyLogOptions configures BodyLog. The zero value logs up to 4 KiB of each
+// body and hides DefaultRedactHeaders.
+type BodyLogOptions struct {
+	// MaxBodyBytes caps the bytes of each body logged. Zero means 4 KiB.
+	MaxBodyBytes int
+	// RedactHeaders names request headers whose values are hidden. Nil means
+	// DefaultRedactHeaders; an empty non-nil slice hides none.
+	RedactHeaders []string
+	// RedactFields names fields of JSON and form-encoded bodies whose values
+	// are hidden, at any depth, such as "password" or "token". Matching
+	// ignores case. A body that has to be redacted but cannot be parsed, or
+	// is longer than MaxBodyBytes, is left out of the log rather than risk
+	// showing a secret.
+	RedactFields []string
+}
+
+// BodyLog is debug middleware that writes each request, with its headers
+// and body, and the status and body of its response to out. Bodies are
+// logged up to a size cap and secrets are redacted as opts says. The
+// response is streamed as usual; only a copy of its first bytes is kept.
+// Bodies can hold personal data even after redaction, so keep this for
+// troubleshooting.
+func BodyLog(out io.Writer, opts BodyLogOptions) Middleware {
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = 4096
+	}
+	if opts.RedactHeaders == nil {
+		opts.RedactHeaders = DefaultRedactHeaders
+	}
+	headers := map[string]bool{}
+	for _, name := range opts.RedactHeaders {
+		headers[strings.ToLower(name)] = true
+	}
+	fields := map[string]bool{}
+	for _, name := range opts.RedactFields {
+		fields[strings.ToLower(name)] = true
+	}
+
+	var mu sync.Mutex
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			// Keep one byte past the cap to tell a full body from a cut one.
+			captured := &capBuffer{max: opts.MaxBodyBytes + 1}
+			w.CopyBodyTo(captured)
+			defer func() {
+				w.CopyBodyTo(nil)
+
+				var b strings.Builder
+				fmt.Fprintf(&b, "--> %s %s HTTP/%s\n", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion)
+				var lines []string
+				req.Headers.ForEach(func(key, value string) {
+					if headers[key] {
+						value = redacted
+					}
+					lines = append(lines, key+": "+value)
+				})
+				sort.Strings(lines)
+				for _, line := range lines {
+					b.WriteString(line + "\n")
+				}
+				b.WriteString(formatBody(req.Body, req.Headers.Get("Content-Type"), len(req.Body), opts.MaxBodyBytes, fields))
+				fmt.Fprintf(&b, "<-- %d (%d bytes)\n", w.StatusCode(), w.BodyBytes())
+				// The response headers are gone once written; fall back on
+				// sniffing to recognise JSON.
+				b.WriteString(formatBody(captured.Bytes(), "", int(w.BodyBytes()), opts.MaxBodyBytes, fields))
+
+				mu.Lock()
+				defer mu.Unlock()
+				io.WriteString(out, b.String())
+			}()
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// capBuffer keeps the first max bytes written to it and drops the rest.
+type capBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (c *capBuffer) Write(p []byte) (int, error) {
+	if room := c.max - c.Len(); room > 0 {
+		c.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// formatBody renders the logged part of a body of total bytes, of which
+// body holds the first, redacting fields when there are any.
+func formatBody(body []byte, contentType string, total, max int, fields map[string]bool) string {
+	if total == 0 {
+		return ""
+	}
+	truncated := total > max
+	if truncated {
+		body = body[:min(max, len(body))]
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+		(mediaType == "" && looksLikeJSON(body))
+	isForm := mediaType == "application/x-www-form-urlencoded"
+
+	if len(fields) > 0 && (isJSON || isForm) {
+		if truncated {
+			return fmt.Sprintf("(%d bytes not shown: too long to redact)\n", total)
+		}
+		var ok bool
+		if isJSON {
+			body, ok = redactJSON(body, fields)
+		} else {
+			body, ok = redactForm(body, fields)
+		}
+		if !ok {
+			return fmt.Sprintf("(%d bytes not shown: cannot be parsed for redaction)\n", total)
+		}
+	}
+	if !utf8.Valid(body) {
+		return fmt.Sprintf("(%d bytes of binary data)\n", total)
+	}
+
+	s := string(body)
+	if truncated {
+		s += fmt.Sprintf("... (%d more bytes)", total-max)
+	}
+	return s + "\n"
+}
+
+// looksLikeJSON sniffs a body of unknown type, possibly cut short.
+func looksLikeJSON(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return len(body) > 0 && (body[0] == '{' || body[0] == '[')
+}
+
+func redactJSON(body []byte, fields map[string]bool) ([]byte, bool) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	out, err := json.Marshal(redactValue(v, fields))
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func redactValue(v any, fields map[string]bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if fields[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(value, fields)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactValue(value, fields)
+		}
+	}
+	return v
+}
+
+func redactForm(body []byte, fields map[string]bool) ([]byte, bool) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, false
+	}
+	for key, values := range form {
+		if fields[strings.ToLower(key)] {
+			for i := range values {
+				values[i] = redacted
+			}
+		}
+	}
+	return []byte(form.Encode()), true
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLog(t *testing.T) {
+	respond := func(body string) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			hdrs := response.GetDefaultHeaders(len(body))
+			hdrs.Replace("Content-Type", "application/json")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*hdrs)
+			w.WriteBody([]byte(body))
+		})
+	}
+	post := func(contentType, body string) string {
+		return fmt.Sprintf("POST /login HTTP/1.1\r\nHost: x\r\nAuthorization: Bearer abc\r\nCookie: s=1\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)
+	}
+
+	// Test: Headers and JSON fields are redacted in both directions
+	t.Run("Redaction", func(t *testing.T) {
+		var out bytes.Buffer
+		h := BodyLog(&out, BodyLogOptions{RedactFields: []string{"Password", "token"}})(respond(`{"token":"t0p","user":{"id":1}}`))
+		resp := serve(t, h, post("application/json", `{"user":"ann","password":"hunter2","nested":[{"token":"x"}]}`))
+
+		assert.Equal(t, `{"token":"t0p","user":{"id":1}}`, string(resp.Body), "the client gets the real body")
+		log := out.String()
+		assert.Contains(t, log, "--> POST /login HTTP/1.1\n")
+		assert.Contains(t, log, "authorization: [REDACTED]\n")
+		assert.Contains(t, log, "cookie: [REDACTED]\n")
+		assert.Contains(t, log, `"user":"ann"`)
+		assert.Contains(t, log, "<-- 200 (31 bytes)\n")
+		assert.Contains(t, log, `"user":{"id":1}`)
+		for _, secret := range []string{"Bearer abc", "s=1", "hunter2", `"x"`, "t0p"} {
+			assert.NotContains(t, log, secret)
+		}
+	})
+
+	// Test: Form fields are redacted
+	t.Run("Form", func(t *testing.T) {
+		var out bytes.Buffer
+		h := BodyLog(&out, BodyLogOptions{RedactFields: []string{"password"}})(respond(""))
+		serve(t, h, post("application/x-www-form-urlencoded", "user=ann&password=hunter2"))
+		assert.Contains(t, out.String(), "password=%5BREDACTED%5D&user=ann\n")
+	})
+
+	// Test: Long bodies are cut, unless they need redacting
+	t.Run("Size cap", func(t *testing.T) {
+		var out bytes.Buffer
+		h := BodyLog(&out, BodyLogOptions{MaxBodyBytes: 10})(respond(`{"a":"` + strings.Repeat("b", 20) + `"}`))
+		serve(t, h, post("text/plain", strings.Repeat("x", 25)))
+		assert.Contains(t, out.String(), "xxxxxxxxxx... (15 more bytes)\n")
+		assert.Contains(t, out.String(), `{"a":"bbbb... (18 more bytes)`)
+
+		out.Reset()
+		h = BodyLog(&out, BodyLogOptions{MaxBodyBytes: 10, RedactFields: []string{"a"}})(respond(`{"a":"` + strings.Repeat("b", 20) + `"}`))
+		serve(t, h, post("text/plain", "short"))
+		assert.Contains(t, out.String(), "short\n")
+		assert.Contains(t, out.String(), "(28 bytes not shown: too long to redact)\n")
+		assert.NotContains(t, out.String(), "bbb")
+	})
+
+	// Test: Binary bodies are summarised
+	t.Run("Binary", func(t *testing.T) {
+		var out bytes.Buffer
+		h := BodyLog(&out, BodyLogOptions{RedactHeaders: []string{}})(respond(""))
+		serve(t, h, post("application/octet-stream", "\xff\xfe\x00"))
+		assert.Contains(t, out.String(), "(3 bytes of binary data)\n")
+		assert.Contains(t, out.String(), "authorization: Bearer abc\n", "redaction turned off")
+	})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// ClientCert is middleware that authorizes requests by their verified TLS
+// client certificate. identify maps the chain, leaf first, to the identity
+// it stands for and whether that identity may make req; allowed requests
+// reach next with the identity on their context, see UserFromContext.
+// Requests without a verified certificate, which needs a TLSConfig with
+// ClientCAs and a ClientAuth that verifies, and those identify refuses get
+// 403.
+func ClientCert(identify func(chain []*x509.Certificate, req *request.Request) (string, bool)) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			chain := req.VerifiedChain()
+			if chain == nil {
+				writeError(w, response.StatusForbidden, "client certificate required")
+				return
+			}
+			identity, ok := identify(chain, req)
+			if !ok {
+				writeError(w, response.StatusForbidden, "client certificate not authorized")
+				return
+			}
+
+			ctx := context.WithValue(req.Context(), userKey{}, identity)
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+}
+
+// AllowCommonNames returns a ClientCert identify function accepting leaf
+// certificates whose subject common name is one of names, which becomes the
+// identity.
+func AllowCommonNames(names ...string) func(chain []*x509.Certificate, req *request.Request) (string, bool) {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	return func(chain []*x509.Certificate, _ *request.Request) (string, bool) {
+		cn := chain[0].Subject.CommonName
+		return cn, allowed[cn]
+	}
+}
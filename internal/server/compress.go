@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// Encoder starts a content coding of what is written to the returned
+// writer into w at level. Closing the writer ends the coding; it must not
+// close w. Level -1 means the encoder's default.
+type Encoder func(w io.Writer, level int) (io.WriteCloser, error)
+
+// builtinEncoders are the codings Compress always knows. The deflate
+// content coding is the zlib format (RFC 9110 section 8.4.1.2).
+var builtinEncoders = map[string]Encoder{
+	"gzip": func(w io.Writer, level int) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	},
+	"deflate": func(w io.Writer, level int) (io.WriteCloser, error) {
+		return zlib.NewWriterLevel(w, level)
+	},
+}
+
+// CompressOptions configures Compress. The zero value offers gzip, then
+// deflate, at their default levels for bodies of 1 KiB and up.
+type CompressOptions struct {
+	// Encodings lists the codings offered, in the server's order of
+	// preference, which breaks ties between q-values of the client's. Each
+	// must be gzip, deflate or a key of Encoders. Nil means gzip and
+	// deflate.
+	Encodings []string
+	// Levels sets the compression level of each coding, such as
+	// {"gzip": gzip.BestSpeed}. Codings left out get -1, their default.
+	Levels map[string]int
+	// Encoders adds codings beyond gzip and deflate, keyed by their name.
+	// Brotli, for one, is not in the standard library; with a pure-Go
+	// encoder it is plugged in as "br" and listed in Encodings.
+	Encoders map[string]Encoder
+	// MinSize is the smallest body compressed; smaller ones cost more to
+	// code than they save. Zero means 1 KiB.
+	MinSize int
+}
+
+// Compress is middleware that codes response bodies with the content
+// coding the request prefers by its Accept-Encoding q-values (RFC 9110
+// section 12.5.3), among those opts offers. The identity coding is sent
+// when the client accepts none of them better, and for bodies under
+// MinSize, responses other than 2xx with content, media that is
+// compressed already and responses the handler coded itself or marked
+// no-transform. Vary gets Accept-Encoding either way, and a strong ETag
+// turns weak on a coded response, which no longer has the same bytes.
+//
+// The response is buffered, as with Cacheable, so handlers that stream
+// without end or hijack the connection should not be wrapped, and
+// trailers are dropped. Compress panics if Encodings names a coding it
+// has no encoder for.
+func Compress(opts CompressOptions) Middleware {
+	if opts.Encodings == nil {
+		opts.Encodings = []string{"gzip", "deflate"}
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1024
+	}
+	encoders := map[string]Encoder{}
+	for _, name := range opts.Encodings {
+		enc := opts.Encoders[name]
+		if enc == nil {
+			enc = builtinEncoders[name]
+		}
+		if enc == nil {
+			panic(fmt.Sprintf("compress: no encoder for %q", name))
+		}
+		encoders[name] = enc
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			method := req.RequestLine.Method
+			var buf bytes.Buffer
+			inner := response.NewWriter(&buf)
+			w.Header().ForEach(func(key, value string) {
+				inner.Header().Set(key, value)
+			})
+			// HEAD runs as GET so that its headers tell the coded length.
+			next.ServeHTTP(inner, headAsGET(req))
+			if inner.StatusCode() == 0 {
+				return
+			}
+
+			resp, err := response.ResponseFromReader(&buf)
+			if err != nil {
+				writeError(w, response.StatusInternalServerError, "internal server error")
+				return
+			}
+
+			h := resp.Headers.Clone()
+			h.Delete("Transfer-Encoding")
+			body := resp.Body
+			status := resp.StatusLine.StatusCode
+			if status >= 200 && status < 300 && status != 204 && status != 206 {
+				addVary(h, "Accept-Encoding")
+				if coding := chooseCoding(req, opts.Encodings); coding != "" && compressible(h, len(body), opts.MinSize) {
+					level, ok := opts.Levels[coding]
+					if !ok {
+						level = -1
+					}
+					coded, err := encode(encoders[coding], level, body)
+					if err != nil {
+						writeError(w, response.StatusInternalServerError, "internal server error")
+						return
+					}
+					body = coded
+					h.Set("Content-Encoding", coding)
+					if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+						h.Replace("ETag", "W/"+etag)
+					}
+				}
+			}
+			if status != 204 && status != 304 {
+				h.Replace("Content-Length", strconv.Itoa(len(body)))
+			}
+
+			writeStatusLine(w, resp)
+			w.WriteHeaders(*h)
+			if method != "HEAD" {
+				w.WriteBody(body)
+			}
+		})
+	}
+}
+
+// chooseCoding returns the coding of offers that req accepts with the
+// highest q-value, the earliest on a tie, or "" when the identity coding
+// does as well as any.
+func chooseCoding(req *request.Request, offers []string) string {
+	value, present := requestField(req, "Accept-Encoding")
+	if !present {
+		// No preference at all; coding anyway could reach a client that
+		// cannot decode it.
+		return ""
+	}
+	ranges := parseAccept(value)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(ranges, offer, matchEncoding, true); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if bestQ < acceptQuality(ranges, "identity", matchEncoding, true) {
+		return ""
+	}
+	return best
+}
+
+// compressible reports whether a response with headers h and a body of
+// size bytes is worth coding and may be.
+func compressible(h *headers.Headers, size, minSize int) bool {
+	if size < minSize || h.Get("Content-Encoding") != "" || hasToken(h.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(strings.ToLower(h.Get("Content-Type")), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	switch {
+	case mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"):
+		return false
+	}
+	switch mediaType {
+	case "application/gzip", "application/zip", "application/zstd", "application/x-7z-compressed", "application/x-bzip2", "application/x-xz":
+		return false
+	}
+	return true
+}
+
+// encode codes body with enc at level.
+func encode(enc Encoder, level int, body []byte) ([]byte, error) {
+	var b bytes.Buffer
+	cw, err := enc(&b, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
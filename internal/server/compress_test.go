@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat("compress me ", 200)
+	h := Compress(CompressOptions{})(reply(func(*request.Request) string { return text }))
+	withEncoding := func(method, value string) string {
+		return method + " / HTTP/1.1\r\nHost: x\r\nAccept-Encoding: " + value + "\r\n\r\n"
+	}
+	decode := func(t *testing.T, resp *response.Response) string {
+		t.Helper()
+		var r io.Reader
+		var err error
+		switch resp.Headers.Get("Content-Encoding") {
+		case "gzip":
+			r, err = gzip.NewReader(bytes.NewReader(resp.Body))
+		case "deflate":
+			r, err = zlib.NewReader(bytes.NewReader(resp.Body))
+		default:
+			return string(resp.Body)
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	// Test: The coding with the highest q-value is chosen, the server's
+	// order breaking ties
+	t.Run("Choice", func(t *testing.T) {
+		for value, want := range map[string]string{
+			"gzip, deflate, br":       "gzip",
+			"deflate, gzip":           "gzip",
+			"gzip;q=0.5, deflate":     "deflate",
+			"*":                       "gzip",
+			"*;q=0.5, gzip;q=0":       "deflate",
+			"br":                      "",
+			"":                        "",
+			"gzip;q=0.5":              "",
+			"identity;q=0, gzip;q=.2": "gzip",
+		} {
+			resp := serve(t, h, withEncoding("GET", value))
+			assert.Equal(t, 200, resp.StatusLine.StatusCode, value)
+			assert.Equal(t, want, resp.Headers.Get("Content-Encoding"), value)
+			assert.Equal(t, strconv.Itoa(len(resp.Body)), resp.Headers.Get("Content-Length"), value)
+			assert.Equal(t, "Accept-Encoding", resp.Headers.Get("Vary"), value)
+			assert.Equal(t, text, decode(t, resp), value)
+		}
+	})
+
+	// Test: Without Accept-Encoding the body is sent as is
+	t.Run("No preference", func(t *testing.T) {
+		resp := serve(t, h, get("/"))
+		assert.Empty(t, resp.Headers.Get("Content-Encoding"))
+		assert.Equal(t, text, string(resp.Body))
+	})
+
+	// Test: Small bodies, compressed media, coded and no-transform
+	// responses and errors are left alone
+	t.Run("Skipped", func(t *testing.T) {
+		for name, hdrs := range map[string]*headers.Headers{
+			"small":        headers.NewHeadersFromPairs("X-Body", "short"),
+			"image":        headers.NewHeadersFromPairs("Content-Type", "image/png"),
+			"coded":        headers.NewHeadersFromPairs("Content-Encoding", "gzip"),
+			"no-transform": headers.NewHeadersFromPairs("Cache-Control", "public, no-transform"),
+			"error":        headers.NewHeadersFromPairs("X-Status", "404"),
+		} {
+			h := Compress(CompressOptions{})(HandlerFunc(func(w *response.Writer, req *request.Request) {
+				body := text
+				if b := hdrs.Get("X-Body"); b != "" {
+					body = b
+				}
+				status := response.StatusOK
+				if hdrs.Get("X-Status") != "" {
+					status = response.StatusNotFound
+				}
+				out := response.GetDefaultHeaders(len(body))
+				hdrs.ForEach(func(key, value string) { out.Replace(key, value) })
+				w.WriteStatusLine(status)
+				w.WriteHeaders(*out)
+				w.WriteBody([]byte(body))
+			}))
+			resp := serve(t, h, withEncoding("GET", "gzip"))
+			assert.Equal(t, hdrs.Get("Content-Encoding"), resp.Headers.Get("Content-Encoding"), name)
+			assert.Equal(t, strconv.Itoa(len(resp.Body)), resp.Headers.Get("Content-Length"), name)
+		}
+	})
+
+	// Test: A coded response's strong ETag turns weak, and still matches
+	// under Cacheable
+	t.Run("ETag", func(t *testing.T) {
+		h := Compress(CompressOptions{})(Cacheable("")(reply(func(*request.Request) string { return text })))
+		resp := serve(t, h, withEncoding("GET", "gzip"))
+		etag := resp.Headers.Get("ETag")
+		assert.True(t, strings.HasPrefix(etag, `W/"`), etag)
+
+		resp = serve(t, h, "GET / HTTP/1.1\r\nHost: x\r\nAccept-Encoding: gzip\r\nIf-None-Match: "+etag+"\r\n\r\n")
+		assert.Equal(t, 304, resp.StatusLine.StatusCode)
+	})
+
+	// Test: HEAD gets the coded length and no body
+	t.Run("HEAD", func(t *testing.T) {
+		coded := serve(t, h, withEncoding("GET", "gzip"))
+		req, err := request.RequestFromReader(strings.NewReader(withEncoding("HEAD", "gzip")))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		h.ServeHTTP(response.NewWriter(&buf), req)
+		head, err := response.ResponseFromReaderWithOptions(&buf, response.Options{RequestMethod: "HEAD"})
+		require.NoError(t, err)
+		assert.Equal(t, "gzip", head.Headers.Get("Content-Encoding"))
+		assert.Equal(t, coded.Headers.Get("Content-Length"), head.Headers.Get("Content-Length"))
+		assert.Empty(t, head.Body)
+		assert.Equal(t, "HEAD", req.RequestLine.Method)
+	})
+
+	// Test: Other methods reach the handler unchanged, and a HEAD handler
+	// that panics leaves the request a HEAD
+	t.Run("Methods", func(t *testing.T) {
+		var seen string
+		h := Compress(CompressOptions{})(HandlerFunc(func(w *response.Writer, req *request.Request) {
+			seen = req.RequestLine.Method
+			if seen == "GET" {
+				panic("boom")
+			}
+			writeError(w, response.StatusOK, "ok")
+		}))
+		serve(t, h, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+		assert.Equal(t, "POST", seen)
+
+		req, err := request.RequestFromReader(strings.NewReader(withEncoding("HEAD", "gzip")))
+		require.NoError(t, err)
+		assert.Panics(t, func() { h.ServeHTTP(response.NewWriter(&bytes.Buffer{}), req) })
+		assert.Equal(t, "HEAD", req.RequestLine.Method)
+	})
+
+	// Test: Levels and extra encoders are applied
+	t.Run("Encoders", func(t *testing.T) {
+		var levels []int
+		plain := func(w io.Writer, level int) (io.WriteCloser, error) {
+			levels = append(levels, level)
+			return nopCloser{w}, nil
+		}
+		h := Compress(CompressOptions{
+			Encodings: []string{"x-plain", "gzip"},
+			Encoders:  map[string]Encoder{"x-plain": plain},
+			Levels:    map[string]int{"gzip": gzip.BestSpeed},
+		})(reply(func(*request.Request) string { return text }))
+
+		resp := serve(t, h, withEncoding("GET", "gzip, x-plain"))
+		assert.Equal(t, "x-plain", resp.Headers.Get("Content-Encoding"))
+		assert.Equal(t, []int{-1}, levels)
+		resp = serve(t, h, withEncoding("GET", "gzip"))
+		assert.Equal(t, text, decode(t, resp))
+
+		assert.PanicsWithValue(t, `compress: no encoder for "br"`, func() {
+			Compress(CompressOptions{Encodings: []string{"br"}})
+		})
+	})
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// TimeFormat is the IMF-fixdate layout used by Last-Modified and other date
+// headers.
+const TimeFormat = response.TimeFormat
+
+var (
+	ErrInvalidRange        = fmt.Errorf("invalid range")
+	ErrRangeNotSatisfiable = fmt.Errorf("range not satisfiable")
+)
+
+// byteRange is a span of content: length bytes starting at start.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header against content of the given size. It
+// fails with ErrInvalidRange when the header is malformed and with
+// ErrRangeNotSatisfiable when no range overlaps the content.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	specs, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, ErrInvalidRange
+	}
+
+	var ranges []byteRange
+	satisfiable := false
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, ErrInvalidRange
+		}
+
+		var r byteRange
+		if first == "" {
+			// A suffix range: the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, ErrInvalidRange
+			}
+			if n == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, ErrInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, ErrInvalidRange
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		satisfiable = true
+		ranges = append(ranges, r)
+	}
+
+	if !satisfiable {
+		return nil, ErrRangeNotSatisfiable
+	}
+	return ranges, nil
+}
+
+// ServeContent answers req with content, honouring a Range header with 206
+// Partial Content: one range is sent as is with Content-Range, several as
+// a multipart/byteranges body with a part for each. The Content-Type is
+// guessed from the extension of name, and modtime, if not zero, is sent as
+// Last-Modified. Requests for more than 32 ranges, or for more bytes in
+// all than the content holds, get the whole content, as do those whose
+// If-Range names another ETag or Last-Modified date than the content's,
+// so a resumed download never joins two versions.
+//
+// The response carries an ETag: the one already set on w.Header(), such as
+// a content hash the caller computed, or else one derived from modtime and
+// the size. A GET or HEAD whose If-None-Match matches it, or, without
+// If-None-Match, whose If-Modified-Since is not older than modtime, gets 304
+// Not Modified and no body.
+func ServeContent(w *response.Writer, req *request.Request, name string, modtime time.Time, content io.ReadSeeker) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		writeError(w, response.StatusInternalServerError, "cannot determine content size")
+		return
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" && !modtime.IsZero() {
+		etag = fmt.Sprintf(`"%x-%x"`, modtime.UnixNano(), size)
+	}
+	if method := req.RequestLine.Method; (method == "GET" || method == "HEAD") && notModified(req, etag, modtime) {
+		h := headers.NewHeaders()
+		h.Set("Connection", "close")
+		if etag != "" {
+			h.Set("ETag", etag)
+		}
+		if !modtime.IsZero() {
+			h.Set("Last-Modified", modtime.UTC().Format(TimeFormat))
+		}
+		w.WriteStatusLine(response.StatusNotModified)
+		w.WriteHeaders(*h)
+		return
+	}
+
+	var ranges []byteRange
+	if header := req.Headers.Get("Range"); header != "" && ifRange(req, etag, modtime) {
+		var err error
+		ranges, err = parseRange(header, size)
+		if err == ErrRangeNotSatisfiable {
+			h := response.GetDefaultHeaders(0)
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteStatusLine(response.StatusRangeNotSatisfiable)
+			w.WriteHeaders(*h)
+			return
+		}
+		// A malformed header is ignored, as RFC 9110 allows, and so are
+		// requests for more ranges or bytes than sending the whole
+		// content would cost.
+		if err != nil || len(ranges) > maxRanges || totalLength(ranges) > size {
+			ranges = nil
+		}
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Set("Accept-Ranges", "bytes")
+	if !modtime.IsZero() {
+		h.Set("Last-Modified", modtime.UTC().Format(TimeFormat))
+	}
+	if etag != "" {
+		h.Replace("ETag", etag)
+	}
+
+	var parts []string
+	statusCode := response.StatusOK
+	span := byteRange{start: 0, length: size}
+	length := size
+	switch {
+	case len(ranges) == 1:
+		statusCode = response.StatusPartialContent
+		span = ranges[0]
+		length = span.length
+		h.Set("Content-Range", span.contentRange(size))
+		h.Replace("Content-Type", contentType)
+	case len(ranges) > 1:
+		statusCode = response.StatusPartialContent
+		boundary := multipartBoundary()
+		parts = multipartHeaders(boundary, contentType, ranges, size)
+		length = int64(len(parts[len(parts)-1]))
+		for i, r := range ranges {
+			length += int64(len(parts[i])) + r.length
+		}
+		h.Replace("Content-Type", "multipart/byteranges; boundary="+boundary)
+		span = ranges[0]
+	default:
+		h.Replace("Content-Type", contentType)
+	}
+	h.Replace("Content-Length", strconv.FormatInt(length, 10))
+
+	if _, err := content.Seek(span.start, io.SeekStart); err != nil {
+		writeError(w, response.StatusInternalServerError, "cannot seek content")
+		return
+	}
+
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(*h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	if parts == nil {
+		sendSpan(w, name, content, span)
+		return
+	}
+	for i, r := range ranges {
+		if _, err := w.WriteBody([]byte(parts[i])); err != nil {
+			return
+		}
+		if !sendSpan(w, name, content, r) {
+			return
+		}
+	}
+	w.WriteBody([]byte(parts[len(parts)-1]))
+}
+
+// maxRanges is the most ranges one request may ask for before the whole
+// content is sent instead.
+const maxRanges = 32
+
+func totalLength(ranges []byteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r.length
+	}
+	return n
+}
+
+// multipartBoundary returns a random boundary for multipart/byteranges.
+func multipartBoundary() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// multipartHeaders returns the delimiter and headers that go before each
+// of ranges in a multipart/byteranges body (RFC 9110 section 14.6),
+// followed by the closing delimiter.
+func multipartHeaders(boundary, contentType string, ranges []byteRange, size int64) []string {
+	parts := make([]string, 0, len(ranges)+1)
+	for i, r := range ranges {
+		lead := "\r\n"
+		if i == 0 {
+			lead = ""
+		}
+		parts = append(parts, fmt.Sprintf("%s--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			lead, boundary, contentType, r.contentRange(size)))
+	}
+	return append(parts, "\r\n--"+boundary+"--\r\n")
+}
+
+// sendSpan writes span of content as body, reporting whether it all went.
+func sendSpan(w *response.Writer, name string, content io.ReadSeeker, span byteRange) bool {
+	if _, err := content.Seek(span.start, io.SeekStart); err != nil {
+		log.Printf("server: seeking %s: %v", name, err)
+		return false
+	}
+	// On a plain TCP connection an *os.File goes out with sendfile.
+	if _, err := w.WriteBodyFrom(io.LimitReader(content, span.length)); err != nil {
+		log.Printf("server: sending %s: %v", name, err)
+		return false
+	}
+	return true
+}
+
+// notModified evaluates If-None-Match, or failing that If-Modified-Since,
+// as RFC 9110 section 13.2.2 orders them.
+func notModified(req *request.Request, etag string, modtime time.Time) bool {
+	if inm := req.Headers.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	ims := req.Headers.Get("If-Modified-Since")
+	if ims == "" || modtime.IsZero() {
+		return false
+	}
+	t, err := time.Parse(TimeFormat, ims)
+	if err != nil {
+		return false
+	}
+	// Last-Modified has whole seconds only.
+	return !modtime.Truncate(time.Second).After(t)
+}
+
+// ifRange reports whether the Range header of req may be honoured under
+// its If-Range (RFC 9110 section 13.1.5): an entity-tag must match etag by
+// strong comparison, a date must equal modtime exactly. Without If-Range
+// it always may.
+func ifRange(req *request.Request, etag string, modtime time.Time) bool {
+	value := strings.TrimSpace(req.Headers.Get("If-Range"))
+	switch {
+	case value == "":
+		return true
+	case strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/"):
+		return value == etag && !strings.HasPrefix(etag, "W/")
+	case modtime.IsZero():
+		return false
+	}
+	t, err := time.Parse(TimeFormat, value)
+	return err == nil && modtime.Truncate(time.Second).Equal(t)
+}
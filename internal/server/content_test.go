@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: Closed, open-ended and suffix ranges
+	t.Run("Range forms", func(t *testing.T) {
+		ranges, err := parseRange("bytes=0-4, 5-, -3", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []byteRange{{0, 5}, {5, 5}, {7, 3}}, ranges)
+	})
+
+	// Test: Ranges past the end are clipped
+	t.Run("Clipped ranges", func(t *testing.T) {
+		ranges, err := parseRange("bytes=8-100,-50", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []byteRange{{8, 2}, {0, 10}}, ranges)
+	})
+
+	// Test: Malformed headers
+	t.Run("Invalid ranges", func(t *testing.T) {
+		for _, header := range []string{"items=0-1", "bytes=5", "bytes=4-2", "bytes=a-b", "bytes=--1"} {
+			_, err := parseRange(header, 10)
+			assert.ErrorIs(t, err, ErrInvalidRange, header)
+		}
+	})
+
+	// Test: No range overlaps the content
+	t.Run("Unsatisfiable ranges", func(t *testing.T) {
+		for _, header := range []string{"bytes=10-", "bytes=20-30", "bytes=-0"} {
+			_, err := parseRange(header, 10)
+			assert.ErrorIs(t, err, ErrRangeNotSatisfiable, header)
+		}
+	})
+}
+
+func TestServeContent(t *testing.T) {
+	modtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	url := startServer(t, HandlerFunc(func(w *response.Writer, req *request.Request) {
+		ServeContent(w, req, "clip.mp4", modtime, strings.NewReader("0123456789"))
+	}))
+	get := func(method, rangeHeader string) *response.Response {
+		b := client.NewRequest(method, url+"/")
+		if rangeHeader != "" {
+			b.Header("Range", rangeHeader)
+		}
+		req, err := b.Build()
+		require.NoError(t, err)
+		resp, err := client.NewClient().Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Test: Whole content
+	t.Run("Full content", func(t *testing.T) {
+		resp := get("GET", "")
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "0123456789", string(resp.Body))
+		assert.Equal(t, "video/mp4", resp.Headers.Get("Content-Type"))
+		assert.Equal(t, "bytes", resp.Headers.Get("Accept-Ranges"))
+		assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", resp.Headers.Get("Last-Modified"))
+	})
+
+	// Test: A single range yields 206
+	t.Run("Partial content", func(t *testing.T) {
+		resp := get("GET", "bytes=2-5")
+		assert.Equal(t, 206, resp.StatusLine.StatusCode)
+		assert.Equal(t, "2345", string(resp.Body))
+		assert.Equal(t, "bytes 2-5/10", resp.Headers.Get("Content-Range"))
+		assert.Equal(t, "4", resp.Headers.Get("Content-Length"))
+	})
+
+	// Test: Suffix range
+	t.Run("Suffix range", func(t *testing.T) {
+		resp := get("GET", "bytes=-3")
+		assert.Equal(t, 206, resp.StatusLine.StatusCode)
+		assert.Equal(t, "789", string(resp.Body))
+	})
+
+	// Test: Unsatisfiable ranges yield 416
+	t.Run("Range not satisfiable", func(t *testing.T) {
+		resp := get("GET", "bytes=50-")
+		assert.Equal(t, 416, resp.StatusLine.StatusCode)
+		assert.Equal(t, "bytes */10", resp.Headers.Get("Content-Range"))
+	})
+
+	// Test: Malformed and multiple ranges get the whole content
+	t.Run("Ignored ranges", func(t *testing.T) {
+		for _, header := range []string{"bytes=oops", "bytes=0-7,2-9"} {
+			resp := get("GET", header)
+			assert.Equal(t, 200, resp.StatusLine.StatusCode, header)
+			assert.Equal(t, "0123456789", string(resp.Body), header)
+		}
+	})
+
+	// Test: Several ranges come back as multipart/byteranges
+	t.Run("Multiple ranges", func(t *testing.T) {
+		resp := get("GET", "bytes=0-1,-2")
+		require.Equal(t, 206, resp.StatusLine.StatusCode)
+		mediaType, params, err := mime.ParseMediaType(resp.Headers.Get("Content-Type"))
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/byteranges", mediaType)
+		assert.Equal(t, strconv.Itoa(len(resp.Body)), resp.Headers.Get("Content-Length"))
+
+		mr := multipart.NewReader(bytes.NewReader(resp.Body), params["boundary"])
+		for _, want := range []struct{ contentRange, body string }{
+			{"bytes 0-1/10", "01"},
+			{"bytes 8-9/10", "89"},
+		} {
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			assert.Equal(t, "video/mp4", part.Header.Get("Content-Type"))
+			assert.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+			body, err := io.ReadAll(part)
+			require.NoError(t, err)
+			assert.Equal(t, want.body, string(body))
+		}
+		_, err = mr.NextPart()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	// Test: HEAD sends the headers only
+	t.Run("HEAD", func(t *testing.T) {
+		resp := get("HEAD", "bytes=0-1")
+		assert.Equal(t, 206, resp.StatusLine.StatusCode)
+		assert.Equal(t, "2", resp.Headers.Get("Content-Length"))
+		assert.Empty(t, resp.Body)
+	})
+}
+
+func TestServeContentConditional(t *testing.T) {
+	modtime := time.Date(2024, 3, 1, 12, 0, 0, 500, time.UTC)
+	h := HandlerFunc(func(w *response.Writer, req *request.Request) {
+		ServeContent(w, req, "page.html", modtime, strings.NewReader("<p>hi</p>"))
+	})
+	withHeader := func(name, value string) string {
+		return "GET / HTTP/1.1\r\nHost: x\r\n" + name + ": " + value + "\r\n\r\n"
+	}
+
+	resp := serve(t, h, get("/"))
+	etag := resp.Headers.Get("ETag")
+	require.Regexp(t, `^"[0-9a-f]+-9"$`, etag)
+
+	// Test: A matching If-None-Match gets 304 with the validators only
+	t.Run("If-None-Match", func(t *testing.T) {
+		resp := serve(t, h, withHeader("If-None-Match", `"x", `+etag))
+		assert.Equal(t, 304, resp.StatusLine.StatusCode)
+		assert.Empty(t, resp.Body)
+		assert.Equal(t, etag, resp.Headers.Get("ETag"))
+		assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", resp.Headers.Get("Last-Modified"))
+		assert.Empty(t, resp.Headers.Get("Content-Length"))
+
+		resp = serve(t, h, withHeader("If-None-Match", `"stale"`))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+	})
+
+	// Test: If-Modified-Since compares whole seconds
+	t.Run("If-Modified-Since", func(t *testing.T) {
+		for date, want := range map[string]int{
+			"Fri, 01 Mar 2024 12:00:00 GMT": 304,
+			"Sat, 02 Mar 2024 00:00:00 GMT": 304,
+			"Fri, 01 Mar 2024 11:59:59 GMT": 200,
+			"yesterday":                     200,
+		} {
+			resp := serve(t, h, withHeader("If-Modified-Since", date))
+			assert.Equal(t, want, resp.StatusLine.StatusCode, date)
+		}
+	})
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	t.Run("Precedence", func(t *testing.T) {
+		raw := "GET / HTTP/1.1\r\nHost: x\r\nIf-None-Match: \"stale\"\r\nIf-Modified-Since: Sat, 02 Mar 2024 00:00:00 GMT\r\n\r\n"
+		resp := serve(t, h, raw)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+	})
+
+	// Test: If-Range honours Range only for the current validator
+	t.Run("If-Range", func(t *testing.T) {
+		for value, want := range map[string]int{
+			etag:                            206,
+			"Fri, 01 Mar 2024 12:00:00 GMT": 206,
+			`"stale"`:                       200,
+			"W/" + etag:                     200,
+			"Fri, 01 Mar 2024 12:00:01 GMT": 200,
+			"yesterday":                     200,
+		} {
+			raw := "GET / HTTP/1.1\r\nHost: x\r\nRange: bytes=3-5\r\nIf-Range: " + value + "\r\n\r\n"
+			resp := serve(t, h, raw)
+			assert.Equal(t, want, resp.StatusLine.StatusCode, value)
+			if want == 200 {
+				assert.Equal(t, "<p>hi</p>", string(resp.Body), value)
+			} else {
+				assert.Equal(t, "<p>hi</p>"[3:6], string(resp.Body), value)
+			}
+		}
+	})
+
+	// Test: An ETag set by the caller is used instead
+	t.Run("Caller ETag", func(t *testing.T) {
+		h := HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.Header().Set("ETag", `"sha-abc"`)
+			ServeContent(w, req, "page.html", modtime, strings.NewReader("<p>hi</p>"))
+		})
+		resp := serve(t, h, get("/"))
+		assert.Equal(t, `"sha-abc"`, resp.Headers.Get("ETag"))
+		resp = serve(t, h, withHeader("If-None-Match", `"sha-abc"`))
+		assert.Equal(t, 304, resp.StatusLine.StatusCode)
+	})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// Cacheable is middleware that makes GET and HEAD responses revalidatable.
+// The response is buffered; a 200 gets a strong ETag computed over its body
+// unless the handler set one, and a request whose If-None-Match matches it
+// is answered with 304 and no body. cacheControl, if not empty, is sent as
+// Cache-Control on those 200s and 304s unless the handler set its own.
+// Wrap single routes to give each its own policy:
+//
+//	r.GET("/logo.png", server.Cacheable("public, max-age=86400")(logo))
+//
+// HEAD requests run the handler as GET so both get the same ETag. Since the
+// response is buffered, handlers that stream without end or hijack the
+// connection should not be wrapped, and trailers are dropped.
+func Cacheable(cacheControl string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			method := req.RequestLine.Method
+			if method != "GET" && method != "HEAD" {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			var buf bytes.Buffer
+			inner := response.NewWriter(&buf)
+			w.Header().ForEach(func(key, value string) {
+				inner.Header().Set(key, value)
+			})
+			next.ServeHTTP(inner, headAsGET(req))
+			if inner.StatusCode() == 0 {
+				return
+			}
+
+			resp, err := response.ResponseFromReader(&buf)
+			if err != nil {
+				writeError(w, response.StatusInternalServerError, "internal server error")
+				return
+			}
+
+			h := resp.Headers.Clone()
+			h.Delete("Transfer-Encoding")
+			h.Replace("Content-Length", strconv.Itoa(len(resp.Body)))
+			status := response.StatusCode(resp.StatusLine.StatusCode)
+			if status == response.StatusOK {
+				if h.Get("ETag") == "" {
+					sum := sha256.Sum256(resp.Body)
+					h.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+				}
+				if cacheControl != "" && h.Get("Cache-Control") == "" {
+					h.Set("Cache-Control", cacheControl)
+				}
+				if etagMatches(req.Headers.Get("If-None-Match"), h.Get("ETag")) {
+					// A 304 carries the validators and caching fields of the
+					// 200 it stands for, but no body or framing.
+					h.Delete("Content-Length")
+					h.Delete("Content-Type")
+					w.WriteStatusLine(response.StatusNotModified)
+					w.WriteHeaders(*h)
+					return
+				}
+			}
+
+			writeStatusLine(w, resp)
+			w.WriteHeaders(*h)
+			if method != "HEAD" {
+				w.WriteBody(resp.Body)
+			}
+		})
+	}
+}
+
+// headAsGET returns a HEAD request as a GET, for running its handler as
+// it would run for the body. The method is changed on a copy, so that a
+// handler that panics or keeps the request leaves req as it was; other
+// requests are returned as they are.
+func headAsGET(req *request.Request) *request.Request {
+	if req.RequestLine.Method != "HEAD" {
+		return req
+	}
+	get := *req
+	get.RequestLine.Method = "GET"
+	return &get
+}
+
+// writeStatusLine writes the status line of a buffered response resp to
+// w, keeping the reason phrase the handler gave it.
+func writeStatusLine(w *response.Writer, resp *response.Response) error {
+	status := response.StatusCode(resp.StatusLine.StatusCode)
+	if resp.StatusLine.ReasonPhrase == "" {
+		return w.WriteStatusLine(status)
+	}
+	return w.WriteStatusLineReason(status, resp.StatusLine.ReasonPhrase)
+}
+
+// etagMatches reports whether an If-None-Match value matches etag, using the
+// weak comparison RFC 9110 section 13.1.2 prescribes for it.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
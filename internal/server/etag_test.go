@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheable(t *testing.T) {
+	calls := 0
+	h := Cacheable("public, max-age=60")(reply(func(*request.Request) string {
+		calls++
+		return "hello"
+	}))
+	withHeader := func(method, name, value string) string {
+		return method + " / HTTP/1.1\r\nHost: x\r\n" + name + ": " + value + "\r\n\r\n"
+	}
+
+	// Test: A 200 gets an ETag and Cache-Control
+	resp := serve(t, h, get("/"))
+	assert.Equal(t, 200, resp.StatusLine.StatusCode)
+	assert.Equal(t, "hello", string(resp.Body))
+	assert.Equal(t, "public, max-age=60", resp.Headers.Get("Cache-Control"))
+	etag := resp.Headers.Get("ETag")
+	require.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	// Test: A matching If-None-Match gets 304 without a body
+	t.Run("Not modified", func(t *testing.T) {
+		for _, value := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+			resp := serve(t, h, withHeader("GET", "If-None-Match", value))
+			assert.Equal(t, 304, resp.StatusLine.StatusCode, value)
+			assert.Empty(t, resp.Body)
+			assert.Equal(t, etag, resp.Headers.Get("ETag"))
+			assert.Equal(t, "public, max-age=60", resp.Headers.Get("Cache-Control"))
+		}
+	})
+
+	// Test: A stale ETag gets the full response
+	t.Run("Modified", func(t *testing.T) {
+		resp := serve(t, h, withHeader("GET", "If-None-Match", `"stale"`))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "hello", string(resp.Body))
+	})
+
+	// Test: HEAD gets the same ETag and no body
+	t.Run("HEAD", func(t *testing.T) {
+		req, err := request.RequestFromReader(strings.NewReader("HEAD / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		h.ServeHTTP(response.NewWriter(&buf), req)
+
+		head, err := response.ResponseFromReaderWithOptions(&buf, response.Options{RequestMethod: "HEAD"})
+		require.NoError(t, err)
+		assert.Equal(t, etag, head.Headers.Get("ETag"))
+		assert.Equal(t, "5", head.Headers.Get("Content-Length"))
+		assert.Empty(t, head.Body)
+		assert.Equal(t, "HEAD", req.RequestLine.Method)
+	})
+
+	// Test: A handler panicking on a HEAD leaves the request a HEAD
+	t.Run("HEAD panic", func(t *testing.T) {
+		h := Cacheable("")(HandlerFunc(func(w *response.Writer, req *request.Request) {
+			assert.Equal(t, "GET", req.RequestLine.Method)
+			panic("boom")
+		}))
+		req, err := request.RequestFromReader(strings.NewReader("HEAD / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Panics(t, func() { h.ServeHTTP(response.NewWriter(&bytes.Buffer{}), req) })
+		assert.Equal(t, "HEAD", req.RequestLine.Method)
+	})
+
+	// Test: A custom reason phrase is kept
+	t.Run("Reason", func(t *testing.T) {
+		h := Cacheable("")(HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLineReason(response.StatusOK, "Fine Thanks")
+			w.WriteHeaders(*response.GetDefaultHeaders(2))
+			w.WriteBody([]byte("hi"))
+		}))
+		resp := serve(t, h, get("/"))
+		assert.Equal(t, "Fine Thanks", resp.StatusLine.ReasonPhrase)
+	})
+
+	// Test: The handler's own ETag and Cache-Control win
+	t.Run("Handler headers", func(t *testing.T) {
+		h := Cacheable("max-age=60")(HandlerFunc(func(w *response.Writer, req *request.Request) {
+			hdrs := response.GetDefaultHeaders(2)
+			hdrs.Set("ETag", `"v1"`)
+			hdrs.Set("Cache-Control", "no-cache")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*hdrs)
+			w.WriteBody([]byte("hi"))
+		}))
+		resp := serve(t, h, withHeader("GET", "If-None-Match", `"v1"`))
+		assert.Equal(t, 304, resp.StatusLine.StatusCode)
+		assert.Equal(t, `"v1"`, resp.Headers.Get("ETag"))
+		assert.Equal(t, "no-cache", resp.Headers.Get("Cache-Control"))
+	})
+
+	// Test: Chunked bodies are buffered into one with a length
+	t.Run("Chunked", func(t *testing.T) {
+		h := Cacheable("")(HandlerFunc(func(w *response.Writer, req *request.Request) {
+			hdrs := response.GetDefaultHeaders(0)
+			hdrs.Delete("Content-Length")
+			hdrs.Set("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*hdrs)
+			w.WriteChunkedBody([]byte("ab"))
+			w.WriteChunkedBody([]byte("cd"))
+			w.WriteChunkedBodyDone()
+		}))
+		resp := serve(t, h, get("/"))
+		assert.Equal(t, "abcd", string(resp.Body))
+		assert.Equal(t, "4", resp.Headers.Get("Content-Length"))
+		assert.Empty(t, resp.Headers.Get("Transfer-Encoding"))
+		assert.Empty(t, resp.Headers.Get("Cache-Control"))
+		assert.NotEmpty(t, resp.Headers.Get("ETag"))
+	})
+
+	// Test: Errors and other methods pass through untouched
+	t.Run("Pass through", func(t *testing.T) {
+		resp := serve(t, Cacheable("max-age=60")(NewRouter()), get("/"))
+		assert.Equal(t, 404, resp.StatusLine.StatusCode)
+		assert.Empty(t, resp.Headers.Get("ETag"))
+
+		before := calls
+		resp = serve(t, h, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Empty(t, resp.Headers.Get("ETag"))
+		assert.Equal(t, before+1, calls)
+	})
+}
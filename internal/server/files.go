@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// FileServer serves the files under a directory. Request paths are resolved
+// inside the directory through os.Root, so neither ".." segments nor
+// symbolic links can reach files outside it. Files are sent with
+// ServeContent, which supports ranges; a directory is answered with its
+// index.html, or with a listing when ListDirectories is set.
+type FileServer struct {
+	// ListDirectories shows an HTML listing for directories without an
+	// index.html instead of 403 Forbidden.
+	ListDirectories bool
+
+	dir string
+}
+
+// FileHandler returns a FileServer for dir. It serves the request path as
+// is, so mount it where paths are relative to dir:
+//
+//	r.Mount("/static", server.FileHandler("./public"))
+func FileHandler(dir string) *FileServer {
+	return &FileServer{dir: dir}
+}
+
+func (fsrv *FileServer) ServeHTTP(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		h := response.GetDefaultHeaders(0)
+		h.Set("Allow", "GET, HEAD")
+		w.WriteStatusLine(response.StatusMethodNotAllowed)
+		w.WriteHeaders(*h)
+		return
+	}
+
+	rawPath := requestPath(req.RequestLine.RequestTarget)
+	urlPath, err := url.PathUnescape(rawPath)
+	if err != nil {
+		writeError(w, response.StatusBadRequest, "bad request path")
+		return
+	}
+	// Cleaning a rooted path drops every "..", and os.Root refuses the rest
+	// of the ways out, symbolic links included.
+	urlPath = path.Clean("/" + urlPath)
+	name := strings.TrimPrefix(urlPath, "/")
+	if name == "" {
+		name = "."
+	}
+
+	root, err := os.OpenRoot(fsrv.dir)
+	if err != nil {
+		writeError(w, response.StatusInternalServerError, "cannot open file root")
+		return
+	}
+	defer root.Close()
+
+	info, err := root.Stat(name)
+	if err != nil {
+		writeError(w, response.StatusNotFound, "not found")
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(rawPath, "/") {
+			// Relative links in the index or listing need the slash. The
+			// Location is relative too, so it works wherever this is mounted.
+			response.Redirect(w, response.StatusMovedPermanently, path.Base(urlPath)+"/")
+			return
+		}
+
+		index := path.Join(name, "index.html")
+		if indexInfo, err := root.Stat(index); err == nil && !indexInfo.IsDir() {
+			fsrv.serveFile(w, req, root, index)
+			return
+		}
+		if !fsrv.ListDirectories {
+			writeError(w, response.StatusForbidden, "directory listing is disabled")
+			return
+		}
+		fsrv.serveListing(w, req, root, name, urlPath)
+		return
+	}
+
+	fsrv.serveFile(w, req, root, name)
+}
+
+func (fsrv *FileServer) serveFile(w *response.Writer, req *request.Request, root *os.Root, name string) {
+	f, err := root.Open(name)
+	if err != nil {
+		writeError(w, response.StatusNotFound, "not found")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, response.StatusInternalServerError, "cannot read file")
+		return
+	}
+	ServeContent(w, req, name, info.ModTime(), f)
+}
+
+func (fsrv *FileServer) serveListing(w *response.Writer, req *request.Request, root *os.Root, name, urlPath string) {
+	dir, err := root.Open(name)
+	if err != nil {
+		writeError(w, response.StatusInternalServerError, "cannot read directory")
+		return
+	}
+	entries, err := dir.ReadDir(-1)
+	dir.Close()
+	if err != nil {
+		writeError(w, response.StatusInternalServerError, "cannot read directory")
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	body := listingHTML(entries, urlPath)
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody([]byte(body))
+	}
+}
+
+// listingHTML renders entries of the directory at urlPath as a page of
+// relative links.
+func listingHTML(entries []fs.DirEntry, urlPath string) string {
+	var b strings.Builder
+	title := html.EscapeString(urlPath)
+	fmt.Fprintf(&b, "<html>\n<head><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	if urlPath != "/" {
+		b.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		// "./" keeps a name with a colon from being read as a URL scheme.
+		fmt.Fprintf(&b, "<li><a href=\"./%s\">%s</a></li>\n", (&url.URL{Path: name}).EscapedPath(), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileHandler(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	write := func(name, content string) {
+		t.Helper()
+		name = filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	write("style.css", "body{}")
+	write("site/index.html", "<h1>site</h1>")
+	write("files/b.txt", "b")
+	write("files/a dir/x.txt", "x")
+	require.NoError(t, os.WriteFile(filepath.Join(base, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(root, "escape.txt")))
+	require.NoError(t, os.Symlink("style.css", filepath.Join(root, "alias.css")))
+
+	fs := FileHandler(root)
+
+	// Test: Files are served with their type and modification time
+	t.Run("File", func(t *testing.T) {
+		resp := serve(t, fs, get("/style.css"))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "body{}", string(resp.Body))
+		assert.Contains(t, resp.Headers.Get("Content-Type"), "text/css")
+		assert.NotEmpty(t, resp.Headers.Get("Last-Modified"))
+	})
+
+	// Test: Directories serve their index.html, after a redirect to the slash
+	t.Run("Index", func(t *testing.T) {
+		resp := serve(t, fs, get("/site"))
+		assert.Equal(t, 301, resp.StatusLine.StatusCode)
+		assert.Equal(t, "site/", resp.Headers.Get("Location"))
+
+		resp = serve(t, fs, get("/site/"))
+		assert.Equal(t, "<h1>site</h1>", string(resp.Body))
+		assert.Contains(t, resp.Headers.Get("Content-Type"), "text/html")
+	})
+
+	// Test: Listings only when enabled
+	t.Run("Listing", func(t *testing.T) {
+		resp := serve(t, fs, get("/files/"))
+		assert.Equal(t, 403, resp.StatusLine.StatusCode)
+
+		listing := FileHandler(root)
+		listing.ListDirectories = true
+		resp = serve(t, listing, get("/files/"))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Contains(t, string(resp.Body), `<a href="./a%20dir/">a dir/</a>`)
+		assert.Contains(t, string(resp.Body), `<a href="./b.txt">b.txt</a>`)
+	})
+
+	// Test: Neither .. nor symbolic links leave the root
+	t.Run("Escapes", func(t *testing.T) {
+		for _, target := range []string{"/../secret.txt", "/files/../../secret.txt", "/%2e%2e/secret.txt", "/escape.txt"} {
+			resp := serve(t, fs, get(target))
+			assert.Equal(t, 404, resp.StatusLine.StatusCode, target)
+			assert.NotContains(t, string(resp.Body), "secret", target)
+		}
+
+		resp := serve(t, fs, get("/alias.css"))
+		assert.Equal(t, "body{}", string(resp.Body), "links inside the root work")
+	})
+
+	// Test: Only GET and HEAD
+	t.Run("Methods", func(t *testing.T) {
+		resp := serve(t, fs, "DELETE /style.css HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, 405, resp.StatusLine.StatusCode)
+		assert.Equal(t, "GET, HEAD", resp.Headers.Get("Allow"))
+	})
+
+	// Test: Mounted under a prefix
+	t.Run("Mounted", func(t *testing.T) {
+		r := NewRouter()
+		r.Mount("/static", fs)
+		resp := serve(t, r, get("/static/files/b.txt"))
+		assert.Equal(t, "b", string(resp.Body))
+
+		resp = serve(t, r, get("/static/site"))
+		assert.Equal(t, "site/", resp.Headers.Get("Location"))
+	})
+}
+
+// BenchmarkFileHandlerLargeFile downloads a 16 MB file over TCP, which the
+// server sends with sendfile.
+func BenchmarkFileHandlerLargeFile(b *testing.B) {
+	const size = 16 << 20
+	dir := b.TempDir()
+	require.NoError(b, os.WriteFile(filepath.Join(dir, "big.bin"), make([]byte, size), 0o644))
+
+	s, err := Serve(0, FileHandler(dir))
+	require.NoError(b, err)
+	defer s.Close()
+
+	b.SetBytes(size)
+	for b.Loop() {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(b, err)
+		fmt.Fprint(conn, get("/big.bin"))
+		n, err := io.Copy(io.Discard, conn)
+		conn.Close()
+		require.NoError(b, err)
+		require.Greater(b, n, int64(size))
+	}
+}
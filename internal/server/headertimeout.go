@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+)
+
+// headerRateGrace is how long a client may send nothing before
+// MinHeaderRate starts counting against it.
+const headerRateGrace = time.Second
+
+// headerReader reads requests from conn, holding each request line and
+// headers to ReadHeaderTimeout and MinHeaderRate. Each read gets a deadline
+// from those limits and the ReadTimeout one, so a client trickling bytes
+// cannot keep the connection's goroutine forever. Once req has its headers,
+// only the ReadTimeout deadline is left.
+type headerReader struct {
+	conn    net.Conn
+	req     *request.Request
+	timeout time.Duration
+	rate    int
+	// deadline is the ReadTimeout deadline, zero when there is none.
+	deadline time.Time
+
+	// start is when the header limits began to run; zero while waiting
+	// idle for a request's first byte.
+	start   time.Time
+	n       int64
+	body    bool
+	expired bool
+}
+
+func newHeaderReader(conn net.Conn, req *request.Request, opts Options) *headerReader {
+	return &headerReader{
+		conn:    conn,
+		req:     req,
+		timeout: opts.ReadHeaderTimeout,
+		rate:    opts.MinHeaderRate,
+	}
+}
+
+// reset starts on the next request, which must be read by deadline when
+// that is not zero. The header limits run from now, or with idle, from the
+// request's first byte.
+func (hr *headerReader) reset(deadline time.Time, idle bool) {
+	hr.deadline = deadline
+	hr.start = time.Time{}
+	if !idle {
+		hr.start = time.Now()
+	}
+	hr.n = 0
+	hr.body = false
+	hr.expired = false
+}
+
+func (hr *headerReader) Read(p []byte) (int, error) {
+	if hr.start.IsZero() {
+		hr.conn.SetReadDeadline(hr.deadline)
+		n, err := hr.conn.Read(p)
+		if n > 0 {
+			hr.start = time.Now()
+			hr.n = int64(n)
+		}
+		return n, err
+	}
+	if hr.req.HeadersDone() {
+		if !hr.body {
+			hr.conn.SetReadDeadline(hr.deadline)
+			hr.body = true
+		}
+		return hr.conn.Read(p)
+	}
+
+	limit := hr.headerDeadline()
+	headerBound := hr.deadline.IsZero() || limit.Before(hr.deadline)
+	if headerBound {
+		hr.conn.SetReadDeadline(limit)
+	} else {
+		hr.conn.SetReadDeadline(hr.deadline)
+	}
+	n, err := hr.conn.Read(p)
+	hr.n += int64(n)
+	if headerBound && errors.Is(err, os.ErrDeadlineExceeded) {
+		hr.expired = true
+	}
+	return n, err
+}
+
+// headerDeadline is when the headers are late: ReadHeaderTimeout after the
+// start, or sooner when the bytes so far fall behind MinHeaderRate.
+func (hr *headerReader) headerDeadline() time.Time {
+	var limit time.Time
+	if hr.timeout > 0 {
+		limit = hr.start.Add(hr.timeout)
+	}
+	if hr.rate > 0 {
+		earned := time.Duration(hr.n * int64(time.Second) / int64(hr.rate))
+		if t := hr.start.Add(headerRateGrace + earned); limit.IsZero() || t.Before(limit) {
+			limit = t
+		}
+	}
+	return limit
+}
+
+// timedOut reports whether reading stopped because the headers were late.
+func (hr *headerReader) timedOut() bool {
+	return hr != nil && hr.expired
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// reapIdle closes connections idle for longer than IdleTimeout, checking a
+// few times per timeout, until the server is closed.
+func (s *Server) reapIdle() {
+	ticker := time.NewTicker(max(s.opts.IdleTimeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.closed.Load() {
+			return
+		}
+		cutoff := time.Now().Add(-s.opts.IdleTimeout)
+		s.mu.Lock()
+		for conn, st := range s.conns {
+			if !st.active && !st.closing && st.idleSince.Before(cutoff) {
+				st.closing = true
+				s.reapedConns.Add(1)
+				conn.Close()
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// closeExcessIdle closes the longest idle connections while there are more
+// than MaxIdleConns. s.mu must be held.
+func (s *Server) closeExcessIdle() {
+	if s.opts.MaxIdleConns <= 0 {
+		return
+	}
+	for {
+		idle := 0
+		var oldest *connState
+		var oldestConn net.Conn
+		for conn, st := range s.conns {
+			if st.active || st.closing {
+				continue
+			}
+			idle++
+			if oldest == nil || st.idleSince.Before(oldest.idleSince) {
+				oldest, oldestConn = st, conn
+			}
+		}
+		if idle <= s.opts.MaxIdleConns {
+			return
+		}
+		oldest.closing = true
+		s.reapedConns.Add(1)
+		oldestConn.Close()
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// IPFilter decides which client addresses may connect. A source matching
+// Deny is refused; otherwise, if Allow is not empty, only sources matching
+// it get through. The zero value lets everyone in.
+type IPFilter struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// ParseIPFilter builds a filter from CIDR strings such as "10.0.0.0/8" or
+// "2001:db8::/32". A bare address stands for itself alone.
+func ParseIPFilter(allow, deny []string) (*IPFilter, error) {
+	f := &IPFilter{}
+	var err error
+	if f.Allow, err = ParsePrefixes(allow); err != nil {
+		return nil, fmt.Errorf("ip filter: %w", err)
+	}
+	if f.Deny, err = ParsePrefixes(deny); err != nil {
+		return nil, fmt.Errorf("ip filter: %w", err)
+	}
+	return f, nil
+}
+
+// ParsePrefixes parses CIDR strings as ParseIPFilter does, for options such
+// as Options.TrustedProxies.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an address or CIDR", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or CIDR", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// Allowed reports whether a connection from addr may be served. Addresses
+// that are not IP addresses, such as Unix sockets, are refused when the
+// filter has any rules.
+func (f *IPFilter) Allowed(addr net.Addr) bool {
+	if len(f.Allow) == 0 && len(f.Deny) == 0 {
+		return true
+	}
+	ip, ok := addrIP(addr)
+	if !ok {
+		return false
+	}
+	if containsAddr(f.Deny, ip) {
+		return false
+	}
+	return len(f.Allow) == 0 || containsAddr(f.Allow, ip)
+}
+
+// addrIP returns the IP address of a TCP-style addr.
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	// IPv4 clients of a dual-stack listener show up as ::ffff:a.b.c.d.
+	return ap.Addr().Unmap(), true
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
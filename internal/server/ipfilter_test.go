@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPFilterAllowed(t *testing.T) {
+	tcp := func(addr string) net.Addr {
+		return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))
+	}
+
+	// Test: The zero filter allows everyone
+	t.Run("Empty", func(t *testing.T) {
+		assert.True(t, (&IPFilter{}).Allowed(tcp("203.0.113.9:4000")))
+	})
+
+	// Test: An allow list admits only its ranges
+	t.Run("Allow", func(t *testing.T) {
+		f, err := ParseIPFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, nil)
+		require.NoError(t, err)
+		assert.True(t, f.Allowed(tcp("10.1.2.3:4000")))
+		assert.True(t, f.Allowed(tcp("[2001:db8::1]:4000")))
+		assert.False(t, f.Allowed(tcp("192.168.0.1:4000")))
+	})
+
+	// Test: Deny wins over allow
+	t.Run("Deny", func(t *testing.T) {
+		f, err := ParseIPFilter([]string{"10.0.0.0/8"}, []string{"10.0.0.5"})
+		require.NoError(t, err)
+		assert.True(t, f.Allowed(tcp("10.0.0.4:4000")))
+		assert.False(t, f.Allowed(tcp("10.0.0.5:4000")))
+	})
+
+	// Test: IPv4-mapped IPv6 sources match IPv4 rules
+	t.Run("Mapped", func(t *testing.T) {
+		f, err := ParseIPFilter(nil, []string{"192.0.2.0/24"})
+		require.NoError(t, err)
+		assert.False(t, f.Allowed(tcp("[::ffff:192.0.2.7]:4000")))
+	})
+
+	// Test: Malformed entries are errors
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ParseIPFilter([]string{"10.0.0.0/33"}, nil)
+		assert.Error(t, err)
+		_, err = ParseIPFilter(nil, []string{"example.com"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request duration
+// histogram.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricMethods are the methods given their own label value; the rest are
+// counted as "OTHER" so clients cannot grow the series without bound.
+var metricMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "OPTIONS": true, "CONNECT": true, "TRACE": true,
+}
+
+type routeKey struct{}
+
+// routeInfo collects the pattern a Router matched for the Metrics
+// middleware, which runs outside the router and cannot see it otherwise.
+type routeInfo struct {
+	// mount is the prefix of the routers the request was mounted through.
+	mount   string
+	pattern string
+}
+
+// setRoute records the matched pattern for Metrics, if it is listening.
+func setRoute(req *request.Request, pattern string) {
+	if info, ok := req.Context().Value(routeKey{}).(*routeInfo); ok {
+		info.pattern = info.mount + pattern
+	}
+}
+
+// addMount records that req is passing through a mount point at prefix.
+func addMount(req *request.Request, prefix string) {
+	if info, ok := req.Context().Value(routeKey{}).(*routeInfo); ok {
+		info.mount += prefix
+	}
+}
+
+type requestLabels struct {
+	method, route string
+	status        int
+}
+
+type routeLabels struct {
+	method, route string
+}
+
+// histogram counts observations per bucket; counts[i] holds those up to
+// durationBuckets[i], and the last element those above every bound.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	total  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(durationBuckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.total++
+}
+
+// Metrics collects request and server statistics and serves them in the
+// Prometheus text format. Requests are counted by the Middleware, labelled
+// with their method, status and the route pattern a Router matched, or ""
+// when none did; connection figures come from the servers passed to
+// Observe.
+//
+//	m := server.NewMetrics()
+//	r.Handle("GET", "/metrics", m)
+//	srv, err := server.Serve(port, m.Middleware()(r))
+//	m.Observe(srv)
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[requestLabels]uint64
+	durations map[routeLabels]*histogram
+	bytesIn   uint64
+	bytesOut  uint64
+	servers   []*Server
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  map[requestLabels]uint64{},
+		durations: map[routeLabels]*histogram{},
+	}
+}
+
+// Observe adds the connection and rejection counts of s to the metrics.
+func (m *Metrics) Observe(s *Server) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers = append(m.servers, s)
+}
+
+// Middleware counts every request once it has been answered. A handler
+// that panics before writing is counted with the 500 the server sends.
+func (m *Metrics) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			info := &routeInfo{}
+			panicked := true
+			defer func() {
+				status := int(w.StatusCode())
+				if panicked && status == 0 {
+					status = int(response.StatusInternalServerError)
+				}
+				m.record(req, info.pattern, status, w.BodyBytes(), time.Since(start))
+			}()
+
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), routeKey{}, info)))
+			panicked = false
+		})
+	}
+}
+
+func (m *Metrics) record(req *request.Request, route string, status int, bytesOut int64, elapsed time.Duration) {
+	method := req.RequestLine.Method
+	if !metricMethods[method] {
+		method = "OTHER"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{method, route, status}]++
+	h, ok := m.durations[routeLabels{method, route}]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets)+1)}
+		m.durations[routeLabels{method, route}] = h
+	}
+	h.observe(elapsed.Seconds())
+	m.bytesIn += uint64(len(req.Body))
+	m.bytesOut += uint64(bytesOut)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w *response.Writer, req *request.Request) {
+	var b strings.Builder
+	m.WriteTo(&b)
+
+	h := response.GetDefaultHeaders(b.Len())
+	h.Replace("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody([]byte(b.String()))
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(out io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("http_requests_total", "counter", "Requests answered, by method, route and status.")
+	reqKeys := make([]requestLabels, 0, len(m.requests))
+	for k := range m.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	sort.Slice(reqKeys, func(i, j int) bool {
+		a, c := reqKeys[i], reqKeys[j]
+		if a.route != c.route {
+			return a.route < c.route
+		}
+		if a.method != c.method {
+			return a.method < c.method
+		}
+		return a.status < c.status
+	})
+	for _, k := range reqKeys {
+		fmt.Fprintf(&b, "http_requests_total{method=%s,route=%s,status=\"%d\"} %d\n",
+			quoteLabel(k.method), quoteLabel(k.route), k.status, m.requests[k])
+	}
+
+	header("http_request_duration_seconds", "histogram", "Time taken to answer requests, by method and route.")
+	durKeys := make([]routeLabels, 0, len(m.durations))
+	for k := range m.durations {
+		durKeys = append(durKeys, k)
+	}
+	sort.Slice(durKeys, func(i, j int) bool {
+		if durKeys[i].route != durKeys[j].route {
+			return durKeys[i].route < durKeys[j].route
+		}
+		return durKeys[i].method < durKeys[j].method
+	})
+	for _, k := range durKeys {
+		h := m.durations[k]
+		labels := fmt.Sprintf("method=%s,route=%s", quoteLabel(k.method), quoteLabel(k.route))
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.total)
+		fmt.Fprintf(&b, "http_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "http_request_duration_seconds_count{%s} %d\n", labels, h.total)
+	}
+
+	header("http_request_body_bytes_total", "counter", "Request body bytes received.")
+	fmt.Fprintf(&b, "http_request_body_bytes_total %d\n", m.bytesIn)
+	header("http_response_body_bytes_total", "counter", "Response body bytes sent.")
+	fmt.Fprintf(&b, "http_response_body_bytes_total %d\n", m.bytesOut)
+
+	if len(m.servers) > 0 {
+		var total Stats
+		for _, s := range m.servers {
+			st := s.Stats()
+			total.Conns += st.Conns
+			total.IdleConns += st.IdleConns
+			total.InflightRequests += st.InflightRequests
+			total.RejectedConns += st.RejectedConns
+			total.RejectedRequests += st.RejectedRequests
+			total.BlockedConns += st.BlockedConns
+			total.BannedConns += st.BannedConns
+			total.ParseErrors += st.ParseErrors
+			total.HeaderTimeouts += st.HeaderTimeouts
+			total.ReapedConns += st.ReapedConns
+			total.WriteTimeouts += st.WriteTimeouts
+			total.ShedRequests += st.ShedRequests
+		}
+		header("http_open_connections", "gauge", "Connections currently open.")
+		fmt.Fprintf(&b, "http_open_connections %d\n", total.Conns)
+		header("http_idle_connections", "gauge", "Open connections waiting for a request.")
+		fmt.Fprintf(&b, "http_idle_connections %d\n", total.IdleConns)
+		header("http_inflight_requests", "gauge", "Requests currently being handled.")
+		fmt.Fprintf(&b, "http_inflight_requests %d\n", total.InflightRequests)
+		header("http_rejected_connections_total", "counter", "Connections refused over the connection limit.")
+		fmt.Fprintf(&b, "http_rejected_connections_total %d\n", total.RejectedConns)
+		header("http_rejected_requests_total", "counter", "Requests refused over the in-flight limit.")
+		fmt.Fprintf(&b, "http_rejected_requests_total %d\n", total.RejectedRequests)
+		header("http_blocked_connections_total", "counter", "Connections dropped by the IP filter.")
+		fmt.Fprintf(&b, "http_blocked_connections_total %d\n", total.BlockedConns)
+		header("http_banned_connections_total", "counter", "Connections dropped from addresses banned for abuse.")
+		fmt.Fprintf(&b, "http_banned_connections_total %d\n", total.BannedConns)
+		header("http_parse_errors_total", "counter", "Requests that could not be parsed.")
+		fmt.Fprintf(&b, "http_parse_errors_total %d\n", total.ParseErrors)
+		header("http_header_timeouts_total", "counter", "Requests whose headers arrived too slowly.")
+		fmt.Fprintf(&b, "http_header_timeouts_total %d\n", total.HeaderTimeouts)
+		header("http_reaped_connections_total", "counter", "Idle connections closed for idling too long or too many.")
+		fmt.Fprintf(&b, "http_reaped_connections_total %d\n", total.ReapedConns)
+		header("http_write_timeouts_total", "counter", "Responses cut off because the client stopped reading.")
+		fmt.Fprintf(&b, "http_write_timeouts_total %d\n", total.WriteTimeouts)
+		header("http_shed_requests_total", "counter", "Requests refused while the server was overloaded.")
+		fmt.Fprintf(&b, "http_shed_requests_total %d\n", total.ShedRequests)
+	}
+
+	n, err := io.WriteString(out, b.String())
+	return int64(n), err
+}
+
+// quoteLabel quotes a label value as the exposition format requires.
+func quoteLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return `"` + v + `"`
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	api := NewRouter()
+	api.GET("/users/:id", reply(func(req *request.Request) string { return "user " + req.PathValue("id") }))
+	r := NewRouter()
+	r.POST("/echo", reply(func(req *request.Request) string { return string(req.Body) }))
+	r.GET("/boom", func(w *response.Writer, req *request.Request) { panic("boom") })
+	r.Mount("/api", api)
+	r.Handle("GET", "/metrics", m)
+
+	s, err := ServeWithOptions("127.0.0.1:0", m.Middleware()(r), Options{})
+	require.NoError(t, err)
+	defer s.Close()
+	m.Observe(s)
+	url := "http://" + s.Addr().String()
+
+	c := client.NewClient()
+	for _, id := range []string{"1", "2"} {
+		_, err := c.Get(url + "/api/users/" + id)
+		require.NoError(t, err)
+	}
+	req, err := client.NewRequest("POST", url+"/echo").Body([]byte("hello")).Build()
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.NoError(t, err)
+	_, err = c.Get(url + "/boom")
+	require.NoError(t, err)
+	_, err = c.Get(url + "/nowhere/" + strings.Repeat("x", 5))
+	require.NoError(t, err)
+	req, err = client.NewRequest("BREW", url+"/echo").Build()
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	conn.Write([]byte("NOT HTTP\r\n\r\n"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	conn.Read(make([]byte, 512))
+	conn.Close()
+
+	resp, err := c.Get(url + "/metrics")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusLine.StatusCode)
+	assert.Contains(t, resp.Headers.Get("Content-Type"), "version=0.0.4")
+	body := string(resp.Body)
+
+	// Test: Requests are counted by method, route pattern and status
+	t.Run("Requests", func(t *testing.T) {
+		assert.Contains(t, body, `http_requests_total{method="GET",route="/api/users/:id",status="200"} 2`)
+		assert.Contains(t, body, `http_requests_total{method="POST",route="/echo",status="200"} 1`)
+		assert.Contains(t, body, `http_requests_total{method="GET",route="/boom",status="500"} 1`)
+		assert.Contains(t, body, `http_requests_total{method="GET",route="",status="404"} 1`, "unmatched paths share one series")
+		assert.Contains(t, body, `http_requests_total{method="OTHER",route="/echo",status="405"} 1`)
+	})
+
+	// Test: Durations form a cumulative histogram
+	t.Run("Durations", func(t *testing.T) {
+		assert.Contains(t, body, "# TYPE http_request_duration_seconds histogram")
+		assert.Contains(t, body, `http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",le="+Inf"} 2`)
+		assert.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/api/users/:id"} 2`)
+		assert.Contains(t, body, `http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",le="10"} 2`)
+	})
+
+	// Test: Body bytes and server figures
+	t.Run("Totals", func(t *testing.T) {
+		assert.Contains(t, body, "http_request_body_bytes_total 5\n")
+		assert.Contains(t, body, "http_parse_errors_total 1\n")
+		assert.Contains(t, body, "http_open_connections 1\n", "the scrape itself")
+		assert.Contains(t, body, "http_inflight_requests 1\n")
+	})
+
+	// Test: Label values are escaped
+	t.Run("Escaping", func(t *testing.T) {
+		assert.Equal(t, `"a\"b\\c\nd"`, quoteLabel("a\"b\\c\nd"))
+	})
+}
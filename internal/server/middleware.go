@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// Middleware wraps a handler with behaviour that runs around it.
+type Middleware func(next Handler) Handler
+
+// Chain wraps h in middlewares, the first being the outermost, so
+// Chain(h, a, b) runs a, then b, then h.
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// LogFormat selects the line format of AccessLog.
+type LogFormat int
+
+const (
+	// LogCommon is the Common Log Format followed by the time taken to
+	// answer in microseconds, as Apache's "%h %l %u %t \"%r\" %>s %b %D",
+	// and by the request ID when the RequestID middleware set one.
+	LogCommon LogFormat = iota
+	// LogJSON writes one JSON object per request.
+	LogJSON
+)
+
+// clfTimeFormat is the timestamp layout of the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// accessEntry is the JSON form of an access log line.
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	Remote     string    `json:"remote"`
+	Method     string    `json:"method"`
+	Target     string    `json:"target"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationUS int64     `json:"duration_us"`
+	RequestID  string    `json:"request_id,omitempty"`
+	// ALPN is the protocol negotiated over TLS, if any.
+	ALPN string `json:"alpn,omitempty"`
+}
+
+// AccessLog writes a line to out for every request once it has been
+// answered. A handler that panics before writing is logged with the 500
+// the server sends for it.
+func AccessLog(out io.Writer, format LogFormat) Middleware {
+	var mu sync.Mutex
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			panicked := true
+			defer func() {
+				status := int(w.StatusCode())
+				if panicked && status == 0 {
+					status = int(response.StatusInternalServerError)
+				}
+				line := formatAccess(format, accessEntry{
+					Time:       start,
+					Remote:     req.ClientIP(),
+					Method:     req.RequestLine.Method,
+					Target:     req.RequestLine.RequestTarget,
+					Proto:      "HTTP/" + req.RequestLine.HttpVersion,
+					Status:     status,
+					Bytes:      w.BodyBytes(),
+					DurationUS: time.Since(start).Microseconds(),
+					// RequestID echoes the ID in the response whichever
+					// side of this middleware it runs on.
+					RequestID: w.Header().Get(RequestIDHeader),
+					ALPN:      negotiatedProtocol(req),
+				})
+
+				mu.Lock()
+				defer mu.Unlock()
+				io.WriteString(out, line)
+			}()
+
+			next.ServeHTTP(w, req)
+			panicked = false
+		})
+	}
+}
+
+// negotiatedProtocol returns the ALPN protocol of req's TLS connection, or
+// an empty string.
+func negotiatedProtocol(req *request.Request) string {
+	if req.TLS == nil {
+		return ""
+	}
+	return req.TLS.NegotiatedProtocol
+}
+
+func formatAccess(format LogFormat, e accessEntry) string {
+	if format == LogJSON {
+		b, _ := json.Marshal(e)
+		return string(b) + "\n"
+	}
+
+	// CLF writes "-" for unknown fields and for an empty body.
+	remote := e.Remote
+	if remote == "" {
+		remote = "-"
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = fmt.Sprint(e.Bytes)
+	}
+	line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s %d",
+		escapeLog(remote), e.Time.Format(clfTimeFormat), escapeLog(e.Method), escapeLog(e.Target),
+		escapeLog(e.Proto), e.Status, size, e.DurationUS)
+	if e.RequestID != "" {
+		line += " " + escapeLog(e.RequestID)
+	}
+	return line + "\n"
+}
+
+// escapeLog escapes s for a Common Log Format line as Apache does for %r:
+// quotes and backslashes get a backslash, and control characters and
+// bytes past ASCII are written as \n, \r, \t or \xhh, so that no request
+// can end its quoted field or the line early and forge another.
+func escapeLog(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	// Test: Middlewares run outermost first
+	t.Run("Order", func(t *testing.T) {
+		var order []string
+		mark := func(name string) Middleware {
+			return func(next Handler) Handler {
+				return HandlerFunc(func(w *response.Writer, req *request.Request) {
+					order = append(order, name)
+					next.ServeHTTP(w, req)
+				})
+			}
+		}
+		h := Chain(reply(func(*request.Request) string {
+			order = append(order, "handler")
+			return ""
+		}), mark("a"), mark("b"))
+
+		serve(t, h, get("/"))
+		assert.Equal(t, []string{"a", "b", "handler"}, order)
+	})
+}
+
+func TestAccessLog(t *testing.T) {
+	parse := func(t *testing.T, raw string) *request.Request {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		req.RemoteAddr = "192.0.2.7:51000"
+		return req
+	}
+
+	// Test: Common Log Format line
+	t.Run("Common", func(t *testing.T) {
+		var out bytes.Buffer
+		h := AccessLog(&out, LogCommon)(reply(func(*request.Request) string { return "hello" }))
+		h.ServeHTTP(response.NewWriter(&bytes.Buffer{}), parse(t, get("/a?b=1")))
+
+		clf := regexp.MustCompile(`^192\.0\.2\.7 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /a\?b=1 HTTP/1\.1" 200 5 \d+\n$`)
+		assert.Regexp(t, clf, out.String())
+	})
+
+	// Test: Quotes, backslashes and control characters in the request are
+	// escaped, so it cannot forge a line of its own
+	t.Run("Escaping", func(t *testing.T) {
+		var out bytes.Buffer
+		h := AccessLog(&out, LogCommon)(reply(func(*request.Request) string { return "" }))
+		req := parse(t, get("/"))
+		req.RequestLine.RequestTarget = "/a\" 200 1 1\n192.0.2.9 - - [x] \"GET /\\\r\x01\xff"
+		h.ServeHTTP(response.NewWriter(&bytes.Buffer{}), req)
+
+		assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+		assert.Contains(t, out.String(), `"GET /a\" 200 1 1\n192.0.2.9 - - [x] \"GET /\\\r\x01\xff HTTP/1.1" 200 - `)
+	})
+
+	// Test: Empty bodies are logged as "-" and chunked bodies by payload
+	t.Run("Body sizes", func(t *testing.T) {
+		var out bytes.Buffer
+		h := AccessLog(&out, LogCommon)(HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusNoContent)
+			w.WriteHeaders(*response.GetDefaultHeaders(0))
+		}))
+		h.ServeHTTP(response.NewWriter(&bytes.Buffer{}), parse(t, get("/")))
+		assert.Contains(t, out.String(), `" 204 - `)
+
+		out.Reset()
+		h = AccessLog(&out, LogCommon)(HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(0))
+			w.WriteChunkedBody([]byte("abc"))
+			w.WriteChunkedBody([]byte("defg"))
+			w.WriteChunkedBodyDone()
+		}))
+		h.ServeHTTP(response.NewWriter(&bytes.Buffer{}), parse(t, get("/")))
+		assert.Contains(t, out.String(), `" 200 7 `)
+	})
+
+	// Test: JSON variant
+	t.Run("JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		h := AccessLog(&out, LogJSON)(reply(func(*request.Request) string { return "hi" }))
+		h.ServeHTTP(response.NewWriter(&bytes.Buffer{}), parse(t, get("/j")))
+
+		var e map[string]any
+		require.NoError(t, json.Unmarshal(out.Bytes(), &e))
+		assert.Equal(t, "192.0.2.7", e["remote"])
+		assert.Equal(t, "GET", e["method"])
+		assert.Equal(t, "/j", e["target"])
+		assert.Equal(t, float64(200), e["status"])
+		assert.Equal(t, float64(2), e["bytes"])
+		assert.Contains(t, e, "duration_us")
+	})
+
+	// Test: Panics are logged as 500 and still propagate
+	t.Run("Panic", func(t *testing.T) {
+		var out bytes.Buffer
+		h := AccessLog(&out, LogCommon)(HandlerFunc(func(*response.Writer, *request.Request) {
+			panic("boom")
+		}))
+		assert.Panics(t, func() {
+			h.ServeHTTP(response.NewWriter(&bytes.Buffer{}), parse(t, get("/")))
+		})
+		assert.Contains(t, out.String(), `" 500 - `)
+	})
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// Representation is one form a route can answer in, for Negotiate to
+// choose among: a media type such as "text/html", a language tag such as
+// "en-GB" and a content coding such as "gzip". An empty field leaves that
+// dimension out of the choice; an empty Encoding is the identity coding.
+type Representation struct {
+	MediaType string
+	Language  string
+	Encoding  string
+}
+
+// Negotiate picks from offers the representation req prefers by its
+// Accept, Accept-Language and Accept-Encoding fields (RFC 9110 section 12),
+// weighing their q-values together. Ties go to the earlier offer, so offers
+// list the server's own preference first. It reports false when every
+// offer is refused; the handler then usually answers 406 Not Acceptable.
+//
+// Each of the three fields whose value could change the outcome, because
+// offers differ in that dimension, is added to Vary on w.Header() so that
+// caches keep the representations apart. That happens whether or not an
+// offer is chosen, and members already listed are not repeated:
+//
+//	rep, ok := server.Negotiate(w, req, []server.Representation{
+//		{MediaType: "application/json"},
+//		{MediaType: "text/html"},
+//	})
+func Negotiate(w *response.Writer, req *request.Request, offers []Representation) (Representation, bool) {
+	dimensions := []struct {
+		field string
+		value func(Representation) string
+		match func(accept, offer string) int
+	}{
+		{"Accept", func(r Representation) string { return r.MediaType }, matchMediaType},
+		{"Accept-Language", func(r Representation) string { return r.Language }, matchLanguage},
+		{"Accept-Encoding", func(r Representation) string { return r.Encoding }, matchEncoding},
+	}
+
+	scores := make([]float64, len(offers))
+	for i := range scores {
+		scores[i] = 1
+	}
+	for _, d := range dimensions {
+		varies := false
+		for _, offer := range offers[min(1, len(offers)):] {
+			varies = varies || d.value(offer) != d.value(offers[0])
+		}
+		if !varies {
+			continue
+		}
+		addVary(w.Header(), d.field)
+
+		value, present := requestField(req, d.field)
+		if !present {
+			continue
+		}
+		ranges := parseAccept(value)
+		for i, offer := range offers {
+			if d.value(offer) == "" && d.field != "Accept-Encoding" {
+				continue
+			}
+			scores[i] *= acceptQuality(ranges, d.value(offer), d.match, d.field == "Accept-Encoding")
+		}
+	}
+
+	best := -1
+	for i, score := range scores {
+		if score > 0 && (best < 0 || score > scores[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Representation{}, false
+	}
+	return offers[best], true
+}
+
+// acceptRange is one member of an Accept-style list with its weight.
+type acceptRange struct {
+	value string
+	q     float64
+}
+
+// parseAccept splits an Accept, Accept-Language or Accept-Encoding value
+// into its members. Parameters other than q are dropped, and members with
+// an unreadable q are skipped.
+func parseAccept(value string) []acceptRange {
+	var ranges []acceptRange
+	for _, member := range strings.Split(value, ",") {
+		name, params, _ := strings.Cut(member, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		r := acceptRange{value: name, q: 1}
+		for _, param := range strings.Split(params, ";") {
+			key, val, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(key), "q") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil || q < 0 || q > 1 {
+				q = -1
+			}
+			r.q = q
+		}
+		if r.q >= 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// acceptQuality returns the q-value that the most specific of ranges
+// matching offer gives it, or zero when none does. match scores how
+// specifically a range matches, zero meaning not at all. For codings the
+// identity coding is acceptable unless refused (section 12.5.3).
+func acceptQuality(ranges []acceptRange, offer string, match func(accept, offer string) int, coding bool) float64 {
+	offer = strings.ToLower(offer)
+	q, specificity := 0.0, 0
+	for _, r := range ranges {
+		if s := match(r.value, offer); s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	if coding && specificity == 0 && (offer == "" || offer == "identity") {
+		return 1
+	}
+	return q
+}
+
+func matchMediaType(accept, offer string) int {
+	offer, _, _ = strings.Cut(offer, ";")
+	offer = strings.TrimSpace(offer)
+	switch {
+	case accept == "*/*":
+		return 1
+	case accept == offer:
+		return 3
+	case strings.HasSuffix(accept, "/*") && strings.HasPrefix(offer, accept[:len(accept)-1]):
+		return 2
+	}
+	return 0
+}
+
+// matchLanguage is the basic filtering of RFC 4647 section 3.3.1: a range
+// matches a tag equal to it or starting with it and a hyphen. Longer
+// ranges are more specific.
+func matchLanguage(accept, offer string) int {
+	switch {
+	case accept == "*":
+		return 1
+	case accept == offer || strings.HasPrefix(offer, accept+"-"):
+		return 1 + len(accept)
+	}
+	return 0
+}
+
+func matchEncoding(accept, offer string) int {
+	if offer == "" {
+		offer = "identity"
+	}
+	switch accept {
+	case "*":
+		return 1
+	case offer:
+		return 2
+	}
+	return 0
+}
+
+// requestField returns the value of the named field of req and whether it
+// was sent at all, since an empty Accept-Encoding differs from none.
+func requestField(req *request.Request, name string) (string, bool) {
+	name = strings.ToLower(name)
+	value, present := "", false
+	req.Headers.ForEach(func(key, v string) {
+		if key == name {
+			value, present = v, true
+		}
+	})
+	return value, present
+}
+
+// addVary adds field to the Vary header in h unless it, or "*", is already
+// listed.
+func addVary(h *headers.Headers, field string) {
+	vary := h.Get("Vary")
+	for _, member := range strings.Split(vary, ",") {
+		member = strings.TrimSpace(member)
+		if member == "*" || strings.EqualFold(member, field) {
+			return
+		}
+	}
+	h.Set("Vary", field)
+}
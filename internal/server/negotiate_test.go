@@ -0,0 +1,103 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	// negotiate runs Negotiate for a request with the given header lines
+	// and returns its choice and the Vary it left on the writer.
+	negotiate := func(fields string, offers ...Representation) (Representation, bool, string) {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n" + fields + "\r\n"))
+		require.NoError(t, err)
+		w := response.NewWriter(io.Discard)
+		rep, ok := Negotiate(w, req, offers)
+		return rep, ok, w.Header().Get("Vary")
+	}
+	html := Representation{MediaType: "text/html"}
+	json := Representation{MediaType: "application/json"}
+
+	// Test: Media types are chosen by q-value and specificity
+	t.Run("Media type", func(t *testing.T) {
+		for accept, want := range map[string]Representation{
+			"application/json":                        json,
+			"text/html;q=0.5, application/json;q=0.9": json,
+			"text/*, application/json;q=0.9":          html,
+			"*/*;q=0.1, application/json":             json,
+			"*/*":                                     html,
+		} {
+			rep, ok, vary := negotiate("Accept: "+accept+"\r\n", html, json)
+			assert.True(t, ok, accept)
+			assert.Equal(t, want, rep, accept)
+			assert.Equal(t, "Accept", vary, accept)
+		}
+	})
+
+	// Test: Without the field the first offer wins
+	t.Run("Server preference", func(t *testing.T) {
+		rep, ok, _ := negotiate("", json, html)
+		assert.True(t, ok)
+		assert.Equal(t, json, rep)
+	})
+
+	// Test: Refusing every offer reports false
+	t.Run("Not acceptable", func(t *testing.T) {
+		_, ok, vary := negotiate("Accept: image/png, text/html;q=0\r\n", html, json)
+		assert.False(t, ok)
+		assert.Equal(t, "Accept", vary)
+	})
+
+	// Test: Language ranges match tag prefixes
+	t.Run("Language", func(t *testing.T) {
+		en := Representation{MediaType: "text/html", Language: "en-GB"}
+		tr := Representation{MediaType: "text/html", Language: "tr"}
+		rep, ok, vary := negotiate("Accept-Language: tr;q=0.8, en\r\n", tr, en)
+		assert.True(t, ok)
+		assert.Equal(t, en, rep)
+		assert.Equal(t, "Accept-Language", vary)
+
+		rep, _, _ = negotiate("Accept-Language: de, *;q=0.5, en;q=0.1\r\n", en, tr)
+		assert.Equal(t, tr, rep)
+	})
+
+	// Test: Identity stays acceptable unless refused
+	t.Run("Encoding", func(t *testing.T) {
+		gzip := Representation{Encoding: "gzip"}
+		plain := Representation{}
+		for fields, want := range map[string]Representation{
+			"Accept-Encoding: gzip\r\n":                   gzip,
+			"Accept-Encoding: br\r\n":                     plain,
+			"Accept-Encoding: \r\n":                       plain,
+			"Accept-Encoding: gzip;q=0.5, identity\r\n":   plain,
+			"Accept-Encoding: gzip;q=0.1, *;q=0\r\n":      gzip,
+			"Accept-Encoding: gzip;q=0, identity;q=0\r\n": {},
+		} {
+			rep, ok, vary := negotiate(fields, gzip, plain)
+			assert.Equal(t, want, rep, fields)
+			assert.Equal(t, fields != "Accept-Encoding: gzip;q=0, identity;q=0\r\n", ok, fields)
+			assert.Equal(t, "Accept-Encoding", vary, fields)
+		}
+	})
+
+	// Test: Vary lists every dimension the offers differ in, once
+	t.Run("Vary", func(t *testing.T) {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		require.NoError(t, err)
+		w := response.NewWriter(io.Discard)
+		w.Header().Set("Vary", "accept-encoding")
+		offers := []Representation{
+			{MediaType: "text/html", Language: "en", Encoding: "gzip"},
+			{MediaType: "text/html", Language: "tr"},
+		}
+		Negotiate(w, req, offers)
+		Negotiate(w, req, offers)
+		assert.Equal(t, "accept-encoding, Accept-Language", w.Header().Get("Vary"))
+	})
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load is what the server knows of its own load when a request arrives.
+type Load struct {
+	// Inflight is the number of requests being handled, not counting the
+	// one arriving.
+	Inflight int64
+	// Latency is a moving average of how long the handler took with recent
+	// requests. It decays toward zero while no requests finish, so a server
+	// shedding everything soon lets requests through again to measure.
+	Latency time.Duration
+}
+
+// OverloadDetector decides whether the server is overloaded. While it
+// reports true, new requests are answered at once with 503 and
+// Retry-After, before reaching the handler, so that the requests already
+// admitted can finish in time. It is called for every request, from many
+// goroutines at once.
+type OverloadDetector interface {
+	Overloaded(load Load) bool
+}
+
+// OverloadFunc adapts an ordinary function to an OverloadDetector.
+type OverloadFunc func(load Load) bool
+
+func (f OverloadFunc) Overloaded(load Load) bool {
+	return f(load)
+}
+
+// InflightDetector reports overload once max requests are in flight.
+func InflightDetector(max int64) OverloadDetector {
+	return OverloadFunc(func(load Load) bool {
+		return load.Inflight >= max
+	})
+}
+
+// LatencyDetector reports overload while the handler's moving average
+// latency is above target, a sign that requests are queueing for the CPU,
+// a database or another shared resource.
+func LatencyDetector(target time.Duration) OverloadDetector {
+	return OverloadFunc(func(load Load) bool {
+		return load.Latency > target
+	})
+}
+
+// heapSampleInterval is how often HeapDetector reads the heap size.
+const heapSampleInterval = 100 * time.Millisecond
+
+// HeapDetector reports overload while the live heap is above limit bytes,
+// read from the runtime at most every heapSampleInterval.
+func HeapDetector(limit uint64) OverloadDetector {
+	d := &heapDetector{limit: limit}
+	return OverloadFunc(func(Load) bool { return d.heap() > d.limit })
+}
+
+type heapDetector struct {
+	limit uint64
+
+	mu     sync.Mutex
+	sample [1]metrics.Sample
+	last   time.Time
+	bytes  atomic.Uint64
+}
+
+// heap returns the last heap size read, reading it anew if it is stale.
+// A goroutine finding another one reading uses the previous value.
+func (d *heapDetector) heap() uint64 {
+	if d.mu.TryLock() {
+		if now := time.Now(); now.Sub(d.last) >= heapSampleInterval {
+			d.sample[0].Name = "/memory/classes/heap/objects:bytes"
+			metrics.Read(d.sample[:])
+			d.bytes.Store(d.sample[0].Value.Uint64())
+			d.last = now
+		}
+		d.mu.Unlock()
+	}
+	return d.bytes.Load()
+}
+
+// latencyHalfLife is how quickly latencyAverage forgets: a sample's weight
+// halves each half-life, whether or not new samples arrive.
+const latencyHalfLife = time.Second
+
+// latencyAverage is an exponentially weighted moving average of handler
+// latency, weighted by time rather than by sample count.
+type latencyAverage struct {
+	mu   sync.Mutex
+	avg  float64
+	last time.Time
+}
+
+func (l *latencyAverage) observe(d time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// A new sample counts for as much as the time since the last one.
+	w := 1 - decay(now.Sub(l.last))
+	l.avg += w * (float64(d) - l.avg)
+	l.last = now
+}
+
+func (l *latencyAverage) get() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Duration(l.avg * decay(time.Since(l.last)))
+}
+
+// decay is the weight left to a sample elapsed old.
+func decay(elapsed time.Duration) float64 {
+	return math.Exp2(-float64(elapsed) / float64(latencyHalfLife))
+}
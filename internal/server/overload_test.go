@@ -0,0 +1,79 @@
+package server
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverloadDetectors(t *testing.T) {
+	// Test: InflightDetector trips at its maximum
+	t.Run("Inflight", func(t *testing.T) {
+		d := InflightDetector(2)
+		assert.False(t, d.Overloaded(Load{Inflight: 1}))
+		assert.True(t, d.Overloaded(Load{Inflight: 2}))
+	})
+
+	// Test: LatencyDetector trips above its target
+	t.Run("Latency", func(t *testing.T) {
+		d := LatencyDetector(100 * time.Millisecond)
+		assert.False(t, d.Overloaded(Load{Latency: 100 * time.Millisecond}))
+		assert.True(t, d.Overloaded(Load{Latency: 101 * time.Millisecond}))
+	})
+
+	// Test: HeapDetector compares the live heap with its limit
+	t.Run("Heap", func(t *testing.T) {
+		assert.True(t, HeapDetector(0).Overloaded(Load{}))
+		assert.False(t, HeapDetector(math.MaxUint64).Overloaded(Load{}))
+	})
+
+	// Test: The latency average follows samples and decays without them
+	t.Run("Average", func(t *testing.T) {
+		var l latencyAverage
+		l.observe(100 * time.Millisecond)
+		assert.InDelta(t, float64(100*time.Millisecond), float64(l.get()), float64(time.Millisecond))
+
+		l.last = l.last.Add(-latencyHalfLife)
+		assert.InDelta(t, float64(50*time.Millisecond), float64(l.get()), float64(time.Millisecond))
+	})
+}
+
+func TestOverloadShedding(t *testing.T) {
+	var overloaded atomic.Bool
+	var seen Load
+	s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}), Options{OverloadDetector: OverloadFunc(func(load Load) bool {
+		seen = load
+		return overloaded.Load()
+	})})
+	require.NoError(t, err)
+	defer s.Close()
+	url := "http://" + s.Addr().String() + "/"
+
+	// Test: Requests pass while the detector reports no overload
+	resp, err := client.NewClient().Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusLine.StatusCode)
+
+	// Test: While overloaded, requests get 503 and Retry-After
+	overloaded.Store(true)
+	resp, err = client.NewClient().Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, 503, resp.StatusLine.StatusCode)
+	assert.Equal(t, "1", resp.Headers.Get("Retry-After"))
+	assert.Equal(t, uint64(1), s.Stats().ShedRequests)
+
+	// Test: The detector is told of the handler's latency
+	assert.GreaterOrEqual(t, seen.Latency, 5*time.Millisecond)
+	assert.Equal(t, int64(0), seen.Inflight)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// MethodOverrideHeader names the header MethodOverride reads.
+const MethodOverrideHeader = "X-HTTP-Method-Override"
+
+// overridableMethods are the methods a POST may be turned into. Safe methods
+// are left out: a GET that is really a POST would dodge the checks meant for
+// state-changing requests.
+var overridableMethods = map[string]bool{
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
+}
+
+// MethodOverride is middleware for clients behind intermediaries that only
+// pass GET and POST. A POST carrying X-HTTP-Method-Override, or a "_method"
+// field in a form-encoded body, is handled as the method it names, which
+// must be PUT, PATCH or DELETE; other values are ignored. Handlers further
+// in, the access log included, see the effective method.
+func MethodOverride() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.Method == "POST" {
+				method := req.Headers.Get(MethodOverrideHeader)
+				if method == "" {
+					method = formMethod(req)
+				}
+				if method = strings.ToUpper(strings.TrimSpace(method)); overridableMethods[method] {
+					req.RequestLine.Method = method
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// formMethod returns the _method field of a form-encoded body, or "".
+func formMethod(req *request.Request) string {
+	mediaType, _, err := mime.ParseMediaType(req.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return ""
+	}
+	form, err := url.ParseQuery(string(req.Body))
+	if err != nil {
+		return ""
+	}
+	return form.Get("_method")
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMethodOverride(t *testing.T) {
+	h := MethodOverride()(reply(func(req *request.Request) string { return req.RequestLine.Method }))
+	post := func(method, header, contentType, body string) string {
+		raw := method + " / HTTP/1.1\r\nHost: x\r\n"
+		if header != "" {
+			raw += "X-HTTP-Method-Override: " + header + "\r\n"
+		}
+		if contentType != "" {
+			raw += "Content-Type: " + contentType + "\r\n"
+		}
+		return raw + fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+
+	cases := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"Header", post("POST", "delete", "", ""), "DELETE"},
+		{"Form field", post("POST", "", "application/x-www-form-urlencoded; charset=utf-8", "a=1&_method=PATCH"), "PATCH"},
+		{"Header wins", post("POST", "PUT", "application/x-www-form-urlencoded", "_method=DELETE"), "PUT"},
+		{"Not POST", post("GET", "DELETE", "", ""), "GET"},
+		{"Safe method refused", post("POST", "GET", "", ""), "POST"},
+		{"Other content type", post("POST", "", "text/plain", "_method=PUT"), "POST"},
+		{"No override", post("POST", "", "", ""), "POST"},
+	}
+	for _, tc := range cases {
+		// Test: Each case yields the expected effective method
+		t.Run(tc.name, func(t *testing.T) {
+			resp := serve(t, h, tc.raw)
+			assert.Equal(t, tc.want, string(resp.Body))
+		})
+	}
+}
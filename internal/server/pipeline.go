@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// pipelinable reports whether req may be handled while other requests on
+// its connection are. Only safe methods may be (RFC 9112 section 9.3.2),
+// and not requests that take the connection over or wait for a go-ahead.
+func pipelinable(req *request.Request) bool {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+	default:
+		return false
+	}
+	return req.Headers.Get("upgrade") == "" && req.Headers.Get("expect") == ""
+}
+
+// canPipeline reports whether req, just read, is to be handled together
+// with the requests the client already sent behind it.
+func (s *Server) canPipeline(req *request.Request, br *bufio.Reader) bool {
+	return s.opts.PipelineDepth > 1 && !s.opts.StreamBodies && br.Buffered() > 0 && pipelinable(req)
+}
+
+// servePipelined handles first, request number n on conn, together with
+// the pipelinable requests already waiting behind it, up to PipelineDepth
+// of them, each handler starting as soon as its request is read. It
+// returns once all are answered, with the number of the last request
+// answered, a request it read that must be handled on its own, if any, and
+// whether the connection stays open.
+func (s *Server) servePipelined(conn net.Conn, br *bufio.Reader, hr *headerReader, first *request.Request, n int) (int, *request.Request, bool) {
+	p := &pipeline{conn: conn}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	req := first
+	sl := p.add(s.writeDeadline(), s.opts.WriteStallTimeout)
+	w := response.NewWriter(sl)
+	for {
+		last := s.opts.MaxRequestsPerConn > 0 && n >= s.opts.MaxRequestsPerConn
+		if last {
+			w.Header().Replace("Connection", "close")
+		}
+		wg.Add(1)
+		go func(sl *pipeSlot, w *response.Writer, req *request.Request) {
+			defer wg.Done()
+			s.serve(w, req)
+			if errors.Is(w.Err(), os.ErrDeadlineExceeded) {
+				s.writeTimeouts.Add(1)
+			}
+			p.finish(sl, s.keepAlive(w, req))
+		}(sl, w, req)
+
+		if last || len(p.slots) >= s.opts.PipelineDepth || br.Buffered() == 0 || s.closed.Load() {
+			wg.Wait()
+			return n, nil, !last && p.keepAlive()
+		}
+
+		// The next request has started to arrive; its error response, if
+		// any, takes its turn like the others.
+		next := request.NewRequest()
+		if hr != nil {
+			hr.req = next
+		}
+		sl = p.add(s.writeDeadline(), s.opts.WriteStallTimeout)
+		w = response.NewWriter(sl)
+		if !s.readRequest(conn, w, br, hr, next, false) {
+			p.finish(sl, false)
+			wg.Wait()
+			return n + 1, nil, false
+		}
+		if !pipelinable(next) {
+			p.finish(sl, true)
+			wg.Wait()
+			return n, next, p.keepAlive()
+		}
+		req = next
+		n++
+	}
+}
+
+// pipeline writes the responses to a batch of pipelined requests to conn in
+// request order. The oldest response not yet finished, the head, goes
+// straight to conn as its handler writes it; the others are held in memory
+// until every response before them is done.
+type pipeline struct {
+	conn net.Conn
+
+	mu    sync.Mutex
+	slots []*pipeSlot
+	head  int
+	// closing is set once a response has ended the connection, and
+	// closeAfter is its slot; the responses after it are dropped.
+	closing    bool
+	closeAfter int
+	// err is the first error writing to conn.
+	err error
+}
+
+// pipeSlot is where the response to one request of a pipeline is written.
+type pipeSlot struct {
+	p        *pipeline
+	index    int
+	buf      bytes.Buffer
+	done     bool
+	deadline time.Time
+	stall    time.Duration
+}
+
+// add appends a slot for the next request, whose response must be written
+// by deadline, when that is not zero, and with no write stalling past
+// stall.
+func (p *pipeline) add(deadline time.Time, stall time.Duration) *pipeSlot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sl := &pipeSlot{p: p, index: len(p.slots), deadline: deadline, stall: stall}
+	p.slots = append(p.slots, sl)
+	return sl
+}
+
+func (sl *pipeSlot) Write(b []byte) (int, error) {
+	p := sl.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.err != nil:
+		return 0, p.err
+	case p.dropped(sl):
+		return len(b), nil
+	case sl.index != p.head:
+		return sl.buf.Write(b)
+	}
+	return p.write(sl, b)
+}
+
+// finish records that the response in sl is complete and whether the
+// connection may carry another after it, and hands conn to the next
+// response if sl was the head.
+func (p *pipeline) finish(sl *pipeSlot, keepAlive bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sl.done = true
+	if !keepAlive && (!p.closing || sl.index < p.closeAfter) {
+		p.closing = true
+		p.closeAfter = sl.index
+	}
+	for p.head < len(p.slots) && p.err == nil {
+		head := p.slots[p.head]
+		if head.buf.Len() > 0 && !p.dropped(head) {
+			p.write(head, head.buf.Bytes())
+		}
+		head.buf.Reset()
+		if !head.done {
+			return
+		}
+		p.head++
+	}
+}
+
+// keepAlive reports whether the connection is still usable once every
+// response is done.
+func (p *pipeline) keepAlive() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err == nil && !p.closing
+}
+
+// dropped reports whether sl comes after a response that ended the
+// connection. p.mu is held.
+func (p *pipeline) dropped(sl *pipeSlot) bool {
+	return p.closing && sl.index > p.closeAfter
+}
+
+// write sends b, part of the response in sl, to conn under that
+// response's write deadlines. p.mu is held.
+func (p *pipeline) write(sl *pipeSlot, b []byte) (int, error) {
+	d := sl.deadline
+	if sl.stall > 0 {
+		if t := time.Now().Add(sl.stall); d.IsZero() || t.Before(d) {
+			d = t
+		}
+	}
+	p.conn.SetWriteDeadline(d)
+	n, err := p.conn.Write(b)
+	if err != nil {
+		p.err = err
+	}
+	return n, err
+}
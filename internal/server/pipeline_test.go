@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelining(t *testing.T) {
+	// slow answers with the target after sleeping for ?ms=, keeping track
+	// of how many requests it handles at once.
+	var running, most atomic.Int32
+	var order []string
+	var mu sync.Mutex
+	slow := HandlerFunc(func(w *response.Writer, req *request.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+		}
+		mu.Lock()
+		order = append(order, req.RequestLine.Method+" "+req.RequestLine.RequestTarget)
+		mu.Unlock()
+
+		path, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		var ms int
+		fmt.Sscanf(query, "ms=%d", &ms)
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		h := headers.NewHeadersFromPairs("Content-Length", fmt.Sprint(len(path)))
+		if path == "/close" {
+			h.Replace("Connection", "close")
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(path))
+	})
+	start := func(t *testing.T, opts Options) net.Conn {
+		t.Helper()
+		running.Store(0)
+		most.Store(0)
+		order = nil
+		s, err := ServeWithOptions("127.0.0.1:0", slow, opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	get := func(target string) string {
+		return "GET " + target + " HTTP/1.1\r\nHost: x\r\n\r\n"
+	}
+	// bodies reads n responses and returns their bodies.
+	bodies := func(t *testing.T, conn net.Conn, n int) []string {
+		t.Helper()
+		rr := response.NewReader(conn)
+		var got []string
+		for range n {
+			resp, err := rr.ReadResponse(response.Options{})
+			require.NoError(t, err)
+			got = append(got, string(resp.Body))
+		}
+		return got
+	}
+	closed := func(t *testing.T, conn net.Conn) bool {
+		t.Helper()
+		_, err := conn.Read(make([]byte, 1))
+		return err == io.EOF
+	}
+	raw := get("/a?ms=30") + get("/b") + "POST /c HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nbody" + get("/d?ms=10") + get("/e")
+	want := []string{"/a", "/b", "/c", "/d", "/e"}
+
+	for _, depth := range []int{0, 4} {
+		// Test: Pipelined requests sent at once are answered in order
+		t.Run(fmt.Sprintf("Depth %d/One write", depth), func(t *testing.T) {
+			conn := start(t, Options{PipelineDepth: depth})
+			io.WriteString(conn, raw)
+			assert.Equal(t, want, bodies(t, conn, 5))
+		})
+
+		// Test: Requests split at odd boundaries, with lines and bodies
+		// torn across reads, are answered in order
+		t.Run(fmt.Sprintf("Depth %d/Odd chunks", depth), func(t *testing.T) {
+			for _, size := range []int{1, 3, 7, 13, 29} {
+				conn := start(t, Options{PipelineDepth: depth})
+				go func() {
+					for i := 0; i < len(raw); i += size {
+						io.WriteString(conn, raw[i:min(i+size, len(raw))])
+						time.Sleep(time.Millisecond)
+					}
+				}()
+				assert.Equal(t, want, bodies(t, conn, 5), "chunk size %d", size)
+			}
+		})
+	}
+
+	// Test: With a depth, safe requests run at once and unsafe ones wait
+	// for those before them
+	t.Run("Concurrency", func(t *testing.T) {
+		conn := start(t, Options{PipelineDepth: 4})
+		io.WriteString(conn, get("/a?ms=50")+get("/b?ms=50")+get("/c?ms=50")+
+			"DELETE /d HTTP/1.1\r\nHost: x\r\n\r\n"+get("/e"))
+		assert.Equal(t, []string{"/a", "/b", "/c", "/d", "/e"}, bodies(t, conn, 5))
+		assert.Equal(t, int32(3), most.Load())
+		mu.Lock()
+		assert.Equal(t, "DELETE /d", order[3])
+		mu.Unlock()
+	})
+
+	// Test: Without a depth, requests are handled one at a time
+	t.Run("Serial", func(t *testing.T) {
+		conn := start(t, Options{})
+		io.WriteString(conn, get("/a?ms=20")+get("/b?ms=20")+get("/c"))
+		assert.Equal(t, []string{"/a", "/b", "/c"}, bodies(t, conn, 3))
+		assert.Equal(t, int32(1), most.Load())
+	})
+
+	// Test: A response that closes the connection drops those after it
+	t.Run("Close", func(t *testing.T) {
+		conn := start(t, Options{PipelineDepth: 4})
+		io.WriteString(conn, get("/a?ms=30")+get("/close")+get("/b"))
+		assert.Equal(t, []string{"/a", "/close"}, bodies(t, conn, 2))
+		assert.True(t, closed(t, conn))
+	})
+
+	// Test: A malformed request is answered in its turn, then the
+	// connection closes
+	t.Run("Malformed", func(t *testing.T) {
+		conn := start(t, Options{PipelineDepth: 4})
+		io.WriteString(conn, get("/a?ms=30")+"GET /b HTTP/9.9\r\n\r\n"+get("/c"))
+		rr := response.NewReader(conn)
+		resp, err := rr.ReadResponse(response.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "/a", string(resp.Body))
+		resp, err = rr.ReadResponse(response.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 505, resp.StatusLine.StatusCode)
+		assert.True(t, closed(t, conn))
+	})
+
+	// Test: MaxRequestsPerConn counts pipelined requests
+	t.Run("MaxRequestsPerConn", func(t *testing.T) {
+		conn := start(t, Options{PipelineDepth: 4, MaxRequestsPerConn: 2})
+		io.WriteString(conn, get("/a")+get("/b")+get("/c"))
+		assert.Equal(t, []string{"/a", "/b"}, bodies(t, conn, 2))
+		assert.True(t, closed(t, conn))
+	})
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// maxProfileSeconds bounds the seconds parameter of CPU profiles and traces.
+const maxProfileSeconds = 300
+
+// Profiler returns a handler serving the runtime profiles that
+// net/http/pprof serves, for use with "go tool pprof" and "go tool trace".
+// It expects paths relative to its mount point:
+//
+//	r.Mount("/debug/pprof", server.Profiler())
+//
+// It then answers:
+//
+//	/                     an index of the profiles
+//	/profile?seconds=30   a CPU profile taken over that many seconds
+//	/trace?seconds=5      an execution trace
+//	/cmdline              the command line, NUL-separated
+//	/heap, /goroutine, …  a named profile; ?debug=1 gives text, and
+//	                      ?gc=1 runs a collection before a heap profile
+//
+// The index links relative to itself, so link to it with a trailing slash.
+// A CPU profile or trace holds the request for its whole duration, so a
+// server WriteTimeout must leave room for it. Profiles reveal a great deal
+// about a program; do not expose them to untrusted clients.
+func Profiler() Handler {
+	return HandlerFunc(serveProfile)
+}
+
+func serveProfile(w *response.Writer, req *request.Request) {
+	target := req.RequestLine.RequestTarget
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	name := strings.Trim(rawPath, "/")
+
+	switch name {
+	case "":
+		writeProfileIndex(w, req)
+	case "cmdline":
+		writeProfileBody(w, req, "text/plain; charset=utf-8", "", []byte(strings.Join(os.Args, "\x00")))
+	case "profile", "trace":
+		param := query.Get("seconds")
+		if param == "" {
+			param = map[string]string{"profile": "30", "trace": "1"}[name]
+		}
+		seconds, err := strconv.Atoi(param)
+		if err != nil || seconds <= 0 || seconds > maxProfileSeconds {
+			writeError(w, response.StatusBadRequest, fmt.Sprintf("seconds must be between 1 and %d", maxProfileSeconds))
+			return
+		}
+
+		var buf bytes.Buffer
+		if name == "profile" {
+			err = pprof.StartCPUProfile(&buf)
+		} else {
+			err = trace.Start(&buf)
+		}
+		if err != nil {
+			// Only one CPU profile or trace can run at a time.
+			writeError(w, response.StatusInternalServerError, "could not start "+name+": "+err.Error())
+			return
+		}
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+		case <-req.Context().Done():
+		}
+		if name == "profile" {
+			pprof.StopCPUProfile()
+		} else {
+			trace.Stop()
+		}
+		writeProfileBody(w, req, "application/octet-stream", name, buf.Bytes())
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			writeError(w, response.StatusNotFound, "unknown profile")
+			return
+		}
+		if name == "heap" && query.Get("gc") != "" {
+			runtime.GC()
+		}
+		debug, _ := strconv.Atoi(query.Get("debug"))
+
+		var buf bytes.Buffer
+		if err := p.WriteTo(&buf, debug); err != nil {
+			writeError(w, response.StatusInternalServerError, "could not write profile: "+err.Error())
+			return
+		}
+		if debug > 0 {
+			writeProfileBody(w, req, "text/plain; charset=utf-8", "", buf.Bytes())
+		} else {
+			writeProfileBody(w, req, "application/octet-stream", name, buf.Bytes())
+		}
+	}
+}
+
+// writeProfileBody sends body as a 200, offering it as a download named
+// after the profile when attachment is not empty.
+func writeProfileBody(w *response.Writer, req *request.Request, contentType, attachment string, body []byte) {
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	if attachment != "" {
+		h.Set("Content-Disposition", `attachment; filename="`+attachment+`"`)
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body)
+	}
+}
+
+func writeProfileIndex(w *response.Writer, req *request.Request) {
+	var b strings.Builder
+	b.WriteString("<html>\n<head><title>Profiles</title></head>\n<body>\n<h1>Profiles</h1>\n<ul>\n")
+	for _, p := range pprof.Profiles() {
+		name := html.EscapeString(p.Name())
+		fmt.Fprintf(&b, "<li><a href=\"%s?debug=1\">%s</a> (%d)</li>\n", name, name, p.Count())
+	}
+	b.WriteString("<li><a href=\"profile?seconds=30\">profile</a> (30s CPU profile)</li>\n")
+	b.WriteString("<li><a href=\"trace?seconds=1\">trace</a> (1s execution trace)</li>\n")
+	b.WriteString("<li><a href=\"cmdline\">cmdline</a></li>\n")
+	b.WriteString("</ul>\n</body>\n</html>\n")
+	writeProfileBody(w, req, "text/html; charset=utf-8", "", []byte(b.String()))
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfiler(t *testing.T) {
+	r := NewRouter()
+	r.Mount("/debug/pprof", Profiler())
+
+	// Test: The index lists the runtime profiles
+	t.Run("Index", func(t *testing.T) {
+		resp := serve(t, r, get("/debug/pprof/"))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Contains(t, string(resp.Body), `<a href="goroutine?debug=1">goroutine</a>`)
+		assert.Contains(t, string(resp.Body), `profile?seconds=30`)
+	})
+
+	// Test: Named profiles in binary and text form
+	t.Run("Named profile", func(t *testing.T) {
+		resp := serve(t, r, get("/debug/pprof/heap?gc=1"))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "application/octet-stream", resp.Headers.Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="heap"`, resp.Headers.Get("Content-Disposition"))
+		assert.NotEmpty(t, resp.Body)
+
+		resp = serve(t, r, get("/debug/pprof/goroutine?debug=1"))
+		assert.Contains(t, resp.Headers.Get("Content-Type"), "text/plain")
+		assert.Contains(t, string(resp.Body), "goroutine profile:")
+	})
+
+	// Test: A short CPU profile
+	t.Run("CPU profile", func(t *testing.T) {
+		resp := serve(t, r, get("/debug/pprof/profile?seconds=1"))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.NotEmpty(t, resp.Body)
+	})
+
+	// Test: Bad parameters and unknown profiles
+	t.Run("Errors", func(t *testing.T) {
+		for _, target := range []string{"/debug/pprof/profile?seconds=0", "/debug/pprof/trace?seconds=x", "/debug/pprof/profile?seconds=9999"} {
+			resp := serve(t, r, get(target))
+			assert.Equal(t, 400, resp.StatusLine.StatusCode, target)
+		}
+		resp := serve(t, r, get("/debug/pprof/nothing"))
+		assert.Equal(t, 404, resp.StatusLine.StatusCode)
+	})
+
+	// Test: The command line
+	t.Run("Cmdline", func(t *testing.T) {
+		resp := serve(t, r, get("/debug/pprof/cmdline"))
+		assert.Contains(t, string(resp.Body), "server.test")
+	})
+}
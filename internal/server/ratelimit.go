@@ -0,0 +1,99 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// RateLimiter limits how fast each client may send requests, with a token
+// bucket per client IP as req.ClientIP reports it: a client may send Burst
+// requests at once and then Rate a second. Requests beyond that get 429
+// Too Many Requests with a Retry-After saying when the next one will be
+// let through. Create one with NewRateLimiter and apply it with Middleware.
+type RateLimiter struct {
+	Rate  float64
+	Burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// tokenBucket is one client's allowance as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter letting each client send rate requests
+// a second with bursts of up to burst.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		Rate:    rate,
+		Burst:   burst,
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// Middleware returns middleware applying the limit to every request.
+func (l *RateLimiter) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			if wait := l.take(req.ClientIP()); wait > 0 {
+				body := []byte("too many requests\n")
+				h := response.GetDefaultHeaders(len(body))
+				response.SetRetryAfter(h, wait)
+				w.WriteStatusLine(response.StatusTooManyRequests)
+				w.WriteHeaders(*h)
+				w.WriteBody(body)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// take spends a token of client's bucket, returning zero, or, if none is
+// left, how long until one is.
+func (l *RateLimiter) take(client string) time.Duration {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	if l.Rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// sweep drops the buckets that have refilled, at most once a minute, so
+// that clients seen once do not pile up.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.Rate >= float64(l.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
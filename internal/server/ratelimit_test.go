@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(2, 3)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	h := l.Middleware()(reply(func(*request.Request) string { return "ok" }))
+
+	// send serves a request from remote and returns the response.
+	send := func(remote string) *response.Response {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		require.NoError(t, err)
+		req.RemoteAddr = remote
+		var buf bytes.Buffer
+		h.ServeHTTP(response.NewWriter(&buf), req)
+		resp, err := response.ResponseFromReader(&buf)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Test: A burst is let through, then 429 with Retry-After
+	t.Run("Burst", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, 200, send("10.0.0.1:1000").StatusLine.StatusCode)
+		}
+		resp := send("10.0.0.1:1001")
+		assert.Equal(t, 429, resp.StatusLine.StatusCode)
+		assert.Equal(t, "Too Many Requests", resp.StatusLine.ReasonPhrase)
+		assert.Equal(t, "1", resp.Headers.Get("Retry-After"))
+	})
+
+	// Test: Each client has its own bucket
+	t.Run("Per client", func(t *testing.T) {
+		assert.Equal(t, 200, send("10.0.0.2:1000").StatusLine.StatusCode)
+		assert.Equal(t, 429, send("10.0.0.1:1000").StatusLine.StatusCode)
+	})
+
+	// Test: Tokens come back at Rate a second
+	t.Run("Refill", func(t *testing.T) {
+		now = now.Add(500 * time.Millisecond)
+		assert.Equal(t, 200, send("10.0.0.1:1000").StatusLine.StatusCode)
+		assert.Equal(t, 429, send("10.0.0.1:1000").StatusLine.StatusCode)
+
+		now = now.Add(time.Hour)
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, 200, send("10.0.0.1:1000").StatusLine.StatusCode)
+		}
+	})
+
+	// Test: Refilled buckets are swept
+	t.Run("Sweep", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		send("10.0.0.3:1000")
+		assert.Len(t, l.buckets, 1)
+	})
+}
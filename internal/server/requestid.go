@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// RequestIDHeader carries the request ID between services.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds incoming IDs, which end up in logs.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID is middleware that gives every request an ID: the incoming
+// X-Request-ID when it is usable, a random one otherwise. The ID is set on
+// the request's headers, so proxies forward it, stored on its context, see
+// RequestIDFromContext, and echoed in the X-Request-ID response header,
+// error responses included.
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			id := req.Headers.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+				req.Headers.Replace(RequestIDHeader, id)
+			}
+
+			w.Header().Replace(RequestIDHeader, id)
+			ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+}
+
+// RequestIDFromContext returns the ID RequestID stored in ctx, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts IDs of visible ASCII characters, which are safe to
+// log and to send on in a header.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
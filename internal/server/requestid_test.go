@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID()(reply(func(req *request.Request) string {
+		seen = RequestIDFromContext(req.Context())
+		return req.Headers.Get(RequestIDHeader)
+	}))
+
+	// Test: A missing ID is generated and visible everywhere
+	t.Run("Generated", func(t *testing.T) {
+		resp := serve(t, h, get("/"))
+		id := resp.Headers.Get(RequestIDHeader)
+		assert.Regexp(t, `^[0-9a-f]{32}$`, id)
+		assert.Equal(t, id, seen)
+		assert.Equal(t, id, string(resp.Body), "set on the request for proxies to forward")
+	})
+
+	// Test: A usable incoming ID is kept
+	t.Run("Incoming", func(t *testing.T) {
+		resp := serve(t, h, "GET / HTTP/1.1\r\nHost: x\r\nX-Request-ID: abc-123\r\n\r\n")
+		assert.Equal(t, "abc-123", resp.Headers.Get(RequestIDHeader))
+		assert.Equal(t, "abc-123", seen)
+	})
+
+	// Test: Unsafe incoming IDs are replaced
+	t.Run("Invalid incoming", func(t *testing.T) {
+		long := strings.Repeat("a", maxRequestIDLength+1)
+		for _, id := range []string{long, "has space", "tab\there"} {
+			resp := serve(t, h, "GET / HTTP/1.1\r\nHost: x\r\nX-Request-ID: "+id+"\r\n\r\n")
+			assert.NotEqual(t, id, resp.Headers.Get(RequestIDHeader))
+			assert.Len(t, resp.Headers.Get(RequestIDHeader), 32)
+		}
+	})
+
+	// Test: Error responses written by inner handlers carry the ID
+	t.Run("Error responses", func(t *testing.T) {
+		resp := serve(t, RequestID()(NewRouter()), "GET /missing HTTP/1.1\r\nHost: x\r\nX-Request-ID: err-1\r\n\r\n")
+		assert.Equal(t, 404, resp.StatusLine.StatusCode)
+		assert.Equal(t, "err-1", resp.Headers.Get(RequestIDHeader))
+	})
+
+	// Test: The access log records the ID on either side of the middleware
+	t.Run("Access log", func(t *testing.T) {
+		inner := reply(func(*request.Request) string { return "" })
+		raw := "GET / HTTP/1.1\r\nHost: x\r\nX-Request-ID: log-7\r\n\r\n"
+
+		var out bytes.Buffer
+		serve(t, Chain(inner, AccessLog(&out, LogCommon), RequestID()), raw)
+		assert.True(t, strings.HasSuffix(out.String(), " log-7\n"), out.String())
+
+		out.Reset()
+		serve(t, Chain(inner, RequestID(), AccessLog(&out, LogCommon)), raw)
+		assert.True(t, strings.HasSuffix(out.String(), " log-7\n"), out.String())
+	})
+}
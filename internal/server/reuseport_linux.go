@@ -0,0 +1,24 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package server
+
+import "syscall"
+
+const reusePortSupported = true
+
+// soReusePort is SO_REUSEPORT, which package syscall does not define for
+// Linux.
+const soReusePort = 0xf
+
+// reusePort sets SO_REUSEPORT, letting several listeners bind the same
+// address while the kernel spreads incoming connections across them.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,11 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+
+package server
+
+import "syscall"
+
+const reusePortSupported = false
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// Router dispatches requests on method and path. Patterns are split into
+// "/"-separated segments; a segment written ":name" matches any single
+// segment and makes it available as req.PathValue("name"). A final segment
+// written "*name" matches the rest of the path, slashes included and
+// possibly empty; the name may be left out. Literal segments win over
+// parameters, and parameters over wildcards, when several match.
+//
+//	r := server.NewRouter()
+//	r.GET("/users/:id", func(w *response.Writer, req *request.Request) {
+//		id := req.PathValue("id")
+//		...
+//	})
+type Router struct {
+	// NotFound handles requests no route matches. Nil means a plain 404.
+	NotFound Handler
+	// RedirectTrailingSlash redirects a path no route matches to the same
+	// path with the trailing slash added or removed, when that one has a
+	// route: 301 for GET and HEAD, 308 otherwise so the method is kept. The
+	// query is carried over.
+	RedirectTrailingSlash bool
+
+	root *routeNode
+}
+
+// routeNode is one segment of the route tree.
+type routeNode struct {
+	children map[string]*routeNode
+	// param is the child matching any segment, named paramName.
+	param     *routeNode
+	paramName string
+	// wildcard is the child matching all remaining segments.
+	wildcard     *routeNode
+	wildcardName string
+	// handlers maps methods to handlers; the empty method matches any.
+	handlers map[string]Handler
+	// pattern is the pattern that registered the handlers, for Metrics.
+	pattern string
+}
+
+func NewRouter() *Router {
+	return &Router{root: &routeNode{}}
+}
+
+// Handle registers h for method and pattern; an empty method matches every
+// method. It panics on a malformed pattern, on a route registered twice and
+// on two parameter or wildcard names at the same position, since those are
+// programming errors.
+func (r *Router) Handle(method, pattern string, h Handler) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic(fmt.Sprintf("router: pattern %q must start with /", pattern))
+	}
+
+	n := r.root
+	segs := splitPath(pattern)
+	for i, seg := range segs {
+		if name, ok := strings.CutPrefix(seg, "*"); ok {
+			if i != len(segs)-1 {
+				panic(fmt.Sprintf("router: pattern %q has a wildcard before its last segment", pattern))
+			}
+			if n.wildcard == nil {
+				n.wildcard = &routeNode{}
+				n.wildcardName = name
+			} else if n.wildcardName != name {
+				panic(fmt.Sprintf("router: pattern %q names wildcard %q where another route uses %q", pattern, name, n.wildcardName))
+			}
+			n = n.wildcard
+			continue
+		}
+		if name, ok := strings.CutPrefix(seg, ":"); ok {
+			if name == "" {
+				panic(fmt.Sprintf("router: pattern %q has an unnamed parameter", pattern))
+			}
+			if n.param == nil {
+				n.param = &routeNode{}
+				n.paramName = name
+			} else if n.paramName != name {
+				panic(fmt.Sprintf("router: pattern %q names parameter %q where another route uses %q", pattern, name, n.paramName))
+			}
+			n = n.param
+			continue
+		}
+
+		if n.children == nil {
+			n.children = map[string]*routeNode{}
+		}
+		child, ok := n.children[seg]
+		if !ok {
+			child = &routeNode{}
+			n.children[seg] = child
+		}
+		n = child
+	}
+
+	if n.handlers == nil {
+		n.handlers = map[string]Handler{}
+	}
+	if _, ok := n.handlers[method]; ok {
+		panic(fmt.Sprintf("router: %s %s registered twice", cmp.Or(method, "any method"), pattern))
+	}
+	n.handlers[method] = h
+	n.pattern = pattern
+}
+
+// HandlePrefix registers h for every method and every path under prefix,
+// which must end in "/". The handler sees the full path; the part after
+// the prefix is req.PathValue("path"). It suits attaching a static-file
+// handler or a whole sub-API without listing its routes.
+func (r *Router) HandlePrefix(prefix string, h Handler) {
+	if !strings.HasSuffix(prefix, "/") {
+		panic(fmt.Sprintf("router: prefix %q must end with /", prefix))
+	}
+	r.Handle("", prefix+"*path", h)
+}
+
+// Mount attaches h under prefix, for every method, with the prefix removed
+// from the path h sees: mounted at "/api/v1", a request for
+// "/api/v1/users?page=2" reaches h as "/users?page=2", and one for "/api/v1"
+// itself as "/". The prefix may hold parameters, which are set on the
+// request before h runs. h is typically another Router whose routes are
+// then written relative to the mount point.
+func (r *Router) Mount(prefix string, h Handler) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		panic("router: mount prefix must not be the root")
+	}
+	m := &mounted{prefix: prefix, segments: len(splitPath(prefix)), h: h}
+	r.Handle("", prefix, m)
+	r.Handle("", prefix+"/*", m)
+}
+
+// mounted serves h with the first segments of the path removed.
+type mounted struct {
+	prefix   string
+	segments int
+	h        Handler
+}
+
+func (m *mounted) ServeHTTP(w *response.Writer, req *request.Request) {
+	target := req.RequestLine.RequestTarget
+	if u, err := url.Parse(target); err == nil && u.IsAbs() {
+		target = u.RequestURI()
+	}
+	path, query, hasQuery := strings.Cut(target, "?")
+
+	inner := "/" + strings.Join(splitPath(path)[m.segments:], "/")
+	if hasQuery {
+		inner += "?" + query
+	}
+	// Copy the request so the outer handlers, such as an access log, still
+	// see the path the client asked for.
+	stripped := *req
+	stripped.RequestLine.RequestTarget = inner
+	addMount(req, m.prefix)
+	m.h.ServeHTTP(w, &stripped)
+}
+
+// HandleFunc registers a handler function for method and pattern.
+func (r *Router) HandleFunc(method, pattern string, f HandlerFunc) {
+	r.Handle(method, pattern, f)
+}
+
+func (r *Router) GET(pattern string, f HandlerFunc)    { r.Handle("GET", pattern, f) }
+func (r *Router) HEAD(pattern string, f HandlerFunc)   { r.Handle("HEAD", pattern, f) }
+func (r *Router) POST(pattern string, f HandlerFunc)   { r.Handle("POST", pattern, f) }
+func (r *Router) PUT(pattern string, f HandlerFunc)    { r.Handle("PUT", pattern, f) }
+func (r *Router) PATCH(pattern string, f HandlerFunc)  { r.Handle("PATCH", pattern, f) }
+func (r *Router) DELETE(pattern string, f HandlerFunc) { r.Handle("DELETE", pattern, f) }
+
+// ServeHTTP dispatches req to the handler registered for its method and
+// path. A path that matches only for other methods gets 405 with an Allow
+// header.
+func (r *Router) ServeHTTP(w *response.Writer, req *request.Request) {
+	path := requestPath(req.RequestLine.RequestTarget)
+
+	var params []string
+	n := r.root.match(splitPath(path), &params)
+	if n == nil {
+		if r.RedirectTrailingSlash && r.redirectSlash(w, req, path) {
+			return
+		}
+		r.notFound(w, req)
+		return
+	}
+	setRoute(req, n.pattern)
+
+	h, ok := n.handlers[req.RequestLine.Method]
+	if !ok {
+		h, ok = n.handlers[""]
+	}
+	if !ok {
+		methods := make([]string, 0, len(n.handlers))
+		for m := range n.handlers {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+
+		body := []byte("method not allowed\n")
+		hdrs := response.GetDefaultHeaders(len(body))
+		hdrs.Set("Allow", strings.Join(methods, ", "))
+		w.WriteStatusLine(response.StatusMethodNotAllowed)
+		w.WriteHeaders(*hdrs)
+		w.WriteBody(body)
+		return
+	}
+
+	for i := 0; i < len(params); i += 2 {
+		req.SetPathValue(params[i], params[i+1])
+	}
+	h.ServeHTTP(w, req)
+}
+
+// Methods returns the methods r has routes for, sorted, including those
+// of Routers mounted in it. Routes registered for every method add none.
+func (r *Router) Methods() []string {
+	set := map[string]bool{}
+	r.root.collectMethods(set)
+	methods := make([]string, 0, len(set))
+	for m := range set {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// collectMethods adds the methods registered at n and below it to set.
+func (n *routeNode) collectMethods(set map[string]bool) {
+	for m, h := range n.handlers {
+		if m != "" {
+			set[m] = true
+		} else if mt, ok := h.(*mounted); ok {
+			if ml, ok := mt.h.(MethodLister); ok {
+				for _, m := range ml.Methods() {
+					set[m] = true
+				}
+			}
+		}
+	}
+	for _, child := range n.children {
+		child.collectMethods(set)
+	}
+	if n.param != nil {
+		n.param.collectMethods(set)
+	}
+	if n.wildcard != nil {
+		n.wildcard.collectMethods(set)
+	}
+}
+
+func (r *Router) notFound(w *response.Writer, req *request.Request) {
+	if r.NotFound != nil {
+		r.NotFound.ServeHTTP(w, req)
+		return
+	}
+	writeError(w, response.StatusNotFound, "not found")
+}
+
+// redirectSlash redirects to path with its trailing slash toggled if a
+// route matches that, and reports whether it did.
+func (r *Router) redirectSlash(w *response.Writer, req *request.Request, path string) bool {
+	if path == "/" {
+		return false
+	}
+	alt, ok := strings.CutSuffix(path, "/")
+	if !ok {
+		alt = path + "/"
+	}
+	// Browsers read a Location starting "//" or "/\" as naming another
+	// host, so redirecting there would be an open redirect.
+	if strings.HasPrefix(alt, "//") || strings.HasPrefix(alt, "/\\") {
+		return false
+	}
+	var params []string
+	if r.root.match(splitPath(alt), &params) == nil {
+		return false
+	}
+
+	location := alt
+	if _, query, ok := strings.Cut(req.RequestLine.RequestTarget, "?"); ok {
+		location += "?" + query
+	}
+	statusCode := response.StatusPermanentRedirect
+	if m := req.RequestLine.Method; m == "GET" || m == "HEAD" {
+		statusCode = response.StatusMovedPermanently
+	}
+	response.Redirect(w, statusCode, response.EscapeLocation(location))
+	return true
+}
+
+// match finds the node for segs, preferring literal segments and falling
+// back to parameters. Matched parameters are appended to params as
+// name, value pairs.
+func (n *routeNode) match(segs []string, params *[]string) *routeNode {
+	if len(segs) == 0 {
+		if n.handlers == nil {
+			return nil
+		}
+		return n
+	}
+
+	if child, ok := n.children[segs[0]]; ok {
+		if found := child.match(segs[1:], params); found != nil {
+			return found
+		}
+	}
+
+	if n.param != nil && segs[0] != "" {
+		value, err := url.PathUnescape(segs[0])
+		if err != nil {
+			value = segs[0]
+		}
+		mark := len(*params)
+		*params = append(*params, n.paramName, value)
+		if found := n.param.match(segs[1:], params); found != nil {
+			return found
+		}
+		*params = (*params)[:mark]
+	}
+
+	if n.wildcard != nil && n.wildcard.handlers != nil {
+		rest := strings.Join(segs, "/")
+		value, err := url.PathUnescape(rest)
+		if err != nil {
+			value = rest
+		}
+		if n.wildcardName != "" {
+			*params = append(*params, n.wildcardName, value)
+		}
+		return n.wildcard
+	}
+	return nil
+}
+
+// splitPath splits a path into segments. A trailing slash yields a final
+// empty segment, so "/a" and "/a/" are different routes and "/" is a single
+// empty segment.
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// requestPath returns the path of a request target, which may be in origin
+// or absolute form, without the query.
+func requestPath(target string) string {
+	if u, err := url.Parse(target); err == nil && u.IsAbs() {
+		target = u.EscapedPath()
+	}
+	path, _, _ := strings.Cut(target, "?")
+	if path == "" {
+		return "/"
+	}
+	return path
+}
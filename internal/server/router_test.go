@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs h on a request parsed from raw and parses the response.
+func serve(t *testing.T, h Handler, raw string) *response.Response {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	h.ServeHTTP(response.NewWriter(&buf), req)
+	resp, err := response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	return resp
+}
+
+// reply answers with a fixed body built from the request.
+func reply(body func(req *request.Request) string) HandlerFunc {
+	return func(w *response.Writer, req *request.Request) {
+		b := body(req)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(b)))
+		w.WriteBody([]byte(b))
+	}
+}
+
+func get(target string) string {
+	return "GET " + target + " HTTP/1.1\r\nHost: x\r\n\r\n"
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	r.GET("/", reply(func(*request.Request) string { return "home" }))
+	r.GET("/users/:id", reply(func(req *request.Request) string { return "user " + req.PathValue("id") }))
+	r.GET("/users/me", reply(func(*request.Request) string { return "me" }))
+	r.POST("/users/:id", reply(func(req *request.Request) string { return "update " + req.PathValue("id") }))
+	r.GET("/users/:id/posts/:post", reply(func(req *request.Request) string {
+		return req.PathValue("id") + "/" + req.PathValue("post")
+	}))
+	r.GET("/dir/", reply(func(*request.Request) string { return "dir" }))
+
+	// Test: Literal and parameter routes
+	t.Run("Matching", func(t *testing.T) {
+		cases := map[string]string{
+			"/":                     "home",
+			"/users/42":             "user 42",
+			"/users/me":             "me",
+			"/users/7/posts/9":      "7/9",
+			"/users/42?tab=profile": "user 42",
+			"/users/a%20b":          "user a b",
+			"http://x/users/5":      "user 5",
+			"/dir/":                 "dir",
+		}
+		for target, want := range cases {
+			resp := serve(t, r, get(target))
+			assert.Equal(t, 200, resp.StatusLine.StatusCode, target)
+			assert.Equal(t, want, string(resp.Body), target)
+		}
+	})
+
+	// Test: Method dispatch
+	t.Run("Methods", func(t *testing.T) {
+		resp := serve(t, r, "POST /users/3 HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+		assert.Equal(t, "update 3", string(resp.Body))
+
+		resp = serve(t, r, "DELETE /users/3 HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, 405, resp.StatusLine.StatusCode)
+		assert.Equal(t, "GET, POST", resp.Headers.Get("Allow"))
+	})
+
+	// Test: Methods lists every routed method, mounted routers included
+	t.Run("Method list", func(t *testing.T) {
+		assert.Equal(t, []string{"GET", "POST"}, r.Methods())
+
+		api := NewRouter()
+		api.DELETE("/items/:id", reply(func(*request.Request) string { return "" }))
+		outer := NewRouter()
+		outer.GET("/", reply(func(*request.Request) string { return "" }))
+		outer.Mount("/api", api)
+		outer.HandlePrefix("/files/", reply(func(*request.Request) string { return "" }))
+		assert.Equal(t, []string{"DELETE", "GET"}, outer.Methods())
+	})
+
+	// Test: Unmatched paths
+	t.Run("Not found", func(t *testing.T) {
+		for _, target := range []string{"/nope", "/users", "/users/", "/users/1/posts", "/dir"} {
+			resp := serve(t, r, get(target))
+			assert.Equal(t, 404, resp.StatusLine.StatusCode, target)
+		}
+	})
+
+	// Test: Custom NotFound handler
+	t.Run("Custom NotFound", func(t *testing.T) {
+		r := NewRouter()
+		r.NotFound = reply(func(req *request.Request) string { return "no " + req.RequestLine.RequestTarget })
+
+		resp := serve(t, r, get("/missing"))
+		assert.Equal(t, "no /missing", string(resp.Body))
+	})
+
+	// Test: Trailing-slash variants redirect to the registered form
+	t.Run("Redirect trailing slash", func(t *testing.T) {
+		r := NewRouter()
+		r.RedirectTrailingSlash = true
+		r.GET("/docs/", reply(func(*request.Request) string { return "docs" }))
+		r.POST("/items", reply(func(*request.Request) string { return "items" }))
+
+		resp := serve(t, r, get("/docs?page=2"))
+		assert.Equal(t, 301, resp.StatusLine.StatusCode)
+		assert.Equal(t, "/docs/?page=2", resp.Headers.Get("Location"))
+
+		resp = serve(t, r, "POST /items/ HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+		assert.Equal(t, 308, resp.StatusLine.StatusCode)
+		assert.Equal(t, "/items", resp.Headers.Get("Location"))
+
+		resp = serve(t, r, get("/nothing/"))
+		assert.Equal(t, 404, resp.StatusLine.StatusCode)
+
+		r.RedirectTrailingSlash = false
+		resp = serve(t, r, get("/docs"))
+		assert.Equal(t, 404, resp.StatusLine.StatusCode)
+	})
+
+	// Test: A path that would redirect to another host is not redirected
+	t.Run("Redirect to another host", func(t *testing.T) {
+		r := NewRouter()
+		r.RedirectTrailingSlash = true
+		r.GET("/:user", reply(func(*request.Request) string { return "user" }))
+		r.GET("//evil.com", reply(func(*request.Request) string { return "evil" }))
+
+		for _, target := range []string{"/\\evil.com/", "//evil.com/"} {
+			resp := serve(t, r, get(target))
+			assert.Equal(t, 404, resp.StatusLine.StatusCode, target)
+			assert.Empty(t, resp.Headers.Get("Location"), target)
+		}
+	})
+
+	// Test: A bare LF in the query cannot split the redirect: the server
+	// refuses the request, and the Location of one that gets through is
+	// percent-encoded
+	t.Run("Redirect with LF in query", func(t *testing.T) {
+		r := NewRouter()
+		r.RedirectTrailingSlash = true
+		r.GET("/about/", reply(func(*request.Request) string { return "about" }))
+
+		url := startServer(t, r)
+		conn, err := net.Dial("tcp", url[len("http://"):])
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "GET /about?x\nSet-Cookie:pwned=1 HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusLine.StatusCode)
+		assert.Empty(t, resp.Headers.Get("Set-Cookie"))
+
+		req, err := request.RequestFromReader(strings.NewReader(get("/about")))
+		require.NoError(t, err)
+		req.RequestLine.RequestTarget = "/about?x\nSet-Cookie:pwned=1 \xff"
+		var buf bytes.Buffer
+		r.ServeHTTP(response.NewWriter(&buf), req)
+		resp, err = response.ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, 301, resp.StatusLine.StatusCode)
+		assert.Equal(t, "/about/?x%0ASet-Cookie:pwned=1%20%FF", resp.Headers.Get("Location"))
+		assert.Empty(t, resp.Headers.Get("Set-Cookie"))
+	})
+
+	// Test: Mounted handlers see paths relative to the mount point
+	t.Run("Mount", func(t *testing.T) {
+		api := NewRouter()
+		api.GET("/", reply(func(*request.Request) string { return "api root" }))
+		api.GET("/users/:id", reply(func(req *request.Request) string {
+			return "user " + req.PathValue("id") + " of " + req.PathValue("org") + " " + req.RequestLine.RequestTarget
+		}))
+
+		r := NewRouter()
+		r.Mount("/orgs/:org/api/", api)
+		outer := HandlerFunc(func(w *response.Writer, req *request.Request) {
+			r.ServeHTTP(w, req)
+			assert.Equal(t, "/orgs/acme/api/users/7?x=1", req.RequestLine.RequestTarget, "outer request untouched")
+		})
+
+		resp := serve(t, outer, get("/orgs/acme/api/users/7?x=1"))
+		assert.Equal(t, "user 7 of acme /users/7?x=1", string(resp.Body))
+
+		for _, target := range []string{"/orgs/acme/api", "/orgs/acme/api/"} {
+			resp = serve(t, r, get(target))
+			assert.Equal(t, "api root", string(resp.Body), target)
+		}
+
+		resp = serve(t, r, get("/orgs/acme/api/nope"))
+		assert.Equal(t, 404, resp.StatusLine.StatusCode)
+		assert.Panics(t, func() { r.Mount("/", api) })
+	})
+
+	// Test: Conflicting registrations panic
+	t.Run("Conflicts", func(t *testing.T) {
+		r := NewRouter()
+		h := reply(func(*request.Request) string { return "" })
+		r.GET("/a/:id", h)
+		assert.Panics(t, func() { r.GET("/a/:id", h) })
+		assert.Panics(t, func() { r.GET("/a/:name/b", h) })
+		assert.Panics(t, func() { r.GET("a", h) })
+		assert.Panics(t, func() { r.GET("/a/:", h) })
+	})
+	// Test: Wildcards match the rest of the path
+	t.Run("Wildcards", func(t *testing.T) {
+		r := NewRouter()
+		r.GET("/static/*filepath", reply(func(req *request.Request) string { return "file " + req.PathValue("filepath") }))
+		r.GET("/static/index", reply(func(*request.Request) string { return "index" }))
+		r.GET("/files/:id/*rest", reply(func(req *request.Request) string {
+			return req.PathValue("id") + ":" + req.PathValue("rest")
+		}))
+
+		cases := map[string]string{
+			"/static/css/site.css": "file css/site.css",
+			"/static/":             "file ",
+			"/static/index":        "index",
+			"/static/index/more":   "file index/more",
+			"/static/a%20b.txt":    "file a b.txt",
+			"/files/3/x/y":         "3:x/y",
+		}
+		for target, want := range cases {
+			resp := serve(t, r, get(target))
+			assert.Equal(t, 200, resp.StatusLine.StatusCode, target)
+			assert.Equal(t, want, string(resp.Body), target)
+		}
+		assert.Equal(t, 404, serve(t, r, get("/static")).StatusLine.StatusCode)
+
+		assert.Panics(t, func() { r.GET("/static/*other", reply(nil)) })
+		assert.Panics(t, func() { r.GET("/a/*rest/b", reply(nil)) })
+	})
+
+	// Test: A root wildcard catches everything, including "/"
+	t.Run("Root wildcard", func(t *testing.T) {
+		r := NewRouter()
+		r.GET("/*", reply(func(*request.Request) string { return "catch-all" }))
+		r.GET("/health", reply(func(*request.Request) string { return "ok" }))
+
+		assert.Equal(t, "catch-all", string(serve(t, r, get("/")).Body))
+		assert.Equal(t, "catch-all", string(serve(t, r, get("/a/b")).Body))
+		assert.Equal(t, "ok", string(serve(t, r, get("/health")).Body))
+	})
+
+	// Test: HandlePrefix attaches a handler for every method under a prefix
+	t.Run("HandlePrefix", func(t *testing.T) {
+		r := NewRouter()
+		r.HandlePrefix("/api/", reply(func(req *request.Request) string {
+			return req.RequestLine.Method + " " + req.PathValue("path")
+		}))
+
+		assert.Equal(t, "GET v1/users", string(serve(t, r, get("/api/v1/users")).Body))
+		resp := serve(t, r, "DELETE /api/v1/users/2 HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, "DELETE v1/users/2", string(resp.Body))
+		assert.Panics(t, func() { r.HandlePrefix("/nope", reply(nil)) })
+	})
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// DefaultSecurityHeaders are the headers SecurityHeaders sets unless told
+// otherwise. Browsers ignore Strict-Transport-Security on plain HTTP, so it
+// is harmless there.
+var DefaultSecurityHeaders = map[string]string{
+	"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+	"X-Content-Type-Options":    "nosniff",
+	"X-Frame-Options":           "DENY",
+	"Referrer-Policy":           "strict-origin-when-cross-origin",
+	"Content-Security-Policy":   "default-src 'self'",
+}
+
+// SecurityHeaders is middleware that adds DefaultSecurityHeaders to every
+// response, error responses included. Entries in overrides replace the
+// default of the same name, matched without regard to case, or drop it when
+// their value is empty, and may add headers of their own. A handler that
+// sets one of the headers itself keeps its value.
+func SecurityHeaders(overrides map[string]string) Middleware {
+	set := make(map[string]string, len(DefaultSecurityHeaders))
+	for name, value := range DefaultSecurityHeaders {
+		set[strings.ToLower(name)] = value
+	}
+	for name, value := range overrides {
+		name = strings.ToLower(name)
+		if value == "" {
+			delete(set, name)
+		} else {
+			set[name] = value
+		}
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w *response.Writer, req *request.Request) {
+			for name, value := range set {
+				w.Header().Replace(name, value)
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	ok := reply(func(*request.Request) string { return "ok" })
+
+	// Test: The defaults are set on every response
+	t.Run("Defaults", func(t *testing.T) {
+		resp := serve(t, SecurityHeaders(nil)(ok), get("/"))
+		for name, value := range DefaultSecurityHeaders {
+			assert.Equal(t, value, resp.Headers.Get(name), name)
+		}
+	})
+
+	// Test: Overrides replace, drop and add headers
+	t.Run("Overrides", func(t *testing.T) {
+		h := SecurityHeaders(map[string]string{
+			"X-Frame-Options":           "SAMEORIGIN",
+			"strict-transport-security": "",
+			"Permissions-Policy":        "camera=()",
+		})(ok)
+		resp := serve(t, h, get("/"))
+		assert.Equal(t, "SAMEORIGIN", resp.Headers.Get("X-Frame-Options"))
+		assert.Empty(t, resp.Headers.Get("Strict-Transport-Security"))
+		assert.Equal(t, "camera=()", resp.Headers.Get("Permissions-Policy"))
+		assert.Equal(t, "nosniff", resp.Headers.Get("X-Content-Type-Options"))
+	})
+
+	// Test: A handler's own value wins
+	t.Run("Handler wins", func(t *testing.T) {
+		h := SecurityHeaders(nil)(HandlerFunc(func(w *response.Writer, req *request.Request) {
+			hdrs := response.GetDefaultHeaders(0)
+			hdrs.Set("Content-Security-Policy", "default-src *")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*hdrs)
+		}))
+		resp := serve(t, h, get("/"))
+		assert.Equal(t, "default-src *", resp.Headers.Get("Content-Security-Policy"))
+	})
+
+	// Test: Error responses carry the headers too
+	t.Run("Errors", func(t *testing.T) {
+		resp := serve(t, SecurityHeaders(nil)(NewRouter()), get("/missing"))
+		assert.Equal(t, 404, resp.StatusLine.StatusCode)
+		assert.Equal(t, "DENY", resp.Headers.Get("X-Frame-Options"))
+	})
+}
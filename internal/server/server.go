@@ -0,0 +1,839 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/h2"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// Handler responds to a request by writing the response parts to w. The
+// server reuses req once ServeHTTP returns, so the handler must not keep req
+// or its Headers past that; req.Body is never reused and may be kept, but a
+// streamed req.BodyReader must not be read after ServeHTTP returns.
+type Handler interface {
+	ServeHTTP(w *response.Writer, req *request.Request)
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(w *response.Writer, req *request.Request)
+
+func (f HandlerFunc) ServeHTTP(w *response.Writer, req *request.Request) {
+	f(w, req)
+}
+
+// Server accepts connections and hands each request to its handler. A
+// connection carries requests until one of them or its response asks to
+// close it, or its response is framed so that nothing can follow it; as
+// GetDefaultHeaders says Connection: close, that is usually after one.
+type Server struct {
+	handler   Handler
+	listeners []net.Listener
+	opts      Options
+	closed    atomic.Bool
+
+	mu sync.Mutex
+	// conns tracks each open connection: whether it is busy with a
+	// request, and if not, since when it has been idle.
+	conns map[net.Conn]*connState
+	wg    sync.WaitGroup
+
+	inflight         atomic.Int64
+	rejectedConns    atomic.Uint64
+	rejectedRequests atomic.Uint64
+	blockedConns     atomic.Uint64
+	bannedConns      atomic.Uint64
+	parseErrors      atomic.Uint64
+	headerTimeouts   atomic.Uint64
+	reapedConns      atomic.Uint64
+	writeTimeouts    atomic.Uint64
+	shedRequests     atomic.Uint64
+	latency          latencyAverage
+}
+
+// Stats is a snapshot of a server's load and of the work it turned away.
+type Stats struct {
+	// Conns is the number of open connections.
+	Conns int
+	// IdleConns is the number of open connections waiting for a request.
+	IdleConns int
+	// InflightRequests is the number of requests being handled.
+	InflightRequests int64
+	// RejectedConns counts connections refused over MaxConns.
+	RejectedConns uint64
+	// RejectedRequests counts requests refused over MaxInflightRequests.
+	RejectedRequests uint64
+	// BlockedConns counts connections dropped by the IPFilter.
+	BlockedConns uint64
+	// BannedConns counts connections dropped because the AbuseTracker
+	// banned their source.
+	BannedConns uint64
+	// ParseErrors counts requests answered with an error because they could
+	// not be parsed or were too large.
+	ParseErrors uint64
+	// HeaderTimeouts counts requests answered with 408 because their headers
+	// missed ReadHeaderTimeout or MinHeaderRate.
+	HeaderTimeouts uint64
+	// ReapedConns counts idle connections closed for IdleTimeout or
+	// MaxIdleConns.
+	ReapedConns uint64
+	// WriteTimeouts counts responses cut off by WriteTimeout or
+	// WriteStallTimeout.
+	WriteTimeouts uint64
+	// ShedRequests counts requests refused because the OverloadDetector
+	// reported overload.
+	ShedRequests uint64
+}
+
+// Options configures a server. The zero value serves plain HTTP with no
+// timeouts and the request package's default body limit.
+type Options struct {
+	// TLSConfig, if set, makes the server speak HTTPS. It must hold at least
+	// one certificate. Unless it sets NextProtos, the server advertises
+	// http/1.1 with ALPN, and h2 first with EnableHTTP2; either way it
+	// closes connections that negotiate a protocol it does not serve
+	// before reading from them. ClientCAs
+	// and ClientAuth ask for client certificates, whose verified chain
+	// handlers get from Request.VerifiedChain; see ClientCert.
+	TLSConfig *tls.Config
+	// EnableHTTP2 serves HTTP/2 on TLS connections that negotiate h2 with
+	// ALPN, through the experimental internal/h2 package. Each stream is
+	// handed to the handler as a request with HttpVersion "2"; handlers
+	// cannot hijack it. The timeouts other than IdleTimeout apply to the
+	// TLS handshake only, and StreamBodies does not apply.
+	EnableHTTP2 bool
+	// ReadTimeout bounds waiting for and reading each request, body
+	// included.
+	ReadTimeout time.Duration
+	// ReadHeaderTimeout bounds reading the request line and headers.
+	// Clients that miss it get 408 Request Timeout. Zero means no limit
+	// beyond ReadTimeout.
+	ReadHeaderTimeout time.Duration
+	// MinHeaderRate is the slowest, in bytes per second, a client may send
+	// the request line and headers after a first second of grace; slower
+	// clients get 408 as with ReadHeaderTimeout. Zero means no minimum.
+	MinHeaderRate int
+	// WriteTimeout bounds writing the response, counted from the end of the
+	// request.
+	WriteTimeout time.Duration
+	// WriteStallTimeout bounds each write of a response to the connection,
+	// so a client that stops reading is dropped without cutting off long
+	// downloads to clients that keep up. Zero means no limit.
+	WriteStallTimeout time.Duration
+	// IdleTimeout is how long a connection may wait for its next request
+	// before it is closed. Zero means no limit beyond ReadTimeout.
+	IdleTimeout time.Duration
+	// MaxIdleConns caps the connections waiting for a request; past it the
+	// longest idle ones are closed. Zero means no limit.
+	MaxIdleConns int
+	// MaxRequestsPerConn is the most requests served on one connection.
+	// The response to the last carries Connection: close, unless the
+	// handler sets Connection itself, and the connection is closed after
+	// it, so that long-lived clients reconnect and a load balancer in
+	// front can spread them anew. Zero means no limit.
+	MaxRequestsPerConn int
+	// MaxBodySize is the largest request body accepted; larger ones get 413.
+	MaxBodySize int64
+	// StreamBodies hands requests to the handler as soon as their headers
+	// are read, with the body in req.BodyReader instead of req.Body. The
+	// body is read from the connection only as the handler reads it: no
+	// more than the connection's 4 KB read buffer is held unread, and the
+	// rest waits in the socket, so a slow handler slows the client down
+	// through TCP flow control instead of the body filling memory. A
+	// connection whose body the handler left unread is closed after the
+	// response.
+	StreamBodies bool
+	// PipelineDepth is how many pipelined requests, sent by the client one
+	// behind the other without waiting for responses, may be handled at
+	// once. Responses still go out in request order; those finished early
+	// are held in memory until the ones before them are done. Only requests
+	// with safe methods are handled alongside others (RFC 9112 section
+	// 9.3.2); any other waits for those before it to be answered. Zero or
+	// one handles pipelined requests one at a time, as does StreamBodies.
+	PipelineDepth int
+	// ParserProfile sets what the request parser tolerates. The default,
+	// request.Lenient, skips empty lines ahead of a request; request.Strict
+	// refuses requests that parsers are known to disagree on:
+	// Transfer-Encoding, a missing or repeated Host, stray whitespace or
+	// underscores in field names and control characters anywhere. They
+	// get 400 before reaching the handler.
+	ParserProfile request.ParserProfile
+	// MaxConns caps open connections. Connections beyond it are answered
+	// with 503 and closed straight after Accept, before any parsing. Zero
+	// means no limit.
+	MaxConns int
+	// MaxInflightRequests caps the requests being handled at once. Requests
+	// beyond it get 503 with Retry-After instead of reaching the handler.
+	// Zero means no limit.
+	MaxInflightRequests int
+	// OverloadDetector, if set, is asked before each request is handled
+	// whether the server is overloaded; while it is, requests get 503 with
+	// Retry-After straight away. See InflightDetector, LatencyDetector and
+	// HeapDetector.
+	OverloadDetector OverloadDetector
+	// IPFilter, if set, is checked against each connection's source right
+	// after Accept; refused connections are closed without a response.
+	IPFilter *IPFilter
+	// AbuseTracker, if set, is told of every request answered with a parse
+	// error, and connections from the addresses it bans are closed right
+	// after Accept.
+	AbuseTracker *AbuseTracker
+	// Listeners is how many listeners to open on the address, sharing it
+	// through SO_REUSEPORT so that the kernel spreads connections across
+	// them; each has its own accept loops. More than one is only
+	// supported on Linux. Zero means one.
+	Listeners int
+	// AcceptLoops is how many goroutines accept connections from each
+	// listener. Zero means one.
+	AcceptLoops int
+	// EnableTrace has the server answer TRACE requests itself, echoing
+	// the request line and headers back as message/http with
+	// Authorization, Proxy-Authorization and Cookie removed, which helps
+	// find what intermediaries changed on the way. It is off by default
+	// because the echo can reveal fields a proxy added; TRACE then goes to
+	// the handler like any other method.
+	EnableTrace bool
+	// DrainRetryAfter, if positive, answers requests read once Shutdown or
+	// Close has begun with 503 and a Retry-After of this long instead of
+	// handing them to the handler, so that clients come back to whatever
+	// replaces the server. Zero serves them while draining as before.
+	DrainRetryAfter time.Duration
+	// TrustedProxies are the peers whose Forwarded and X-Forwarded-For/Proto
+	// headers Request.ClientIP and Request.Scheme believe.
+	TrustedProxies []netip.Prefix
+}
+
+// Serve starts a server on port, answering in the background until Close is
+// called. Port 0 picks a free port; see Addr.
+func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithOptions(fmt.Sprintf(":%d", port), handler, Options{})
+}
+
+// ServeWithOptions starts a server listening on addr, a host:port pair, and
+// configured by opts.
+func ServeWithOptions(addr string, handler Handler, opts Options) (*Server, error) {
+	listeners, err := listenShards(addr, max(opts.Listeners, 1))
+	if err != nil {
+		return nil, err
+	}
+	if cfg := opts.TLSConfig; cfg != nil {
+		if len(cfg.NextProtos) == 0 {
+			cfg = cfg.Clone()
+			cfg.NextProtos = []string{alpnHTTP11}
+			if opts.EnableHTTP2 {
+				cfg.NextProtos = []string{h2.ALPN, alpnHTTP11}
+			}
+		}
+		for i, l := range listeners {
+			listeners[i] = tls.NewListener(l, cfg)
+		}
+	}
+
+	s := &Server{
+		handler:   handler,
+		listeners: listeners,
+		opts:      opts,
+		conns:     map[net.Conn]*connState{},
+	}
+	for _, l := range listeners {
+		for range max(opts.AcceptLoops, 1) {
+			go s.listen(l)
+		}
+	}
+	if opts.IdleTimeout > 0 {
+		go s.reapIdle()
+	}
+	return s, nil
+}
+
+// listenShards opens n listeners on addr. Past the first they share the
+// address with SO_REUSEPORT, binding the port the first was given when
+// addr asks for any.
+func listenShards(addr string, n int) ([]net.Listener, error) {
+	if n == 1 {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+	if !reusePortSupported {
+		return nil, fmt.Errorf("server: %d listeners need SO_REUSEPORT, which this platform lacks", n)
+	}
+
+	lc := net.ListenConfig{Control: reusePort}
+	listeners := make([]net.Listener, 0, n)
+	for range n {
+		l, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		addr = l.Addr().String()
+	}
+	return listeners, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listeners[0].Addr()
+}
+
+// closeListeners closes every listener, returning the first error.
+func (s *Server) closeListeners() error {
+	var first error
+	for _, l := range s.listeners {
+		if err := l.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Stats returns the server's current counters.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	conns := len(s.conns)
+	idle := 0
+	for _, st := range s.conns {
+		if !st.active && !st.closing {
+			idle++
+		}
+	}
+	s.mu.Unlock()
+
+	return Stats{
+		Conns:            conns,
+		IdleConns:        idle,
+		InflightRequests: s.inflight.Load(),
+		RejectedConns:    s.rejectedConns.Load(),
+		RejectedRequests: s.rejectedRequests.Load(),
+		BlockedConns:     s.blockedConns.Load(),
+		BannedConns:      s.bannedConns.Load(),
+		ParseErrors:      s.parseErrors.Load(),
+		HeaderTimeouts:   s.headerTimeouts.Load(),
+		ReapedConns:      s.reapedConns.Load(),
+		WriteTimeouts:    s.writeTimeouts.Load(),
+		ShedRequests:     s.shedRequests.Load(),
+	}
+}
+
+// Close stops accepting connections. Requests already being handled run to
+// completion.
+func (s *Server) Close() error {
+	s.closed.Store(true)
+	return s.closeListeners()
+}
+
+// Shutdown stops accepting connections, closes those still waiting for a
+// request and waits for the requests being handled to finish. If ctx ends
+// first, the remaining connections are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed.Store(true)
+	err := s.closeListeners()
+	var goAways []func()
+	for conn, st := range s.conns {
+		if st.goAway != nil {
+			goAways = append(goAways, st.goAway)
+		} else if !st.active {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+	// An HTTP/2 connection finishes its open streams before closing. It
+	// takes its own lock before s.mu, so it is told without holding s.mu.
+	for _, goAway := range goAways {
+		goAway()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Bounds of the delay before accepting again after Accept fails.
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+func (s *Server) listen(l net.Listener) {
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.closed.Load() {
+				return
+			}
+			// Errors such as EMFILE last until connections close, so back
+			// off, doubling up to a second as net/http does, rather than
+			// spin and flood the log.
+			delay = min(max(2*delay, minAcceptDelay), maxAcceptDelay)
+			log.Printf("server: accept: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		if s.opts.IPFilter != nil && !s.opts.IPFilter.Allowed(conn.RemoteAddr()) {
+			s.blockedConns.Add(1)
+			conn.Close()
+			continue
+		}
+		if at := s.opts.AbuseTracker; at != nil && at.Banned(conn.RemoteAddr()) {
+			s.bannedConns.Add(1)
+			if at.Tarpit > 0 {
+				go tarpit(conn, at.Tarpit)
+			} else {
+				conn.Close()
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closed.Load() {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		if s.opts.MaxConns > 0 && len(s.conns) >= s.opts.MaxConns {
+			s.mu.Unlock()
+			s.rejectedConns.Add(1)
+			go rejectConn(conn)
+			continue
+		}
+		s.conns[conn] = &connState{idleSince: time.Now()}
+		s.closeExcessIdle()
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+// connState is what the server tracks of an open connection.
+type connState struct {
+	active    bool
+	idleSince time.Time
+	// closing is set once the server has closed the connection for idling
+	// and its goroutine has yet to notice.
+	closing bool
+	// goAway shuts an HTTP/2 connection down gracefully.
+	goAway func()
+}
+
+// setActive records whether conn is handling a request, which Shutdown
+// waits for, or is idle, which Shutdown closes.
+func (s *Server) setActive(conn net.Conn, active bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.conns[conn]
+	st.active = active
+	if !active {
+		st.idleSince = time.Now()
+		s.closeExcessIdle()
+	}
+}
+
+// readerPool and requestPool recycle the read buffer and the parsed Request
+// of each connection, which are otherwise allocated anew per request.
+var (
+	readerPool  sync.Pool
+	requestPool = sync.Pool{New: func() any { return request.NewRequest() }}
+)
+
+func getReader(src io.Reader) *bufio.Reader {
+	if br, ok := readerPool.Get().(*bufio.Reader); ok {
+		br.Reset(src)
+		return br
+	}
+	return bufio.NewReader(src)
+}
+
+func putReader(br *bufio.Reader) {
+	br.Reset(nil)
+	readerPool.Put(br)
+}
+
+func (s *Server) handle(conn net.Conn) {
+	var w *response.Writer
+	req := requestPool.Get().(*request.Request)
+	var src io.Reader = conn
+	var hr *headerReader
+	if s.opts.ReadHeaderTimeout > 0 || s.opts.MinHeaderRate > 0 {
+		hr = newHeaderReader(conn, req, s.opts)
+		src = hr
+	}
+	br := getReader(src)
+	defer func() {
+		// A hijacked connection belongs to the handler now, and with it
+		// the request and any bytes buffered behind it.
+		if w == nil || !w.Hijacked() {
+			conn.Close()
+			putReader(br)
+			req.Reset()
+			requestPool.Put(req)
+		}
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	if tc, ok := conn.(*tls.Conn); ok && s.opts.EnableHTTP2 {
+		if !s.handshake(tc) {
+			return
+		}
+		if tc.ConnectionState().NegotiatedProtocol == h2.ALPN {
+			s.serveHTTP2(tc)
+			return
+		}
+	}
+
+	// next is a request read while serving pipelined ones, to be served
+	// on its own after them.
+	var next *request.Request
+	for n := 1; ; n++ {
+		w = response.NewWriter(conn)
+		w.SetBufferedReader(br)
+		cur := req
+		if next != nil {
+			cur, next = next, nil
+			w.SetWriteTimeout(s.writeDeadline(), s.opts.WriteStallTimeout)
+		} else if !s.readRequest(conn, w, br, hr, req, n == 1) {
+			return
+		}
+		if s.canPipeline(cur, br) {
+			var keepAlive bool
+			n, next, keepAlive = s.servePipelined(conn, br, hr, cur, n)
+			if hr != nil {
+				hr.req = req
+			}
+			if !keepAlive {
+				return
+			}
+			req.Reset()
+			if next == nil {
+				s.setActive(conn, false)
+			}
+			continue
+		}
+
+		last := s.opts.MaxRequestsPerConn > 0 && n >= s.opts.MaxRequestsPerConn
+		if last {
+			w.Header().Replace("Connection", "close")
+		}
+		s.serve(w, cur)
+		if errors.Is(w.Err(), os.ErrDeadlineExceeded) {
+			s.writeTimeouts.Add(1)
+		}
+		if last || !s.keepAlive(w, cur) {
+			return
+		}
+		req.Reset()
+		s.setActive(conn, false)
+	}
+}
+
+// readRequest reads the next request on conn into req. It reports whether
+// there is a request to serve; if not, it has answered any error and the
+// connection is to be closed.
+func (s *Server) readRequest(conn net.Conn, w *response.Writer, br *bufio.Reader, hr *headerReader, req *request.Request, first bool) bool {
+	var deadline time.Time
+	if s.opts.ReadTimeout > 0 {
+		deadline = time.Now().Add(s.opts.ReadTimeout)
+		conn.SetReadDeadline(deadline)
+	}
+	if hr != nil {
+		// The first request is timed from the accept; later ones from
+		// their first byte, after however long the connection sat idle.
+		hr.reset(deadline, !first)
+	}
+
+	// Until the request's first byte the connection is idle. If none
+	// comes, the client went away or the connection was closed for idling.
+	_, err := br.Peek(1)
+	if err == nil && !alpnAllowed(conn) {
+		return false
+	}
+	if err == nil {
+		s.setActive(conn, true)
+		err = request.ReadRequestInto(req, br, request.Options{
+			MaxBodySize: s.opts.MaxBodySize,
+			Profile:     s.opts.ParserProfile,
+			StreamBody:  s.opts.StreamBodies,
+		})
+	} else if !hr.timedOut() {
+		return false
+	}
+	w.SetWriteTimeout(s.writeDeadline(), s.opts.WriteStallTimeout)
+	if hr.timedOut() {
+		s.headerTimeouts.Add(1)
+		writeError(w, response.StatusRequestTimeout, "request header timeout")
+		return false
+	}
+	if err != nil {
+		s.badRequest(conn)
+		statusCode, message := response.StatusBadRequest, err.Error()
+		switch {
+		case errors.Is(err, request.ErrContentLengthTooLarge):
+			statusCode = response.StatusContentTooLarge
+		case errors.Is(err, request.ErrUnsupportedTransferCoding):
+			statusCode = response.StatusNotImplemented
+		case errors.Is(err, request.ErrUnsupportedHttpVer):
+			if errors.Is(err, request.ErrHTTP2Preface) {
+				// A client with prior knowledge of HTTP/2 will not read
+				// the answer, but it shows up in the log.
+				log.Printf("server: HTTP/2 connection preface from %s; only HTTP/1.1 is served", conn.RemoteAddr())
+			}
+			statusCode = response.StatusHTTPVersionNotSupported
+			message += "; supported: HTTP/1.1"
+		}
+		writeError(w, statusCode, message)
+		return false
+	}
+	if !req.Done() {
+		// The client went away before sending a whole request; answer only
+		// if it sent anything at all.
+		if req.RequestLine.Method != "" {
+			s.badRequest(conn)
+			writeError(w, response.StatusBadRequest, "incomplete request")
+		}
+		return false
+	}
+
+	if hasToken(req.Headers.Get("upgrade"), "h2c") {
+		// Declining an upgrade is done by answering over HTTP/1.1 as if
+		// it had not been offered (RFC 9110 section 7.8).
+		log.Printf("server: h2c upgrade from %s declined; serving over HTTP/1.1", conn.RemoteAddr())
+	}
+
+	req.RemoteAddr = conn.RemoteAddr().String()
+	req.TrustProxies(s.opts.TrustedProxies)
+	if tc, ok := conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		req.TLS = &state
+	}
+	return true
+}
+
+// writeDeadline returns the WriteTimeout deadline for a response whose
+// request has just been read, or zero when there is none.
+func (s *Server) writeDeadline() time.Time {
+	if s.opts.WriteTimeout > 0 {
+		return time.Now().Add(s.opts.WriteTimeout)
+	}
+	return time.Time{}
+}
+
+// alpnHTTP11 is the ALPN protocol ID of HTTP/1.1, the only one served
+// besides h2 with EnableHTTP2.
+const alpnHTTP11 = "http/1.1"
+
+// alpnAllowed reports whether conn, with its TLS handshake done, negotiated
+// HTTP/1.1 or no protocol at all, as clients without ALPN do. It logs
+// connections that negotiated something else.
+func alpnAllowed(conn net.Conn) bool {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return true
+	}
+	p := tc.ConnectionState().NegotiatedProtocol
+	if p != "" && p != alpnHTTP11 {
+		log.Printf("server: %s negotiated ALPN protocol %q; only %s is served", conn.RemoteAddr(), p, alpnHTTP11)
+		return false
+	}
+	return true
+}
+
+// handshake completes conn's TLS handshake, within ReadHeaderTimeout or
+// else ReadTimeout, so that the negotiated protocol is known before
+// anything is read. It reports whether the handshake succeeded.
+func (s *Server) handshake(conn *tls.Conn) bool {
+	if d := cmp.Or(s.opts.ReadHeaderTimeout, s.opts.ReadTimeout); d > 0 {
+		conn.SetDeadline(time.Now().Add(d))
+		defer conn.SetDeadline(time.Time{})
+	}
+	return conn.Handshake() == nil
+}
+
+// serveHTTP2 serves conn, which negotiated h2, until it is closed. The
+// connection counts as active while it has streams open.
+func (s *Server) serveHTTP2(conn *tls.Conn) {
+	state := conn.ConnectionState()
+	handler := HandlerFunc(func(w *response.Writer, req *request.Request) {
+		req.RemoteAddr = conn.RemoteAddr().String()
+		req.TrustProxies(s.opts.TrustedProxies)
+		req.TLS = &state
+		s.serve(w, req)
+	})
+	c := h2.NewConn(conn, handler, h2.Options{
+		Request: request.Options{
+			MaxBodySize: s.opts.MaxBodySize,
+			Profile:     s.opts.ParserProfile,
+		},
+		OnActive: func(active bool) { s.setActive(conn, active) },
+	})
+
+	s.mu.Lock()
+	s.conns[conn].goAway = c.GoAway
+	closed := s.closed.Load()
+	s.mu.Unlock()
+	if closed {
+		// Shutdown began before goAway was recorded.
+		c.GoAway()
+	}
+
+	if err := c.Serve(); err != nil {
+		log.Printf("server: HTTP/2 connection from %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// serve hands req to the handler, unless the server is draining with
+// DrainRetryAfter set, MaxInflightRequests is reached or the
+// OverloadDetector reports overload, and answers for a handler that panics
+// or writes nothing.
+func (s *Server) serve(w *response.Writer, req *request.Request) {
+	if s.opts.DrainRetryAfter > 0 && s.closed.Load() {
+		writeUnavailable(w, "server shutting down", s.opts.DrainRetryAfter)
+		return
+	}
+	n := s.inflight.Add(1)
+	if s.opts.MaxInflightRequests > 0 && n > int64(s.opts.MaxInflightRequests) {
+		s.inflight.Add(-1)
+		s.rejectedRequests.Add(1)
+		writeOverloaded(w)
+		return
+	}
+	defer s.inflight.Add(-1)
+
+	if d := s.opts.OverloadDetector; d != nil {
+		if d.Overloaded(Load{Inflight: n - 1, Latency: s.latency.get()}) {
+			s.shedRequests.Add(1)
+			writeOverloaded(w)
+			return
+		}
+		start := time.Now()
+		defer func() { s.latency.observe(time.Since(start)) }()
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("server: panic serving %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, v)
+			// Too late for an error response once the handler has started
+			// writing; the closed connection tells the client.
+			if w.StatusCode() == 0 && !w.Hijacked() {
+				writeError(w, response.StatusInternalServerError, "internal server error")
+			}
+		}
+	}()
+
+	if req.RequestLine.RequestTarget == "*" {
+		s.serveAsterisk(w)
+		return
+	}
+	if s.opts.EnableTrace && req.RequestLine.Method == "TRACE" {
+		serveTrace(w, req)
+		return
+	}
+	s.handler.ServeHTTP(w, req)
+
+	if w.StatusCode() == 0 && !w.Hijacked() {
+		// The handler wrote nothing: answer with an empty 200.
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}
+}
+
+// keepAlive reports whether the connection can go on to another request:
+// the server is open, the client did not ask to close, the request's body
+// has been read and the response was framed so the next one can follow it.
+func (s *Server) keepAlive(w *response.Writer, req *request.Request) bool {
+	if s.closed.Load() || w.Err() != nil || !w.KeepAlive(req.RequestLine.Method) || !req.BodyConsumed() {
+		return false
+	}
+	for _, token := range strings.Split(req.Headers.Get("connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "close") {
+			return false
+		}
+	}
+	return true
+}
+
+// badRequest counts a request from conn that could not be parsed or was too
+// large, and reports it to the AbuseTracker.
+func (s *Server) badRequest(conn net.Conn) {
+	s.parseErrors.Add(1)
+	if s.opts.AbuseTracker != nil {
+		s.opts.AbuseTracker.Report(conn.RemoteAddr())
+	}
+}
+
+// rejectConn answers a connection over MaxConns without reading from it.
+// The short deadline keeps a client that does not read from holding the
+// goroutine.
+func rejectConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	writeOverloaded(response.NewWriter(conn))
+}
+
+// overloadRetryAfter is how long clients refused for overload are asked to
+// wait.
+const overloadRetryAfter = time.Second
+
+// writeOverloaded sends a 503 asking the client to come back shortly.
+func writeOverloaded(w *response.Writer) {
+	writeUnavailable(w, "server overloaded", overloadRetryAfter)
+}
+
+// writeUnavailable sends a 503 with message, asking the client to come
+// back after retryAfter.
+func writeUnavailable(w *response.Writer, message string, retryAfter time.Duration) {
+	body := []byte(message + "\n")
+	h := response.GetDefaultHeaders(len(body))
+	response.SetRetryAfter(h, retryAfter)
+	w.WriteStatusLine(response.StatusServiceUnavailable)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
+
+// writeError sends a plain-text error response with message as the body.
+func writeError(w *response.Writer, statusCode response.StatusCode, message string) {
+	body := []byte(message + "\n")
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
@@ -0,0 +1,842 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves handler on a free port for the duration of the test and
+// returns its base URL.
+func startServer(t *testing.T, handler Handler) string {
+	t.Helper()
+
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return fmt.Sprintf("http://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+func TestServer(t *testing.T) {
+	// Test: Handler sees the request and writes the response
+	t.Run("Handler response", func(t *testing.T) {
+		url := startServer(t, HandlerFunc(func(w *response.Writer, req *request.Request) {
+			body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body))
+			w.WriteStatusLine(response.StatusCreated)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		}))
+
+		req, err := client.NewRequest("POST", url+"/items?x=1").Body([]byte("payload")).Build()
+		require.NoError(t, err)
+		resp, err := client.NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 201, resp.StatusLine.StatusCode)
+		assert.Equal(t, "Created", resp.StatusLine.ReasonPhrase)
+		assert.Equal(t, "POST /items?x=1 payload", string(resp.Body))
+	})
+
+	// Test: The handler sees the client's address
+	t.Run("Remote address", func(t *testing.T) {
+		url := startServer(t, HandlerFunc(func(w *response.Writer, req *request.Request) {
+			body := []byte(req.RemoteAddr)
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		}))
+
+		resp, err := client.NewClient().Get(url + "/")
+		require.NoError(t, err)
+		assert.Regexp(t, `^127\.0\.0\.1:\d+$`, string(resp.Body))
+	})
+
+	// Test: A handler that writes nothing yields an empty 200
+	t.Run("Empty handler", func(t *testing.T) {
+		url := startServer(t, HandlerFunc(func(*response.Writer, *request.Request) {}))
+
+		resp, err := client.NewClient().Get(url + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Empty(t, resp.Body)
+	})
+
+	// Test: Panics become 500 responses
+	t.Run("Panicking handler", func(t *testing.T) {
+		url := startServer(t, HandlerFunc(func(*response.Writer, *request.Request) {
+			panic("boom")
+		}))
+
+		resp, err := client.NewClient().Get(url + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 500, resp.StatusLine.StatusCode)
+	})
+
+	// Test: Malformed requests get a 400 without reaching the handler
+	t.Run("Malformed request", func(t *testing.T) {
+		called := false
+		url := startServer(t, HandlerFunc(func(*response.Writer, *request.Request) {
+			called = true
+		}))
+
+		conn, err := net.Dial("tcp", url[len("http://"):])
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "NOT A REQUEST\r\n\r\n")
+
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusLine.StatusCode)
+		assert.False(t, called)
+	})
+
+	// Test: An HTTP/2 preface gets 505 naming the supported version
+	t.Run("HTTP/2 preface", func(t *testing.T) {
+		called := false
+		url := startServer(t, HandlerFunc(func(*response.Writer, *request.Request) { called = true }))
+
+		conn, err := net.Dial("tcp", url[len("http://"):])
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 505, resp.StatusLine.StatusCode)
+		assert.Contains(t, string(resp.Body), "HTTP/2 connection preface; supported: HTTP/1.1")
+		assert.False(t, called)
+	})
+
+	// Test: A hijacked connection is left to the handler
+	t.Run("Hijack", func(t *testing.T) {
+		url := startServer(t, HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*headers.NewHeaders())
+			conn, err := w.Hijack()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// Echo one line in raw mode after the handler returned.
+				line := make([]byte, 5)
+				if _, err := io.ReadFull(conn, line); err == nil {
+					conn.Write(line)
+				}
+			}()
+		}))
+
+		conn, err := net.Dial("tcp", url[len("http://"):])
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+
+		head := make([]byte, len("HTTP/1.1 200 OK\r\n\r\n"))
+		_, err = io.ReadFull(conn, head)
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\n", string(head))
+
+		io.WriteString(conn, "ping\n")
+		echo := make([]byte, 5)
+		_, err = io.ReadFull(conn, echo)
+		require.NoError(t, err)
+		assert.Equal(t, "ping\n", string(echo))
+	})
+
+	// Test: Close stops accepting connections
+	t.Run("Close", func(t *testing.T) {
+		s, err := Serve(0, HandlerFunc(func(*response.Writer, *request.Request) {}))
+		require.NoError(t, err)
+		addr := s.Addr().String()
+		require.NoError(t, s.Close())
+
+		_, err = net.Dial("tcp", addr)
+		require.Error(t, err)
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	// Test: In-flight requests finish before Shutdown returns
+	t.Run("Waits for in-flight requests", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		s, err := Serve(0, HandlerFunc(func(w *response.Writer, req *request.Request) {
+			close(started)
+			<-release
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(4))
+			w.WriteBody([]byte("done"))
+		}))
+		require.NoError(t, err)
+		addr := s.Addr().String()
+
+		type result struct {
+			resp *response.Response
+			err  error
+		}
+		results := make(chan result, 1)
+		go func() {
+			resp, err := client.NewClient().Get("http://" + addr + "/")
+			results <- result{resp, err}
+		}()
+		<-started
+
+		shutdownDone := make(chan error, 1)
+		go func() { shutdownDone <- s.Shutdown(context.Background()) }()
+
+		select {
+		case <-shutdownDone:
+			t.Fatal("Shutdown returned while a request was in flight")
+		case <-time.After(50 * time.Millisecond):
+		}
+		_, err = net.Dial("tcp", addr)
+		assert.Error(t, err, "new connections are refused")
+
+		close(release)
+		require.NoError(t, <-shutdownDone)
+		r := <-results
+		require.NoError(t, r.err)
+		assert.Equal(t, "done", string(r.resp.Body))
+	})
+
+	// Test: Connections that have not sent a request are closed at once
+	t.Run("Closes idle connections", func(t *testing.T) {
+		s, err := Serve(0, HandlerFunc(func(*response.Writer, *request.Request) {}))
+		require.NoError(t, err)
+
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		// Let the server register the connection.
+		time.Sleep(20 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		start := time.Now()
+		require.NoError(t, s.Shutdown(ctx))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+		_, err = conn.Read(make([]byte, 1))
+		assert.Error(t, err)
+	})
+
+	// Test: A context that ends first aborts the remaining requests
+	t.Run("Context deadline", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		started := make(chan struct{})
+		s, err := Serve(0, HandlerFunc(func(w *response.Writer, req *request.Request) {
+			close(started)
+			<-release
+		}))
+		require.NoError(t, err)
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := client.NewClient().Get("http://" + s.Addr().String() + "/")
+			errs <- err
+		}()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+		assert.Error(t, <-errs, "the client sees its connection closed")
+	})
+
+	// Test: DrainRetryAfter refuses requests read after Shutdown began
+	t.Run("Drain Retry-After", func(t *testing.T) {
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+			writeError(w, response.StatusOK, "served")
+		}), Options{DrainRetryAfter: 30 * time.Second})
+		require.NoError(t, err)
+		addr := s.Addr().String()
+
+		// A request half sent keeps its connection open through Shutdown.
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, "GET / HTTP/1.1\r\n")
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			st := s.Stats()
+			return st.Conns == 1 && st.IdleConns == 0
+		}, time.Second, time.Millisecond)
+
+		shutdownDone := make(chan error, 1)
+		go func() { shutdownDone <- s.Shutdown(context.Background()) }()
+		require.Eventually(t, func() bool {
+			c, err := net.Dial("tcp", addr)
+			if err == nil {
+				c.Close()
+			}
+			return err != nil
+		}, time.Second, time.Millisecond)
+
+		_, err = io.WriteString(conn, "Host: x\r\n\r\n")
+		require.NoError(t, err)
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 503, resp.StatusLine.StatusCode)
+		assert.Equal(t, "30", resp.Headers.Get("Retry-After"))
+		assert.Equal(t, "close", resp.Headers.Get("Connection"))
+		require.NoError(t, <-shutdownDone)
+	})
+}
+
+func TestServeWithOptions(t *testing.T) {
+	start := func(t *testing.T, opts Options) string {
+		t.Helper()
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(req.Body)))
+			w.WriteBody(req.Body)
+		}), opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s.Addr().String()
+	}
+
+	// Test: Several accept loops share one listener
+	t.Run("AcceptLoops", func(t *testing.T) {
+		addr := start(t, Options{AcceptLoops: 4})
+		for range 20 {
+			resp, err := client.NewClient().Get("http://" + addr + "/")
+			require.NoError(t, err)
+			assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		}
+	})
+
+	// Test: Listener shards bind one address and all serve it
+	t.Run("Listeners", func(t *testing.T) {
+		if !reusePortSupported {
+			_, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {}), Options{Listeners: 2})
+			require.Error(t, err)
+			return
+		}
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(0))
+		}), Options{Listeners: 4, AcceptLoops: 2})
+		require.NoError(t, err)
+		require.Len(t, s.listeners, 4)
+		for _, l := range s.listeners {
+			assert.Equal(t, s.Addr().String(), l.Addr().String())
+		}
+		for range 40 {
+			resp, err := client.NewClient().Get("http://" + s.Addr().String() + "/")
+			require.NoError(t, err)
+			assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		}
+
+		require.NoError(t, s.Close())
+		_, err = net.Dial("tcp", s.Addr().String())
+		assert.Error(t, err)
+	})
+
+	// Test: A failing Accept is retried with a growing delay, not in a spin
+	t.Run("Accept errors", func(t *testing.T) {
+		l := &failingListener{}
+		s := &Server{conns: map[net.Conn]*connState{}}
+		done := make(chan struct{})
+		go func() {
+			s.listen(l)
+			close(done)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		s.closed.Store(true)
+		<-done
+
+		// 5, 10, 20, 40 and 80 ms apart: a handful of calls, not thousands.
+		assert.GreaterOrEqual(t, l.calls.Load(), int32(3))
+		assert.LessOrEqual(t, l.calls.Load(), int32(8))
+	})
+
+	// Test: Bodies over MaxBodySize get 413
+	t.Run("MaxBodySize", func(t *testing.T) {
+		addr := start(t, Options{MaxBodySize: 4})
+
+		req, err := client.NewRequest("POST", "http://"+addr+"/").Body([]byte("abcd")).Build()
+		require.NoError(t, err)
+		resp, err := client.NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+
+		req, err = client.NewRequest("POST", "http://"+addr+"/").Body([]byte("abcde")).Build()
+		require.NoError(t, err)
+		resp, err = client.NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 413, resp.StatusLine.StatusCode)
+	})
+
+	// Test: A request that does not arrive within ReadTimeout is dropped
+	t.Run("ReadTimeout", func(t *testing.T) {
+		addr := start(t, Options{ReadTimeout: 50 * time.Millisecond})
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n")
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusLine.StatusCode)
+	})
+
+	// Test: Forwarding headers from TrustedProxies name the client
+	t.Run("TrustedProxies", func(t *testing.T) {
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+			body := []byte(req.ClientIP() + " " + req.Scheme())
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		}), Options{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}})
+		require.NoError(t, err)
+		defer s.Close()
+
+		req, err := client.NewRequest("GET", "http://"+s.Addr().String()+"/").
+			Header("X-Forwarded-For", "198.51.100.1").Header("X-Forwarded-Proto", "https").Build()
+		require.NoError(t, err)
+		resp, err := client.NewClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, "198.51.100.1 https", string(resp.Body))
+	})
+
+	// Test: The Strict profile answers a CL.TE request with 400
+	t.Run("Strict profile", func(t *testing.T) {
+		addr := start(t, Options{ParserProfile: request.Strict})
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusLine.StatusCode)
+	})
+
+	// Test: Headers not in by ReadHeaderTimeout get 408
+	t.Run("ReadHeaderTimeout", func(t *testing.T) {
+		addr := start(t, Options{ReadHeaderTimeout: 50 * time.Millisecond})
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n")
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 408, resp.StatusLine.StatusCode)
+	})
+
+	// Test: The body is not held to ReadHeaderTimeout
+	t.Run("Slow body", func(t *testing.T) {
+		addr := start(t, Options{ReadHeaderTimeout: 50 * time.Millisecond})
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\n")
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(conn, "body")
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "body", string(resp.Body))
+	})
+
+	// Test: A client trickling its headers below MinHeaderRate gets 408
+	// long before ReadHeaderTimeout
+	t.Run("MinHeaderRate", func(t *testing.T) {
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(*response.Writer, *request.Request) {}),
+			Options{ReadHeaderTimeout: time.Minute, MinHeaderRate: 1000})
+		require.NoError(t, err)
+		defer s.Close()
+
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		go func() {
+			for _, b := range []byte("GET / HTTP/1.1\r\nHost: x\r\n") {
+				if _, err := conn.Write([]byte{b}); err != nil {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		}()
+
+		began := time.Now()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 408, resp.StatusLine.StatusCode)
+		assert.Less(t, time.Since(began), 3*time.Second)
+		assert.Equal(t, uint64(1), s.Stats().HeaderTimeouts)
+	})
+}
+
+func TestLimits(t *testing.T) {
+	// Test: Connections over MaxConns get 503 before any parsing
+	t.Run("MaxConns", func(t *testing.T) {
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(*response.Writer, *request.Request) {}), Options{MaxConns: 1})
+		require.NoError(t, err)
+		defer s.Close()
+
+		idle, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer idle.Close()
+		require.Eventually(t, func() bool { return s.Stats().Conns == 1 }, time.Second, 5*time.Millisecond)
+
+		resp, err := client.NewClient().Get("http://" + s.Addr().String() + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 503, resp.StatusLine.StatusCode)
+		assert.Equal(t, uint64(1), s.Stats().RejectedConns)
+
+		idle.Close()
+		require.Eventually(t, func() bool { return s.Stats().Conns == 0 }, time.Second, 5*time.Millisecond)
+		resp, err = client.NewClient().Get("http://" + s.Addr().String() + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+	})
+
+	// Test: Requests over MaxInflightRequests get 503 with Retry-After
+	t.Run("MaxInflightRequests", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.RequestTarget == "/slow" {
+				close(started)
+				<-release
+			}
+		}), Options{MaxInflightRequests: 1})
+		require.NoError(t, err)
+		defer s.Close()
+		url := "http://" + s.Addr().String()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			client.NewClient().Get(url + "/slow")
+		}()
+		<-started
+		assert.Equal(t, int64(1), s.Stats().InflightRequests)
+
+		resp, err := client.NewClient().Get(url + "/fast")
+		require.NoError(t, err)
+		assert.Equal(t, 503, resp.StatusLine.StatusCode)
+		assert.Equal(t, "1", resp.Headers.Get("Retry-After"))
+		assert.Equal(t, uint64(1), s.Stats().RejectedRequests)
+
+		close(release)
+		<-done
+		resp, err = client.NewClient().Get(url + "/fast")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+	})
+}
+
+// Test: Connections refused by the IPFilter are closed without a response
+func TestIPFilter(t *testing.T) {
+	filter, err := ParseIPFilter(nil, []string{"127.0.0.0/8"})
+	require.NoError(t, err)
+	s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(*response.Writer, *request.Request) {}), Options{IPFilter: filter})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, uint64(1), s.Stats().BlockedConns)
+}
+
+// Test: After enough malformed requests the client's connections are closed
+// without a response
+func TestAbuseBan(t *testing.T) {
+	tracker := NewAbuseTracker(2, time.Minute, time.Minute)
+	s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(*response.Writer, *request.Request) {}), Options{AbuseTracker: tracker})
+	require.NoError(t, err)
+	defer s.Close()
+
+	send := func(raw string) (*response.Response, error) {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, raw)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		return response.ResponseFromReader(conn)
+	}
+
+	for range 2 {
+		resp, err := send("NOT A REQUEST\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusLine.StatusCode)
+	}
+	require.Len(t, tracker.Bans(), 1)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, uint64(1), s.Stats().BannedConns)
+}
+
+func TestKeepAlive(t *testing.T) {
+	// echo answers with the target and no Connection: close, so the
+	// connection stays open.
+	echo := HandlerFunc(func(w *response.Writer, req *request.Request) {
+		body := []byte(req.RequestLine.RequestTarget)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*headers.NewHeadersFromPairs("Content-Length", fmt.Sprint(len(body))))
+		w.WriteBody(body)
+	})
+	start := func(t *testing.T, opts Options) (*Server, net.Conn) {
+		t.Helper()
+		s, err := ServeWithOptions("127.0.0.1:0", echo, opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		return s, conn
+	}
+	closed := func(t *testing.T, conn net.Conn) bool {
+		t.Helper()
+		_, err := conn.Read(make([]byte, 1))
+		return err == io.EOF
+	}
+
+	// Test: Several requests on one connection, then Connection: close
+	t.Run("Requests", func(t *testing.T) {
+		_, conn := start(t, Options{})
+		rr := response.NewReader(conn)
+		for _, target := range []string{"/a", "/b"} {
+			io.WriteString(conn, "GET "+target+" HTTP/1.1\r\nHost: x\r\n\r\n")
+			resp, err := rr.ReadResponse(response.Options{})
+			require.NoError(t, err)
+			assert.Equal(t, target, string(resp.Body))
+		}
+
+		io.WriteString(conn, "GET /c HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+		resp, err := rr.ReadResponse(response.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "/c", string(resp.Body))
+		assert.True(t, closed(t, conn))
+	})
+
+	// Test: The last request allowed by MaxRequestsPerConn is told to close
+	t.Run("MaxRequestsPerConn", func(t *testing.T) {
+		_, conn := start(t, Options{MaxRequestsPerConn: 2})
+		rr := response.NewReader(conn)
+		for _, target := range []string{"/a", "/b"} {
+			io.WriteString(conn, "GET "+target+" HTTP/1.1\r\nHost: x\r\n\r\n")
+			resp, err := rr.ReadResponse(response.Options{})
+			require.NoError(t, err)
+			assert.Equal(t, target, string(resp.Body))
+			if target == "/a" {
+				assert.Equal(t, "", resp.Headers.Get("Connection"))
+			} else {
+				assert.Equal(t, "close", resp.Headers.Get("Connection"))
+			}
+		}
+		assert.True(t, closed(t, conn))
+	})
+
+	// Test: A connection idle past IdleTimeout is closed
+	t.Run("IdleTimeout", func(t *testing.T) {
+		s, conn := start(t, Options{IdleTimeout: 50 * time.Millisecond})
+		io.WriteString(conn, "GET /a HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp, err := response.NewReader(conn).ReadResponse(response.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "/a", string(resp.Body))
+
+		assert.True(t, closed(t, conn))
+		assert.Equal(t, uint64(1), s.Stats().ReapedConns)
+	})
+
+	// Test: Past MaxIdleConns the longest idle connection is closed
+	t.Run("MaxIdleConns", func(t *testing.T) {
+		s, first := start(t, Options{MaxIdleConns: 1})
+		require.Eventually(t, func() bool { return s.Stats().IdleConns == 1 }, time.Second, 5*time.Millisecond)
+
+		second, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer second.Close()
+
+		assert.True(t, closed(t, first))
+		assert.Equal(t, uint64(1), s.Stats().ReapedConns)
+		io.WriteString(second, "GET /b HTTP/1.1\r\nHost: x\r\n\r\n")
+		second.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := response.NewReader(second).ReadResponse(response.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "/b", string(resp.Body))
+	})
+}
+
+func TestStreamBodies(t *testing.T) {
+	// Test: The handler reads the body itself and the connection stays open
+	t.Run("Read", func(t *testing.T) {
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+			body, _ := io.ReadAll(req.BodyReader)
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*headers.NewHeadersFromPairs("Content-Length", fmt.Sprint(len(body))))
+			w.WriteBody(body)
+		}), Options{StreamBodies: true})
+		require.NoError(t, err)
+		defer s.Close()
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		rr := response.NewReader(conn)
+		for _, body := range []string{"first", "second"} {
+			fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+			resp, err := rr.ReadResponse(response.Options{})
+			require.NoError(t, err)
+			assert.Equal(t, body, string(resp.Body))
+		}
+	})
+
+	// Test: A body the handler leaves unread closes the connection
+	t.Run("Unread", func(t *testing.T) {
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*headers.NewHeadersFromPairs("Content-Length", "0"))
+		}), Options{StreamBodies: true})
+		require.NoError(t, err)
+		defer s.Close()
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello")
+		rr := response.NewReader(conn)
+		resp, err := rr.ReadResponse(response.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		_, err = conn.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+	})
+
+	// Test: A handler that does not read holds back the uploader
+	t.Run("Backpressure", func(t *testing.T) {
+		release := make(chan struct{})
+		s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+			<-release
+			n, _ := io.Copy(io.Discard, req.BodyReader)
+			body := []byte(fmt.Sprint(n))
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		}), Options{StreamBodies: true, MaxBodySize: 64 << 20})
+		require.NoError(t, err)
+		defer s.Close()
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		const size = 64 << 20
+		fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n\r\n", size)
+		chunk := make([]byte, 64<<10)
+		sent := 0
+		conn.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+		for sent < size {
+			n, err := conn.Write(chunk)
+			sent += n
+			if err != nil {
+				break
+			}
+		}
+		// Only what the socket buffers hold got through.
+		assert.Less(t, sent, size)
+
+		close(release)
+		conn.SetWriteDeadline(time.Time{})
+		go func() {
+			for rest := size - sent; rest > 0; {
+				n, err := conn.Write(chunk[:min(len(chunk), rest)])
+				if err != nil {
+					return
+				}
+				rest -= n
+			}
+		}()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(size), string(resp.Body))
+	})
+}
+
+// Test: A handler streaming to a client that stops reading gets an error
+// once a write stalls past WriteStallTimeout
+func TestWriteStallTimeout(t *testing.T) {
+	result := make(chan error, 1)
+	s, err := ServeWithOptions("127.0.0.1:0", HandlerFunc(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*headers.NewHeadersFromPairs("Transfer-Encoding", "chunked"))
+		chunk := make([]byte, 64*1024)
+		for {
+			if _, err := w.WriteChunkedBody(chunk); err != nil {
+				result <- err
+				return
+			}
+		}
+	}), Options{WriteStallTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	select {
+	case err := <-result:
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still blocked writing")
+	}
+	require.Eventually(t, func() bool { return s.Stats().WriteTimeouts == 1 }, time.Second, 5*time.Millisecond)
+}
+
+// failingListener fails every Accept as a process out of file descriptors
+// would.
+type failingListener struct {
+	net.Listener
+	calls atomic.Int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.calls.Add(1)
+	return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", syscall.EMFILE)}
+}
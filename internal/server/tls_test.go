@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSignedCert returns a certificate valid for 127.0.0.1.
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestALPN(t *testing.T) {
+	cert := selfSignedCert(t)
+	negotiated := make(chan string, 1)
+	handler := HandlerFunc(func(w *response.Writer, req *request.Request) {
+		negotiated <- req.TLS.NegotiatedProtocol
+	})
+	serveTLS := func(nextProtos ...string) string {
+		s, err := ServeWithOptions("127.0.0.1:0", handler, Options{
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: nextProtos},
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s.Addr().String()
+	}
+	get := func(addr string, nextProtos ...string) (*response.Response, error) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: nextProtos})
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		return response.ResponseFromReader(conn)
+	}
+	addr := serveTLS()
+
+	// Test: http/1.1 is advertised and seen by the handler
+	t.Run("HTTP/1.1", func(t *testing.T) {
+		resp, err := get(addr, "h2", "http/1.1")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "http/1.1", <-negotiated)
+	})
+
+	// Test: Clients without ALPN are served
+	t.Run("No ALPN", func(t *testing.T) {
+		resp, err := get(addr)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "", <-negotiated)
+	})
+
+	// Test: A client offering only h2 fails the handshake
+	t.Run("Only h2 offered", func(t *testing.T) {
+		_, err := get(addr, "h2")
+		require.Error(t, err)
+	})
+
+	// Test: A connection that negotiates h2 anyway is closed unread
+	t.Run("h2 negotiated", func(t *testing.T) {
+		h2Addr := serveTLS("h2", "http/1.1")
+		_, err := get(h2Addr, "h2")
+		require.Error(t, err)
+		assert.Empty(t, negotiated)
+	})
+
+	// Test: With EnableHTTP2, h2 is advertised first and served
+	t.Run("HTTP/2", func(t *testing.T) {
+		s, err := ServeWithOptions("127.0.0.1:0", handler, Options{
+			TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}},
+			EnableHTTP2: true,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get("https://" + s.Addr().String() + "/")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "HTTP/2.0", resp.Proto)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "h2", <-negotiated)
+
+		// Test: HTTP/1.1 clients are still served
+		resp2, err := get(s.Addr().String(), "http/1.1")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp2.StatusLine.StatusCode)
+		assert.Equal(t, "http/1.1", <-negotiated)
+	})
+}
+
+// clientCA is a CA that issues client certificates for tests.
+type clientCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newClientCA(t *testing.T) *clientCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &clientCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a client certificate for name signed by the CA.
+func (ca *clientCA) issue(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCert(t *testing.T) {
+	ca := newClientCA(t)
+	handler := ClientCert(AllowCommonNames("alice"))(HandlerFunc(func(w *response.Writer, req *request.Request) {
+		body := []byte(UserFromContext(req.Context()) + " " + req.VerifiedChain()[1].Subject.CommonName)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}))
+	s, err := ServeWithOptions("127.0.0.1:0", handler, Options{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{selfSignedCert(t)},
+			ClientCAs:    ca.pool,
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	get := func(certs ...tls.Certificate) *response.Response {
+		conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: certs})
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Test: An allowed certificate reaches the handler with its identity
+	t.Run("Allowed", func(t *testing.T) {
+		resp := get(ca.issue(t, "alice"))
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "alice test client CA", string(resp.Body))
+	})
+
+	// Test: A valid certificate with another identity is refused
+	t.Run("Not allowed", func(t *testing.T) {
+		resp := get(ca.issue(t, "mallory"))
+		assert.Equal(t, 403, resp.StatusLine.StatusCode)
+	})
+
+	// Test: No certificate is refused
+	t.Run("Missing", func(t *testing.T) {
+		resp := get()
+		assert.Equal(t, 403, resp.StatusLine.StatusCode)
+		assert.Contains(t, string(resp.Body), "client certificate required")
+	})
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// traceHiddenFields are left out of TRACE echoes, so that a script able to
+// send TRACE cannot read back credentials the browser added for it
+// (cross-site tracing).
+var traceHiddenFields = []string{"authorization", "proxy-authorization", "cookie"}
+
+// serveTrace answers a TRACE (RFC 9110 section 9.3.8) with the request
+// line and headers as received, less traceHiddenFields, as a message/http
+// body. A TRACE carries no content, so any body sent is not echoed.
+func serveTrace(w *response.Writer, req *request.Request) {
+	echo := request.Request{RequestLine: req.RequestLine, Headers: *req.Headers.Clone()}
+	for _, key := range traceHiddenFields {
+		echo.Headers.Delete(key)
+	}
+	var body bytes.Buffer
+	echo.Write(&body)
+
+	h := response.GetDefaultHeaders(body.Len())
+	h.Replace("Content-Type", "message/http")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	w.WriteBody(body.Bytes())
+}
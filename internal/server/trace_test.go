@@ -0,0 +1,45 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	handler := HandlerFunc(func(w *response.Writer, req *request.Request) {
+		writeError(w, response.StatusMethodNotAllowed, "handler saw "+req.RequestLine.Method)
+	})
+
+	// Test: TRACE is echoed without credentials when enabled
+	t.Run("Enabled", func(t *testing.T) {
+		s, err := ServeWithOptions("127.0.0.1:0", handler, Options{EnableTrace: true})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		resp, err := liveResponse(s.Addr().String(), "TRACE /a?b=c HTTP/1.1\r\nHost: x\r\nVia: 1.1 edge\r\nAuthorization: Basic c2VjcmV0\r\nCookie: id=1\r\nX-Seen: yes\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.Equal(t, "message/http", resp.Headers.Get("content-type"))
+		assert.Equal(t, "TRACE /a?b=c HTTP/1.1\r\nhost: x\r\nvia: 1.1 edge\r\nx-seen: yes\r\n\r\n", string(resp.Body))
+
+		resp, err = liveResponse(s.Addr().String(), "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, "handler saw GET\n", string(resp.Body))
+	})
+
+	// Test: TRACE goes to the handler by default
+	t.Run("Disabled", func(t *testing.T) {
+		s, err := ServeWithOptions("127.0.0.1:0", handler, Options{})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		resp, err := liveResponse(s.Addr().String(), "TRACE / HTTP/1.1\r\nHost: x\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, 405, resp.StatusLine.StatusCode)
+		assert.Equal(t, "handler saw TRACE\n", string(resp.Body))
+	})
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// Tunnel answers CONNECT requests (RFC 9110 section 9.3.6) the way a
+// forward proxy does for HTTPS: it checks the authority-form target, dials
+// it, answers 200 Connection Established and then relays bytes both ways
+// until both sides are done. A target that is not host:port gets 400, one
+// Allow refuses 403, and one that cannot be reached 502, or 504 when the
+// dial times out. Other methods go to Next.
+type Tunnel struct {
+	// Dial connects to the target. Nil means a net.Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// DialTimeout bounds the dial. Zero means no limit beyond the
+	// request's context.
+	DialTimeout time.Duration
+	// Allow, if set, is asked whether host and port may be tunnelled to,
+	// for instance to keep clients to port 443.
+	Allow func(host string, port int) bool
+	// Header is added to every 200 answering a CONNECT, such as a Via.
+	Header *headers.Headers
+	// Next handles requests other than CONNECT. Nil answers them 405.
+	Next Handler
+}
+
+func (t *Tunnel) ServeHTTP(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "CONNECT" {
+		if t.Next != nil {
+			t.Next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Allow", "CONNECT")
+		writeError(w, response.StatusMethodNotAllowed, "only CONNECT is supported")
+		return
+	}
+
+	target := req.RequestLine.RequestTarget
+	host, port, ok := parseAuthority(target)
+	if !ok {
+		writeError(w, response.StatusBadRequest, "CONNECT target must be host:port")
+		return
+	}
+	if t.Allow != nil && !t.Allow(host, port) {
+		writeError(w, response.StatusForbidden, "tunnel to "+target+" not allowed")
+		return
+	}
+
+	ctx := req.Context()
+	if t.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.DialTimeout)
+		defer cancel()
+	}
+	dial := t.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	upstream, err := dial(ctx, "tcp", target)
+	if err != nil {
+		log.Printf("server: CONNECT %s: %v", target, err)
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+			writeError(w, response.StatusGatewayTimeout, "timed out reaching "+target)
+		} else {
+			writeError(w, response.StatusBadGateway, "cannot reach "+target)
+		}
+		return
+	}
+
+	// A 2xx to CONNECT has no body and no framing headers; the tunnel
+	// starts right after the empty line.
+	h := headers.NewHeaders()
+	if t.Header != nil {
+		h = t.Header.Clone()
+	}
+	w.WriteStatusLineReason(response.StatusOK, "Connection Established")
+	w.WriteHeaders(*h)
+	conn, err := w.Hijack()
+	if err != nil {
+		log.Printf("server: CONNECT %s: %v", target, err)
+		upstream.Close()
+		return
+	}
+	Splice(conn, upstream)
+}
+
+// parseAuthority splits a CONNECT target into its host and port, which
+// are both required. The host is a bracketed IPv6 address or a name or
+// IPv4 address without userinfo, path or other delimiters.
+func parseAuthority(target string) (string, int, bool) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil || host == "" {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 || portStr[0] == '+' {
+		return "", 0, false
+	}
+	if strings.HasPrefix(target, "[") {
+		addr, err := netip.ParseAddr(host)
+		return host, port, err == nil && addr.Is6()
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return "", 0, false
+		}
+	}
+	return host, port, true
+}
+
+// Splice relays bytes both ways between a and b until both directions
+// have ended. When one side stops sending, the other is told with a
+// half-close where the connection supports it, so a response can still
+// come back; otherwise, or once both are done, both are closed.
+func Splice(a, b net.Conn) {
+	var wg sync.WaitGroup
+	var once sync.Once
+	closeBoth := func() {
+		a.Close()
+		b.Close()
+	}
+	relay := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, err := io.Copy(dst, src)
+		cw, ok := dst.(interface{ CloseWrite() error })
+		if err != nil || !ok || cw.CloseWrite() != nil {
+			once.Do(closeBoth)
+		}
+	}
+	wg.Add(2)
+	go relay(a, b)
+	go relay(b, a)
+	wg.Wait()
+	once.Do(closeBoth)
+}
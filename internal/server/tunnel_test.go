@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startUpstream accepts connections and answers each with what handle
+// writes after reading from it.
+func startUpstream(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// connect sends raw to the server at url and returns the connection and
+// the response head read from it.
+func connect(t *testing.T, url, raw string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	var head strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		head.WriteString(line)
+		if line == "\r\n" {
+			return conn, br, head.String()
+		}
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	echo := startUpstream(t, func(conn net.Conn) { io.Copy(conn, conn) })
+
+	// Test: Bytes are relayed both ways after 200 Connection Established
+	t.Run("Relays", func(t *testing.T) {
+		url := startServer(t, &Tunnel{Header: headers.NewHeadersFromPairs("Via", "1.1 test")})
+		conn, br, head := connect(t, url, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 200 Connection Established\r\nvia: 1.1 test\r\n\r\n", head)
+
+		io.WriteString(conn, "ping\n")
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "ping\n", line)
+	})
+
+	// Test: Bytes sent right behind the CONNECT reach the target
+	t.Run("Early bytes", func(t *testing.T) {
+		url := startServer(t, &Tunnel{})
+		_, br, _ := connect(t, url, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\n\r\nearly\n")
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "early\n", line)
+	})
+
+	// Test: A client done sending still gets the target's answer
+	t.Run("Half-close", func(t *testing.T) {
+		counter := startUpstream(t, func(conn net.Conn) {
+			n, _ := io.Copy(io.Discard, conn)
+			fmt.Fprintf(conn, "read %d bytes", n)
+		})
+		url := startServer(t, &Tunnel{})
+		conn, br, _ := connect(t, url, "CONNECT "+counter+" HTTP/1.1\r\nHost: "+counter+"\r\n\r\n")
+		io.WriteString(conn, "hello")
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+		answer, err := io.ReadAll(br)
+		require.NoError(t, err)
+		assert.Equal(t, "read 5 bytes", string(answer))
+	})
+
+	// Test: A target done sending is seen to end by the client, which can
+	// still send, when the hijacked connection carries early bytes
+	t.Run("Half-close with early bytes", func(t *testing.T) {
+		got := make(chan string, 1)
+		greeter := startUpstream(t, func(conn net.Conn) {
+			io.WriteString(conn, "hi")
+			conn.(*net.TCPConn).CloseWrite()
+			b, _ := io.ReadAll(conn)
+			got <- string(b)
+		})
+		url := startServer(t, &Tunnel{})
+		conn, br, _ := connect(t, url, "CONNECT "+greeter+" HTTP/1.1\r\nHost: "+greeter+"\r\n\r\nearly ")
+		greeting, err := io.ReadAll(br)
+		require.NoError(t, err)
+		assert.Equal(t, "hi", string(greeting))
+
+		io.WriteString(conn, "late")
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+		select {
+		case b := <-got:
+			assert.Equal(t, "early late", b)
+		case <-time.After(5 * time.Second):
+			t.Fatal("target never saw the client finish")
+		}
+	})
+
+	// Test: Targets other than host:port are refused with 400
+	t.Run("Bad target", func(t *testing.T) {
+		url := startServer(t, &Tunnel{})
+		for _, target := range []string{"example.com", "example.com:0", "example.com:https", "user@example.com:443", "/path", "example.com:+443", "[example.com]:443"} {
+			_, _, head := connect(t, url, "CONNECT "+target+" HTTP/1.1\r\nHost: x\r\n\r\n")
+			assert.True(t, strings.HasPrefix(head, "HTTP/1.1 400 "), "%s: %s", target, head)
+		}
+	})
+
+	// Test: Allow refuses targets with 403
+	t.Run("Not allowed", func(t *testing.T) {
+		var asked string
+		url := startServer(t, &Tunnel{Allow: func(host string, port int) bool {
+			asked = fmt.Sprintf("%s %d", host, port)
+			return port == 443
+		}})
+		_, _, head := connect(t, url, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\n\r\n")
+		assert.True(t, strings.HasPrefix(head, "HTTP/1.1 403 "), head)
+		host, port, _ := net.SplitHostPort(echo)
+		assert.Equal(t, host+" "+port, asked)
+	})
+
+	// Test: Unreachable targets get 502 and slow ones 504
+	t.Run("Dial errors", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		closed := l.Addr().String()
+		l.Close()
+
+		url := startServer(t, &Tunnel{})
+		_, _, head := connect(t, url, "CONNECT "+closed+" HTTP/1.1\r\nHost: "+closed+"\r\n\r\n")
+		assert.True(t, strings.HasPrefix(head, "HTTP/1.1 502 "), head)
+
+		url = startServer(t, &Tunnel{
+			DialTimeout: 20 * time.Millisecond,
+			Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		})
+		_, _, head = connect(t, url, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+		assert.True(t, strings.HasPrefix(head, "HTTP/1.1 504 "), head)
+	})
+
+	// Test: Other methods go to Next, or get 405 without one
+	t.Run("Other methods", func(t *testing.T) {
+		url := startServer(t, &Tunnel{})
+		_, _, head := connect(t, url, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.True(t, strings.HasPrefix(head, "HTTP/1.1 405 "), head)
+		assert.Contains(t, head, "allow: CONNECT\r\n")
+
+		url = startServer(t, &Tunnel{Next: HandlerFunc(func(w *response.Writer, _ *request.Request) {
+			writeError(w, response.StatusNoContent, "")
+		})})
+		_, _, head = connect(t, url, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.True(t, strings.HasPrefix(head, "HTTP/1.1 204 "), head)
+	})
+}
+
+func TestParseAuthority(t *testing.T) {
+	// Test: Names, IPv4 and bracketed IPv6 addresses are accepted
+	t.Run("Valid", func(t *testing.T) {
+		for target, want := range map[string]string{
+			"example.com:443": "example.com 443",
+			"10.0.0.1:8443":   "10.0.0.1 8443",
+			"[::1]:443":       "::1 443",
+		} {
+			host, port, ok := parseAuthority(target)
+			require.True(t, ok, target)
+			assert.Equal(t, want, fmt.Sprintf("%s %d", host, port))
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+var ErrNoUpgrade = fmt.Errorf("request does not ask to upgrade to the protocol")
+
+// UpgradeProtocols returns the protocols req asks to switch to, in the
+// client's order of preference, or nil if it asks for none. A request only
+// asks when Connection lists upgrade as well as carrying Upgrade
+// (RFC 9110 section 7.8).
+func UpgradeProtocols(req *request.Request) []string {
+	if !hasToken(req.Headers.Get("connection"), "upgrade") {
+		return nil
+	}
+	var protocols []string
+	for _, p := range strings.Split(req.Headers.Get("upgrade"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			protocols = append(protocols, p)
+		}
+	}
+	return protocols
+}
+
+// Upgrade accepts req's request to switch the connection to protocol: it
+// answers 101 Switching Protocols with Connection: Upgrade and protocol
+// echoed in Upgrade, plus any fields in h, then hijacks the connection and
+// returns it for the new protocol. The protocol name is matched without
+// regard to case, and a version in the request, as in "websocket/13",
+// must match as well when protocol has one. Upgrade fails with
+// ErrNoUpgrade, having written nothing, when req does not offer protocol.
+func Upgrade(w *response.Writer, req *request.Request, protocol string, h *headers.Headers) (net.Conn, error) {
+	offered := ""
+	for _, p := range UpgradeProtocols(req) {
+		if protocolMatches(p, protocol) {
+			offered = p
+			break
+		}
+	}
+	if offered == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNoUpgrade, protocol)
+	}
+
+	if h == nil {
+		h = headers.NewHeaders()
+	} else {
+		h = h.Clone()
+	}
+	h.Replace("Connection", "Upgrade")
+	h.Replace("Upgrade", offered)
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return nil, err
+	}
+	return w.Hijack()
+}
+
+// protocolMatches reports whether the offered protocol, name["/" version],
+// is the wanted one.
+func protocolMatches(offered, wanted string) bool {
+	if strings.EqualFold(offered, wanted) {
+		return true
+	}
+	name, _, _ := strings.Cut(offered, "/")
+	return !strings.Contains(wanted, "/") && strings.EqualFold(name, wanted)
+}
+
+// hasToken reports whether the comma-separated list value contains token,
+// ignoring case.
+func hasToken(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
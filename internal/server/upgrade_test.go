@@ -0,0 +1,95 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpgradeProtocols(t *testing.T) {
+	parse := func(raw string) *request.Request {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		return req
+	}
+
+	// Test: Protocols are listed in the client's order
+	t.Run("Listed", func(t *testing.T) {
+		req := parse("GET / HTTP/1.1\r\nHost: x\r\nConnection: keep-alive, Upgrade\r\nUpgrade: h2c, websocket/13\r\n\r\n")
+		assert.Equal(t, []string{"h2c", "websocket/13"}, UpgradeProtocols(req))
+	})
+
+	// Test: Upgrade without Connection: upgrade asks for nothing
+	t.Run("Connection missing", func(t *testing.T) {
+		req := parse("GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\n\r\n")
+		assert.Nil(t, UpgradeProtocols(req))
+	})
+
+	// Test: Protocol names match without case, versions only when given
+	t.Run("Matching", func(t *testing.T) {
+		assert.True(t, protocolMatches("WebSocket", "websocket"))
+		assert.True(t, protocolMatches("websocket/13", "websocket"))
+		assert.True(t, protocolMatches("websocket/13", "websocket/13"))
+		assert.False(t, protocolMatches("websocket/12", "websocket/13"))
+		assert.False(t, protocolMatches("websocket", "websocket/13"))
+		assert.False(t, protocolMatches("h2c", "websocket"))
+	})
+}
+
+func TestUpgrade(t *testing.T) {
+	upgraded := make(chan error, 1)
+	url := startServer(t, HandlerFunc(func(w *response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req, "echo", headers.NewHeadersFromPairs("X-Echo", "on"))
+		upgraded <- err
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}))
+	addr := url[len("http://"):]
+
+	// Test: The 101 echoes the protocol and bytes sent right behind the
+	// request reach the new protocol
+	t.Run("Switches", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "GET /echo HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: Echo\r\n\r\nearly")
+
+		want := "HTTP/1.1 101 Switching Protocols\r\nx-echo: on\r\nconnection: Upgrade\r\nupgrade: Echo\r\n\r\nearly"
+		got := make([]byte, len(want))
+		_, err = io.ReadFull(conn, got)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+		require.NoError(t, <-upgraded)
+
+		io.WriteString(conn, "later")
+		echo := make([]byte, 5)
+		_, err = io.ReadFull(conn, echo)
+		require.NoError(t, err)
+		assert.Equal(t, "later", string(echo))
+	})
+
+	// Test: A request that does not offer the protocol stays in HTTP
+	t.Run("Not offered", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: echo\r\n\r\n")
+
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusLine.StatusCode)
+		assert.ErrorIs(t, <-upgraded, ErrNoUpgrade)
+	})
+}
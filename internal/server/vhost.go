@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+)
+
+// HostRouter dispatches requests on the host they are addressed to, so one
+// server can serve several sites. Hosts are matched without their port and
+// regardless of case. A pattern "*.example.com" matches every subdomain of
+// example.com, at any depth, but not example.com itself; exact hosts win
+// over wildcards, and longer wildcards over shorter ones.
+//
+//	hr := server.NewHostRouter()
+//	hr.Handle("example.com", site)
+//	hr.Handle("*.example.com", tenants)
+type HostRouter struct {
+	// Default handles requests for hosts no pattern matches. Nil means 421
+	// Misdirected Request.
+	Default Handler
+
+	exact     map[string]Handler
+	wildcards map[string]Handler
+}
+
+func NewHostRouter() *HostRouter {
+	return &HostRouter{exact: map[string]Handler{}, wildcards: map[string]Handler{}}
+}
+
+// Handle registers h for host, either a host name or "*." followed by one.
+// It panics on an empty or duplicate pattern.
+func (r *HostRouter) Handle(host string, h Handler) {
+	host = normalizeHost(host)
+	patterns := r.exact
+	if suffix, ok := strings.CutPrefix(host, "*."); ok {
+		host = suffix
+		patterns = r.wildcards
+	}
+	if host == "" || strings.Contains(host, "*") {
+		panic(fmt.Sprintf("host router: malformed host pattern %q", host))
+	}
+	if _, ok := patterns[host]; ok {
+		panic(fmt.Sprintf("host router: host %q registered twice", host))
+	}
+	patterns[host] = h
+}
+
+func (r *HostRouter) ServeHTTP(w *response.Writer, req *request.Request) {
+	if h := r.handler(requestHost(req)); h != nil {
+		h.ServeHTTP(w, req)
+		return
+	}
+	if r.Default != nil {
+		r.Default.ServeHTTP(w, req)
+		return
+	}
+	writeError(w, response.StatusMisdirectedRequest, "unknown host")
+}
+
+func (r *HostRouter) handler(host string) Handler {
+	if host == "" {
+		return nil
+	}
+	if h, ok := r.exact[host]; ok {
+		return h
+	}
+	// Drop one label at a time: a.b.example.com tries b.example.com, then
+	// example.com, then com.
+	for rest := host; ; {
+		_, parent, ok := strings.Cut(rest, ".")
+		if !ok {
+			return nil
+		}
+		if h, ok := r.wildcards[parent]; ok {
+			return h
+		}
+		rest = parent
+	}
+}
+
+// requestHost returns the host a request is addressed to: the authority of
+// an absolute-form target, otherwise the Host header.
+func requestHost(req *request.Request) string {
+	host := req.Headers.Get("Host")
+	if u, err := url.Parse(req.RequestLine.RequestTarget); err == nil && u.IsAbs() {
+		host = u.Host
+	}
+	return normalizeHost(host)
+}
+
+// normalizeHost lowercases host and strips its port and any trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostRouter(t *testing.T) {
+	site := func(name string) Handler {
+		return reply(func(*request.Request) string { return name })
+	}
+	hr := NewHostRouter()
+	hr.Handle("example.com", site("apex"))
+	hr.Handle("*.example.com", site("any"))
+	hr.Handle("*.eu.example.com", site("eu"))
+	hr.Handle("API.example.com", site("api"))
+	hostGet := func(host string) string {
+		return "GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"
+	}
+
+	// Test: Exact, wildcard and longest-wildcard matches
+	t.Run("Match", func(t *testing.T) {
+		cases := map[string]string{
+			"example.com":          "apex",
+			"example.com:8080":     "apex",
+			"EXAMPLE.com.":         "apex",
+			"api.example.com":      "api",
+			"www.example.com":      "any",
+			"a.b.example.com":      "any",
+			"paris.eu.example.com": "eu",
+			"eu.example.com":       "any",
+		}
+		for host, want := range cases {
+			resp := serve(t, hr, hostGet(host))
+			assert.Equal(t, want, string(resp.Body), host)
+		}
+	})
+
+	// Test: Absolute-form targets take precedence over Host
+	t.Run("Absolute form", func(t *testing.T) {
+		resp := serve(t, hr, "GET http://api.example.com/x HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		assert.Equal(t, "api", string(resp.Body))
+	})
+
+	// Test: Unknown hosts get 421 or the default handler
+	t.Run("Unknown", func(t *testing.T) {
+		resp := serve(t, hr, hostGet("example.org"))
+		assert.Equal(t, 421, resp.StatusLine.StatusCode)
+
+		hr := NewHostRouter()
+		hr.Default = site("default")
+		resp = serve(t, hr, hostGet("example.org"))
+		assert.Equal(t, "default", string(resp.Body))
+	})
+
+	// Test: Duplicate and malformed patterns panic
+	t.Run("Conflicts", func(t *testing.T) {
+		assert.Panics(t, func() { hr.Handle("Example.com", site("again")) })
+		assert.Panics(t, func() { hr.Handle("*.example.com", site("again")) })
+		assert.Panics(t, func() { hr.Handle("", site("empty")) })
+		assert.Panics(t, func() { hr.Handle("a.*.com", site("middle")) })
+	})
+}
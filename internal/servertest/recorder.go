@@ -0,0 +1,139 @@
+package servertest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+// ResponseRecorder keeps what a handler writes to its response.Writer in
+// memory, so that handlers can be tested without a connection:
+//
+//	rec := servertest.NewRecorder()
+//	rec.Serve(handler, servertest.NewRequest("GET", "/items", nil))
+//	rec.AssertStatus(t, 200)
+//	rec.AssertBody(t, "[]")
+type ResponseRecorder struct {
+	buf    bytes.Buffer
+	w      *response.Writer
+	method string
+}
+
+// NewRecorder returns an empty ResponseRecorder.
+func NewRecorder() *ResponseRecorder {
+	r := &ResponseRecorder{}
+	r.w = response.NewWriter(&r.buf)
+	return r
+}
+
+// Writer returns the response.Writer whose output is recorded. Hijack
+// fails on it, as there is no connection to take over.
+func (r *ResponseRecorder) Writer() *response.Writer {
+	return r.w
+}
+
+// Serve runs h with req, answering with an empty 200 if h writes nothing,
+// as the server does. The response is read as an answer to req's method.
+func (r *ResponseRecorder) Serve(h server.Handler, req *request.Request) {
+	r.method = req.RequestLine.Method
+	h.ServeHTTP(r.w, req)
+	if r.w.StatusCode() == 0 {
+		r.w.WriteStatusLine(response.StatusOK)
+		r.w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}
+}
+
+// Raw returns the bytes written so far, exactly as they would have gone
+// out on the connection.
+func (r *ResponseRecorder) Raw() []byte {
+	return r.buf.Bytes()
+}
+
+// Result parses the recorded response: its status, headers, body, with
+// chunked coding undone, and trailers. Interim 1xx responses are skipped.
+func (r *ResponseRecorder) Result() (*response.Response, error) {
+	if r.buf.Len() == 0 {
+		return nil, errors.New("servertest: no response written")
+	}
+	rr := response.NewReader(bytes.NewReader(r.buf.Bytes()))
+	for {
+		resp, err := rr.ReadResponse(response.Options{RequestMethod: r.method})
+		if err != nil {
+			return nil, fmt.Errorf("servertest: reading recorded response: %w", err)
+		}
+		if code := resp.StatusLine.StatusCode; code < 100 || code >= 200 || code == 101 {
+			return resp, nil
+		}
+	}
+}
+
+// result returns Result, failing tb if the response cannot be read.
+func (r *ResponseRecorder) result(tb testing.TB) *response.Response {
+	tb.Helper()
+	resp, err := r.Result()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return resp
+}
+
+// AssertStatus reports an error to tb unless the response has status want.
+func (r *ResponseRecorder) AssertStatus(tb testing.TB, want int) {
+	tb.Helper()
+	if got := r.result(tb).StatusLine.StatusCode; got != want {
+		tb.Errorf("status %d, want %d", got, want)
+	}
+}
+
+// AssertHeader reports an error to tb unless the header name has the value
+// want. An empty want asserts the header is absent.
+func (r *ResponseRecorder) AssertHeader(tb testing.TB, name, want string) {
+	tb.Helper()
+	if got := r.result(tb).Headers.Get(name); got != want {
+		tb.Errorf("header %s: %q, want %q", name, got, want)
+	}
+}
+
+// AssertBody reports an error to tb unless the body is want.
+func (r *ResponseRecorder) AssertBody(tb testing.TB, want string) {
+	tb.Helper()
+	if got := string(r.result(tb).Body); got != want {
+		tb.Errorf("body %q, want %q", got, want)
+	}
+}
+
+// AssertTrailer reports an error to tb unless the trailer field name has
+// the value want.
+func (r *ResponseRecorder) AssertTrailer(tb testing.TB, name, want string) {
+	tb.Helper()
+	if got := r.result(tb).Trailers.Get(name); got != want {
+		tb.Errorf("trailer %s: %q, want %q", name, got, want)
+	}
+}
+
+// NewRequest returns a request for target as the server would hand it to a
+// handler, with Host example.com, a Content-Length when body is not nil and
+// a RemoteAddr from the documentation range. It panics if the request
+// cannot be parsed. Add headers with req.Headers.Set.
+func NewRequest(method, target string, body []byte) *request.Request {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: example.com\r\n", method, target)
+	if body != nil {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+
+	req, err := request.RequestFromReader(strings.NewReader(b.String()))
+	if err != nil {
+		panic(fmt.Sprintf("servertest: NewRequest(%q, %q): %v", method, target, err))
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	return req
+}
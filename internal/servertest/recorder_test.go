@@ -0,0 +1,106 @@
+package servertest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failures collects what assertions report instead of failing the test.
+type failures struct {
+	testing.TB
+	errors []string
+}
+
+func (f *failures) Helper() {}
+
+func (f *failures) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecorder(t *testing.T) {
+	echo := server.HandlerFunc(func(w *response.Writer, req *request.Request) {
+		body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body))
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("X-Remote", req.RemoteAddr)
+		w.WriteStatusLine(response.StatusCreated)
+		w.WriteHeaders(*h)
+		if req.RequestLine.Method != "HEAD" {
+			w.WriteBody(body)
+		}
+	})
+
+	// Test: Status, headers and body are recorded
+	t.Run("Response", func(t *testing.T) {
+		rec := NewRecorder()
+		rec.Serve(echo, NewRequest("POST", "/items", []byte("data")))
+		rec.AssertStatus(t, 201)
+		rec.AssertHeader(t, "X-Remote", "192.0.2.1:1234")
+		rec.AssertBody(t, "POST /items data")
+		assert.Contains(t, string(rec.Raw()), "HTTP/1.1 201 Created\r\n")
+	})
+
+	// Test: A HEAD response is read without a body
+	t.Run("HEAD", func(t *testing.T) {
+		rec := NewRecorder()
+		rec.Serve(echo, NewRequest("HEAD", "/", nil))
+		rec.AssertStatus(t, 201)
+		rec.AssertHeader(t, "Content-Length", "7")
+		rec.AssertBody(t, "")
+	})
+
+	// Test: A chunked body is decoded and its trailers kept, past an
+	// interim response
+	t.Run("Chunked", func(t *testing.T) {
+		rec := NewRecorder()
+		rec.Serve(server.HandlerFunc(func(w *response.Writer, req *request.Request) {
+			w.WriteInterim(response.StatusEarlyHints, headers.NewHeadersFromPairs("Link", "</a.css>; rel=preload"))
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*headers.NewHeadersFromPairs("Transfer-Encoding", "chunked", "Trailer", "X-Sum"))
+			w.WriteChunkedBody([]byte("ab"))
+			w.WriteChunkedBody([]byte("cd"))
+			w.WriteTrailers(headers.NewHeadersFromPairs("X-Sum", "4"))
+		}), NewRequest("GET", "/", nil))
+		rec.AssertStatus(t, 200)
+		rec.AssertBody(t, "abcd")
+		rec.AssertTrailer(t, "X-Sum", "4")
+	})
+
+	// Test: A handler that writes nothing gets an empty 200
+	t.Run("Nothing written", func(t *testing.T) {
+		rec := NewRecorder()
+		rec.Serve(server.HandlerFunc(func(*response.Writer, *request.Request) {}), NewRequest("GET", "/", nil))
+		rec.AssertStatus(t, 200)
+		rec.AssertBody(t, "")
+
+		_, err := NewRecorder().Result()
+		assert.Error(t, err)
+	})
+
+	// Test: Mismatches are reported to the test
+	t.Run("Failures", func(t *testing.T) {
+		rec := NewRecorder()
+		rec.Serve(echo, NewRequest("GET", "/", nil))
+		f := &failures{TB: t}
+		rec.AssertStatus(f, 200)
+		rec.AssertHeader(f, "X-Remote", "")
+		rec.AssertBody(f, "GET / ")
+		rec.AssertTrailer(f, "X-Sum", "1")
+		assert.Equal(t, []string{
+			"status 201, want 200",
+			`header X-Remote: "192.0.2.1:1234", want ""`,
+			`trailer X-Sum: "", want "1"`,
+		}, f.errors)
+	})
+
+	// Test: NewRequest panics on a request that cannot be parsed
+	t.Run("Bad request", func(t *testing.T) {
+		require.Panics(t, func() { NewRequest("GET", "/a b", nil) })
+	})
+}
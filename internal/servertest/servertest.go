@@ -0,0 +1,62 @@
+// Package servertest runs a server.Handler on a loopback port for
+// integration tests, as net/http/httptest does for net/http handlers:
+//
+//	ts := servertest.NewServer(handler)
+//	defer ts.Close()
+//	req, _ := client.NewRequest("GET", ts.URL+"/items").Build()
+//	resp, err := client.NewClient().Do(req)
+package servertest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+// closeTimeout bounds how long Close waits for requests still being
+// handled before cutting their connections.
+const closeTimeout = 5 * time.Second
+
+// Server is a server.Server listening on a loopback port.
+type Server struct {
+	// URL is the base URL of the server, such as "http://127.0.0.1:40123",
+	// with no trailing slash. It is https when the server has a TLSConfig.
+	URL string
+	// Server is the running server, for its Addr and Stats.
+	Server *server.Server
+}
+
+// NewServer starts a server for handler on a free loopback port. It panics
+// if no port can be had, as a test cannot go on without one; the caller
+// closes it when done.
+func NewServer(handler server.Handler) *Server {
+	return NewServerWithOptions(handler, server.Options{})
+}
+
+// NewServerWithOptions is NewServer with the server configured by opts.
+// Set opts.TLSConfig to serve HTTPS.
+func NewServerWithOptions(handler server.Handler, opts server.Options) *Server {
+	s, err := server.ServeWithOptions("127.0.0.1:0", handler, opts)
+	if err != nil {
+		// A host without IPv4 may still have IPv6 loopback.
+		var err6 error
+		if s, err6 = server.ServeWithOptions("[::1]:0", handler, opts); err6 != nil {
+			panic(fmt.Sprintf("servertest: failed to listen on a port: %v", err))
+		}
+	}
+	scheme := "http"
+	if opts.TLSConfig != nil {
+		scheme = "https"
+	}
+	return &Server{URL: scheme + "://" + s.Addr().String(), Server: s}
+}
+
+// Close shuts the server down, waiting for the requests being handled to
+// finish, for up to five seconds, before closing their connections.
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	s.Server.Shutdown(ctx)
+}
@@ -0,0 +1,77 @@
+package servertest
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/client"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	release := make(chan struct{})
+	handler := server.HandlerFunc(func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			<-release
+		}
+		body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	get := func(url string) (*response.Response, error) {
+		req, err := client.NewRequest("GET", url).Build()
+		require.NoError(t, err)
+		return client.NewClient().Do(req)
+	}
+
+	// Test: The URL reaches the handler on a loopback port
+	t.Run("URL", func(t *testing.T) {
+		ts := NewServer(handler)
+		defer ts.Close()
+		assert.True(t, strings.HasPrefix(ts.URL, "http://127.0.0.1:"), ts.URL)
+
+		resp, err := get(ts.URL + "/items")
+		require.NoError(t, err)
+		assert.Equal(t, "GET /items", string(resp.Body))
+	})
+
+	// Test: Close waits for the request being handled, then the port is
+	// closed
+	t.Run("Close", func(t *testing.T) {
+		ts := NewServer(handler)
+		done := make(chan string)
+		go func() {
+			resp, err := get(ts.URL + "/slow")
+			if err != nil {
+				done <- err.Error()
+				return
+			}
+			done <- string(resp.Body)
+		}()
+		require.Eventually(t, func() bool { return ts.Server.Stats().InflightRequests == 1 }, time.Second, time.Millisecond)
+
+		closed := make(chan struct{})
+		go func() {
+			ts.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+			t.Fatal("Close returned while a request was being handled")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(release)
+		assert.Equal(t, "GET /slow", <-done)
+		<-closed
+
+		_, err := net.Dial("tcp", ts.Server.Addr().String())
+		assert.Error(t, err)
+	})
+}
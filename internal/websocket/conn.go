@@ -0,0 +1,224 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"unicode/utf8"
+)
+
+// Close status codes (RFC 6455 section 7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+	CloseInternalError   = 1011
+)
+
+// DefaultMaxMessageSize is the MaxMessageSize of a new Conn.
+const DefaultMaxMessageSize = 1 << 20
+
+var ErrUnexpectedContinuation = fmt.Errorf("continuation frame without a message to continue")
+
+// CloseError is returned by ReadMessage once the peer has closed the
+// connection, with the code and reason from its close frame. Code is
+// CloseNoStatus when the frame carried none.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection, exchanging whole messages and answering
+// pings and close frames itself. One goroutine may read while others write.
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	client bool
+	// MaxMessageSize caps a message, all its fragments together, at this
+	// many bytes; a larger one closes the connection with CloseTooBig.
+	// Zero means no limit.
+	MaxMessageSize int64
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+// newConn wraps an established connection. client is the side this end
+// plays, which decides the masking of frames in both directions.
+func newConn(conn net.Conn, client bool) *Conn {
+	return &Conn{
+		conn:           conn,
+		r:              bufio.NewReader(conn),
+		client:         client,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// ReadMessage returns the next text or binary message, reassembled from its
+// fragments. Pings are answered with pongs and pongs are dropped on the
+// way. When the peer closes, its close frame is echoed and a *CloseError
+// returned. A protocol violation, an oversized message or text that is not
+// UTF-8 sends the matching close frame and returns the error.
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	var (
+		op      Opcode
+		message []byte
+	)
+	for {
+		limit := int64(0)
+		if c.MaxMessageSize > 0 {
+			limit = c.MaxMessageSize - int64(len(message))
+		}
+		f, err := ReadFrame(c.r, !c.client, limit)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch f.Opcode {
+		case OpPing:
+			if err := c.writeFrame(Frame{Fin: true, Opcode: OpPong, Payload: f.Payload}); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			return 0, nil, c.closed(f.Payload)
+		case OpContinuation:
+			if op == 0 {
+				return 0, nil, c.fail(ErrUnexpectedContinuation)
+			}
+		default:
+			if op != 0 {
+				return 0, nil, c.fail(fmt.Errorf("new message before the end of a fragmented one"))
+			}
+			op = f.Opcode
+		}
+
+		message = append(message, f.Payload...)
+		if f.Fin {
+			if op == OpText && !utf8.Valid(message) {
+				c.WriteClose(CloseInvalidPayload, "invalid UTF-8")
+				return 0, nil, fmt.Errorf("text message is not valid UTF-8")
+			}
+			return op, message, nil
+		}
+	}
+}
+
+// fail answers a read error with the close frame it calls for, if any, and
+// returns it.
+func (c *Conn) fail(err error) error {
+	switch {
+	case errors.Is(err, ErrFrameTooLarge):
+		c.WriteClose(CloseTooBig, "message too large")
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+	default:
+		var netErr net.Error
+		if !errors.As(err, &netErr) {
+			c.WriteClose(CloseProtocolError, "")
+		}
+	}
+	return err
+}
+
+// closed handles a close frame with the given payload: it echoes the
+// peer's code unless this end closed first, and returns the CloseError.
+func (c *Conn) closed(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(fmt.Errorf("close frame with a one-byte payload"))
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+		if !validCloseCode(ce.Code) {
+			return c.fail(fmt.Errorf("invalid close code %d", ce.Code))
+		}
+		if !utf8.ValidString(ce.Reason) {
+			c.WriteClose(CloseInvalidPayload, "invalid UTF-8")
+			return fmt.Errorf("close reason is not valid UTF-8")
+		}
+	}
+	code := ce.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	c.WriteClose(code, "")
+	return ce
+}
+
+// validCloseCode reports whether code may be sent in a close frame: the
+// defined codes other than those reserved for local use, and the ranges
+// for extensions and applications.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WriteMessage sends data as one text or binary message.
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	if op != OpText && op != OpBinary {
+		return fmt.Errorf("%w: 0x%x is not a message type", ErrUnknownOpcode, byte(op))
+	}
+	return c.writeFrame(Frame{Fin: true, Opcode: op, Payload: data})
+}
+
+// Ping sends a ping with the given payload of at most 125 bytes.
+func (c *Conn) Ping(payload []byte) error {
+	return c.writeFrame(Frame{Fin: true, Opcode: OpPing, Payload: payload})
+}
+
+// WriteClose starts or completes the closing handshake with code and
+// reason. Only the first close frame is sent; later calls do nothing.
+func (c *Conn) WriteClose(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	return WriteFrame(c.conn, Frame{Fin: true, Opcode: OpClose, Payload: payload}, c.client)
+}
+
+func (c *Conn) writeFrame(f Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return WriteFrame(c.conn, f, c.client)
+}
+
+// Close closes the underlying connection without a closing handshake.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
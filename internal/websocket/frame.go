@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Opcode is the type of a frame (RFC 6455 section 5.2).
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+// IsControl reports whether op is a control opcode: close, ping or pong.
+func (op Opcode) IsControl() bool {
+	return op&0x8 != 0
+}
+
+// maxControlPayload is the largest payload a control frame may carry.
+const maxControlPayload = 125
+
+var (
+	ErrReservedBits   = fmt.Errorf("reserved bits set")
+	ErrUnknownOpcode  = fmt.Errorf("unknown opcode")
+	ErrBadControl     = fmt.Errorf("fragmented or oversized control frame")
+	ErrMaskRequired   = fmt.Errorf("frame from client is not masked")
+	ErrMaskForbidden  = fmt.Errorf("frame from server is masked")
+	ErrFrameTooLarge  = fmt.Errorf("frame payload too large")
+	ErrLengthEncoding = fmt.Errorf("payload length not minimally encoded")
+)
+
+// Frame is one WebSocket frame, its payload unmasked.
+type Frame struct {
+	Fin     bool
+	Opcode  Opcode
+	Payload []byte
+}
+
+// ReadFrame reads one frame from r. fromClient says which side sent it:
+// frames from clients must be masked and frames from servers must not
+// (section 5.1). Payloads over maxPayload bytes fail with ErrFrameTooLarge
+// before any of it is read; zero means no limit.
+func ReadFrame(r io.Reader, fromClient bool, maxPayload int64) (Frame, error) {
+	var head [14]byte
+	if _, err := io.ReadFull(r, head[:2]); err != nil {
+		return Frame{}, err
+	}
+	f := Frame{Fin: head[0]&0x80 != 0, Opcode: Opcode(head[0] & 0x0F)}
+	if head[0]&0x70 != 0 {
+		return Frame{}, ErrReservedBits
+	}
+	switch f.Opcode {
+	case OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong:
+	default:
+		return Frame{}, fmt.Errorf("%w: 0x%x", ErrUnknownOpcode, byte(f.Opcode))
+	}
+
+	masked := head[1]&0x80 != 0
+	if fromClient && !masked {
+		return Frame{}, ErrMaskRequired
+	}
+	if !fromClient && masked {
+		return Frame{}, ErrMaskForbidden
+	}
+
+	length := int64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		if _, err := io.ReadFull(r, head[2:4]); err != nil {
+			return Frame{}, unexpected(err)
+		}
+		length = int64(binary.BigEndian.Uint16(head[2:4]))
+		if length < 126 {
+			return Frame{}, ErrLengthEncoding
+		}
+	case 127:
+		if _, err := io.ReadFull(r, head[2:10]); err != nil {
+			return Frame{}, unexpected(err)
+		}
+		n := binary.BigEndian.Uint64(head[2:10])
+		if n>>63 != 0 {
+			return Frame{}, ErrFrameTooLarge
+		}
+		length = int64(n)
+		if length <= 0xFFFF {
+			return Frame{}, ErrLengthEncoding
+		}
+	}
+	if f.Opcode.IsControl() && (!f.Fin || length > maxControlPayload) {
+		return Frame{}, ErrBadControl
+	}
+	if maxPayload > 0 && length > maxPayload {
+		return Frame{}, fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, length, maxPayload)
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return Frame{}, unexpected(err)
+		}
+	}
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return Frame{}, unexpected(err)
+	}
+	if masked {
+		mask(key, f.Payload)
+	}
+	return f, nil
+}
+
+// WriteFrame writes f to w in a single write. Clients set masked, which
+// masks the payload with a fresh random key as section 5.3 requires;
+// servers never mask. f.Payload is left as it was.
+func WriteFrame(w io.Writer, f Frame, masked bool) error {
+	if f.Opcode.IsControl() && (!f.Fin || len(f.Payload) > maxControlPayload) {
+		return ErrBadControl
+	}
+
+	buf := make([]byte, 0, 14+len(f.Payload))
+	b0 := byte(f.Opcode)
+	if f.Fin {
+		b0 |= 0x80
+	}
+	var b1 byte
+	if masked {
+		b1 = 0x80
+	}
+	switch n := len(f.Payload); {
+	case n < 126:
+		buf = append(buf, b0, b1|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, b0, b1|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, b0, b1|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if !masked {
+		buf = append(buf, f.Payload...)
+	} else {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf = append(buf, key[:]...)
+		start := len(buf)
+		buf = append(buf, f.Payload...)
+		mask(key, buf[start:])
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// mask XORs p in place with key, which masks and unmasks alike.
+func mask(key [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= key[i&3]
+	}
+}
+
+// unexpected turns an EOF in the middle of a frame into
+// io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrame(t *testing.T) {
+	// Test: Frames survive a round trip at every length encoding
+	t.Run("Round trip", func(t *testing.T) {
+		for _, n := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+			for _, masked := range []bool{false, true} {
+				payload := bytes.Repeat([]byte("x"), n)
+				var buf bytes.Buffer
+				require.NoError(t, WriteFrame(&buf, Frame{Fin: true, Opcode: OpBinary, Payload: payload}, masked))
+				f, err := ReadFrame(&buf, masked, 0)
+				require.NoError(t, err)
+				assert.True(t, f.Fin)
+				assert.Equal(t, OpBinary, f.Opcode)
+				assert.Equal(t, payload, f.Payload)
+				assert.Zero(t, buf.Len())
+			}
+		}
+	})
+
+	// Test: The RFC's masked "Hello" example decodes
+	t.Run("RFC example", func(t *testing.T) {
+		raw := []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}
+		f, err := ReadFrame(bytes.NewReader(raw), true, 0)
+		require.NoError(t, err)
+		assert.Equal(t, Frame{Fin: true, Opcode: OpText, Payload: []byte("Hello")}, f)
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrame(&buf, f, false))
+		assert.Equal(t, []byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'}, buf.Bytes())
+	})
+
+	// Test: Masking follows the direction of the frame
+	t.Run("Masking rules", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrame(&buf, Frame{Fin: true, Opcode: OpText, Payload: []byte("hi")}, false))
+		_, err := ReadFrame(bytes.NewReader(buf.Bytes()), true, 0)
+		assert.ErrorIs(t, err, ErrMaskRequired)
+
+		buf.Reset()
+		require.NoError(t, WriteFrame(&buf, Frame{Fin: true, Opcode: OpText, Payload: []byte("hi")}, true))
+		assert.NotContains(t, buf.String(), "hi")
+		_, err = ReadFrame(bytes.NewReader(buf.Bytes()), false, 0)
+		assert.ErrorIs(t, err, ErrMaskForbidden)
+	})
+
+	// Test: Malformed frames are rejected
+	t.Run("Malformed", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			raw  []byte
+			want error
+		}{
+			"reserved bits":         {[]byte{0xC1, 0x00}, ErrReservedBits},
+			"unknown opcode":        {[]byte{0x83, 0x00}, ErrUnknownOpcode},
+			"fragmented control":    {[]byte{0x09, 0x00}, ErrBadControl},
+			"oversized control":     {[]byte{0x89, 0x7E, 0x00, 0x7E}, ErrBadControl},
+			"non-minimal length":    {[]byte{0x82, 0x7E, 0x00, 0x05}, ErrLengthEncoding},
+			"truncated payload":     {[]byte{0x82, 0x05, 'a'}, io.ErrUnexpectedEOF},
+			"over the payload size": {[]byte{0x82, 0x7E, 0x01, 0x00}, ErrFrameTooLarge},
+		} {
+			_, err := ReadFrame(bytes.NewReader(tc.raw), false, 128)
+			assert.ErrorIs(t, err, tc.want, name)
+		}
+	})
+
+	// Test: Control frames over 125 bytes are not written
+	t.Run("Oversized control write", func(t *testing.T) {
+		err := WriteFrame(io.Discard, Frame{Fin: true, Opcode: OpPing, Payload: []byte(strings.Repeat("x", 126))}, false)
+		assert.ErrorIs(t, err, ErrBadControl)
+	})
+}
@@ -0,0 +1,105 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) on top of
+// the server's Upgrade: the opening handshake, the frame codec and a
+// message-level Conn.
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/headers"
+	"github.com/kahvecikaan/httpfromtcp/internal/request"
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+)
+
+// acceptGUID is appended to the client's key to compute
+// Sec-WebSocket-Accept (section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// version is the only protocol version spoken, sent back to clients that
+// ask for another.
+const version = "13"
+
+var ErrBadHandshake = fmt.Errorf("bad websocket handshake")
+
+// AcceptKey returns the Sec-WebSocket-Accept value answering key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// IsUpgrade reports whether req asks to switch to WebSocket.
+func IsUpgrade(req *request.Request) bool {
+	for _, p := range server.UpgradeProtocols(req) {
+		if strings.EqualFold(p, "websocket") {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept completes the opening handshake for req and returns the
+// connection, taken from the server. A request that is not a valid
+// handshake is answered with 400, or with 426 and the supported version
+// when it asks for another version, and Accept returns ErrBadHandshake.
+func Accept(w *response.Writer, req *request.Request) (*Conn, error) {
+	if reason := checkHandshake(req); reason != "" {
+		status := response.StatusBadRequest
+		h := response.GetDefaultHeaders(len(reason))
+		if req.Headers.Get("sec-websocket-version") != version && IsUpgrade(req) {
+			status = response.StatusUpgradeRequired
+			h.Set("Sec-WebSocket-Version", version)
+		}
+		w.WriteStatusLine(status)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(reason))
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+	}
+
+	h := headers.NewHeadersFromPairs("Sec-WebSocket-Accept", AcceptKey(req.Headers.Get("sec-websocket-key")))
+	conn, err := server.Upgrade(w, req, "websocket", h)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, false), nil
+}
+
+// checkHandshake returns why req is not a valid opening handshake
+// (section 4.2.1), or an empty string if it is.
+func checkHandshake(req *request.Request) string {
+	switch {
+	case req.RequestLine.Method != "GET":
+		return "websocket handshake must be a GET"
+	case !IsUpgrade(req):
+		return "missing Upgrade: websocket with Connection: Upgrade"
+	case req.Headers.Get("sec-websocket-version") != version:
+		return "unsupported Sec-WebSocket-Version"
+	}
+	key, err := base64.StdEncoding.DecodeString(req.Headers.Get("sec-websocket-key"))
+	if err != nil || len(key) != 16 {
+		return "Sec-WebSocket-Key must be 16 bytes in base64"
+	}
+	return ""
+}
+
+// Echo is a handler that accepts the handshake and sends every message
+// back as it came until the client closes.
+func Echo(w *response.Writer, req *request.Request) {
+	c, err := Accept(w, req)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	for {
+		op, data, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := c.WriteMessage(op, data); err != nil {
+			return
+		}
+	}
+}
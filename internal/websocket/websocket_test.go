@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/kahvecikaan/httpfromtcp/internal/response"
+	"github.com/kahvecikaan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const handshake = "GET /ws HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+	"Sec-WebSocket-Version: %s\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+
+// startEcho serves Echo on a free port for the duration of the test and
+// returns its address.
+func startEcho(t *testing.T) string {
+	t.Helper()
+	s, err := server.Serve(0, server.HandlerFunc(Echo))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().String()
+}
+
+// dial opens a client Conn to the echo server at addr.
+func dial(t *testing.T, addr string) *Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, handshake, "13")
+
+	resp, err := response.ResponseFromReader(conn)
+	require.NoError(t, err)
+	require.Equal(t, 101, resp.StatusLine.StatusCode)
+	assert.Equal(t, "websocket", resp.Headers.Get("upgrade"))
+	assert.Equal(t, "Upgrade", resp.Headers.Get("connection"))
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Headers.Get("sec-websocket-accept"))
+	return newConn(conn, true)
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Test: The example from RFC 6455 section 1.3
+	t.Run("RFC example", func(t *testing.T) {
+		assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+	})
+}
+
+func TestEcho(t *testing.T) {
+	addr := startEcho(t)
+
+	// Test: Text and binary messages come back as sent
+	t.Run("Messages", func(t *testing.T) {
+		c := dial(t, addr)
+		require.NoError(t, c.WriteMessage(OpText, []byte("hello")))
+		op, data, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, OpText, op)
+		assert.Equal(t, "hello", string(data))
+
+		require.NoError(t, c.WriteMessage(OpBinary, []byte{0, 1, 2}))
+		op, data, err = c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, OpBinary, op)
+		assert.Equal(t, []byte{0, 1, 2}, data)
+	})
+
+	// Test: Fragments are reassembled around an interleaved ping
+	t.Run("Fragments and ping", func(t *testing.T) {
+		c := dial(t, addr)
+		require.NoError(t, WriteFrame(c.conn, Frame{Opcode: OpText, Payload: []byte("hel")}, true))
+		require.NoError(t, WriteFrame(c.conn, Frame{Fin: true, Opcode: OpPing, Payload: []byte("p")}, true))
+		require.NoError(t, WriteFrame(c.conn, Frame{Fin: true, Opcode: OpContinuation, Payload: []byte("lo")}, true))
+
+		f, err := ReadFrame(c.r, false, 0)
+		require.NoError(t, err)
+		assert.Equal(t, Frame{Fin: true, Opcode: OpPong, Payload: []byte("p")}, f)
+		op, data, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, OpText, op)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	// Test: A close from the client is echoed
+	t.Run("Close handshake", func(t *testing.T) {
+		c := dial(t, addr)
+		require.NoError(t, c.WriteClose(CloseGoingAway, "bye"))
+		_, _, err := c.ReadMessage()
+		var ce *CloseError
+		require.ErrorAs(t, err, &ce)
+		assert.Equal(t, CloseGoingAway, ce.Code)
+		_, err = c.r.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	// Test: Protocol violations close the connection with a code
+	t.Run("Violations", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			frame Frame
+			code  int
+		}{
+			"invalid UTF-8":      {Frame{Fin: true, Opcode: OpText, Payload: []byte{0xff}}, CloseInvalidPayload},
+			"stray continuation": {Frame{Fin: true, Opcode: OpContinuation, Payload: []byte("x")}, CloseProtocolError},
+		} {
+			c := dial(t, addr)
+			require.NoError(t, WriteFrame(c.conn, tc.frame, true), name)
+			f, err := ReadFrame(c.r, false, 0)
+			require.NoError(t, err, name)
+			require.Equal(t, OpClose, f.Opcode, name)
+			assert.Equal(t, tc.code, int(binary.BigEndian.Uint16(f.Payload)), name)
+		}
+	})
+
+	// Test: A message over MaxMessageSize is refused from its length alone
+	t.Run("Too large", func(t *testing.T) {
+		c := dial(t, addr)
+		head := binary.BigEndian.AppendUint64([]byte{0x82, 0xFF}, DefaultMaxMessageSize+1)
+		_, err := c.conn.Write(append(head, 0, 0, 0, 0))
+		require.NoError(t, err)
+		f, err := ReadFrame(c.r, false, 0)
+		require.NoError(t, err)
+		require.Equal(t, OpClose, f.Opcode)
+		assert.Equal(t, CloseTooBig, int(binary.BigEndian.Uint16(f.Payload)))
+	})
+
+	// Test: Unmasked frames from a client are a protocol error
+	t.Run("Unmasked client frame", func(t *testing.T) {
+		c := dial(t, addr)
+		require.NoError(t, WriteFrame(c.conn, Frame{Fin: true, Opcode: OpText, Payload: []byte("x")}, false))
+		f, err := ReadFrame(c.r, false, 0)
+		require.NoError(t, err)
+		assert.Equal(t, CloseProtocolError, int(binary.BigEndian.Uint16(f.Payload)))
+	})
+
+	// Test: Bad handshakes are refused before upgrading
+	t.Run("Bad handshake", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		fmt.Fprintf(conn, handshake, "8")
+		resp, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 426, resp.StatusLine.StatusCode)
+		assert.Equal(t, "13", resp.Headers.Get("sec-websocket-version"))
+
+		conn2, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn2.Close()
+		io.WriteString(conn2, "GET /ws HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: short\r\n\r\n")
+		resp, err = response.ResponseFromReader(conn2)
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusLine.StatusCode)
+	})
+}